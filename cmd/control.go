@@ -0,0 +1,277 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"golang-dhcpcd/internal/pkg/config"
+	"golang-dhcpcd/internal/pkg/control"
+	"golang-dhcpcd/internal/pkg/dhcpc"
+	"golang-dhcpcd/internal/pkg/metrics"
+	"golang-dhcpcd/internal/pkg/static"
+	"golang-dhcpcd/internal/pkg/version"
+)
+
+// Interface management modes reported by status
+const (
+	modeDHCP   = "dhcp"
+	modeStatic = "static"
+)
+
+// interfaceArgs selects the interface of a ctl command
+type interfaceArgs struct {
+	Interface string `json:"interface,omitempty"`
+	NetNS     string `json:"netns,omitempty"`
+}
+
+// daemonStatus is the result of the status command
+type daemonStatus struct {
+	Version    string            `json:"version"`
+	Commit     string            `json:"commit"`
+	ConfigFile string            `json:"config_file"`
+	Started    time.Time         `json:"started"`
+	Interfaces []interfaceStatus `json:"interfaces"`
+	Unmatched  []string          `json:"unmatched,omitempty"` // Required entries no interface matched yet
+}
+
+// interfaceStatus describes a managed interface
+type interfaceStatus struct {
+	Interface string         `json:"interface"`
+	NetNS     string         `json:"netns,omitempty"`
+	Entry     string         `json:"entry"`
+	Mode      string         `json:"mode"`
+	Required  bool           `json:"required"`
+	DHCP      *dhcpc.Status  `json:"dhcp,omitempty"`
+	Static    *static.Status `json:"static,omitempty"`
+}
+
+// clientKey identifies a managed interface by namespace and name
+type clientKey struct {
+	netns string
+	name  string
+}
+
+// managedClient is the DHCP or static client running for an interface
+type managedClient struct {
+	entry    string
+	required bool
+	dhcp     *dhcpc.Client
+	static   *static.Client
+}
+
+// status returns the state of the client
+func (m *managedClient) status(key clientKey) interfaceStatus {
+	status := interfaceStatus{Interface: key.name, NetNS: key.netns, Entry: m.entry, Required: m.required}
+	if m.dhcp != nil {
+		dhcpStatus := m.dhcp.Status()
+		status.Mode = modeDHCP
+		status.DHCP = &dhcpStatus
+	} else {
+		staticStatus := m.static.Status()
+		status.Mode = modeStatic
+		status.Static = &staticStatus
+	}
+	return status
+}
+
+// clientSet tracks the running interface clients for ctl commands
+type clientSet struct {
+	mu      sync.Mutex
+	clients map[clientKey]*managedClient
+}
+
+// newClientSet creates an empty client set
+func newClientSet() *clientSet {
+	return &clientSet{clients: make(map[clientKey]*managedClient)}
+}
+
+// add tracks a client until the returned function is called
+func (s *clientSet) add(netns, name string, client *managedClient) func() {
+	key := clientKey{netns: netns, name: name}
+
+	s.mu.Lock()
+	s.clients[key] = client
+	s.mu.Unlock()
+
+	return func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.clients[key] == client {
+			delete(s.clients, key)
+		}
+	}
+}
+
+// parseArgs decodes the arguments of a command
+func parseArgs(data json.RawMessage) (interfaceArgs, error) {
+	var args interfaceArgs
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, &args); err != nil {
+			return args, fmt.Errorf("invalid arguments: %w", err)
+		}
+	}
+	return args, nil
+}
+
+// lookup returns the client selected by the arguments of a command
+func (s *clientSet) lookup(data json.RawMessage) (clientKey, *managedClient, error) {
+	args, err := parseArgs(data)
+	if err != nil {
+		return clientKey{}, nil, err
+	}
+	if args.Interface == "" {
+		return clientKey{}, nil, errors.New("no interface given")
+	}
+
+	key := clientKey{netns: args.NetNS, name: args.Interface}
+	s.mu.Lock()
+	client, exists := s.clients[key]
+	s.mu.Unlock()
+	if !exists {
+		if key.netns != "" {
+			return key, nil, fmt.Errorf("interface %s is not managed in namespace %s", key.name, key.netns)
+		}
+		return key, nil, fmt.Errorf("interface %s is not managed", key.name)
+	}
+	return key, client, nil
+}
+
+// statuses returns the state of every client, ordered by namespace and name
+func (s *clientSet) statuses() []interfaceStatus {
+	s.mu.Lock()
+	keys := make([]clientKey, 0, len(s.clients))
+	clients := make(map[clientKey]*managedClient, len(s.clients))
+	for key, client := range s.clients {
+		keys = append(keys, key)
+		clients[key] = client
+	}
+	s.mu.Unlock()
+
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].netns != keys[j].netns {
+			return keys[i].netns < keys[j].netns
+		}
+		return keys[i].name < keys[j].name
+	})
+	statuses := make([]interfaceStatus, 0, len(keys))
+	for _, key := range keys {
+		statuses = append(statuses, clients[key].status(key))
+	}
+	return statuses
+}
+
+// leases returns the leases of the DHCP clients for the metrics
+func (s *clientSet) leases() []metrics.Lease {
+	var leases []metrics.Lease
+	for _, status := range s.statuses() {
+		if status.DHCP == nil {
+			continue
+		}
+		lease := metrics.Lease{Interface: status.Interface, NetNS: status.NetNS, State: status.DHCP.State}
+		if status.DHCP.Lease != nil {
+			lease.Expires = status.DHCP.Lease.Expires
+		}
+		leases = append(leases, lease)
+	}
+	return leases
+}
+
+// isRequired reports whether the interfaces of an entry have to be configured for the daemon to be online
+func isRequired(ifaceConfig config.InterfaceConfig) bool {
+	return ifaceConfig.Required == nil || *ifaceConfig.Required
+}
+
+// unmatched returns the required entries without any interface, in file order
+func (s *interfaceSet) unmatched(statuses []interfaceStatus) []string {
+	cfg := s.config()
+	var entries []string
+	for _, entry := range cfg.InterfaceNames() {
+		if !isRequired(cfg.Interfaces[entry]) {
+			continue
+		}
+		matched := false
+		for _, status := range statuses {
+			if status.Entry == entry && status.NetNS == cfg.Interfaces[entry].NetNS {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// registerCommands answers the interface commands of ctl from the running clients
+func registerCommands(server *control.Server, interfaces *interfaceSet) {
+	clients := interfaces.clients
+	started := time.Now()
+
+	server.Handle("status", func(ctx context.Context, data json.RawMessage) (any, error) {
+		args, err := parseArgs(data)
+		if err != nil {
+			return nil, err
+		}
+		if args.Interface != "" {
+			key, client, err := clients.lookup(data)
+			if err != nil {
+				return nil, err
+			}
+			return client.status(key), nil
+		}
+
+		info := version.GetGitInfo()
+		statuses := clients.statuses()
+		return daemonStatus{
+			Version:    info.Tag,
+			Commit:     info.Commit,
+			ConfigFile: configFlag,
+			Started:    started,
+			Interfaces: statuses,
+			Unmatched:  interfaces.unmatched(statuses),
+		}, nil
+	})
+
+	// DHCP lease operations answer with the state reached
+	for command, operation := range map[string]func(*dhcpc.Client, context.Context) error{
+		"renew":   (*dhcpc.Client).Renew,
+		"rebind":  (*dhcpc.Client).Rebind,
+		"release": (*dhcpc.Client).Release,
+	} {
+		server.Handle(command, func(ctx context.Context, data json.RawMessage) (any, error) {
+			key, client, err := clients.lookup(data)
+			if err != nil {
+				return nil, err
+			}
+			if client.dhcp == nil {
+				return nil, fmt.Errorf("interface %s is not configured by DHCP", key.name)
+			}
+			if err := operation(client.dhcp, ctx); err != nil {
+				return nil, fmt.Errorf("%s failed: %w", command, err)
+			}
+			return client.status(key), nil
+		})
+	}
+
+	server.Handle("reapply", func(ctx context.Context, data json.RawMessage) (any, error) {
+		key, client, err := clients.lookup(data)
+		if err != nil {
+			return nil, err
+		}
+		if client.dhcp != nil {
+			err = client.dhcp.Reapply()
+		} else {
+			err = client.static.Reapply()
+		}
+		if err != nil {
+			return nil, fmt.Errorf("reapply failed: %w", err)
+		}
+		return client.status(key), nil
+	})
+}
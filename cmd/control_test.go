@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"encoding/json"
+	"net"
+	"testing"
+
+	"golang-dhcpcd/internal/pkg/static"
+)
+
+func TestParseArgs(t *testing.T) {
+	tests := []struct {
+		name    string
+		data    string
+		want    interfaceArgs
+		wantErr bool
+	}{
+		{"none", "", interfaceArgs{}, false},
+		{"interface", `{"interface": "eth0"}`, interfaceArgs{Interface: "eth0"}, false},
+		{"namespace", `{"interface": "eth0", "netns": "blue"}`, interfaceArgs{Interface: "eth0", NetNS: "blue"}, false},
+		{"invalid", `["eth0"]`, interfaceArgs{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			args, err := parseArgs(json.RawMessage(tt.data))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseArgs() error = %v, want error %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && args != tt.want {
+				t.Errorf("parseArgs() = %+v, want %+v", args, tt.want)
+			}
+		})
+	}
+}
+
+// staticClient returns a managed static client for the tests.
+func staticClient(entry, name string) *managedClient {
+	return &managedClient{entry: entry, static: &static.Client{Iface: &net.Interface{Name: name}}}
+}
+
+func TestClientSet(t *testing.T) {
+	clients := newClientSet()
+	eth0 := staticClient("eth0", "eth0")
+	clients.add("", "eth1", staticClient("eth*", "eth1"))
+	clients.add("blue", "eth0", staticClient("eth*", "eth0"))
+	remove := clients.add("", "eth0", eth0)
+
+	tests := []struct {
+		name    string
+		args    string
+		want    *managedClient
+		wantErr bool
+	}{
+		{"interface", `{"interface": "eth0"}`, eth0, false},
+		{"no interface", `{}`, nil, true},
+		{"unknown interface", `{"interface": "eth9"}`, nil, true},
+		{"unknown namespace", `{"interface": "eth1", "netns": "blue"}`, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, client, err := clients.lookup(json.RawMessage(tt.args))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("lookup() error = %v, want error %v", err, tt.wantErr)
+			}
+			if client != tt.want {
+				t.Errorf("lookup() = %v, want %v", client, tt.want)
+			}
+		})
+	}
+
+	statuses := clients.statuses()
+	var order []string
+	for _, status := range statuses {
+		if status.Mode != modeStatic || status.Static == nil {
+			t.Errorf("Status of %s = %+v, want static", status.Interface, status)
+		}
+		order = append(order, status.NetNS+"/"+status.Interface)
+	}
+	if len(order) != 3 || order[0] != "/eth0" || order[1] != "/eth1" || order[2] != "blue/eth0" {
+		t.Errorf("statuses() = %v, want ordered by namespace and name", order)
+	}
+
+	// A replaced client stays tracked when the previous one is removed
+	replacement := staticClient("eth0", "eth0")
+	clients.add("", "eth0", replacement)
+	remove()
+	if _, client, err := clients.lookup(json.RawMessage(`{"interface": "eth0"}`)); err != nil || client != replacement {
+		t.Errorf("lookup() = %v, %v, want the replacement", client, err)
+	}
+}
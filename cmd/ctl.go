@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+
+	"golang-dhcpcd/internal/pkg/control"
+
+	"github.com/spf13/cobra"
+)
+
+// Exit codes of ctl commands, usage errors exit with 1 as every other command
+const (
+	exitFailed      = 1 // The daemon failed to execute the command
+	exitUnreachable = 2 // No daemon answers on the control socket
+	exitDenied      = 3 // The caller may not access the control socket
+)
+
+var (
+	ctlSocketFlag string
+	ctlNetNSFlag  string
+)
+
+var ctlCmd = &cobra.Command{
+	Use:   "ctl",
+	Short: "Control a running daemon through its control socket",
+	Long: `Sends a command to a running "serve" instance and prints its result as JSON.
+Exits with 1 if the command failed, 2 if no daemon answers on the socket and 3
+if access to the socket was denied.`,
+}
+
+var ctlStatusCmd = &cobra.Command{
+	Use:   "status [interface]",
+	Short: "Show the state of the daemon and its interfaces, or of one interface",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var ctlArgs any
+		if len(args) == 1 {
+			ctlArgs = interfaceArgs{Interface: args[0], NetNS: ctlNetNSFlag}
+		}
+		runCtl("status", ctlArgs)
+	},
+}
+
+var ctlRenewCmd = &cobra.Command{
+	Use:   "renew <interface>",
+	Short: "Renew the DHCP lease of an interface with its server now, or restart discovery",
+	Args:  cobra.ExactArgs(1),
+	Run:   runInterfaceCtl("renew"),
+}
+
+var ctlRebindCmd = &cobra.Command{
+	Use:   "rebind <interface>",
+	Short: "Extend the DHCP lease of an interface with any server now, or restart discovery",
+	Args:  cobra.ExactArgs(1),
+	Run:   runInterfaceCtl("rebind"),
+}
+
+var ctlReleaseCmd = &cobra.Command{
+	Use:   "release <interface>",
+	Short: "Release the DHCP lease of an interface until it is renewed or rebound",
+	Args:  cobra.ExactArgs(1),
+	Run:   runInterfaceCtl("release"),
+}
+
+var ctlReapplyCmd = &cobra.Command{
+	Use:   "reapply <interface>",
+	Short: "Configure an interface again from its lease or static configuration",
+	Args:  cobra.ExactArgs(1),
+	Run:   runInterfaceCtl("reapply"),
+}
+
+var ctlReloadCmd = &cobra.Command{
+	Use:   "reload",
+	Short: "Reload the configuration file, restarting only the interfaces whose configuration changed",
+	Args:  cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		runCtl("reload", nil)
+	},
+}
+
+func init() {
+	ctlCmd.PersistentFlags().StringVarP(&ctlSocketFlag, "socket", "s", control.DefaultSocket, "Path to the control socket of the daemon")
+	ctlCmd.PersistentFlags().StringVarP(&ctlNetNSFlag, "netns", "n", "", "Network namespace of the interface as configured")
+	ctlCmd.AddCommand(ctlStatusCmd, ctlRenewCmd, ctlRebindCmd, ctlReleaseCmd, ctlReapplyCmd, ctlReloadCmd)
+	rootCmd.AddCommand(ctlCmd)
+}
+
+// runInterfaceCtl returns the run function of a command acting on the interface given as argument
+func runInterfaceCtl(command string) func(cmd *cobra.Command, args []string) {
+	return func(cmd *cobra.Command, args []string) {
+		runCtl(command, interfaceArgs{Interface: args[0], NetNS: ctlNetNSFlag})
+	}
+}
+
+// runCtl sends a command to the daemon and prints its result, exiting with the code of the failure
+func runCtl(command string, args any) {
+	result, err := control.Call(ctlSocketFlag, command, args)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		switch {
+		case errors.Is(err, control.ErrUnreachable):
+			os.Exit(exitUnreachable)
+		case errors.Is(err, control.ErrDenied):
+			os.Exit(exitDenied)
+		}
+		os.Exit(exitFailed)
+	}
+
+	var out bytes.Buffer
+	if err := json.Indent(&out, result, "", "  "); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: invalid response: %v\n", err)
+		os.Exit(exitFailed)
+	}
+	out.WriteByte('\n')
+	out.WriteTo(os.Stdout)
+}
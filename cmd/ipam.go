@@ -0,0 +1,29 @@
+package cmd
+
+import (
+	"os"
+
+	"golang-dhcpcd/internal/pkg/ipam"
+
+	"github.com/spf13/cobra"
+)
+
+var ipamCmd = &cobra.Command{
+	Use:   "ipam",
+	Short: "Run as CNI IPAM plugin, leasing addresses through the running daemon",
+	Long: `Implements the CNI IPAM protocol: the command is taken from CNI_COMMAND, the network
+configuration from stdin, and the result is written to stdout. The leases are held by a
+running "serve" instance with ipam enabled. CNI runs plugins without arguments, so the
+command also runs when the binary is invoked as ` + ipam.PluginName + `.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		if err := ipam.RunPlugin(os.Getenv, os.Stdin, os.Stdout); err != nil {
+			ipam.WriteError(os.Stdout, err)
+			os.Exit(1)
+		}
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(ipamCmd)
+}
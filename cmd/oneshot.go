@@ -0,0 +1,159 @@
+package cmd
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"golang-dhcpcd/internal/pkg/config"
+	"golang-dhcpcd/internal/pkg/logging"
+)
+
+// oneshotSet collects the outcome of configuring each interface once
+type oneshotSet struct {
+	mu      sync.Mutex
+	entries map[clientKey]string // Entry of every started interface
+	results map[clientKey]error  // Outcome of every finished interface
+	changed chan struct{}
+}
+
+// newOneshotSet creates an empty one-shot set
+func newOneshotSet() *oneshotSet {
+	return &oneshotSet{
+		entries: make(map[clientKey]string),
+		results: make(map[clientKey]error),
+		changed: make(chan struct{}, 1),
+	}
+}
+
+// start records that an interface of an entry is being configured
+func (o *oneshotSet) start(netns, entry, name string) {
+	o.mu.Lock()
+	o.entries[clientKey{netns: netns, name: name}] = entry
+	o.mu.Unlock()
+	o.notify()
+}
+
+// finish records the outcome of an interface
+func (o *oneshotSet) finish(netns, name string, err error) {
+	o.mu.Lock()
+	o.results[clientKey{netns: netns, name: name}] = err
+	o.mu.Unlock()
+	o.notify()
+}
+
+// notify wakes up wait
+func (o *oneshotSet) notify() {
+	select {
+	case o.changed <- struct{}{}:
+	default:
+	}
+}
+
+// unmatched returns the required entries without any interface, in file order
+func (o *oneshotSet) unmatched(cfg *config.Config) []string {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+
+	var entries []string
+	for _, entry := range cfg.InterfaceNames() {
+		if !isRequired(cfg.Interfaces[entry]) {
+			continue
+		}
+		matched := false
+		for key, started := range o.entries {
+			if started == entry && key.netns == cfg.Interfaces[entry].NetNS {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// done reports whether every required entry has an interface and every interface finished
+func (o *oneshotSet) done(cfg *config.Config) bool {
+	if len(o.unmatched(cfg)) > 0 {
+		return false
+	}
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return len(o.results) == len(o.entries)
+}
+
+// wait returns once done or when the context is cancelled
+func (o *oneshotSet) wait(ctx context.Context, cfg *config.Config) {
+	for !o.done(cfg) {
+		select {
+		case <-ctx.Done():
+			return
+		case <-o.changed:
+		}
+	}
+}
+
+// report logs the outcome of every interface and returns the exit code, 1 if any failed or a
+// required entry had no interface
+func (o *oneshotSet) report(cfg *config.Config) int {
+	code := 0
+	for _, entry := range o.unmatched(cfg) {
+		logging.GetLogger().WithField("entry", entry).Error("No interface found for required entry")
+		code = 1
+	}
+
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	keys := make([]clientKey, 0, len(o.entries))
+	for key := range o.entries {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if keys[i].netns != keys[j].netns {
+			return keys[i].netns < keys[j].netns
+		}
+		return keys[i].name < keys[j].name
+	})
+
+	for _, key := range keys {
+		logger := logging.WithInterface(key.name).WithField("entry", o.entries[key])
+		if key.netns != "" {
+			logger = logger.WithField("netns", key.netns)
+		}
+		if err := o.results[key]; err != nil {
+			logger.WithError(err).Error("Interface not configured")
+			code = 1
+		} else {
+			logger.Info("Interface configured")
+		}
+	}
+	return code
+}
+
+// runOneshot configures every interface once, giving up after the timeout unless it is zero, and
+// returns the exit code
+func runOneshot(ctx context.Context, cfg *config.Config, timeout time.Duration) int {
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+	ctx, cancel := context.WithCancel(ctx)
+
+	interfaces := newInterfaceSet(ctx, cfg)
+	interfaces.oneshot = newOneshotSet()
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		interfaces.Run()
+	}()
+
+	// Interfaces still being configured fail as the context is cancelled
+	interfaces.oneshot.wait(ctx, cfg)
+	cancel()
+	<-stopped
+	return interfaces.oneshot.report(cfg)
+}
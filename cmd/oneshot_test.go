@@ -0,0 +1,132 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"golang-dhcpcd/internal/pkg/config"
+)
+
+func TestOneshotSet(t *testing.T) {
+	optional := false
+	cfg := &config.Config{Interfaces: map[string]config.InterfaceConfig{
+		"eth0":  {},
+		"en*":   {},
+		"wlan0": {Required: &optional},
+		"veth0": {NetNS: "blue"},
+	}}
+
+	type outcome struct {
+		netns, name string
+		err         error
+	}
+	type started struct {
+		netns, entry, name string
+	}
+	tests := []struct {
+		name      string
+		started   []started
+		finished  []outcome
+		unmatched []string
+		done      bool
+		code      int
+	}{
+		{
+			name:      "nothing started",
+			unmatched: []string{"en*", "eth0", "veth0"},
+		},
+		{
+			name:     "all configured",
+			started:  []started{{"", "eth0", "eth0"}, {"", "en*", "en1"}, {"", "en*", "en2"}, {"blue", "veth0", "veth0"}},
+			finished: []outcome{{"", "eth0", nil}, {"", "en1", nil}, {"", "en2", nil}, {"blue", "veth0", nil}},
+			done:     true,
+		},
+		{
+			name:     "still running",
+			started:  []started{{"", "eth0", "eth0"}, {"", "en*", "en1"}, {"blue", "veth0", "veth0"}},
+			finished: []outcome{{"", "eth0", nil}, {"blue", "veth0", nil}},
+			code:     0,
+		},
+		{
+			name:     "failed interface",
+			started:  []started{{"", "eth0", "eth0"}, {"", "en*", "en1"}, {"blue", "veth0", "veth0"}},
+			finished: []outcome{{"", "eth0", errors.New("no lease bound")}, {"", "en1", nil}, {"blue", "veth0", nil}},
+			done:     true,
+			code:     1,
+		},
+		{
+			name:      "entry in other namespace",
+			started:   []started{{"", "eth0", "eth0"}, {"", "en*", "en1"}, {"", "veth0", "veth0"}},
+			finished:  []outcome{{"", "eth0", nil}, {"", "en1", nil}, {"", "veth0", nil}},
+			unmatched: []string{"veth0"},
+			code:      1,
+		},
+		{
+			name:     "optional failed",
+			started:  []started{{"", "eth0", "eth0"}, {"", "en*", "en1"}, {"blue", "veth0", "veth0"}, {"", "wlan0", "wlan0"}},
+			finished: []outcome{{"", "eth0", nil}, {"", "en1", nil}, {"blue", "veth0", nil}, {"", "wlan0", errors.New("no carrier")}},
+			done:     true,
+			code:     1,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			set := newOneshotSet()
+			for _, s := range tt.started {
+				set.start(s.netns, s.entry, s.name)
+			}
+			for _, f := range tt.finished {
+				set.finish(f.netns, f.name, f.err)
+			}
+
+			if got := set.unmatched(cfg); !reflect.DeepEqual(got, tt.unmatched) {
+				t.Errorf("unmatched() = %v, want %v", got, tt.unmatched)
+			}
+			if got := set.done(cfg); got != tt.done {
+				t.Errorf("done() = %v, want %v", got, tt.done)
+			}
+			// Unmatched entries fail the report as well
+			wantCode := tt.code
+			if len(tt.unmatched) > 0 {
+				wantCode = 1
+			}
+			if got := set.report(cfg); got != wantCode {
+				t.Errorf("report() = %d, want %d", got, wantCode)
+			}
+		})
+	}
+}
+
+func TestOneshotSetWait(t *testing.T) {
+	cfg := &config.Config{Interfaces: map[string]config.InterfaceConfig{"eth0": {}}}
+	set := newOneshotSet()
+
+	waited := make(chan struct{})
+	go func() {
+		defer close(waited)
+		set.wait(context.Background(), cfg)
+	}()
+
+	set.start("", "eth0", "eth0")
+	select {
+	case <-waited:
+		t.Fatal("wait() returned before the interface finished")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	set.finish("", "eth0", nil)
+	select {
+	case <-waited:
+	case <-time.After(time.Second):
+		t.Fatal("wait() did not return once the interface finished")
+	}
+
+	// A cancelled context ends the wait while interfaces are missing
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	newOneshotSet().wait(ctx, cfg)
+}
@@ -0,0 +1,174 @@
+package cmd
+
+import (
+	"context"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang-dhcpcd/internal/pkg/dhcpc"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/spf13/cobra"
+)
+
+var (
+	probeDiscoverOnlyFlag bool
+	probeWindowFlag       time.Duration
+	probeOptionsFlag      []uint
+	probeJSONFlag         bool
+)
+
+var probeCmd = &cobra.Command{
+	Use:   "probe <interface>",
+	Short: "Ask the DHCP servers of an interface for a lease and print their answers",
+	Long: `Sends DISCOVER and REQUEST on the interface and prints every option of the
+OFFER and the ACK or NAK, without configuring the interface. The interface has to
+be up. The REQUEST commits the lease on the server, --discover-only stops after
+the OFFER instead. Exits with 1 if no server answered or the request was rejected.`,
+	Args: cobra.ExactArgs(1),
+	Run: func(cmd *cobra.Command, args []string) {
+		var options []dhcpv4.OptionCode
+		for _, code := range probeOptionsFlag {
+			if code == 0 || code > 254 {
+				fmt.Fprintf(os.Stderr, "Error: invalid option code %d\n", code)
+				os.Exit(exitFailed)
+			}
+			options = append(options, dhcpv4.GenericOptionCode(code))
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		result, err := dhcpc.Probe(ctx, args[0], dhcpc.ProbeConfig{
+			DiscoverOnly: probeDiscoverOnlyFlag,
+			Window:       probeWindowFlag,
+			Options:      options,
+		})
+		if result != nil {
+			printProbe(result)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitFailed)
+		}
+		if result.Reply != nil && result.Reply.MessageType() == dhcpv4.MessageTypeNak {
+			os.Exit(exitFailed)
+		}
+	},
+}
+
+func init() {
+	probeCmd.Flags().BoolVar(&probeDiscoverOnlyFlag, "discover-only", false, "Stop after the OFFER, so that no server commits a lease")
+	probeCmd.Flags().DurationVar(&probeWindowFlag, "window", 0, "List the offers of every server answering within this time, not only the first")
+	probeCmd.Flags().UintSliceVarP(&probeOptionsFlag, "option", "o", nil, "Request this option code in addition, may be repeated")
+	probeCmd.Flags().BoolVar(&probeJSONFlag, "json", false, "Print the answers as JSON")
+	rootCmd.AddCommand(probeCmd)
+}
+
+// probeMessage is a decoded answer of a server
+type probeMessage struct {
+	Type       string        `json:"type"`
+	Server     string        `json:"server,omitempty"`
+	Address    string        `json:"address,omitempty"`
+	NextServer string        `json:"next_server,omitempty"`
+	Relay      string        `json:"relay,omitempty"`
+	BootFile   string        `json:"boot_file,omitempty"`
+	Options    []probeOption `json:"options"`
+}
+
+// probeOption is a decoded option of an answer
+type probeOption struct {
+	Code  uint8  `json:"code"`
+	Name  string `json:"name"`
+	Value string `json:"value"`
+	Data  string `json:"data"` // Hex encoded
+}
+
+// decodeMessage decodes the fields and every option of an answer, ordered by option code
+func decodeMessage(msg *dhcpv4.DHCPv4) probeMessage {
+	decoded := probeMessage{
+		Type:     msg.MessageType().String(),
+		BootFile: msg.BootFileName,
+		Options:  []probeOption{},
+	}
+	if server := msg.ServerIdentifier(); server != nil {
+		decoded.Server = server.String()
+	}
+	if !msg.YourIPAddr.IsUnspecified() {
+		decoded.Address = msg.YourIPAddr.String()
+	}
+	if !msg.ServerIPAddr.IsUnspecified() {
+		decoded.NextServer = msg.ServerIPAddr.String()
+	}
+	if !msg.GatewayIPAddr.IsUnspecified() {
+		decoded.Relay = msg.GatewayIPAddr.String()
+	}
+
+	codes := make([]int, 0, len(msg.Options))
+	for code := range msg.Options {
+		codes = append(codes, int(code))
+	}
+	sort.Ints(codes)
+	for _, code := range codes {
+		data := msg.Options[uint8(code)]
+
+		// The library names and formats a single option as "    Name: value\n"
+		summary := strings.TrimSpace(dhcpv4.Options{uint8(code): data}.Summary(nil))
+		name, value, _ := strings.Cut(summary, ": ")
+		decoded.Options = append(decoded.Options, probeOption{
+			Code:  uint8(code),
+			Name:  name,
+			Value: value,
+			Data:  hex.EncodeToString(data),
+		})
+	}
+	return decoded
+}
+
+// printProbe prints the answers as text or JSON
+func printProbe(result *dhcpc.ProbeResult) {
+	messages := make([]probeMessage, 0, len(result.Offers)+1)
+	for _, offer := range result.Offers {
+		messages = append(messages, decodeMessage(offer))
+	}
+	if result.Reply != nil {
+		messages = append(messages, decodeMessage(result.Reply))
+	}
+
+	if probeJSONFlag {
+		out, err := json.MarshalIndent(messages, "", "  ")
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitFailed)
+		}
+		fmt.Println(string(out))
+		return
+	}
+
+	for i, msg := range messages {
+		if i > 0 {
+			fmt.Println()
+		}
+		fmt.Printf("%s from %s\n", msg.Type, msg.Server)
+		for _, field := range []struct{ name, value string }{
+			{"Address", msg.Address},
+			{"Next server", msg.NextServer},
+			{"Relay", msg.Relay},
+			{"Boot file", msg.BootFile},
+		} {
+			if field.value != "" {
+				fmt.Printf("  %-12s %s\n", field.name+":", field.value)
+			}
+		}
+		for _, option := range msg.Options {
+			fmt.Printf("  %3d %s: %s\n", option.Code, option.Name, option.Value)
+		}
+	}
+}
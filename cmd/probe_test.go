@@ -0,0 +1,86 @@
+package cmd
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func TestDecodeMessage(t *testing.T) {
+	tests := []struct {
+		name      string
+		modifiers []dhcpv4.Modifier
+		want      probeMessage
+	}{
+		{
+			name: "offer",
+			modifiers: []dhcpv4.Modifier{
+				dhcpv4.WithMessageType(dhcpv4.MessageTypeOffer),
+				dhcpv4.WithYourIP(net.IPv4(10, 0, 0, 5)),
+				dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.IPv4(10, 0, 0, 1))),
+				dhcpv4.WithOption(dhcpv4.OptRouter(net.IPv4(10, 0, 0, 1))),
+				dhcpv4.WithOption(dhcpv4.OptIPAddressLeaseTime(time.Hour)),
+			},
+			want: probeMessage{
+				Type:    "OFFER",
+				Server:  "10.0.0.1",
+				Address: "10.0.0.5",
+				Options: []probeOption{
+					{Code: 3, Name: "Router", Value: "10.0.0.1", Data: "0a000001"},
+					{Code: 51, Name: "IP Addresses Lease Time", Value: "1h0m0s", Data: "00000e10"},
+					{Code: 53, Name: "DHCP Message Type", Value: "OFFER", Data: "02"},
+					{Code: 54, Name: "Server Identifier", Value: "10.0.0.1", Data: "0a000001"},
+				},
+			},
+		},
+		{
+			name: "relayed boot answer",
+			modifiers: []dhcpv4.Modifier{
+				dhcpv4.WithMessageType(dhcpv4.MessageTypeAck),
+				dhcpv4.WithYourIP(net.IPv4(10, 0, 0, 5)),
+				dhcpv4.WithServerIP(net.IPv4(10, 0, 0, 2)),
+				dhcpv4.WithGatewayIP(net.IPv4(10, 0, 0, 254)),
+				func(d *dhcpv4.DHCPv4) { d.BootFileName = "pxelinux.0" },
+			},
+			want: probeMessage{
+				Type:       "ACK",
+				Address:    "10.0.0.5",
+				NextServer: "10.0.0.2",
+				Relay:      "10.0.0.254",
+				BootFile:   "pxelinux.0",
+				Options: []probeOption{
+					{Code: 53, Name: "DHCP Message Type", Value: "ACK", Data: "05"},
+				},
+			},
+		},
+		{
+			name: "unknown option",
+			modifiers: []dhcpv4.Modifier{
+				dhcpv4.WithMessageType(dhcpv4.MessageTypeNak),
+				dhcpv4.WithOption(dhcpv4.OptGeneric(dhcpv4.GenericOptionCode(224), []byte{1, 2})),
+			},
+			want: probeMessage{
+				Type: "NAK",
+				Options: []probeOption{
+					{Code: 53, Name: "DHCP Message Type", Value: "NAK", Data: "06"},
+					{Code: 224, Name: "unknown (224)", Value: "[1 2]", Data: "0102"},
+				},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := dhcpv4.New(tt.modifiers...)
+			if err != nil {
+				t.Fatalf("New() failed: %v", err)
+			}
+			if got := decodeMessage(msg); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("decodeMessage() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,230 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"slices"
+	"sync"
+
+	"golang-dhcpcd/internal/pkg/config"
+	"golang-dhcpcd/internal/pkg/hotplug"
+	"golang-dhcpcd/internal/pkg/links"
+	"golang-dhcpcd/internal/pkg/logging"
+	"golang-dhcpcd/internal/pkg/namespace"
+	"golang-dhcpcd/internal/pkg/netmon"
+)
+
+// reloadResult lists the interface entries affected by a reload
+type reloadResult struct {
+	Added     []string `json:"added,omitempty"`
+	Removed   []string `json:"removed,omitempty"`
+	Restarted []string `json:"restarted,omitempty"`
+}
+
+// interfaceSet runs the interface watchers of every configured namespace and applies reloaded
+// configurations to them
+type interfaceSet struct {
+	ctx context.Context
+	wg  sync.WaitGroup
+
+	clients *clientSet
+	oneshot *oneshotSet    // Outcome of each interface with --oneshot
+	links   *links.Manager // Declared links, nil if they are not managed
+
+	mu         sync.Mutex
+	cfg        *config.Config
+	watchers   map[string]*hotplug.Watcher   // By namespace, while the namespace exists
+	namespaces map[string]context.CancelFunc // Watches of the other namespaces
+	slots      map[slotKey][]string          // Interfaces of each entry by metric slot, "" for a free slot
+	stopped    bool
+}
+
+// slotKey identifies an entry within a namespace
+type slotKey struct {
+	netns, entry string
+}
+
+// newInterfaceSet creates the interface set of a configuration, running until the context is cancelled
+func newInterfaceSet(ctx context.Context, cfg *config.Config) *interfaceSet {
+	return &interfaceSet{
+		ctx:        ctx,
+		clients:    newClientSet(),
+		cfg:        cfg,
+		watchers:   make(map[string]*hotplug.Watcher),
+		namespaces: make(map[string]context.CancelFunc),
+		slots:      make(map[slotKey][]string),
+	}
+}
+
+// config returns the current configuration
+func (s *interfaceSet) config() *config.Config {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.cfg
+}
+
+// Run configures the interfaces of every namespace and returns once all have stopped
+func (s *interfaceSet) Run() {
+	s.mu.Lock()
+	for _, netns := range s.cfg.Namespaces() {
+		s.watchNamespace(netns)
+	}
+	s.mu.Unlock()
+
+	s.watch(s.ctx, "")
+
+	// No reload starts another namespace from now on
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+	s.wg.Wait()
+}
+
+// watchNamespace configures the interfaces of another namespace while it exists. The lock must be held.
+func (s *interfaceSet) watchNamespace(netns string) {
+	if s.stopped {
+		return
+	}
+	ctx, cancel := context.WithCancel(s.ctx)
+	s.namespaces[netns] = cancel
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		namespace.Watch(ctx, netns, func(ctx context.Context) {
+			ctx = netmon.NewContext(ctx, netmon.NewMonitor(ctx, namespace.FromContext(ctx).Handle()))
+			s.watch(ctx, netns)
+		})
+	}()
+}
+
+// watch configures the matching interfaces of a namespace as they appear and stops when they
+// disappear, until the context is cancelled
+func (s *interfaceSet) watch(ctx context.Context, netns string) {
+	s.mu.Lock()
+	watcher := hotplug.NewWatcher(convertMatchRules(s.cfg, netns), func(ctx context.Context, entry, name string) error {
+		if s.oneshot != nil {
+			s.oneshot.start(netns, entry, name)
+		}
+		slot, release := s.claimSlot(netns, entry, name)
+		defer release()
+		err := runInterface(ctx, s.config(), s.clients, entry, name, slot)
+		if s.oneshot != nil {
+			// Interfaces are configured once, failures are reported instead of retried
+			s.oneshot.finish(netns, name, err)
+			return nil
+		}
+		return err
+	})
+	s.watchers[netns] = watcher
+	s.mu.Unlock()
+
+	defer func() {
+		s.mu.Lock()
+		if s.watchers[netns] == watcher {
+			delete(s.watchers, netns)
+		}
+		s.mu.Unlock()
+	}()
+
+	if err := watcher.Run(ctx); err != nil {
+		logging.GetLogger().WithField("netns", netns).WithError(err).Error("Interface watcher failed")
+	}
+}
+
+// claimSlot returns the lowest metric slot of the entry not held by another of its interfaces, and
+// a function releasing it. Interfaces keep their slot, and so their metric, while they exist.
+func (s *interfaceSet) claimSlot(netns, entry, name string) (int, func()) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := slotKey{netns: netns, entry: entry}
+	slot := slices.Index(s.slots[key], "")
+	if slot < 0 {
+		slot = len(s.slots[key])
+		s.slots[key] = append(s.slots[key], "")
+	}
+	s.slots[key][slot] = name
+
+	return slot, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.slots[key][slot] = ""
+	}
+}
+
+// Reload switches to a validated configuration. Interfaces of added entries are started, those of
+// removed entries stopped and those of changed entries restarted, the others keep running.
+func (s *interfaceSet) Reload(cfg *config.Config) *reloadResult {
+	logger := logging.GetLogger()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	old := s.cfg
+	added, removed, changed := old.Diff(cfg)
+	s.cfg = cfg
+
+	// Apply logging changes first, so that the rest of the reload is logged accordingly
+	if old.Logging != cfg.Logging {
+		logging.InitLogger(cfg.Logging)
+	}
+
+	// Links come before the interfaces, which may be configured on top of them
+	if s.links != nil && !reflect.DeepEqual(old.Links, cfg.Links) {
+		if err := s.links.SetConfigs(cfg.LinkConfigs()); err != nil {
+			logger.WithError(err).Error("Invalid link configuration, keeping the links")
+		} else if err := s.links.Apply(); err != nil {
+			logger.WithError(err).Warn("Failed to apply some links, retrying when links change")
+		}
+	}
+
+	for netns, watcher := range s.watchers {
+		watcher.Update(convertMatchRules(cfg, netns), changed)
+	}
+
+	namespaces := make(map[string]bool)
+	for _, netns := range cfg.Namespaces() {
+		namespaces[netns] = true
+		if _, exists := s.namespaces[netns]; !exists {
+			s.watchNamespace(netns)
+		}
+	}
+	for netns, cancel := range s.namespaces {
+		if !namespaces[netns] {
+			cancel()
+			delete(s.namespaces, netns)
+		}
+	}
+
+	// Settings used once at startup
+	for setting, unchanged := range map[string]bool{
+		"state_dir":      old.StateDir == cfg.StateDir,
+		"ipam":           reflect.DeepEqual(old.IPAM, cfg.IPAM),
+		"metrics":        reflect.DeepEqual(old.Metrics, cfg.Metrics),
+		"control_socket": old.ControlSocket == cfg.ControlSocket,
+	} {
+		if !unchanged {
+			logger.WithField("setting", setting).Warn("Setting changed, restart the daemon to apply it")
+		}
+	}
+
+	logger.WithField("added", added).
+		WithField("removed", removed).
+		WithField("restarted", changed).
+		Info("Reloaded configuration")
+	return &reloadResult{Added: added, Removed: removed, Restarted: changed}
+}
+
+// loadConfig loads and validates the configuration file
+func loadConfig(path string) (*config.Config, error) {
+	cfg, err := config.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid configuration: %w", err)
+	}
+	return cfg, nil
+}
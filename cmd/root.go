@@ -1,6 +1,11 @@
 package cmd
 
 import (
+	"os"
+	"path/filepath"
+
+	"golang-dhcpcd/internal/pkg/ipam"
+
 	"github.com/spf13/cobra"
 )
 
@@ -10,5 +15,9 @@ var rootCmd = &cobra.Command{
 }
 
 func Execute() {
+	// CNI runs plugins by name without arguments
+	if filepath.Base(os.Args[0]) == ipam.PluginName {
+		rootCmd.SetArgs([]string{"ipam"})
+	}
 	cobra.CheckErr(rootCmd.Execute())
 }
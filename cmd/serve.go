@@ -1,18 +1,37 @@
 package cmd
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"golang-dhcpcd/internal/pkg/config"
+	"golang-dhcpcd/internal/pkg/control"
 	"golang-dhcpcd/internal/pkg/dhcpc"
+	"golang-dhcpcd/internal/pkg/hotplug"
+	"golang-dhcpcd/internal/pkg/inventory"
+	"golang-dhcpcd/internal/pkg/ipam"
+	"golang-dhcpcd/internal/pkg/links"
 	"golang-dhcpcd/internal/pkg/logging"
+	"golang-dhcpcd/internal/pkg/metrics"
+	"golang-dhcpcd/internal/pkg/namespace"
+	"golang-dhcpcd/internal/pkg/resolver"
 	"golang-dhcpcd/internal/pkg/static"
+	"golang-dhcpcd/internal/pkg/systemd"
+	"os"
+	"os/signal"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/spf13/cobra"
 )
 
 var (
-	configFlag string
+	configFlag        string
+	oneshotFlag       bool
+	leaseLifetimeFlag bool
+	timeoutFlag       time.Duration
 )
 
 var serveCmd = &cobra.Command{
@@ -37,72 +56,296 @@ var serveCmd = &cobra.Command{
 		logger := logging.GetLogger()
 		logger.WithField("config_file", configFlag).Info("Starting daemon")
 
-		// Start interface configuration in goroutines
+		// Keep track of the addresses we install across restarts
+		inventory.SetStateDir(cfg.StateDir)
+
+		// Stop all interfaces on SIGINT or SIGTERM
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+
+		// Create the virtual links before the interfaces on top of them are configured,
+		// and delete those created for a previous configuration
+		linkManager, err := links.NewManager(cfg.LinkConfigs())
+		if err != nil {
+			logger.WithError(err).Error("Invalid link configuration")
+			return
+		}
+		if err := linkManager.Apply(); err != nil {
+			logger.WithError(err).Warn("Failed to create some links, retrying when links change")
+		}
+
+		// Configure every interface once and exit instead of staying resident
+		if oneshotFlag {
+			code := runOneshot(ctx, cfg, timeoutFlag)
+			stop()
+			os.Exit(code)
+		}
+		// Links declared by a reload are followed as well
+		go linkManager.Run(ctx)
+
+		// Hold leases for containers on behalf of the CNI IPAM plugin
 		var wg sync.WaitGroup
-		for ifaceName, ifaceConfig := range cfg.Interfaces {
+		if cfg.IPAM != nil {
 			wg.Add(1)
-			go func(name string, config config.InterfaceConfig) {
+			go func() {
 				defer wg.Done()
+				if err := ipam.NewServer(cfg.IPAM.Socket).Run(ctx); err != nil {
+					logger.WithError(err).Error("IPAM server failed")
+				}
+			}()
+		}
+
+		// Answer ctl commands
+		interfaces := newInterfaceSet(ctx, cfg)
+		interfaces.links = linkManager
+		reload := func(ctx context.Context, args json.RawMessage) (any, error) {
+			logger.WithField("config_file", configFlag).Info("Reloading configuration")
+			newCfg, err := loadConfig(configFlag)
+			if err != nil {
+				logger.WithError(err).Error("Failed to reload configuration, keeping the running one")
+				return nil, err
+			}
+			return interfaces.Reload(newCfg), nil
+		}
+		controlServer := control.NewServer(cfg.ControlSocket)
+		registerCommands(controlServer, interfaces)
+		controlServer.Handle("reload", reload)
 
-				ifaceLogger := logging.WithInterface(name)
-
-				if config.DHCP {
-					ifaceLogger.WithField("component", "dhcp").Info("Starting DHCP client")
-					if err := runDHCP(name); err != nil {
-						ifaceLogger.WithField("component", "dhcp").WithError(err).Error("DHCP client failed")
-					}
-				} else if config.Static != nil {
-					ifaceLogger.WithField("component", "static").
-						WithField("ip", config.Static.IP).
-						WithField("netmask", config.Static.Netmask).
-						WithField("gateway", config.Static.Gateway).
-						Info("Configuring static IP")
-					if err := runStaticConfig(name, config.Static); err != nil {
-						ifaceLogger.WithField("component", "static").WithError(err).Error("Static configuration failed")
-					}
+		// Sockets passed by systemd replace those the daemon would create
+		sockets := systemd.Activated()
+		socketPath := cfg.ControlSocket
+		if socketPath == "" {
+			socketPath = control.DefaultSocket
+		}
+		controlListener := sockets.Take("control", socketPath)
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if controlListener != nil {
+				controlServer.Serve(ctx, controlListener)
+				return
+			}
+			if err := controlServer.Run(ctx); err != nil {
+				logger.WithError(err).Error("Control server failed")
+			}
+		}()
+
+		// Expose metrics for Prometheus, an activated socket enables them without configuration
+		var metricsListen string
+		if cfg.Metrics != nil {
+			metricsListen = cfg.Metrics.Listen
+		}
+		metricsListener := sockets.Take("metrics", metricsListen)
+		if metricsListener != nil || cfg.Metrics != nil {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var err error
+				if metricsListener != nil {
+					err = metrics.ServeListener(ctx, metricsListener, interfaces.clients.leases)
+				} else {
+					err = metrics.Serve(ctx, metricsListen, interfaces.clients.leases)
+				}
+				if err != nil {
+					logger.WithError(err).Error("Metrics server failed")
 				}
-			}(ifaceName, ifaceConfig)
+			}()
 		}
+		sockets.Close()
 
-		// Wait for all goroutines to complete
+		// Reload the configuration on SIGHUP and keep systemd informed until shutdown
+		hangup := make(chan os.Signal, 1)
+		signal.Notify(hangup, syscall.SIGHUP)
+		defer signal.Stop(hangup)
+		go func() {
+			notifier := &notifier{interfaces: interfaces}
+			status := time.NewTicker(time.Second)
+			defer status.Stop()
+
+			// A nil channel never fires when the watchdog is disabled
+			var watchdog <-chan time.Time
+			if interval := systemd.WatchdogInterval(); interval > 0 {
+				ticker := time.NewTicker(interval)
+				defer ticker.Stop()
+				watchdog = ticker.C
+			}
+
+			for {
+				select {
+				case <-ctx.Done():
+					systemd.Stopping()
+					return
+				case <-hangup:
+					reload(ctx, nil)
+				case <-watchdog:
+					systemd.Watchdog()
+				case <-status.C:
+					notifier.update()
+				}
+			}
+		}()
+
+		interfaces.Run()
 		wg.Wait()
+		logger.Info("Daemon stopped")
 	},
 }
 
 func init() {
 	serveCmd.Flags().StringVarP(&configFlag, "config", "f", "", "Path to config file (YAML)")
+	serveCmd.Flags().BoolVar(&oneshotFlag, "oneshot", false, "Configure every interface once and exit with 1 if any failed")
+	serveCmd.Flags().BoolVar(&leaseLifetimeFlag, "lease-lifetime", false, "With --oneshot, let the kernel remove DHCP addresses when their lease expires")
+	serveCmd.Flags().DurationVar(&timeoutFlag, "timeout", time.Minute, "With --oneshot, give up on interfaces not configured after this long, 0 waits forever")
 	if err := serveCmd.MarkFlagRequired("config"); err != nil {
 		panic(err) // This should never happen during initialization
 	}
 	rootCmd.AddCommand(serveCmd)
 }
 
+// runInterface configures an interface matched by a configuration entry until the context is
+// cancelled, or only once with --oneshot, and returns why that failed
+func runInterface(ctx context.Context, cfg *config.Config, clients *clientSet, entry, name string, slot int) error {
+	ifaceConfig := cfg.Interfaces[entry]
+	ifaceConfig.VRF = cfg.InterfaceVRF(entry, name)
+	// Links are created in the namespace of the daemon, other namespaces have no declared VRF
+	var vrfs []string
+	if ifaceConfig.NetNS == "" {
+		vrfs = cfg.VRFNames()
+	}
+	ifaceLogger := logging.WithInterface(name)
+
+	var err error
+	if ifaceConfig.DHCP {
+		ifaceLogger.WithField("component", "dhcp").Info("Starting DHCP client")
+		if err = runDHCP(ctx, clients, entry, name, ifaceConfig, vrfs, cfg.RouteMetric(entry, name, slot)); err != nil {
+			ifaceLogger.WithField("component", "dhcp").WithError(err).Error("DHCP client failed")
+		}
+	} else if ifaceConfig.Static != nil {
+		ifaceLogger.WithField("component", "static").
+			WithField("ip", ifaceConfig.Static.IP).
+			WithField("netmask", ifaceConfig.Static.Netmask).
+			WithField("addresses", len(ifaceConfig.Static.Addresses)).
+			WithField("gateway", ifaceConfig.Static.Gateway).
+			WithField("gateway6", ifaceConfig.Static.Gateway6).
+			Info("Configuring static IP")
+		if err = runStaticConfig(ctx, clients, entry, name, ifaceConfig, vrfs, cfg.RouteMetric(entry, name, slot)); err != nil {
+			ifaceLogger.WithField("component", "static").WithError(err).Error("Static configuration failed")
+		}
+	}
+
+	// Nameservers of an unplugged interface are no longer reachable
+	if errors.Is(context.Cause(ctx), hotplug.ErrLinkGone) {
+		resolvConf := resolver.For(namespace.FromContext(ctx).ResolvConf())
+		if _, err := resolvConf.Remove(name); err != nil {
+			ifaceLogger.WithError(err).Warn("Failed to remove DNS configuration")
+		}
+	}
+	return err
+}
+
+// convertMatchRules converts the configuration entries of a namespace to hotplug rules in file order
+func convertMatchRules(cfg *config.Config, netns string) []hotplug.Rule {
+	var rules []hotplug.Rule
+	for _, entry := range cfg.InterfaceNames() {
+		if cfg.Interfaces[entry].NetNS != netns {
+			continue
+		}
+		match := cfg.Interfaces[entry].Match
+		if match == nil {
+			rules = append(rules, hotplug.Rule{Entry: entry, Name: entry})
+			continue
+		}
+		rules = append(rules, hotplug.Rule{
+			Entry:  entry,
+			Name:   match.Name,
+			MAC:    match.MAC,
+			Driver: match.Driver,
+			Path:   match.Path,
+			Kind:   match.Kind,
+		})
+	}
+	return rules
+}
+
 // runDHCP runs the real DHCP client on the specified interface
-func runDHCP(ifaceName string) error {
-	client, err := dhcpc.NewClient(ifaceName)
+func runDHCP(ctx context.Context, clients *clientSet, entry, ifaceName string, ifaceConfig config.InterfaceConfig, vrfs []string, metric int) error {
+	client, err := dhcpc.NewClient(ctx, ifaceName)
 	if err != nil {
 		return err
 	}
-	return client.Run()
+	defer clients.add(ifaceConfig.NetNS, ifaceName, &managedClient{entry: entry, required: isRequired(ifaceConfig), dhcp: client})()
+
+	// The timeout was validated with the configuration
+	carrierTimeout, _ := time.ParseDuration(ifaceConfig.CarrierTimeout)
+
+	return client.Run(ctx, dhcpc.Config{
+		Metric:        metric,
+		Exclusive:     ifaceConfig.Exclusive,
+		Routes:        ifaceConfig.Routes,
+		PolicyRouting: ifaceConfig.PolicyRouting,
+		HealthCheck:   ifaceConfig.HealthCheck,
+
+		CarrierTimeout: carrierTimeout,
+		VRF:            ifaceConfig.VRF,
+		DeclaredVRFs:   vrfs,
+
+		Anonymize: ifaceConfig.Anonymize,
+		MACPolicy: ifaceConfig.MACRandomization,
+
+		OneShot:   oneshotFlag,
+		Permanent: oneshotFlag && !leaseLifetimeFlag,
+	})
 }
 
 // runStaticConfig configures static IP on the specified interface
-func runStaticConfig(ifaceName string, staticConfig *config.StaticConfig) error {
+func runStaticConfig(ctx context.Context, clients *clientSet, entry, ifaceName string, ifaceConfig config.InterfaceConfig, vrfs []string, metric int) error {
 	logger := logging.WithComponentAndInterface("static", ifaceName)
+	staticConfig := ifaceConfig.Static
 
 	// Create static client
-	client, err := static.NewClient(ifaceName)
+	client, err := static.NewClient(ctx, ifaceName)
 	if err != nil {
 		return fmt.Errorf("failed to create static client: %w", err)
-	} // Convert config.StaticConfig to static.Config
+	}
+	defer clients.add(ifaceConfig.NetNS, ifaceName, &managedClient{entry: entry, required: isRequired(ifaceConfig), static: client})()
+
 	staticClientConfig := static.Config{
 		IPAddress: staticConfig.IP,
 		Netmask:   staticConfig.Netmask,
 		Gateway:   staticConfig.Gateway,
+		Gateway6:  staticConfig.Gateway6,
+		Metric:    metric,
+		Exclusive: ifaceConfig.Exclusive,
+		Addresses: staticConfig.Addresses,
+		DNS:       staticConfig.DNS,
+		Search:    staticConfig.Search,
+		Domain:    staticConfig.Domain,
+		Routes:    ifaceConfig.Routes,
+
+		PolicyRouting: ifaceConfig.PolicyRouting,
+		HealthCheck:   ifaceConfig.HealthCheck,
+		VRF:           ifaceConfig.VRF,
+		DeclaredVRFs:  vrfs,
+
+		Link: static.LinkSettings{
+			MTU:        ifaceConfig.MTU,
+			MAC:        ifaceConfig.MAC,
+			TxQueueLen: ifaceConfig.TxQueueLen,
+			Down:       ifaceConfig.Up != nil && !*ifaceConfig.Up,
+			Promisc:    ifaceConfig.Promiscuous,
+			Alias:      ifaceConfig.Alias,
+		},
+		OneShot: oneshotFlag,
 	}
 
 	logger.WithField("config", staticClientConfig).Debug("Created static client configuration")
 
 	// Run static configuration
-	return client.Run(staticClientConfig)
+	err = client.Run(ctx, staticClientConfig)
+
+	// Addresses of an entry that was removed or no longer matches are not left behind
+	if errors.Is(context.Cause(ctx), hotplug.ErrLinkGone) {
+		client.RemoveAddresses()
+	}
+	return err
 }
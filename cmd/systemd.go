@@ -0,0 +1,64 @@
+package cmd
+
+import (
+	"fmt"
+	"strings"
+
+	"golang-dhcpcd/internal/pkg/logging"
+	"golang-dhcpcd/internal/pkg/systemd"
+)
+
+// notifier reports readiness and the state of the interfaces to systemd
+type notifier struct {
+	interfaces *interfaceSet
+	ready      bool
+	status     string
+}
+
+// update notifies readiness once the required interfaces are configured, and the status line
+// whenever it changed
+func (n *notifier) update() {
+	statuses := n.interfaces.clients.statuses()
+
+	status := daemonStatus{Interfaces: statuses, Unmatched: n.interfaces.unmatched(statuses)}
+	if !n.ready && (onlineCheck{}).online(status) {
+		n.ready = true
+		systemd.Ready()
+		logging.GetLogger().Info("Required interfaces configured, daemon is ready")
+	}
+
+	summary := summarizeStatus(statuses)
+	if summary != n.status {
+		n.status = summary
+		systemd.Status(summary)
+	}
+}
+
+// summarizeStatus describes the interfaces in one line, e.g. "eth0: bound 10.0.0.5/24, eth1: static"
+func summarizeStatus(statuses []interfaceStatus) string {
+	if len(statuses) == 0 {
+		return "No interfaces managed"
+	}
+
+	parts := make([]string, 0, len(statuses))
+	for _, status := range statuses {
+		name := status.Interface
+		if status.NetNS != "" {
+			name = status.NetNS + "/" + name
+		}
+
+		var state string
+		switch {
+		case status.DHCP != nil && status.DHCP.Lease != nil:
+			state = fmt.Sprintf("%s %s", status.DHCP.State, status.DHCP.Lease.Address)
+		case status.DHCP != nil:
+			state = status.DHCP.State
+		case status.Static.Configured:
+			state = "static"
+		default:
+			state = "static, not configured"
+		}
+		parts = append(parts, name+": "+state)
+	}
+	return strings.Join(parts, ", ")
+}
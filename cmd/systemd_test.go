@@ -0,0 +1,45 @@
+package cmd
+
+import (
+	"testing"
+
+	"golang-dhcpcd/internal/pkg/dhcpc"
+	"golang-dhcpcd/internal/pkg/static"
+)
+
+func TestSummarizeStatus(t *testing.T) {
+	tests := []struct {
+		name     string
+		statuses []interfaceStatus
+		want     string
+	}{
+		{"none", nil, "No interfaces managed"},
+		{
+			"bound",
+			[]interfaceStatus{{Interface: "eth0", DHCP: &dhcpc.Status{State: dhcpc.StateBound, Lease: &dhcpc.LeaseStatus{Address: "10.0.0.5/24"}}}},
+			"eth0: bound 10.0.0.5/24",
+		},
+		{
+			"mixed",
+			[]interfaceStatus{
+				{Interface: "eth0", DHCP: &dhcpc.Status{State: dhcpc.StateInit}},
+				{Interface: "eth1", Static: &static.Status{Configured: true}},
+				{Interface: "eth2", Static: &static.Status{}},
+			},
+			"eth0: init, eth1: static, eth2: static, not configured",
+		},
+		{
+			"namespace",
+			[]interfaceStatus{{Interface: "eth0", NetNS: "blue", Static: &static.Status{Configured: true}}},
+			"blue/eth0: static",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := summarizeStatus(tt.statuses); got != tt.want {
+				t.Errorf("summarizeStatus() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,191 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"time"
+
+	"golang-dhcpcd/internal/pkg/control"
+	"golang-dhcpcd/internal/pkg/dhcpc"
+
+	"github.com/spf13/cobra"
+)
+
+// pollInterval is how often wait-online asks the daemon for the state of the interfaces
+const pollInterval = 500 * time.Millisecond
+
+var (
+	waitSocketFlag     string
+	waitInterfacesFlag []string
+	waitNetNSFlag      string
+	waitAnyFlag        bool
+	waitIPv4Flag       bool
+	waitIPv6Flag       bool
+	waitTimeoutFlag    time.Duration
+)
+
+var waitOnlineCmd = &cobra.Command{
+	Use:   "wait-online",
+	Short: "Wait until the interfaces of a running daemon are configured",
+	Long: `Blocks until every interface given with --interface, or else every required
+interface entry of the running daemon, has a lease or its static configuration
+applied. Exits with 0 once online, 1 if the timeout expired first, 2 if no daemon
+answered on the socket until then and 3 if access to the socket was denied.`,
+	Args: cobra.NoArgs,
+	Run: func(cmd *cobra.Command, args []string) {
+		check := onlineCheck{
+			Interfaces: waitInterfacesFlag,
+			NetNS:      waitNetNSFlag,
+			Any:        waitAnyFlag,
+			IPv4:       waitIPv4Flag,
+			IPv6:       waitIPv6Flag,
+		}
+		os.Exit(waitOnline(check, waitTimeoutFlag))
+	},
+}
+
+func init() {
+	waitOnlineCmd.Flags().StringVarP(&waitSocketFlag, "socket", "s", control.DefaultSocket, "Path to the control socket of the daemon")
+	waitOnlineCmd.Flags().StringSliceVarP(&waitInterfacesFlag, "interface", "i", nil, "Interface to wait for, may be repeated (default all required interfaces)")
+	waitOnlineCmd.Flags().StringVarP(&waitNetNSFlag, "netns", "n", "", "Network namespace of the interfaces as configured")
+	waitOnlineCmd.Flags().BoolVar(&waitAnyFlag, "any", false, "Return once any of the interfaces is configured")
+	waitOnlineCmd.Flags().BoolVarP(&waitIPv4Flag, "ipv4", "4", false, "Require an IPv4 address")
+	waitOnlineCmd.Flags().BoolVarP(&waitIPv6Flag, "ipv6", "6", false, "Require an IPv6 address")
+	waitOnlineCmd.Flags().DurationVar(&waitTimeoutFlag, "timeout", 2*time.Minute, "Give up after this long, 0 waits forever")
+	rootCmd.AddCommand(waitOnlineCmd)
+}
+
+// waitOnline polls the daemon until the check passes and returns the exit code
+func waitOnline(check onlineCheck, timeout time.Duration) int {
+	var deadline <-chan time.Time
+	if timeout > 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		deadline = timer.C
+	}
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	// The daemon may still be starting, so an unreachable socket is retried until the timeout
+	var lastErr error
+	for {
+		status, err := queryStatus()
+		switch {
+		case errors.Is(err, control.ErrDenied):
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			return exitDenied
+		case err == nil && check.online(status):
+			return 0
+		}
+		lastErr = err
+
+		select {
+		case <-deadline:
+			if lastErr != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", lastErr)
+				if errors.Is(lastErr, control.ErrUnreachable) {
+					return exitUnreachable
+				}
+				return exitFailed
+			}
+			fmt.Fprintf(os.Stderr, "Error: interfaces not configured within %s\n", timeout)
+			return exitFailed
+		case <-ticker.C:
+		}
+	}
+}
+
+// queryStatus asks the daemon for the state of all interfaces
+func queryStatus() (daemonStatus, error) {
+	var status daemonStatus
+	result, err := control.Call(waitSocketFlag, "status", nil)
+	if err != nil {
+		return status, err
+	}
+	if err := json.Unmarshal(result, &status); err != nil {
+		return status, fmt.Errorf("invalid response: %w", err)
+	}
+	return status, nil
+}
+
+// onlineCheck decides when the daemon counts as online, for wait-online and the readiness notification
+type onlineCheck struct {
+	Interfaces []string // Interfaces to wait for, every required entry if empty
+	NetNS      string   // Namespace of the interfaces
+	Any        bool     // One configured interface suffices
+	IPv4       bool     // Interfaces need an IPv4 address
+	IPv6       bool     // Interfaces need an IPv6 address
+}
+
+// online reports whether the interfaces selected by the check are configured
+func (c onlineCheck) online(status daemonStatus) bool {
+	if len(c.Interfaces) > 0 {
+		configured := 0
+		for _, name := range c.Interfaces {
+			for _, iface := range status.Interfaces {
+				if iface.Interface == name && iface.NetNS == c.NetNS && c.configured(iface) {
+					configured++
+					break
+				}
+			}
+		}
+		if c.Any {
+			return configured > 0
+		}
+		return configured == len(c.Interfaces)
+	}
+
+	// An entry matching several interfaces is online with one of them
+	entries := make(map[clientKey]bool)
+	for _, iface := range status.Interfaces {
+		if !iface.Required {
+			continue
+		}
+		key := clientKey{netns: iface.NetNS, name: iface.Entry}
+		entries[key] = entries[key] || c.configured(iface)
+		if c.Any && entries[key] {
+			return true
+		}
+	}
+	if c.Any || len(status.Unmatched) > 0 {
+		return false
+	}
+	for _, configured := range entries {
+		if !configured {
+			return false
+		}
+	}
+	return true
+}
+
+// configured reports whether an interface holds a lease or its static configuration was applied,
+// with addresses of the families the check requires
+func (c onlineCheck) configured(status interfaceStatus) bool {
+	var addresses []string
+	switch {
+	case status.DHCP != nil:
+		bound := status.DHCP.State == dhcpc.StateBound || status.DHCP.State == dhcpc.StateSuspect
+		if bound && status.DHCP.Lease != nil {
+			addresses = []string{status.DHCP.Lease.Address}
+		}
+	case status.Static != nil && status.Static.Configured:
+		addresses = status.Static.Addresses
+	}
+
+	var ipv4, ipv6 bool
+	for _, address := range addresses {
+		prefix, err := netip.ParsePrefix(address)
+		if err != nil {
+			continue
+		}
+		if prefix.Addr().Is4() {
+			ipv4 = true
+		} else {
+			ipv6 = true
+		}
+	}
+	return (ipv4 || ipv6) && (!c.IPv4 || ipv4) && (!c.IPv6 || ipv6)
+}
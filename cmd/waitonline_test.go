@@ -0,0 +1,109 @@
+package cmd
+
+import (
+	"testing"
+
+	"golang-dhcpcd/internal/pkg/dhcpc"
+	"golang-dhcpcd/internal/pkg/static"
+)
+
+// dhcpStatus returns the status of a DHCP interface in the state, with a lease for the address if given
+func dhcpStatus(name, entry, state, address string) interfaceStatus {
+	status := interfaceStatus{Interface: name, Entry: entry, Mode: modeDHCP, Required: true, DHCP: &dhcpc.Status{State: state}}
+	if address != "" {
+		status.DHCP.Lease = &dhcpc.LeaseStatus{Address: address}
+	}
+	return status
+}
+
+func TestOnlineCheckConfigured(t *testing.T) {
+	tests := []struct {
+		name   string
+		check  onlineCheck
+		status interfaceStatus
+		want   bool
+	}{
+		{"bound", onlineCheck{}, dhcpStatus("eth0", "eth0", dhcpc.StateBound, "10.0.0.5/24"), true},
+		{"suspect", onlineCheck{}, dhcpStatus("eth0", "eth0", dhcpc.StateSuspect, "10.0.0.5/24"), true},
+		{"init", onlineCheck{}, dhcpStatus("eth0", "eth0", dhcpc.StateInit, ""), false},
+		{"released", onlineCheck{}, dhcpStatus("eth0", "eth0", dhcpc.StateReleased, "10.0.0.5/24"), false},
+		{"ipv6 required from DHCP", onlineCheck{IPv6: true}, dhcpStatus("eth0", "eth0", dhcpc.StateBound, "10.0.0.5/24"), false},
+		{
+			"static",
+			onlineCheck{},
+			interfaceStatus{Static: &static.Status{Configured: true, Addresses: []string{"192.0.2.1/24"}}},
+			true,
+		},
+		{
+			"static not configured",
+			onlineCheck{},
+			interfaceStatus{Static: &static.Status{Addresses: []string{"192.0.2.1/24"}}},
+			false,
+		},
+		{
+			"static without addresses",
+			onlineCheck{},
+			interfaceStatus{Static: &static.Status{Configured: true}},
+			false,
+		},
+		{
+			"both families required",
+			onlineCheck{IPv4: true, IPv6: true},
+			interfaceStatus{Static: &static.Status{Configured: true, Addresses: []string{"192.0.2.1/24", "2001:db8::1/64"}}},
+			true,
+		},
+		{
+			"ipv4 required",
+			onlineCheck{IPv4: true},
+			interfaceStatus{Static: &static.Status{Configured: true, Addresses: []string{"2001:db8::1/64"}}},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.check.configured(tt.status); got != tt.want {
+				t.Errorf("configured() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestOnlineCheckOnline(t *testing.T) {
+	bound := dhcpStatus("eth0", "eth0", dhcpc.StateBound, "10.0.0.5/24")
+	waiting := dhcpStatus("eth1", "eth1", dhcpc.StateInit, "")
+	optional := dhcpStatus("eth2", "eth2", dhcpc.StateInit, "")
+	optional.Required = false
+	// Two interfaces matched by the same entry
+	wildBound := dhcpStatus("en1", "en*", dhcpc.StateBound, "10.0.1.5/24")
+	wildWaiting := dhcpStatus("en2", "en*", dhcpc.StateInit, "")
+
+	tests := []struct {
+		name   string
+		check  onlineCheck
+		status daemonStatus
+		want   bool
+	}{
+		{"no interfaces", onlineCheck{}, daemonStatus{}, true},
+		{"all required configured", onlineCheck{}, daemonStatus{Interfaces: []interfaceStatus{bound, optional}}, true},
+		{"required waiting", onlineCheck{}, daemonStatus{Interfaces: []interfaceStatus{bound, waiting}}, false},
+		{"unmatched entry", onlineCheck{}, daemonStatus{Interfaces: []interfaceStatus{bound}, Unmatched: []string{"eth3"}}, false},
+		{"entry online with one interface", onlineCheck{}, daemonStatus{Interfaces: []interfaceStatus{wildWaiting, wildBound}}, true},
+		{"any required", onlineCheck{Any: true}, daemonStatus{Interfaces: []interfaceStatus{bound, waiting}}, true},
+		{"any without configured", onlineCheck{Any: true}, daemonStatus{Interfaces: []interfaceStatus{waiting}}, false},
+		{"named interfaces", onlineCheck{Interfaces: []string{"eth0"}}, daemonStatus{Interfaces: []interfaceStatus{bound, waiting}}, true},
+		{"named interface waiting", onlineCheck{Interfaces: []string{"eth0", "eth1"}}, daemonStatus{Interfaces: []interfaceStatus{bound, waiting}}, false},
+		{"named any", onlineCheck{Interfaces: []string{"eth0", "eth1"}, Any: true}, daemonStatus{Interfaces: []interfaceStatus{bound, waiting}}, true},
+		{"named optional", onlineCheck{Interfaces: []string{"eth2"}}, daemonStatus{Interfaces: []interfaceStatus{optional}}, false},
+		{"named missing", onlineCheck{Interfaces: []string{"eth9"}}, daemonStatus{Interfaces: []interfaceStatus{bound}}, false},
+		{"named other namespace", onlineCheck{Interfaces: []string{"eth0"}, NetNS: "blue"}, daemonStatus{Interfaces: []interfaceStatus{bound}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.check.online(tt.status); got != tt.want {
+				t.Errorf("online() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
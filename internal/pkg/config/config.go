@@ -2,32 +2,157 @@ package config
 
 import (
 	"fmt"
+	"maps"
+	"net"
 	"os"
+	"path"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sort"
+	"strings"
+	"time"
 
+	"golang-dhcpcd/internal/pkg/health"
+	"golang-dhcpcd/internal/pkg/links"
 	"golang-dhcpcd/internal/pkg/logging"
+	"golang-dhcpcd/internal/pkg/policy"
+	"golang-dhcpcd/internal/pkg/routes"
+	"golang-dhcpcd/internal/pkg/static"
 
 	"gopkg.in/yaml.v3"
 )
 
 // InterfaceConfig represents the configuration for a network interface
 type InterfaceConfig struct {
-	DHCP   bool          `yaml:"dhcp"`
-	Static *StaticConfig `yaml:"static,omitempty"`
+	DHCP   bool           `yaml:"dhcp"`
+	Static *StaticConfig  `yaml:"static,omitempty"`
+	Metric *int           `yaml:"metric,omitempty"` // Default route metric, derived from link type and order if unset
+	Routes []routes.Route `yaml:"routes,omitempty"`
+
+	// Match selects the interfaces by their attributes, the entry name is then only a label
+	Match *MatchConfig `yaml:"match,omitempty"`
+
+	// PolicyRouting installs the interface into a dedicated routing table when set
+	PolicyRouting *policy.Config `yaml:"policy_routing,omitempty"`
+
+	// NetNS is the network namespace of the interface, a name under /run/netns or an absolute path
+	NetNS string `yaml:"netns,omitempty"`
+
+	// VRF enslaves the interface to a VRF, its routes are installed into the table of the VRF
+	VRF string `yaml:"vrf,omitempty"`
+
+	// CarrierTimeout limits how long DHCP waits for carrier before trying anyway, it waits indefinitely if unset
+	CarrierTimeout string `yaml:"carrier_timeout,omitempty"`
+
+	// Anonymize follows the DHCP anonymity profile of RFC 7844 with a random MAC address,
+	// MACRandomization is "per-network" (default) or "per-boot"
+	Anonymize        bool   `yaml:"anonymize,omitempty"`
+	MACRandomization string `yaml:"mac_randomization,omitempty"`
+
+	// HealthCheck probes the gateway and fails over the default route while it is unreachable
+	HealthCheck *health.Config `yaml:"health_check,omitempty"`
+
+	// Link settings enforced on static interfaces
+	MTU         int    `yaml:"mtu,omitempty"`
+	MAC         string `yaml:"mac,omitempty"`
+	TxQueueLen  int    `yaml:"txqueuelen,omitempty"`
+	Up          *bool  `yaml:"up,omitempty"` // false keeps the link administratively down
+	Promiscuous *bool  `yaml:"promiscuous,omitempty"`
+	Alias       string `yaml:"alias,omitempty"` // Interface description
+
+	// Exclusive removes every address and default route on the interface that is not
+	// configured, instead of only those the daemon installed itself
+	Exclusive bool `yaml:"exclusive,omitempty"`
+
+	// Required delays the readiness notification to systemd until the interface is configured,
+	// defaults to true
+	Required *bool `yaml:"required,omitempty"`
+}
+
+// MatchConfig selects interfaces by shell patterns, every field that is set must match
+type MatchConfig struct {
+	Name   string `yaml:"name,omitempty"`
+	MAC    string `yaml:"mac,omitempty"`
+	Driver string `yaml:"driver,omitempty"`
+	Path   string `yaml:"path,omitempty"` // Bus address of the device, e.g. 0000:02:00.0
+	Kind   string `yaml:"kind,omitempty"` // Link type, e.g. device, vlan or bond
+}
+
+// LinkConfig declares a virtual link created by the daemon
+type LinkConfig struct {
+	Kind    string   `yaml:"kind"`              // vlan, bridge, bond, macvlan, ipvlan or vrf
+	Parent  string   `yaml:"parent,omitempty"`  // Lower link of vlan, macvlan and ipvlan links
+	ID      int      `yaml:"id,omitempty"`      // VLAN ID
+	Members []string `yaml:"members,omitempty"` // Ports of bridges, bonds and VRFs
+	Mode    string   `yaml:"mode,omitempty"`    // Bond, macvlan or ipvlan mode
+	MIIMon  int      `yaml:"miimon,omitempty"`  // Bond link monitoring interval in milliseconds
+	Table   int      `yaml:"table,omitempty"`   // Routing table of a VRF
+	MTU     int      `yaml:"mtu,omitempty"`
+
+	// Tunnel settings of vxlan, gre and ipip links. The local address defaults to the first
+	// address of the parent
+	VNI    int    `yaml:"vni,omitempty"`
+	Local  string `yaml:"local,omitempty"`
+	Remote string `yaml:"remote,omitempty"` // Remote endpoint, or multicast group for vxlan
+	Port   int    `yaml:"port,omitempty"`   // VXLAN destination port, defaults to 4789
+	TTL    int    `yaml:"ttl,omitempty"`
+	Key    uint32 `yaml:"key,omitempty"` // GRE key
+
+	// WireGuard settings
+	PrivateKeyFile string                `yaml:"private_key_file,omitempty"`
+	ListenPort     int                   `yaml:"listen_port,omitempty"`
+	Peers          []links.WireGuardPeer `yaml:"peers,omitempty"`
 }
 
 // StaticConfig represents static IP configuration
 type StaticConfig struct {
-	IP      string `yaml:"ip"`
-	Netmask string `yaml:"netmask"`
-	Gateway string `yaml:"gateway"`
+	IP        string           `yaml:"ip"`
+	Netmask   string           `yaml:"netmask"`
+	Gateway   string           `yaml:"gateway"`
+	Gateway6  string           `yaml:"gateway6,omitempty"`
+	Addresses []static.Address `yaml:"addresses,omitempty"`
+	DNS       []string         `yaml:"dns,omitempty"`
+	Search    []string         `yaml:"search,omitempty"`
+	Domain    string           `yaml:"domain,omitempty"`
 }
 
 // Config represents the main configuration structure
 type Config struct {
 	Logging    logging.LogConfig          `yaml:"logging"`
+	StateDir   string                     `yaml:"state_dir,omitempty"` // Defaults to /run/golang-dhcpcd
+	Links      map[string]LinkConfig      `yaml:"links,omitempty"`
 	Interfaces map[string]InterfaceConfig `yaml:"interfaces"`
+
+	// IPAM serves the CNI IPAM plugin when set
+	IPAM *IPAMConfig `yaml:"ipam,omitempty"`
+
+	// Metrics exposes Prometheus metrics when set
+	Metrics *MetricsConfig `yaml:"metrics,omitempty"`
+
+	// ControlSocket is where the daemon listens for ctl commands, defaults to /run/golang-dhcpcd/control.sock
+	ControlSocket string `yaml:"control_socket,omitempty"`
+
+	// interfaceOrder lists the interface names in the order they appear in the file
+	interfaceOrder []string
 }
 
+// IPAMConfig enables leasing addresses for containers through the CNI IPAM plugin
+type IPAMConfig struct {
+	Socket string `yaml:"socket,omitempty"` // Defaults to /run/golang-dhcpcd/ipam.sock
+}
+
+// MetricsConfig enables the Prometheus metrics endpoint
+type MetricsConfig struct {
+	Listen string `yaml:"listen"` // host:port serving /metrics, e.g. 127.0.0.1:9420
+}
+
+// Default route metric bases, wired links are preferred over wireless ones
+const (
+	wiredMetricBase    = 100
+	wirelessMetricBase = 600
+)
+
 // Load loads configuration from a YAML file
 func Load(configPath string) (*Config, error) {
 	data, err := os.ReadFile(configPath)
@@ -40,9 +165,208 @@ func Load(configPath string) (*Config, error) {
 		return nil, fmt.Errorf("failed to parse config file %s: %w", configPath, err)
 	}
 
+	// Remember the interface order, the map above loses it
+	var document struct {
+		Interfaces yaml.Node `yaml:"interfaces"`
+	}
+	if err := yaml.Unmarshal(data, &document); err != nil {
+		return nil, fmt.Errorf("failed to parse config file %s: %w", configPath, err)
+	}
+	for i := 0; i+1 < len(document.Interfaces.Content); i += 2 {
+		config.interfaceOrder = append(config.interfaceOrder, document.Interfaces.Content[i].Value)
+	}
+
 	return &config, nil
 }
 
+// InterfaceNames returns the configured interface names in file order.
+func (c *Config) InterfaceNames() []string {
+	if len(c.interfaceOrder) == len(c.Interfaces) {
+		return c.interfaceOrder
+	}
+
+	// Fall back to name order for configurations not loaded from a file
+	names := make([]string, 0, len(c.Interfaces))
+	for name := range c.Interfaces {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Namespaces returns the network namespaces of the interfaces in file order, without the
+// namespace of the daemon.
+func (c *Config) Namespaces() []string {
+	var namespaces []string
+	seen := make(map[string]bool)
+	for _, name := range c.InterfaceNames() {
+		netns := c.Interfaces[name].NetNS
+		if netns != "" && !seen[netns] {
+			seen[netns] = true
+			namespaces = append(namespaces, netns)
+		}
+	}
+	return namespaces
+}
+
+// Diff compares the interface entries with those of a newer configuration. Entries are changed when
+// their settings differ, their effective VRF changed through the members of a declared VRF or,
+// without an explicit metric, their position in the file moved.
+func (c *Config) Diff(newer *Config) (added, removed, changed []string) {
+	position := func(cfg *Config, entry string) int {
+		return slices.Index(cfg.InterfaceNames(), entry)
+	}
+
+	for _, entry := range newer.InterfaceNames() {
+		old, exists := c.Interfaces[entry]
+		switch {
+		case !exists:
+			added = append(added, entry)
+		case !reflect.DeepEqual(old, newer.Interfaces[entry]):
+			changed = append(changed, entry)
+		case !maps.Equal(c.memberVRFs(entry), newer.memberVRFs(entry)):
+			changed = append(changed, entry)
+		case old.Metric == nil && position(c, entry) != position(newer, entry):
+			changed = append(changed, entry)
+		}
+	}
+	for _, entry := range c.InterfaceNames() {
+		if _, exists := newer.Interfaces[entry]; !exists {
+			removed = append(removed, entry)
+		}
+	}
+	return added, removed, changed
+}
+
+// RouteMetric returns the default route metric for an interface matched by an entry. Unless set
+// explicitly, wired interfaces get 100 and wireless interfaces 600, plus the position of the entry in the file.
+// The slot tells apart the interfaces matched by one entry: each slot adds the number of entries to a derived
+// metric, or one to an explicit metric, so that the default routes of the interfaces do not collide.
+func (c *Config) RouteMetric(entry, interfaceName string, slot int) int {
+	if iface, exists := c.Interfaces[entry]; exists && iface.Metric != nil {
+		return *iface.Metric + slot
+	}
+
+	base := wiredMetricBase
+	if isWireless(interfaceName) {
+		base = wirelessMetricBase
+	}
+	names := c.InterfaceNames()
+	for i, name := range names {
+		if name == entry {
+			return base + i + slot*len(names)
+		}
+	}
+	return base
+}
+
+// isWireless reports whether the interface is a wireless device according to sysfs.
+func isWireless(interfaceName string) bool {
+	for _, entry := range []string{"wireless", "phy80211"} {
+		if _, err := os.Stat(filepath.Join("/sys/class/net", interfaceName, entry)); err == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// LinkConfigs converts the declared virtual links for the link manager, ordered by name
+func (c *Config) LinkConfigs() []links.Config {
+	names := make([]string, 0, len(c.Links))
+	for name := range c.Links {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	converted := make([]links.Config, 0, len(names))
+	for _, name := range names {
+		link := c.Links[name]
+		id := link.ID
+		if link.Kind == links.KindVXLAN {
+			id = link.VNI
+		}
+		linkConfig := links.Config{
+			Name:    name,
+			Kind:    link.Kind,
+			Parent:  link.Parent,
+			ID:      id,
+			Members: link.Members,
+			Mode:    link.Mode,
+			MIIMon:  link.MIIMon,
+			Table:   link.Table,
+			MTU:     link.MTU,
+			Local:   link.Local,
+			Remote:  link.Remote,
+			Port:    link.Port,
+			TTL:     link.TTL,
+			Key:     link.Key,
+		}
+		if link.Kind == links.KindWireGuard || link.PrivateKeyFile != "" || link.ListenPort != 0 || len(link.Peers) > 0 {
+			linkConfig.WireGuard = &links.WireGuardConfig{
+				PrivateKeyFile: link.PrivateKeyFile,
+				ListenPort:     link.ListenPort,
+				Peers:          link.Peers,
+			}
+		}
+		converted = append(converted, linkConfig)
+	}
+	return converted
+}
+
+// InterfaceVRF returns the VRF of an interface matched by an entry: the configured one, or else the
+// declared VRF listing the interface as a member, so that the interface is not released from it
+func (c *Config) InterfaceVRF(entry, interfaceName string) string {
+	iface := c.Interfaces[entry]
+	if iface.VRF != "" || iface.NetNS != "" {
+		return iface.VRF
+	}
+	for name, link := range c.Links {
+		if link.Kind == links.KindVRF && slices.Contains(link.Members, interfaceName) {
+			return name
+		}
+	}
+	return ""
+}
+
+// VRFNames returns the names of the declared VRFs in name order
+func (c *Config) VRFNames() []string {
+	var names []string
+	for name, link := range c.Links {
+		if link.Kind == links.KindVRF {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
+}
+
+// memberVRFs maps the members of declared VRFs that the entry may match to their effective VRF.
+// Entries matching by other attributes than the name may match any member, entries with their own
+// VRF or namespace match none.
+func (c *Config) memberVRFs(entry string) map[string]string {
+	iface := c.Interfaces[entry]
+	if iface.VRF != "" || iface.NetNS != "" {
+		return nil
+	}
+	pattern := entry
+	if iface.Match != nil {
+		pattern = iface.Match.Name
+	}
+
+	vrfs := make(map[string]string)
+	for _, link := range c.Links {
+		if link.Kind != links.KindVRF {
+			continue
+		}
+		for _, member := range link.Members {
+			if matched, err := path.Match(pattern, member); pattern == "" || (err == nil && matched) {
+				vrfs[member] = c.InterfaceVRF(entry, member)
+			}
+		}
+	}
+	return vrfs
+}
+
 // GetInterfaceConfig returns the configuration for a specific interface
 func (c *Config) GetInterfaceConfig(interfaceName string) (InterfaceConfig, bool) {
 	config, exists := c.Interfaces[interfaceName]
@@ -51,9 +375,40 @@ func (c *Config) GetInterfaceConfig(interfaceName string) (InterfaceConfig, bool
 
 // Validate validates the configuration
 func (c *Config) Validate() error {
-	if len(c.Interfaces) == 0 {
+	if len(c.Interfaces) == 0 && c.IPAM == nil {
 		return fmt.Errorf("no interfaces configured")
 	}
+	if c.IPAM != nil && c.IPAM.Socket != "" && !filepath.IsAbs(c.IPAM.Socket) {
+		return fmt.Errorf("ipam: socket path %q must be absolute", c.IPAM.Socket)
+	}
+	if c.Metrics != nil {
+		if _, port, err := net.SplitHostPort(c.Metrics.Listen); err != nil || port == "" {
+			return fmt.Errorf("metrics: listen address %q must be host:port", c.Metrics.Listen)
+		}
+	}
+	if c.ControlSocket != "" && !filepath.IsAbs(c.ControlSocket) {
+		return fmt.Errorf("control_socket: path %q must be absolute", c.ControlSocket)
+	}
+
+	linkConfigs := c.LinkConfigs()
+	for _, link := range linkConfigs {
+		if err := link.Validate(); err != nil {
+			return err
+		}
+	}
+	if _, err := links.Order(linkConfigs); err != nil {
+		return err
+	}
+
+	tables := make(map[int]string)
+	for name, link := range c.Links {
+		if link.Kind == links.KindVRF {
+			if other, exists := tables[link.Table]; exists {
+				return fmt.Errorf("link %s: table %d is already used by %s", name, link.Table, other)
+			}
+			tables[link.Table] = "VRF " + name
+		}
+	}
 
 	for name, iface := range c.Interfaces {
 		if !iface.DHCP && iface.Static == nil {
@@ -62,22 +417,164 @@ func (c *Config) Validate() error {
 		if iface.DHCP && iface.Static != nil {
 			return fmt.Errorf("interface %s: cannot specify both dhcp and static configuration", name)
 		}
+		if iface.Metric != nil && *iface.Metric < 0 {
+			return fmt.Errorf("interface %s: metric must not be negative", name)
+		}
+		if err := validateMatch(name, iface.Match); err != nil {
+			return err
+		}
+		if err := validateNetNS(name, iface); err != nil {
+			return err
+		}
+		if iface.CarrierTimeout != "" {
+			if d, err := time.ParseDuration(iface.CarrierTimeout); err != nil || d < 0 {
+				return fmt.Errorf("interface %s: invalid carrier timeout %q", name, iface.CarrierTimeout)
+			}
+		}
 		if iface.Static != nil {
 			if err := validateStaticConfig(name, iface.Static); err != nil {
 				return err
 			}
 		}
+		if err := validateAnonymize(name, iface); err != nil {
+			return err
+		}
+		if err := validateLinkSettings(name, iface); err != nil {
+			return err
+		}
+		for _, route := range iface.Routes {
+			if err := route.Validate(); err != nil {
+				return fmt.Errorf("interface %s: %w", name, err)
+			}
+		}
+		if iface.PolicyRouting != nil {
+			if err := iface.PolicyRouting.Validate(); err != nil {
+				return fmt.Errorf("interface %s: %w", name, err)
+			}
+			if other, exists := tables[iface.PolicyRouting.Table]; exists {
+				return fmt.Errorf("interface %s: policy routing table %d is already used by %s", name, iface.PolicyRouting.Table, other)
+			}
+			tables[iface.PolicyRouting.Table] = "interface " + name
+		}
+		if iface.VRF != "" {
+			if link, declared := c.Links[iface.VRF]; declared && link.Kind != links.KindVRF {
+				return fmt.Errorf("interface %s: link %s is not a VRF", name, iface.VRF)
+			} else if declared && iface.NetNS != "" {
+				return fmt.Errorf("interface %s: links are created in the namespace of the daemon, VRF %s is not in netns %s", name, iface.VRF, iface.NetNS)
+			}
+			if iface.PolicyRouting != nil {
+				return fmt.Errorf("interface %s: policy routing cannot be combined with a VRF", name)
+			}
+		}
+		if iface.HealthCheck != nil {
+			if err := iface.HealthCheck.Validate(); err != nil {
+				return fmt.Errorf("interface %s: %w", name, err)
+			}
+			if iface.Static != nil && iface.Static.Gateway == "" && iface.Static.Gateway6 == "" {
+				return fmt.Errorf("interface %s: health check requires a gateway", name)
+			}
+		}
+	}
+
+	return nil
+}
+
+func validateMatch(entry string, match *MatchConfig) error {
+	patterns := []string{entry}
+	if match != nil {
+		if *match == (MatchConfig{}) {
+			return fmt.Errorf("interface %s: match must set name, mac, driver, path or kind", entry)
+		}
+		patterns = []string{match.Name, match.MAC, match.Driver, match.Path, match.Kind}
+	}
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("interface %s: invalid pattern %q: %w", entry, pattern, err)
+		}
+	}
+	return nil
+}
+
+func validateLinkSettings(name string, iface InterfaceConfig) error {
+	hasSettings := iface.MTU != 0 || iface.MAC != "" || iface.TxQueueLen != 0 ||
+		iface.Up != nil || iface.Promiscuous != nil || iface.Alias != ""
+	if hasSettings && iface.Static == nil {
+		return fmt.Errorf("interface %s: link settings are only supported for static interfaces", name)
+	}
+	if iface.MTU != 0 && (iface.MTU < 68 || iface.MTU > 65535) {
+		return fmt.Errorf("interface %s: mtu must be between 68 and 65535", name)
+	}
+	if iface.MAC != "" {
+		if _, err := net.ParseMAC(iface.MAC); err != nil {
+			return fmt.Errorf("interface %s: invalid mac %q", name, iface.MAC)
+		}
+	}
+	if iface.TxQueueLen < 0 {
+		return fmt.Errorf("interface %s: txqueuelen must not be negative", name)
+	}
+	if iface.Up != nil && !*iface.Up && iface.HealthCheck != nil {
+		return fmt.Errorf("interface %s: health check cannot be used on an interface kept down", name)
+	}
+	return nil
+}
+
+func validateNetNS(name string, iface InterfaceConfig) error {
+	if iface.NetNS == "" {
+		return nil
+	}
+	if strings.Contains(iface.NetNS, "/") {
+		if !filepath.IsAbs(iface.NetNS) {
+			return fmt.Errorf("interface %s: netns path %q must be absolute", name, iface.NetNS)
+		}
+	} else if iface.NetNS == "." || iface.NetNS == ".." {
+		return fmt.Errorf("interface %s: invalid netns name %q", name, iface.NetNS)
 	}
+	// sysfs only shows the devices of the namespace of the daemon
+	if iface.Match != nil && (iface.Match.Driver != "" || iface.Match.Path != "") {
+		return fmt.Errorf("interface %s: driver and path cannot be matched inside a netns", name)
+	}
+	return nil
+}
 
+func validateAnonymize(name string, iface InterfaceConfig) error {
+	if iface.Anonymize && !iface.DHCP {
+		return fmt.Errorf("interface %s: anonymize is only supported with dhcp", name)
+	}
+	if iface.Anonymize && iface.Match != nil && iface.Match.MAC != "" {
+		return fmt.Errorf("interface %s: anonymize randomizes the MAC address and cannot be matched by it", name)
+	}
+	if iface.MACRandomization != "" {
+		if !iface.Anonymize {
+			return fmt.Errorf("interface %s: mac_randomization requires anonymize", name)
+		}
+		if iface.MACRandomization != "per-network" && iface.MACRandomization != "per-boot" {
+			return fmt.Errorf("interface %s: mac_randomization must be per-network or per-boot", name)
+		}
+	}
 	return nil
 }
 
-func validateStaticConfig(interfaceName string, static *StaticConfig) error {
-	if static.IP == "" {
+func validateStaticConfig(interfaceName string, staticConfig *StaticConfig) error {
+	if staticConfig.IP == "" && len(staticConfig.Addresses) == 0 {
 		return fmt.Errorf("interface %s: static IP address is required", interfaceName)
 	}
-	if static.Netmask == "" {
+	if staticConfig.IP != "" && staticConfig.Netmask == "" {
 		return fmt.Errorf("interface %s: static netmask is required", interfaceName)
 	}
+	for _, addr := range staticConfig.Addresses {
+		if err := addr.Validate(); err != nil {
+			return fmt.Errorf("interface %s: %w", interfaceName, err)
+		}
+	}
+	if staticConfig.Gateway6 != "" {
+		if gw := net.ParseIP(staticConfig.Gateway6); gw == nil || gw.To4() != nil {
+			return fmt.Errorf("interface %s: invalid IPv6 gateway address: %s", interfaceName, staticConfig.Gateway6)
+		}
+	}
+	for _, dns := range staticConfig.DNS {
+		if net.ParseIP(dns) == nil {
+			return fmt.Errorf("interface %s: invalid DNS server address: %s", interfaceName, dns)
+		}
+	}
 	return nil
 }
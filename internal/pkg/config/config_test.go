@@ -0,0 +1,520 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// loadConfig writes the YAML document to a file and loads it.
+func loadConfig(t *testing.T, document string) *Config {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(document), 0644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		t.Fatalf("Load() failed: %v", err)
+	}
+	return cfg
+}
+
+func TestLoadAddresses(t *testing.T) {
+	cfg := loadConfig(t, `
+interfaces:
+  eth0:
+    static:
+      addresses:
+        - 10.0.0.5/24
+        - address: 10.0.1.5/24
+          label: eth0:web
+          valid_lifetime: 1h
+`)
+
+	addresses := cfg.Interfaces["eth0"].Static.Addresses
+	if len(addresses) != 2 {
+		t.Fatalf("Loaded %d addresses, want 2", len(addresses))
+	}
+	if addresses[0].Address != "10.0.0.5/24" {
+		t.Errorf("Plain address = %q, want 10.0.0.5/24", addresses[0].Address)
+	}
+	if addresses[1].Address != "10.0.1.5/24" || addresses[1].Label != "eth0:web" || addresses[1].ValidLifetime != "1h" {
+		t.Errorf("Address mapping = %+v", addresses[1])
+	}
+}
+
+func TestValidateStatic(t *testing.T) {
+	tests := []struct {
+		name    string
+		static  string
+		wantErr bool
+	}{
+		{"legacy address", "{ip: 10.0.0.5, netmask: 255.255.255.0}", false},
+		{"address list", "{addresses: [10.0.0.5/24, 10.0.1.5/24]}", false},
+		{"legacy and list", "{ip: 10.0.0.5, netmask: 255.255.255.0, addresses: [10.0.1.5/24]}", false},
+		{"ipv6", "{addresses: [2001:db8::5/64], gateway6: fe80::1}", false},
+		{"ipv4 gateway6", "{addresses: [2001:db8::5/64], gateway6: 10.0.0.1}", true},
+		{"no address", "{gateway: 10.0.0.1}", true},
+		{"legacy without netmask", "{ip: 10.0.0.5}", true},
+		{"address without prefix", "{addresses: [10.0.0.5]}", true},
+		{"invalid dns", "{addresses: [10.0.0.5/24], dns: [resolver]}", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadConfig(t, "interfaces:\n  eth0:\n    static: "+tt.static+"\n")
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateRoutes(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   string
+		wantErr bool
+	}{
+		{"via gateway", "{destination: 10.1.0.0/16, gateway: 192.168.1.254}", false},
+		{"default", "{destination: default, gateway: 192.168.1.1, metric: 50}", false},
+		{"blackhole", "{destination: 10.2.0.0/16, type: blackhole, table: 100}", false},
+		{"no destination", "{gateway: 192.168.1.254}", true},
+		{"destination without prefix", "{destination: 10.1.0.0}", true},
+		{"invalid gateway", "{destination: 10.1.0.0/16, gateway: router}", true},
+		{"invalid source", "{destination: 10.1.0.0/16, source: eth0}", true},
+		{"negative metric", "{destination: 10.1.0.0/16, metric: -1}", true},
+		{"negative table", "{destination: 10.1.0.0/16, table: -1}", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadConfig(t, "interfaces:\n  eth0:\n    dhcp: true\n    routes: ["+tt.route+"]\n")
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestInterfaceNames(t *testing.T) {
+	cfg := loadConfig(t, `
+interfaces:
+  wan0: {dhcp: true}
+  lan0: {dhcp: true}
+  dmz0: {dhcp: true}
+`)
+	want := []string{"wan0", "lan0", "dmz0"}
+	if got := cfg.InterfaceNames(); !slices.Equal(got, want) {
+		t.Errorf("InterfaceNames() = %v, want file order %v", got, want)
+	}
+
+	// Configurations built in code fall back to name order
+	cfg = &Config{Interfaces: map[string]InterfaceConfig{"wan0": {}, "lan0": {}}}
+	if got := cfg.InterfaceNames(); !slices.Equal(got, []string{"lan0", "wan0"}) {
+		t.Errorf("InterfaceNames() = %v, want name order", got)
+	}
+}
+
+func TestRouteMetric(t *testing.T) {
+	cfg := loadConfig(t, `
+interfaces:
+  wan0: {dhcp: true}
+  lan0: {dhcp: true, metric: 50}
+  dmz0: {dhcp: true}
+`)
+
+	tests := []struct {
+		name  string
+		entry string
+		slot  int
+		want  int
+	}{
+		{"wan0", "wan0", 0, 100},
+		{"lan0", "lan0", 0, 50},
+		{"dmz0", "dmz0", 0, 102},
+		{"other0", "other0", 0, 100},
+		// Further interfaces of an entry are offset by the number of entries, or by one from an explicit metric
+		{"wan1", "wan0", 1, 103},
+		{"dmz2", "dmz0", 2, 108},
+		{"lan1", "lan0", 1, 51},
+	}
+
+	for _, tt := range tests {
+		if got := cfg.RouteMetric(tt.entry, tt.name, tt.slot); got != tt.want {
+			t.Errorf("RouteMetric(%s, %s, %d) = %d, want %d", tt.entry, tt.name, tt.slot, got, tt.want)
+		}
+	}
+
+	negative := loadConfig(t, "interfaces:\n  eth0: {dhcp: true, metric: -1}\n")
+	if err := negative.Validate(); err == nil {
+		t.Error("Validate() accepted a negative metric")
+	}
+}
+
+func TestValidatePolicyRouting(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{"table", "eth0: {dhcp: true, policy_routing: {table: 100}}", false},
+		{"rules", "eth0: {dhcp: true, policy_routing: {table: 100, rules: [{from: 10.10.0.0/16}, {fwmark: 0x1}]}}", false},
+		{"reserved table", "eth0: {dhcp: true, policy_routing: {table: 254}}", true},
+		{"empty rule", "eth0: {dhcp: true, policy_routing: {table: 100, rules: [{}]}}", true},
+		{"invalid source", "eth0: {dhcp: true, policy_routing: {table: 100, rules: [{from: 10.10.0.0}]}}", true},
+		{"shared table", "eth0: {dhcp: true, policy_routing: {table: 100}}\n  eth1: {dhcp: true, policy_routing: {table: 100}}", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadConfig(t, "interfaces:\n  "+tt.config+"\n")
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateHealthCheck(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{"arp", "{dhcp: true, health_check: {type: arp}}", false},
+		{"tcp", "{dhcp: true, health_check: {type: tcp, target: 192.0.2.1:443, interval: 10s}}", false},
+		{"static with gateway", "{static: {addresses: [10.0.0.5/24], gateway: 10.0.0.1}, health_check: {type: icmp}}", false},
+		{"invalid type", "{dhcp: true, health_check: {type: http}}", true},
+		{"tcp without target", "{dhcp: true, health_check: {type: tcp}}", true},
+		{"negative interval", "{dhcp: true, health_check: {type: arp, interval: -10s}}", true},
+		{"timeout exceeding interval", "{dhcp: true, health_check: {type: arp, interval: 1s, timeout: 5s}}", true},
+		{"invalid action", "{dhcp: true, health_check: {type: arp, action: reboot}}", true},
+		{"static without gateway", "{static: {addresses: [10.0.0.5/24]}, health_check: {type: arp}}", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadConfig(t, "interfaces:\n  eth0: "+tt.config+"\n")
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateMatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{"name pattern", "eth*: {dhcp: true}", false},
+		{"match", "uplink: {dhcp: true, match: {mac: \"52:54:00:*\", driver: virtio_net}}", false},
+		{"invalid name pattern", "\"eth[\": {dhcp: true}", true},
+		{"empty match", "uplink: {dhcp: true, match: {}}", true},
+		{"invalid match pattern", "uplink: {dhcp: true, match: {path: \"0000:[\"}}", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadConfig(t, "interfaces:\n  "+tt.config+"\n")
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateLinks(t *testing.T) {
+	tests := []struct {
+		name    string
+		links   string
+		wantErr bool
+	}{
+		{"vlan", "{eth0.100: {kind: vlan, parent: eth0, id: 100}}", false},
+		{"bridge", "{br0: {kind: bridge, members: [eth1, eth2]}}", false},
+		{"invalid kind", "{tun0: {kind: tun}}", true},
+		{"vlan without parent", "{vlan100: {kind: vlan, id: 100}}", true},
+		{"vlan without id", "{eth0.100: {kind: vlan, parent: eth0}}", true},
+		{"bond with parent", "{bond0: {kind: bond, parent: eth0}}", true},
+		{"name too long", "{bridge-with-a-long-name: {kind: bridge}}", true},
+		{"vrf", "{vrf-blue: {kind: vrf, table: 10}}", false},
+		{"vrf without table", "{vrf-blue: {kind: vrf}}", true},
+		{"vrfs sharing a table", "{vrf-blue: {kind: vrf, table: 10}, vrf-red: {kind: vrf, table: 10}}", true},
+		{"vxlan", "{vx0: {kind: vxlan, parent: eth0, vni: 42, remote: 239.1.1.1}}", false},
+		{"wireguard", "{wg0: {kind: wireguard, private_key_file: /etc/wg0.key, peers: [{public_key: \"xTIBA5rboUvnH4htodjb6e697QjLERt1NAB4mZqp8Dg=\", persistent_keepalive: 25s}]}}", false},
+		{"invalid wireguard peer key", "{wg0: {kind: wireguard, private_key_file: /etc/wg0.key, peers: [{public_key: key}]}}", true},
+		{"vxlan without vni", "{vx0: {kind: vxlan, remote: 239.1.1.1}}", true},
+		{"invalid tunnel address", "{gre0: {kind: gre, remote: peer}}", true},
+		{"wireguard without key", "{wg0: {kind: wireguard}}", true},
+		{"peer without public key", "{wg0: {kind: wireguard, private_key_file: /etc/wg0.key, peers: [{endpoint: 192.0.2.1:51820}]}}", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadConfig(t, "links: "+tt.links+"\ninterfaces:\n  eth0: {dhcp: true}\n")
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateVRF(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{"declared vrf", "links:\n  vrf-blue: {kind: vrf, table: 10}\ninterfaces:\n  eth0: {dhcp: true, vrf: vrf-blue}\n", false},
+		{"external vrf", "interfaces:\n  eth0: {dhcp: true, vrf: vrf-blue}\n", false},
+		{"not a vrf", "links:\n  br0: {kind: bridge}\ninterfaces:\n  eth0: {dhcp: true, vrf: br0}\n", true},
+		{"with policy routing", "interfaces:\n  eth0: {dhcp: true, vrf: vrf-blue, policy_routing: {table: 100}}\n", true},
+		{"policy routing table of a vrf", "links:\n  vrf-blue: {kind: vrf, table: 100}\ninterfaces:\n  eth0: {dhcp: true, policy_routing: {table: 100}}\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := loadConfig(t, tt.config).Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateLinkSettings(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{"static", "{static: {addresses: [10.0.0.5/24]}, mtu: 9000, mac: \"52:54:00:12:34:56\", txqueuelen: 1000, alias: uplink}", false},
+		{"kept down", "{static: {addresses: [10.0.0.5/24]}, up: false, promiscuous: true}", false},
+		{"dhcp", "{dhcp: true, mtu: 9000}", true},
+		{"mtu too small", "{static: {addresses: [10.0.0.5/24]}, mtu: 60}", true},
+		{"invalid mac", "{static: {addresses: [10.0.0.5/24]}, mac: \"52:54:00\"}", true},
+		{"negative txqueuelen", "{static: {addresses: [10.0.0.5/24]}, txqueuelen: -1}", true},
+		{"health check while down", "{static: {addresses: [10.0.0.5/24], gateway: 10.0.0.1}, up: false, health_check: {type: arp}}", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := loadConfig(t, "interfaces:\n  eth0: "+tt.config+"\n").Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateAnonymize(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{"anonymize", "{dhcp: true, anonymize: true}", false},
+		{"per-boot", "{dhcp: true, anonymize: true, mac_randomization: per-boot}", false},
+		{"static", "{static: {addresses: [10.0.0.5/24]}, anonymize: true}", true},
+		{"matched by mac", "{dhcp: true, anonymize: true, match: {mac: \"52:54:00:*\"}}", true},
+		{"policy without anonymize", "{dhcp: true, mac_randomization: per-boot}", true},
+		{"invalid policy", "{dhcp: true, anonymize: true, mac_randomization: per-hour}", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := loadConfig(t, "interfaces:\n  eth0: "+tt.config+"\n").Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateNetNS(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{"name", "eth0: {dhcp: true, netns: blue}", false},
+		{"path", "eth0: {dhcp: true, netns: /proc/1234/ns/net}", false},
+		{"relative path", "eth0: {dhcp: true, netns: netns/blue}", true},
+		{"parent directory", "eth0: {dhcp: true, netns: ..}", true},
+		{"driver match", "uplink: {dhcp: true, netns: blue, match: {driver: virtio_net}}", true},
+		{"declared vrf", "eth0: {dhcp: true, netns: blue, vrf: vrf-blue}\nlinks:\n  vrf-blue: {kind: vrf, table: 10}", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := loadConfig(t, "interfaces:\n  "+tt.config+"\n").Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestNamespaces(t *testing.T) {
+	cfg := loadConfig(t, `
+interfaces:
+  eth0: {dhcp: true}
+  eth1: {dhcp: true, netns: red}
+  eth2: {dhcp: true, netns: blue}
+  eth3: {dhcp: true, netns: red}
+`)
+	want := []string{"red", "blue"}
+	if got := cfg.Namespaces(); !slices.Equal(got, want) {
+		t.Errorf("Namespaces() = %v, want %v", got, want)
+	}
+}
+
+func TestValidateIPAM(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  string
+		wantErr bool
+	}{
+		{"ipam only", "ipam: {}\n", false},
+		{"socket", "ipam: {socket: /run/ipam.sock}\n", false},
+		{"relative socket", "ipam: {socket: ipam.sock}\n", true},
+		{"nothing configured", "logging: {level: info}\n", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := loadConfig(t, tt.config).Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	older := loadConfig(t, `
+interfaces:
+  eth0: {dhcp: true}
+  eth1: {dhcp: true, metric: 50}
+  eth2: {dhcp: true}
+  eth3: {dhcp: true}
+`)
+
+	tests := []struct {
+		name    string
+		newer   string
+		added   []string
+		removed []string
+		changed []string
+	}{
+		{"unchanged", "interfaces:\n  eth0: {dhcp: true}\n  eth1: {dhcp: true, metric: 50}\n  eth2: {dhcp: true}\n  eth3: {dhcp: true}\n", nil, nil, nil},
+		{"added and removed", "interfaces:\n  eth0: {dhcp: true}\n  eth1: {dhcp: true, metric: 50}\n  eth2: {dhcp: true}\n  eth4: {dhcp: true}\n",
+			[]string{"eth4"}, []string{"eth3"}, nil},
+		{"settings changed", "interfaces:\n  eth0: {dhcp: true, anonymize: true}\n  eth1: {dhcp: true, metric: 50}\n  eth2: {dhcp: true}\n  eth3: {dhcp: true}\n",
+			nil, nil, []string{"eth0"}},
+		// Moving entries changes the derived metric of those without an explicit one
+		{"moved", "interfaces:\n  eth1: {dhcp: true, metric: 50}\n  eth0: {dhcp: true}\n  eth2: {dhcp: true}\n  eth3: {dhcp: true}\n",
+			nil, nil, []string{"eth0"}},
+		{"shifted by a removal", "interfaces:\n  eth1: {dhcp: true, metric: 50}\n  eth2: {dhcp: true}\n  eth3: {dhcp: true}\n",
+			nil, []string{"eth0"}, []string{"eth2", "eth3"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, removed, changed := older.Diff(loadConfig(t, tt.newer))
+			if !slices.Equal(added, tt.added) || !slices.Equal(removed, tt.removed) || !slices.Equal(changed, tt.changed) {
+				t.Errorf("Diff() = %v, %v, %v, want %v, %v, %v", added, removed, changed, tt.added, tt.removed, tt.changed)
+			}
+		})
+	}
+}
+
+func TestDiffVRFMembers(t *testing.T) {
+	interfaces := "interfaces:\n  eth0: {dhcp: true}\n  en*: {dhcp: true}\n  uplink: {dhcp: true, match: {mac: \"52:54:00:*\"}}\n  eth9: {dhcp: true, vrf: blue}\n"
+	older := loadConfig(t, "links:\n  blue: {kind: vrf, table: 10, members: [eth0]}\n"+interfaces)
+
+	tests := []struct {
+		name    string
+		links   string
+		changed []string
+	}{
+		{"unchanged", "links:\n  blue: {kind: vrf, table: 10, members: [eth0]}\n", nil},
+		{"other table", "links:\n  blue: {kind: vrf, table: 20, members: [eth0]}\n", nil},
+		// Entries matching by attributes may match any member
+		{"member left", "links:\n  blue: {kind: vrf, table: 10}\n", []string{"eth0", "uplink"}},
+		{"member moved", "links:\n  blue: {kind: vrf, table: 10}\n  red: {kind: vrf, table: 20, members: [eth0]}\n", []string{"eth0", "uplink"}},
+		{"pattern member joined", "links:\n  blue: {kind: vrf, table: 10, members: [eth0, en1]}\n", []string{"en*", "uplink"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			added, removed, changed := older.Diff(loadConfig(t, tt.links+interfaces))
+			if len(added) > 0 || len(removed) > 0 || !slices.Equal(changed, tt.changed) {
+				t.Errorf("Diff() = %v, %v, %v, want only %v changed", added, removed, changed, tt.changed)
+			}
+		})
+	}
+}
+
+func TestVRFNames(t *testing.T) {
+	tests := []struct {
+		name  string
+		links string
+		want  []string
+	}{
+		{"no links", "", nil},
+		{"vrfs", "links:\n  red: {kind: vrf, table: 20}\n  blue: {kind: vrf, table: 10}\n", []string{"blue", "red"}},
+		{"other kinds", "links:\n  br0: {kind: bridge}\n  blue: {kind: vrf, table: 10}\n", []string{"blue"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := loadConfig(t, tt.links+"interfaces:\n  eth0: {dhcp: true}\n")
+			if got := cfg.VRFNames(); !slices.Equal(got, tt.want) {
+				t.Errorf("VRFNames() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateControlSocket(t *testing.T) {
+	if err := loadConfig(t, "control_socket: /run/control.sock\ninterfaces:\n  eth0: {dhcp: true}\n").Validate(); err != nil {
+		t.Errorf("Validate() = %v, want no error", err)
+	}
+	if err := loadConfig(t, "control_socket: control.sock\ninterfaces:\n  eth0: {dhcp: true}\n").Validate(); err == nil {
+		t.Error("Validate() accepted a relative control socket")
+	}
+}
+
+func TestValidateMetrics(t *testing.T) {
+	tests := []struct {
+		name    string
+		listen  string
+		wantErr bool
+	}{
+		{"address", "127.0.0.1:9420", false},
+		{"any address", ":9420", false},
+		{"no port", "127.0.0.1", true},
+		{"empty port", "127.0.0.1:", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := loadConfig(t, "metrics: {listen: \""+tt.listen+"\"}\ninterfaces:\n  eth0: {dhcp: true}\n").Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
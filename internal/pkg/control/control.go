@@ -0,0 +1,231 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang-dhcpcd/internal/pkg/logging"
+
+	"golang.org/x/sys/unix"
+)
+
+// DefaultSocket is where the daemon listens for ctl commands unless configured otherwise.
+const DefaultSocket = "/run/golang-dhcpcd/control.sock"
+
+// timeout limits how long a command may take, the connection gets a few more seconds for the response.
+const (
+	timeout     = 30 * time.Second
+	connTimeout = timeout + 5*time.Second
+)
+
+// ErrUnreachable reports that no daemon answers on the socket.
+var ErrUnreachable = errors.New("daemon not reachable")
+
+// ErrDenied reports that the caller may not control the daemon.
+var ErrDenied = errors.New("permission denied")
+
+// Request is sent by ctl to the daemon, one per connection.
+type Request struct {
+	Command string          `json:"command"`
+	Args    json.RawMessage `json:"args,omitempty"`
+}
+
+// Response is the answer of the daemon, carrying either the result of the command or an error.
+type Response struct {
+	Result json.RawMessage `json:"result,omitempty"`
+	Error  string          `json:"error,omitempty"`
+	Denied bool            `json:"denied,omitempty"`
+}
+
+// Handler executes a command with its arguments and returns a result to be encoded as JSON.
+type Handler func(ctx context.Context, args json.RawMessage) (any, error)
+
+// Server answers ctl commands on a unix socket.
+type Server struct {
+	path     string
+	mu       sync.Mutex
+	handlers map[string]Handler
+}
+
+// NewServer creates a server listening on the socket path, DefaultSocket if empty.
+func NewServer(path string) *Server {
+	if path == "" {
+		path = DefaultSocket
+	}
+	return &Server{path: path, handlers: make(map[string]Handler)}
+}
+
+// Handle registers the handler of a command.
+func (s *Server) Handle(command string, handler Handler) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.handlers[command] = handler
+}
+
+// Run listens on the socket path and answers commands until the context is cancelled.
+func (s *Server) Run(ctx context.Context) error {
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	// A socket left behind by a previous run refuses connections
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	listener, err := net.Listen("unix", s.path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.path, err)
+	}
+	if err := os.Chmod(s.path, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to restrict socket permissions: %w", err)
+	}
+
+	s.Serve(ctx, listener)
+	os.Remove(s.path)
+	return nil
+}
+
+// Serve answers commands on the listener until the context is cancelled, e.g. on a socket passed
+// by systemd. The listener is closed on return.
+func (s *Server) Serve(ctx context.Context, listener net.Listener) {
+	logger := logging.WithComponent("control").WithField("socket", listener.Addr().String())
+	logger.Info("Listening for control commands")
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	var wg sync.WaitGroup
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				logger.WithError(err).Error("Failed to accept connection")
+			}
+			break
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serve(ctx, conn)
+		}()
+	}
+	wg.Wait()
+}
+
+// serve answers the command of one connection.
+func (s *Server) serve(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(connTimeout))
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	var request Request
+	var response Response
+	// The request is read in any case, so that a rejected caller gets the response
+	if err := json.NewDecoder(conn).Decode(&request); err != nil {
+		response.Error = fmt.Sprintf("failed to decode request: %v", err)
+	} else if err := checkPeer(conn); err != nil {
+		logging.WithComponent("control").WithError(err).WithField("command", request.Command).Warn("Rejected control command")
+		response.Error = err.Error()
+		response.Denied = true
+	} else if result, err := s.handle(ctx, request); err != nil {
+		response.Error = err.Error()
+	} else if response.Result, err = json.Marshal(result); err != nil {
+		response.Error = fmt.Sprintf("failed to encode result: %v", err)
+	}
+
+	if err := json.NewEncoder(conn).Encode(response); err != nil {
+		logging.WithComponent("control").WithError(err).Warn("Failed to send response")
+	}
+}
+
+// checkPeer admits root and the user the daemon runs as. The socket is only accessible to its
+// owner, unless its permissions were widened.
+func checkPeer(conn net.Conn) error {
+	unixConn, ok := conn.(*net.UnixConn)
+	if !ok {
+		return errors.New("not a unix connection")
+	}
+	raw, err := unixConn.SyscallConn()
+	if err != nil {
+		return fmt.Errorf("failed to access connection: %w", err)
+	}
+
+	var cred *unix.Ucred
+	var credErr error
+	if err := raw.Control(func(fd uintptr) {
+		cred, credErr = unix.GetsockoptUcred(int(fd), unix.SOL_SOCKET, unix.SO_PEERCRED)
+	}); err != nil {
+		return fmt.Errorf("failed to access connection: %w", err)
+	}
+	if credErr != nil {
+		return fmt.Errorf("failed to get peer credentials: %w", credErr)
+	}
+
+	if cred.Uid != 0 && int(cred.Uid) != os.Getuid() {
+		return fmt.Errorf("%w for uid %d", ErrDenied, cred.Uid)
+	}
+	return nil
+}
+
+// handle executes a command.
+func (s *Server) handle(ctx context.Context, request Request) (any, error) {
+	s.mu.Lock()
+	handler, exists := s.handlers[request.Command]
+	s.mu.Unlock()
+	if !exists {
+		return nil, fmt.Errorf("unknown command %q", request.Command)
+	}
+
+	logging.WithComponent("control").WithField("command", request.Command).Debug("Executing command")
+	return handler(ctx, request.Args)
+}
+
+// Call sends a command to the daemon listening on the socket, DefaultSocket if empty, and returns its result.
+func Call(socket, command string, args any) (json.RawMessage, error) {
+	if socket == "" {
+		socket = DefaultSocket
+	}
+	request := Request{Command: command}
+	if args != nil {
+		data, err := json.Marshal(args)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode arguments: %w", err)
+		}
+		request.Args = data
+	}
+
+	conn, err := net.DialTimeout("unix", socket, timeout)
+	if errors.Is(err, os.ErrPermission) {
+		return nil, fmt.Errorf("%w: %s", ErrDenied, socket)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrUnreachable, err)
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(connTimeout))
+
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	var response Response
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	if response.Denied {
+		return nil, fmt.Errorf("%w by daemon", ErrDenied)
+	}
+	if response.Error != "" {
+		return nil, errors.New(response.Error)
+	}
+	return response.Result, nil
+}
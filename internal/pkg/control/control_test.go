@@ -0,0 +1,81 @@
+package control
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestCall(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "control.sock")
+	server := NewServer(socket)
+	server.Handle("echo", func(ctx context.Context, args json.RawMessage) (any, error) {
+		var text string
+		if err := json.Unmarshal(args, &text); err != nil {
+			return nil, err
+		}
+		return map[string]string{"text": text}, nil
+	})
+	server.Handle("fail", func(ctx context.Context, args json.RawMessage) (any, error) {
+		return nil, errors.New("reload failed")
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- server.Run(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run() failed: %v", err)
+		}
+	}()
+
+	// Wait for the server to listen
+	deadline := time.Now().Add(5 * time.Second)
+	for _, err := Call(socket, "echo", "ping"); err != nil; _, err = Call(socket, "echo", "ping") {
+		if time.Now().After(deadline) {
+			t.Fatalf("Server did not answer: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	tests := []struct {
+		name    string
+		command string
+		args    any
+		want    string
+		wantErr string
+	}{
+		{"result", "echo", "hello", `{"text":"hello"}`, ""},
+		{"handler error", "fail", nil, "", "reload failed"},
+		{"unknown command", "restart", nil, "", `unknown command "restart"`},
+		{"invalid arguments", "echo", 42, "", "json: cannot unmarshal number into Go value of type string"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := Call(socket, tt.command, tt.args)
+			if tt.wantErr != "" {
+				if err == nil || err.Error() != tt.wantErr {
+					t.Errorf("Call() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Call() failed: %v", err)
+			}
+			if string(result) != tt.want {
+				t.Errorf("Call() = %s, want %s", result, tt.want)
+			}
+		})
+	}
+}
+
+func TestCallWithoutDaemon(t *testing.T) {
+	if _, err := Call(filepath.Join(t.TempDir(), "missing.sock"), "reload", nil); !errors.Is(err, ErrUnreachable) {
+		t.Errorf("Call() = %v, want %v", err, ErrUnreachable)
+	}
+}
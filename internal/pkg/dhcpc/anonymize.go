@@ -0,0 +1,127 @@
+package dhcpc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang-dhcpcd/internal/pkg/inventory"
+	"golang-dhcpcd/internal/pkg/logging"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/vishvananda/netlink"
+)
+
+// MAC address randomization policies of anonymous clients.
+const (
+	// MACPerNetwork picks a new MAC address for every network attachment, i.e. after every carrier loss.
+	MACPerNetwork = "per-network"
+	// MACPerBoot keeps one random MAC address until the state directory is cleared, which happens
+	// on every boot with the default state directory in /run.
+	MACPerBoot = "per-boot"
+)
+
+// randomMAC returns a random unicast, locally administered MAC address.
+func randomMAC() (net.HardwareAddr, error) {
+	mac := make(net.HardwareAddr, 6)
+	if _, err := rand.Read(mac); err != nil {
+		return nil, fmt.Errorf("failed to generate MAC address: %w", err)
+	}
+	mac[0] = mac[0]&^0x01 | 0x02
+	return mac, nil
+}
+
+// bootMAC returns the random MAC address of the interface for this boot, creating it if needed.
+func (c *Client) bootMAC() (net.HardwareAddr, error) {
+	path := filepath.Join(inventory.StateDir(), "mac", c.ns.Qualify(c.Iface.Name))
+
+	data, err := os.ReadFile(path)
+	if err == nil {
+		if mac, err := net.ParseMAC(strings.TrimSpace(string(data))); err == nil {
+			return mac, nil
+		}
+	} else if !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read MAC address %s: %w", path, err)
+	}
+
+	mac, err := randomMAC()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create state directory: %w", err)
+	}
+	if err := os.WriteFile(path, []byte(mac.String()+"\n"), 0644); err != nil {
+		return nil, fmt.Errorf("failed to write MAC address %s: %w", path, err)
+	}
+	return mac, nil
+}
+
+// randomizeMAC gives the interface the random MAC address of the configured policy, so that no
+// stable identifier is exposed to the network (RFC 7844, section 5).
+func (c *Client) randomizeMAC() error {
+	logger := logging.WithComponentAndInterface("dhcp", c.Iface.Name)
+
+	var mac net.HardwareAddr
+	var err error
+	if c.config.MACPolicy == MACPerBoot {
+		mac, err = c.bootMAC()
+	} else {
+		mac, err = randomMAC()
+	}
+	if err != nil {
+		return err
+	}
+
+	link, err := netlink.LinkByName(c.Iface.Name)
+	if err != nil {
+		return fmt.Errorf("failed to get netlink interface: %w", err)
+	}
+	if bytes.Equal(link.Attrs().HardwareAddr, mac) {
+		return nil
+	}
+
+	// Most drivers only accept a new MAC address while the link is down
+	if err := netlink.LinkSetDown(link); err != nil {
+		return fmt.Errorf("failed to bring interface down: %w", err)
+	}
+	if err := netlink.LinkSetHardwareAddr(link, mac); err != nil {
+		return fmt.Errorf("failed to set MAC address: %w", err)
+	}
+	if err := netlink.LinkSetUp(link); err != nil {
+		return fmt.Errorf("failed to bring interface up: %w", err)
+	}
+
+	iface, err := net.InterfaceByName(c.Iface.Name)
+	if err != nil {
+		return fmt.Errorf("interface not found: %w", err)
+	}
+	c.mu.Lock()
+	c.Iface = iface
+	c.mu.Unlock()
+
+	logger.WithField("mac", mac.String()).WithField("policy", c.macPolicy()).Info("Randomized MAC address")
+	return nil
+}
+
+// macPolicy returns the randomization policy in use.
+func (c *Client) macPolicy() string {
+	if c.config.MACPolicy == "" {
+		return MACPerNetwork
+	}
+	return c.config.MACPolicy
+}
+
+// requestedOptions returns the parameter request list of the client. Anonymous clients keep to the
+// minimal list every client of the library sends (RFC 7844, section 3.7).
+func (c *Client) requestedOptions() dhcpv4.Modifier {
+	if c.config.Anonymize {
+		return dhcpv4.WithRequestedOptions()
+	}
+	return requestedOptions
+}
@@ -0,0 +1,111 @@
+package dhcpc
+
+import (
+	"bytes"
+	"net"
+	"testing"
+
+	"golang-dhcpcd/internal/pkg/inventory"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+)
+
+func TestRandomMAC(t *testing.T) {
+	seen := make(map[string]bool)
+	for i := 0; i < 100; i++ {
+		mac, err := randomMAC()
+		if err != nil {
+			t.Fatalf("randomMAC() failed: %v", err)
+		}
+		if len(mac) != 6 {
+			t.Fatalf("randomMAC() = %s, want 6 bytes", mac)
+		}
+		if mac[0]&0x01 != 0 {
+			t.Errorf("randomMAC() = %s is a multicast address", mac)
+		}
+		if mac[0]&0x02 == 0 {
+			t.Errorf("randomMAC() = %s is not locally administered", mac)
+		}
+		seen[mac.String()] = true
+	}
+	if len(seen) < 100 {
+		t.Errorf("randomMAC() returned only %d distinct addresses out of 100", len(seen))
+	}
+}
+
+func TestBootMAC(t *testing.T) {
+	inventory.SetStateDir(t.TempDir())
+	t.Cleanup(func() { inventory.SetStateDir("") })
+
+	client := &Client{Iface: &net.Interface{Name: "eth0"}}
+	first, err := client.bootMAC()
+	if err != nil {
+		t.Fatalf("bootMAC() failed: %v", err)
+	}
+	second, err := client.bootMAC()
+	if err != nil {
+		t.Fatalf("bootMAC() failed: %v", err)
+	}
+	if !bytes.Equal(first, second) {
+		t.Errorf("bootMAC() = %s then %s, want the same address for the boot", first, second)
+	}
+
+	other, err := (&Client{Iface: &net.Interface{Name: "eth1"}}).bootMAC()
+	if err != nil {
+		t.Fatalf("bootMAC() failed: %v", err)
+	}
+	if bytes.Equal(first, other) {
+		t.Errorf("bootMAC() returned %s for two interfaces", first)
+	}
+}
+
+func TestMACPolicy(t *testing.T) {
+	tests := []struct {
+		policy string
+		want   string
+	}{
+		{"", MACPerNetwork},
+		{MACPerNetwork, MACPerNetwork},
+		{MACPerBoot, MACPerBoot},
+	}
+
+	for _, tt := range tests {
+		client := &Client{config: Config{Anonymize: true, MACPolicy: tt.policy}}
+		if got := client.macPolicy(); got != tt.want {
+			t.Errorf("macPolicy() with %q = %s, want %s", tt.policy, got, tt.want)
+		}
+	}
+}
+
+func TestRequestedOptions(t *testing.T) {
+	tests := []struct {
+		name      string
+		anonymize bool
+		want      []dhcpv4.OptionCode
+		wantNot   []dhcpv4.OptionCode
+	}{
+		{"default", false, []dhcpv4.OptionCode{dhcpv4.OptionDomainNameServer, dhcpv4.OptionDNSDomainSearchList}, nil},
+		{"anonymous", true, nil, []dhcpv4.OptionCode{dhcpv4.OptionDNSDomainSearchList}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := &Client{config: Config{Anonymize: tt.anonymize}}
+			msg, err := dhcpv4.New(client.requestedOptions())
+			if err != nil {
+				t.Fatalf("dhcpv4.New() failed: %v", err)
+			}
+			requested := msg.ParameterRequestList()
+			for _, code := range tt.want {
+				if !requested.Has(code) {
+					t.Errorf("Parameter request list %v lacks %s", requested, code)
+				}
+			}
+			for _, code := range tt.wantNot {
+				if requested.Has(code) {
+					t.Errorf("Parameter request list %v has %s", requested, code)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package dhcpc
+
+import (
+	"context"
+	"time"
+
+	"golang-dhcpcd/internal/pkg/logging"
+	"golang-dhcpcd/internal/pkg/netmon"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// carrierPollInterval is how often the carrier is checked in case netlink events are unavailable.
+const carrierPollInterval = 5 * time.Second
+
+// hasCarrier reports whether the link is up and its lower layer reports carrier.
+func hasCarrier(link netlink.Link) bool {
+	flags := link.Attrs().RawFlags
+	return flags&unix.IFF_UP != 0 && flags&unix.IFF_LOWER_UP != 0
+}
+
+// carrierLost reports whether the event is a link update without carrier.
+func carrierLost(event netmon.Event) bool {
+	return event.Link != nil && !hasCarrier(event.Link)
+}
+
+// waitForCarrier brings the link up if needed and waits until it reports carrier. Without a carrier
+// timeout it waits indefinitely; once the timeout has passed it gives up waiting and proceeds, for
+// drivers that never report carrier. It reports false if the context was cancelled.
+func (c *Client) waitForCarrier(ctx context.Context, events <-chan netmon.Event) bool {
+	logger := logging.WithComponentAndInterface("dhcp", c.Iface.Name)
+
+	link, err := netlink.LinkByName(c.Iface.Name)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to get netlink interface, not waiting for carrier")
+		return ctx.Err() == nil
+	}
+
+	// Bring the interface up if needed
+	if link.Attrs().RawFlags&unix.IFF_UP == 0 {
+		logger.Info("Interface is down, bringing it up")
+		if err := netlink.LinkSetUp(link); err != nil {
+			logger.WithError(err).Warn("Failed to bring interface up")
+		}
+	}
+	if hasCarrier(link) {
+		return ctx.Err() == nil
+	}
+
+	logger.WithField("timeout", c.config.CarrierTimeout).Info("Waiting for carrier")
+	start := time.Now()
+
+	var timeout <-chan time.Time
+	if c.config.CarrierTimeout > 0 {
+		timer := time.NewTimer(c.config.CarrierTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	poll := time.NewTicker(carrierPollInterval)
+	defer poll.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-timeout:
+			logger.Warn("No carrier within timeout, trying DHCP anyway")
+			return true
+		case <-events:
+		case <-poll.C:
+		}
+
+		link, err := netlink.LinkByName(c.Iface.Name)
+		if err != nil {
+			continue
+		}
+		if hasCarrier(link) {
+			logger.WithField("waited", time.Since(start).Round(time.Millisecond)).Info("Carrier detected")
+			return true
+		}
+	}
+}
+
+// sleepUntilCarrierLoss waits for the given duration and reports true if the carrier was lost first,
+// or returns a request that arrived first. The link is checked again on loss events, as events
+// queued before e.g. a MAC address change may be stale by now.
+func (c *Client) sleepUntilCarrierLoss(ctx context.Context, events <-chan netmon.Event, d time.Duration) (bool, *request) {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false, nil
+		case <-timer.C:
+			return false, nil
+		case req := <-c.requests:
+			return false, req
+		case event := <-events:
+			if !carrierLost(event) {
+				continue
+			}
+			if link, err := netlink.LinkByName(c.Iface.Name); err != nil || !hasCarrier(link) {
+				return true, nil
+			}
+		}
+	}
+}
@@ -0,0 +1,42 @@
+package dhcpc
+
+import (
+	"testing"
+
+	"golang-dhcpcd/internal/pkg/netmon"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestCarrier(t *testing.T) {
+	tests := []struct {
+		name  string
+		flags uint32
+		want  bool
+	}{
+		{"down", 0, false},
+		{"up without carrier", unix.IFF_UP, false},
+		{"carrier while down", unix.IFF_LOWER_UP, false},
+		{"up with carrier", unix.IFF_UP | unix.IFF_LOWER_UP | unix.IFF_RUNNING, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link := &netlink.Device{LinkAttrs: netlink.LinkAttrs{Name: "eth0", RawFlags: tt.flags}}
+			if got := hasCarrier(link); got != tt.want {
+				t.Errorf("hasCarrier() = %v, want %v", got, tt.want)
+			}
+
+			event := netmon.Event{Link: &netlink.LinkUpdate{Link: link}}
+			if got := carrierLost(event); got != !tt.want {
+				t.Errorf("carrierLost() = %v, want %v", got, !tt.want)
+			}
+		})
+	}
+
+	// Only link updates report the carrier
+	if carrierLost(netmon.Event{Addr: &netlink.AddrUpdate{LinkIndex: 2}}) {
+		t.Error("carrierLost() = true for an address event")
+	}
+}
@@ -2,166 +2,651 @@ package dhcpc
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"net"
-	"os"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
+	"golang-dhcpcd/internal/pkg/health"
+	"golang-dhcpcd/internal/pkg/inventory"
+	"golang-dhcpcd/internal/pkg/links"
 	"golang-dhcpcd/internal/pkg/logging"
+	"golang-dhcpcd/internal/pkg/metrics"
+	"golang-dhcpcd/internal/pkg/namespace"
+	"golang-dhcpcd/internal/pkg/netmon"
+	"golang-dhcpcd/internal/pkg/policy"
+	"golang-dhcpcd/internal/pkg/resolver"
+	"golang-dhcpcd/internal/pkg/routes"
 
 	"github.com/insomniacslk/dhcp/dhcpv4"
 	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
 // Client wraps the dhcpv4 client for a network interface.
 type Client struct {
 	Iface *net.Interface
+
+	config       Config
+	ns           *namespace.Namespace
+	resolver     *resolver.File
+	inventory    *inventory.Inventory
+	defaultRoute *netlink.Route
+	probeRoute   *netlink.Route
+	routes       []*netlink.Route
+	policyRoutes []*netlink.Route
+	vrfTable     int
+
+	// bound is closed once the first lease is bound
+	bound     chan struct{}
+	boundOnce sync.Once
+
+	// requests carries the operations asked for through Renew, Rebind and Release to the lease
+	// loop, stopped is closed once Run returned
+	requests chan *request
+	stopped  chan struct{}
+
+	// mu serializes lease application and the reaction to gateway health changes
+	mu          sync.Mutex
+	lease       *nclient4.Lease
+	suspect     bool
+	released    bool
+	health      *health.Checker
+	stopHealth  context.CancelFunc
+	gateway     net.IP
+	gatewayDown bool
+}
+
+// Config represents DHCP client configuration parameters.
+type Config struct {
+	Metric    int            `yaml:"metric"`
+	Exclusive bool           `yaml:"exclusive,omitempty"`
+	Routes    []routes.Route `yaml:"routes,omitempty"`
+
+	// PolicyRouting installs the lease into a dedicated routing table when set
+	PolicyRouting *policy.Config `yaml:"policy_routing,omitempty"`
+
+	// HealthCheck probes the gateway of the lease and fails over the default route when set
+	HealthCheck *health.Config `yaml:"health_check,omitempty"`
+
+	// CarrierTimeout limits how long the client waits for carrier before trying DHCP anyway,
+	// it waits indefinitely when unset
+	CarrierTimeout time.Duration `yaml:"carrier_timeout,omitempty"`
+
+	// VRF enslaves the interface to the named VRF and installs the routes into its table when set
+	VRF string `yaml:"vrf,omitempty"`
+
+	// DeclaredVRFs lists the VRFs declared in the configuration. Without VRF the interface only
+	// leaves those, or the VRF it joined itself, other VRFs are managed elsewhere.
+	DeclaredVRFs []string `yaml:"-"`
+
+	// Anonymize follows the anonymity profile of RFC 7844: a random MAC address, the minimal
+	// parameter request list and no lease reuse across attachments. The client never sends a
+	// hostname or client identifier.
+	Anonymize bool `yaml:"anonymize,omitempty"`
+
+	// MACPolicy is MACPerNetwork or MACPerBoot, it defaults to MACPerNetwork
+	MACPolicy string `yaml:"mac_policy,omitempty"`
+
+	// LeaseOnly maintains the lease without configuring the interface, for callers that configure
+	// it themselves from Lease, e.g. CNI plugins
+	LeaseOnly bool `yaml:"lease_only,omitempty"`
+
+	// OneShot returns from Run once the first lease is applied, leaving the interface configured
+	// without maintaining it. Run fails if no lease was bound before the context ends.
+	OneShot bool `yaml:"oneshot,omitempty"`
+
+	// Permanent installs the lease address without lifetimes, so that it outlives a lease nobody
+	// renews. Otherwise the kernel removes the address when the lease expires.
+	Permanent bool `yaml:"permanent,omitempty"`
+
+	// Lease resumes a lease obtained before, e.g. by a previous run of the daemon. It is verified
+	// with INIT-REBOOT like a lease kept across a carrier loss.
+	Lease *nclient4.Lease `yaml:"-"`
 }
 
-// NewClient creates a new DHCP client for the given interface name.
-func NewClient(ifaceName string) (*Client, error) {
+// ErrRelease is the cause to cancel Run with to release the lease to the server on return.
+var ErrRelease = errors.New("lease released")
+
+// NewClient creates a new DHCP client for the given interface name in the network namespace
+// of the context.
+func NewClient(ctx context.Context, ifaceName string) (*Client, error) {
 	iface, err := net.InterfaceByName(ifaceName)
 	if err != nil {
 		return nil, fmt.Errorf("interface not found: %w", err)
 	}
-	return &Client{Iface: iface}, nil
+	ns := namespace.FromContext(ctx)
+	inv, err := inventory.Open(ns.Qualify(ifaceName))
+	if err != nil {
+		return nil, err
+	}
+	return &Client{Iface: iface, ns: ns, resolver: resolver.For(ns.ResolvConf()), inventory: inv,
+		bound: make(chan struct{}), requests: make(chan *request), stopped: make(chan struct{})}, nil
 }
 
 // Run starts and maintains DHCP lease on the interface using the nclient4 library.
-func (c *Client) Run() error {
+// It returns when the context is cancelled, removing the routes it installed, and releasing the
+// lease if the cause is ErrRelease. One-shot clients return as soon as the lease is applied.
+func (c *Client) Run(ctx context.Context, config Config) error {
 	logger := logging.WithComponentAndInterface("dhcp", c.Iface.Name).WithField("mac", c.Iface.HardwareAddr.String())
 	logger.Info("Starting DHCP client")
 
-	const maxRetries = 3
-	const retryDelay = 2 * time.Second
+	c.config = config
+	if config.Lease != nil {
+		c.mu.Lock()
+		c.lease = config.Lease
+		c.mu.Unlock()
+	}
+	defer close(c.stopped)
+	defer func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		// A one-shot lease stays configured
+		if c.config.OneShot && c.lease != nil && ctx.Err() == nil {
+			return
+		}
+		c.removeRoutes()
+		if errors.Is(context.Cause(ctx), ErrRelease) && c.lease != nil {
+			c.releaseLease(c.lease)
+		}
+	}()
 
-	for {
-		var lease *dhcpv4.DHCPv4
+	// Repair the lease configuration when the interface changes
+	if !c.config.LeaseOnly && !c.config.OneShot {
+		namespace.Go(ctx, func() { c.watchLease(ctx) })
+	}
 
-		// Retry DISCOVER/OFFER up to maxRetries times
-		for attempt := 1; attempt <= maxRetries; attempt++ {
-			logger.WithField("attempt", fmt.Sprintf("%d/%d", attempt, maxRetries)).Debug("Attempting to get DHCP lease")
+	// Follow carrier changes of the interface
+	events, err := netmon.Subscribe(ctx, c.Iface.Index)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to subscribe to netlink events, carrier changes are not detected")
+	}
 
-			// Create DHCP client using the nclient4 library
-			client, err := nclient4.New(c.Iface.Name, nclient4.WithTimeout(15*time.Second))
-			if err != nil {
-				logger.WithError(err).Error("Failed to create DHCP client")
-				if attempt < maxRetries {
-					logger.WithField("delay", retryDelay).Debug("Retrying...")
-					time.Sleep(retryDelay)
-					continue
-				}
-				return fmt.Errorf("failed to create DHCP client after %d attempts: %w", maxRetries, err)
+	// pending is a renew or rebind request answered once a lease is bound or discovery failed
+	var pending *request
+	attached := false
+	for ctx.Err() == nil {
+		// A released lease is only acquired again on request
+		c.mu.Lock()
+		released := c.released
+		c.mu.Unlock()
+		if released {
+			logger.Info("Lease released, waiting for a renew or rebind request")
+			if pending = c.awaitAcquire(ctx); pending == nil {
+				return c.interrupted(ctx)
 			}
-			defer client.Close()
+		}
 
-			logger.Debug("Created DHCP client")
+		// Anonymous clients attach to every network with a random MAC address
+		if c.config.Anonymize && !attached {
+			if err := c.randomizeMAC(); err != nil {
+				logger.WithError(err).Warn("Failed to randomize MAC address")
+			}
+		}
+		attached = true
 
-			// Perform DHCP DISCOVER/OFFER exchange
-			offer, err := client.DiscoverOffer(context.Background())
-			if err != nil {
-				logger.WithError(err).WithField("attempt", attempt).Error("DISCOVER/OFFER failed")
-				client.Close()
-				if attempt < maxRetries {
-					logger.WithField("delay", retryDelay).Debug("Retrying...")
-					time.Sleep(retryDelay)
-					continue
-				}
+		// Bring the link up and wait for carrier
+		if !c.waitForCarrier(ctx, events) {
+			return c.interrupted(ctx)
+		}
+
+		// Verify a lease we still hold, or obtain a new one
+		lease, err := c.acquireLease(ctx)
+		if errors.Is(err, errNoLease) {
+			pending.reply(err)
+			pending = nil
+			if req := c.awaitRequest(ctx, 30*time.Second); req != nil && req.op == opRelease {
+				c.mu.Lock()
+				c.released = true
+				c.mu.Unlock()
+				req.reply(nil)
 			} else {
-				lease = offer
-				logger.WithFields(map[string]interface{}{
-					"attempt": attempt,
-					"ip":      offer.YourIPAddr.String(),
-				}).Info("Successfully received OFFER")
-				client.Close()
-				break
+				pending = req
+			}
+			continue
+		}
+		if err != nil {
+			pending.reply(err)
+			return err
+		}
+		if lease == nil {
+			return c.interrupted(ctx)
+		}
+		err = c.bindLease(ctx, lease)
+		pending.reply(nil)
+		pending = nil
+		if c.config.OneShot {
+			if err != nil {
+				c.dropLease()
 			}
+			return err
 		}
 
-		// If no valid offer received after all retries, wait and restart
+		// Keep the lease until it is lost or the carrier drops
+		c.maintainLease(ctx, events)
+
+		// The next carrier may belong to another network
+		c.mu.Lock()
+		attached = !c.suspect
+		c.mu.Unlock()
+	}
+
+	return c.interrupted(ctx)
+}
+
+// interrupted is the result of Run when the context ended, an error for one-shot clients as they
+// return before once bound.
+func (c *Client) interrupted(ctx context.Context) error {
+	if c.config.OneShot {
+		return fmt.Errorf("no lease bound: %w", context.Cause(ctx))
+	}
+	return nil
+}
+
+// Lease returns the current lease, nil while the client holds none.
+func (c *Client) Lease() *nclient4.Lease {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lease
+}
+
+// WaitLease waits until the client bound its first lease and returns the current lease, which
+// may be nil again if it was lost since.
+func (c *Client) WaitLease(ctx context.Context) (*nclient4.Lease, error) {
+	select {
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	case <-c.bound:
+		return c.Lease(), nil
+	}
+}
+
+// acquireLease verifies a lease that is still valid with INIT-REBOOT, keeping it if no server answers,
+// and otherwise obtains a new lease. It returns nil without an error if the context was cancelled.
+func (c *Client) acquireLease(ctx context.Context) (*nclient4.Lease, error) {
+	logger := logging.WithComponentAndInterface("dhcp", c.Iface.Name)
+
+	c.mu.Lock()
+	previous := c.lease
+	c.mu.Unlock()
+
+	// INIT-REBOOT would tell the next network which address we had on the previous one
+	if previous != nil && c.config.Anonymize {
+		logger.Info("Dropping lease of the previous attachment, anonymous clients do not reuse leases")
+		c.dropLease()
+		previous = nil
+	}
+
+	if previous != nil {
+		if _, _, expiry := leaseTimes(previous); time.Now().Before(expiry) {
+			logger.WithField("ip", previous.ACK.YourIPAddr.String()).Info("Verifying lease with INIT-REBOOT")
+			start := time.Now()
+			lease, err := c.rebootLease(ctx, previous)
+			c.observe(ctx, metrics.ExchangeInitReboot, start, err)
+			switch {
+			case err == nil:
+				logger.WithField("ip", lease.ACK.YourIPAddr.String()).Info("Lease confirmed")
+				return lease, nil
+			case ctx.Err() != nil:
+				return nil, nil
+			case isNak(err):
+				logger.WithError(err).Warn("Lease is not valid on this network, restarting discovery")
+				c.fail(metrics.ReasonNak)
+				c.dropLease()
+			default:
+				// RFC 2131 allows using the lease until it expires if no server answers
+				logger.WithError(err).Warn("No answer to INIT-REBOOT, keeping lease")
+				return previous, nil
+			}
+		} else {
+			logger.Info("Lease expired, restarting discovery")
+			c.fail(metrics.ReasonExpired)
+			c.dropLease()
+		}
+	}
+
+	return c.obtainLease(ctx)
+}
+
+// bindLease records the lease and applies it to the interface. A failure to apply it is logged and
+// returned, the lease is kept either way.
+func (c *Client) bindLease(ctx context.Context, lease *nclient4.Lease) error {
+	logger := logging.WithComponentAndInterface("dhcp", c.Iface.Name)
+
+	_, _, expiry := leaseTimes(lease)
+	logger.WithFields(map[string]interface{}{
+		"ip":         lease.ACK.YourIPAddr.String(),
+		"lease_time": lease.ACK.IPAddressLeaseTime(60 * time.Second).String(),
+		"expires":    expiry.Format(time.RFC3339),
+	}).Info("Lease acquired")
+
+	c.boundOnce.Do(func() { close(c.bound) })
+	if c.config.LeaseOnly {
+		c.mu.Lock()
+		c.lease = lease
+		c.suspect = false
+		c.mu.Unlock()
+		return nil
+	}
+
+	// Apply the DHCP lease to the network interface
+	c.mu.Lock()
+	c.lease = lease
+	c.suspect = false
+	if !c.config.OneShot {
+		c.startHealthCheck(ctx, lease.ACK.Router())
+	}
+	err := c.applyDHCPLease(lease)
+	c.mu.Unlock()
+	if err != nil {
+		c.fail(metrics.ReasonApply)
+		logger.WithError(err).Error("Failed to apply lease to interface")
+		if !c.config.OneShot {
+			logger.Warn("Continuing without interface configuration")
+		}
+		return fmt.Errorf("failed to apply lease: %w", err)
+	}
+	logger.Info("Successfully configured interface")
+	return nil
+}
+
+// maintainLease renews the lease at T1, rebinds it at T2 and drops it once it expires or the server
+// rejects it. It returns when the lease is gone, the carrier is lost or the context is cancelled.
+func (c *Client) maintainLease(ctx context.Context, events <-chan netmon.Event) {
+	logger := logging.WithComponentAndInterface("dhcp", c.Iface.Name)
+
+	var req *request
+	for {
+		c.mu.Lock()
+		lease := c.lease
+		c.mu.Unlock()
 		if lease == nil {
-			logger.WithField("attempts", maxRetries).Warn("All attempts failed, waiting before full retry")
-			time.Sleep(30 * time.Second)
-			continue
+			req.reply(errors.New("lease was lost"))
+			return
 		}
 
-		// Perform REQUEST/ACK exchange with retry mechanism
-		var ack *dhcpv4.DHCPv4
-		for attempt := 1; attempt <= maxRetries; attempt++ {
-			// Create a new client for REQUEST/ACK
-			client, err := nclient4.New(c.Iface.Name, nclient4.WithTimeout(10*time.Second))
-			if err != nil {
-				logger.WithError(err).Error("Failed to create DHCP client for REQUEST")
-				break
+		t1, t2, expiry := leaseTimes(lease)
+		now := time.Now()
+
+		// Extend the lease once T1 has passed or on request, with any server once T2 has passed
+		if req != nil || !now.Before(t1) {
+			if !now.Before(expiry) {
+				logger.WithField("ip", lease.ACK.YourIPAddr.String()).Warn("Lease expired")
+				req.reply(errors.New("lease expired"))
+				c.fail(metrics.ReasonExpired)
+				c.dropLease()
+				return
+			}
+
+			rebind := !now.Before(t2) || req != nil && req.op == opRebind
+			state := "RENEWING"
+			if rebind {
+				state = "REBINDING"
 			}
+			ipLogger := logger.WithField("ip", lease.ACK.YourIPAddr.String())
+			if req != nil {
+				ipLogger.Info(state + " lease on request")
+			} else {
+				ipLogger.Info(state + " lease")
+			}
+
+			exchange := metrics.ExchangeRenew
+			if rebind {
+				exchange = metrics.ExchangeRebind
+			}
+			start := time.Now()
+			renewed, err := c.renewLease(ctx, lease, rebind)
+			c.observe(ctx, exchange, start, err)
+			req.reply(err)
+			req = nil
+			switch {
+			case err == nil:
+				c.bindLease(ctx, renewed)
+				continue
+			case ctx.Err() != nil:
+				return
+			case isNak(err):
+				logger.WithError(err).Warn("Server rejected lease, restarting discovery")
+				c.fail(metrics.ReasonNak)
+				c.dropLease()
+				return
+			}
+			logger.WithError(err).Warn("Failed to extend lease")
+		}
+
+		// Sleep until T1, or retry after half the remaining time until T2 or expiry
+		var wait time.Duration
+		switch {
+		case now.Before(t1):
+			wait = t1.Sub(now)
+		case now.Before(t2):
+			wait = max(t2.Sub(now)/2, minRetransmit)
+			wait = min(wait, t2.Sub(now))
+		default:
+			wait = max(expiry.Sub(now)/2, minRetransmit)
+			wait = min(wait, expiry.Sub(now))
+		}
+		logger.WithField("wait", wait.Round(time.Second).String()).Debug("Waiting for lease renewal")
 
-			// Send REQUEST and wait for ACK
-			leasedPacket, err := client.RequestFromOffer(context.Background(), lease)
-			client.Close()
+		lost, received := c.sleepUntilCarrierLoss(ctx, events, wait)
+		if ctx.Err() != nil {
+			received.reply(ctx.Err())
+			return
+		}
+		req = received
+
+		// The lease may not be valid on the network the interface is attached to next
+		if lost {
+			logger.Warn("Carrier lost, lease is suspect until verified")
+			c.mu.Lock()
+			c.suspect = true
+			c.mu.Unlock()
+			return
+		}
+
+		if req != nil && req.op == opRelease {
+			logger.WithField("ip", lease.ACK.YourIPAddr.String()).Info("Releasing lease on request")
+			c.releaseLease(lease)
+			c.dropLease()
+			c.mu.Lock()
+			c.released = true
+			c.mu.Unlock()
+			req.reply(nil)
+			return
+		}
+	}
+}
+
+// dropLease removes the address, routes and DNS settings of the current lease.
+func (c *Client) dropLease() {
+	logger := logging.WithComponentAndInterface("dhcp", c.Iface.Name)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.lease == nil {
+		return
+	}
+	if c.config.LeaseOnly {
+		c.lease = nil
+		c.suspect = false
+		return
+	}
+	ipNet := &net.IPNet{IP: c.lease.ACK.YourIPAddr, Mask: leaseMask(c.lease.ACK)}
+	c.lease = nil
+	c.suspect = false
+	c.stopHealthCheck()
+
+	link, err := netlink.LinkByName(c.Iface.Name)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to get netlink interface")
+		return
+	}
+
+	c.removeRoutes()
+	if err := routes.RemoveStale(link, routes.ProtocolDHCP, nil, c.inventory, logger); err != nil {
+		logger.WithError(err).Warn("Failed to remove lease routes")
+	}
+
+	if err := netlink.AddrDel(link, &netlink.Addr{IPNet: ipNet}); err != nil && !errors.Is(err, unix.EADDRNOTAVAIL) {
+		logger.WithError(err).WithField("ip", ipNet.String()).Warn("Failed to remove lease address")
+	} else {
+		logger.WithField("ip", ipNet.String()).Info("Removed lease address")
+	}
+	if err := c.inventory.Remove(ipNet); err != nil {
+		logger.WithError(err).Warn("Failed to update address inventory")
+	}
+
+	if _, err := c.resolver.Remove(c.Iface.Name); err != nil {
+		logger.WithError(err).Warn("Failed to remove DNS configuration")
+	}
+}
+
+// settleDelay is how long netlink events are collected before the lease configuration is checked.
+const settleDelay = 50 * time.Millisecond
+
+// watchLease repairs the lease configuration when netlink reports changes to the interface,
+// e.g. when someone flushes the lease address or deletes its routes.
+func (c *Client) watchLease(ctx context.Context) {
+	logger := logging.WithComponentAndInterface("dhcp", c.Iface.Name)
 
+	events, err := netmon.Subscribe(ctx, c.Iface.Index)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to subscribe to netlink events, lease configuration is not monitored")
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-events:
+			logger.WithField("event", event.String()).Debug("Interface changed")
+			if !netmon.Settle(ctx, events, settleDelay) {
+				return
+			}
+			c.mu.Lock()
+			err := c.checkLease()
+			c.mu.Unlock()
 			if err != nil {
-				logger.WithError(err).WithField("attempt", attempt).Error("REQUEST/ACK failed")
-				if attempt < maxRetries {
-					logger.WithField("delay", retryDelay).Debug("Retrying REQUEST...")
-					time.Sleep(retryDelay)
-					continue
-				}
-				break
+				logger.WithError(err).Error("Lease configuration check failed")
 			}
+		}
+	}
+}
 
-			// Successfully received ACK - extract the DHCP packet from the lease
-			ack = leasedPacket.ACK
-			logger.WithField("ip", ack.YourIPAddr.String()).Info("Received ACK")
-			break
+// checkLease reapplies the current lease when its address went missing and reinstalls missing routes.
+// The caller must hold mu.
+func (c *Client) checkLease() error {
+	logger := logging.WithComponentAndInterface("dhcp", c.Iface.Name)
+
+	if c.lease == nil {
+		return nil
+	}
+
+	link, err := netlink.LinkByName(c.Iface.Name)
+	if err != nil {
+		return fmt.Errorf("failed to get netlink interface: %w", err)
+	}
+
+	// Check if interface is up
+	if link.Attrs().Flags&net.FlagUp == 0 {
+		logger.Warn("Interface is down, bringing it up")
+		if err := netlink.LinkSetUp(link); err != nil {
+			return fmt.Errorf("failed to bring interface up: %w", err)
 		}
+	}
 
-		// If no valid ACK received after all retries, restart the whole process
-		if ack == nil {
-			logger.Error("Failed to receive ACK after all attempts, restarting DHCP process")
-			continue
+	// Rejoin the VRF if the interface was released from it
+	if c.config.VRF != "" && !links.InVRF(link, c.config.VRF) {
+		logger.WithField("vrf", c.config.VRF).Warn("Interface left its VRF, reapplying lease")
+		return c.applyDHCPLease(c.lease)
+	}
+
+	// Reapply the lease if its address was removed
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+	if err != nil {
+		return fmt.Errorf("failed to get interface addresses: %w", err)
+	}
+	configured := false
+	for _, addr := range addrs {
+		if addr.IP.Equal(c.lease.ACK.YourIPAddr) {
+			configured = true
+			break
 		}
+	}
+	if !configured {
+		logger.WithField("ip", c.lease.ACK.YourIPAddr.String()).Warn("Lease address was removed, reapplying lease")
+		return c.applyDHCPLease(c.lease)
+	}
 
-		leaseTime := ack.IPAddressLeaseTime(60 * time.Second)
-		logger.WithFields(map[string]interface{}{
-			"ip":         ack.YourIPAddr.String(),
-			"lease_time": leaseTime.String(),
-		}).Info("Lease acquired")
+	// Re-assert routes in case they were deleted
+	owned := append(append([]*netlink.Route{}, c.routes...), c.policyRoutes...)
+	if c.defaultRoute != nil {
+		owned = append(owned, c.defaultRoute)
+	}
+	if c.probeRoute != nil {
+		owned = append(owned, c.probeRoute)
+	}
+	added, err := routes.Ensure(owned, logger)
+	if err != nil {
+		return fmt.Errorf("failed to reapply routes: %w", err)
+	}
+	if added > 0 {
+		logger.WithField("routes", added).Warn("Routes were missing, reinstalled them")
+	}
 
-		// Apply the DHCP lease to the network interface
-		if err := c.applyDHCPLease(ack); err != nil {
-			logger.WithError(err).Error("Failed to apply lease to interface")
-			logger.Warn("Continuing without interface configuration")
-		} else {
-			logger.Info("Successfully configured interface")
+	// Re-assert policy rules in case they were deleted
+	if c.config.PolicyRouting != nil {
+		added, err := policy.Apply(*c.config.PolicyRouting, []*net.IPNet{{IP: c.lease.ACK.YourIPAddr, Mask: leaseMask(c.lease.ACK)}}, routes.ProtocolDHCP, logger)
+		if err != nil {
+			return fmt.Errorf("failed to reapply policy rules: %w", err)
+		}
+		if added > 0 {
+			logger.WithField("rules", added).Warn("Policy rules were missing, reinstalled them")
 		}
+	}
+	return nil
+}
+
+// leaseMask returns the subnet mask of the lease, defaulting to /24 if none was provided.
+func leaseMask(ack *dhcpv4.DHCPv4) net.IPMask {
+	if mask := ack.SubnetMask(); mask != nil {
+		return mask
+	}
+	return net.IPv4Mask(255, 255, 255, 0)
+}
 
-		// Sleep until lease renewal time
-		renewal := ack.IPAddressRenewalTime(30 * time.Second)
-		logger.WithField("renewal_time", renewal.String()).Info("Sleeping for renewal time")
-		time.Sleep(renewal)
+// sleep waits for the given duration and reports false if the context was cancelled first.
+func sleep(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
 	}
 }
 
 // applyDHCPLease configures the network interface with the received DHCP lease using netlink
-func (c *Client) applyDHCPLease(ack *dhcpv4.DHCPv4) error {
+func (c *Client) applyDHCPLease(lease *nclient4.Lease) error {
 	logger := logging.WithComponentAndInterface("dhcp", c.Iface.Name)
+	ack := lease.ACK
 
 	// Extract network configuration from DHCP ACK
 	ipAddr := ack.YourIPAddr
 
-	// Get subnet mask
-	subnetMask := ack.SubnetMask()
-	if subnetMask == nil {
-		// Default to /24 if no subnet mask provided
-		subnetMask = net.IPv4Mask(255, 255, 255, 0)
-	}
-
 	// Create IP network
 	ipNet := &net.IPNet{
 		IP:   ipAddr,
-		Mask: subnetMask,
+		Mask: leaseMask(ack),
 	}
 
 	logger.WithField("ip", ipNet.String()).Info("Configuring interface with IP")
@@ -172,6 +657,26 @@ func (c *Client) applyDHCPLease(ack *dhcpv4.DHCPv4) error {
 		return fmt.Errorf("failed to get netlink interface: %w", err)
 	}
 
+	// Join the VRF first, so that the routes of the address land in its table. Without a VRF
+	// the interface leaves the one it was put into by an earlier configuration.
+	if c.config.VRF != "" {
+		if c.vrfTable, err = links.JoinVRF(link, c.config.VRF, logger); err != nil {
+			return err
+		}
+	} else {
+		c.vrfTable = 0
+		vrfs := c.config.DeclaredVRFs
+		if joined := c.inventory.VRF(); joined != "" {
+			vrfs = append(slices.Clone(vrfs), joined)
+		}
+		if err := links.LeaveVRF(link, vrfs, logger); err != nil {
+			return err
+		}
+	}
+	if err := c.inventory.SetVRF(c.config.VRF); err != nil {
+		logger.WithError(err).Warn("Failed to record VRF")
+	}
+
 	// Get existing addresses to check for duplicates
 	existingAddrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
 	if err != nil {
@@ -182,7 +687,6 @@ func (c *Client) applyDHCPLease(ack *dhcpv4.DHCPv4) error {
 	targetConfigured := false
 	for _, addr := range existingAddrs {
 		if addr.IPNet.IP.Equal(ipNet.IP) && addr.IPNet.Mask.String() == ipNet.Mask.String() {
-			logger.WithField("ip", ipNet.String()).Info("IP address already configured, skipping")
 			targetConfigured = true
 			break
 		}
@@ -190,38 +694,73 @@ func (c *Client) applyDHCPLease(ack *dhcpv4.DHCPv4) error {
 
 	// Only remove existing addresses if target IP is not already configured
 	if !targetConfigured {
-		// Remove existing IPv4 addresses that don't match our target
+		// Remove previously leased IPv4 addresses that don't match our target,
+		// or every other IPv4 address when the interface is managed exclusively
 		for _, addr := range existingAddrs {
-			if !addr.IPNet.IP.Equal(ipNet.IP) {
-				if err := netlink.AddrDel(link, &addr); err != nil {
-					logger.WithError(err).WithField("address", addr.IPNet.String()).Warn("Failed to remove existing address")
-				} else {
-					logger.WithField("address", addr.IPNet.String()).Debug("Removed existing address")
-				}
+			if addr.IPNet.IP.Equal(ipNet.IP) {
+				continue
+			}
+			if !c.config.Exclusive && !c.inventory.Owns(addr.IPNet) {
+				continue
+			}
+			if err := netlink.AddrDel(link, &addr); err != nil {
+				logger.WithError(err).WithField("address", addr.IPNet.String()).Warn("Failed to remove existing address")
+				continue
+			}
+			logger.WithField("address", addr.IPNet.String()).Debug("Removed existing address")
+			if err := c.inventory.Remove(addr.IPNet); err != nil {
+				logger.WithError(err).Warn("Failed to update address inventory")
 			}
 		}
 	}
 
-	// Get lease time from DHCP ACK
-	leaseTime := ack.IPAddressLeaseTime(60 * time.Second) // Default to 60 seconds if not specified
-	logger.WithField("lease_time", leaseTime.String()).Debug("Lease time extracted")
+	// The address lives as long as the remainder of the lease, so the kernel expires it with the lease,
+	// unless it is permanent
+	_, _, expiry := leaseTimes(lease)
+	remaining := time.Until(expiry)
+	if remaining < time.Second {
+		return fmt.Errorf("lease for %s has expired", ipNet.String())
+	}
+	logger.WithField("remaining", remaining.Round(time.Second).String()).Debug("Lease time extracted")
+
+	addr := &netlink.Addr{IPNet: ipNet}
+	if !c.config.Permanent {
+		addr.ValidLft = int(remaining.Seconds())
+		addr.PreferedLft = int(remaining.Seconds())
+	}
 
-	// Add new IP address only if not already configured
+	// Add new IP address, or refresh its lifetimes if already configured
 	if !targetConfigured {
-		addr := &netlink.Addr{
-			IPNet:       ipNet,
-			ValidLft:    int(leaseTime.Seconds()),
-			PreferedLft: int(leaseTime.Seconds()),
-		}
 		if err := netlink.AddrAdd(link, addr); err != nil {
 			return fmt.Errorf("failed to add IP address %s: %w", ipNet.String(), err)
 		}
 		logger.WithField("ip", ipNet.String()).Info("Successfully added IP address")
+		if err := c.inventory.Add(ipNet); err != nil {
+			logger.WithError(err).Warn("Failed to update address inventory")
+		}
+	} else {
+		if err := netlink.AddrReplace(link, addr); err != nil {
+			return fmt.Errorf("failed to refresh IP address %s: %w", ipNet.String(), err)
+		}
+		logger.WithField("ip", ipNet.String()).Debug("IP address already configured, refreshed lifetimes")
 	}
 
-	// Configure default gateway if provided
+	// Configure default gateway if provided, unless it is withdrawn while the gateway is down
+	c.defaultRoute = nil
+	c.probeRoute = nil
 	routers := ack.Router()
-	if len(routers) > 0 {
+	if len(routers) > 0 && c.defaultRouteWithdrawn() {
+		logger.WithField("gateway", routers[0].String()).Warn("Gateway is down, default route withdrawn")
+
+		// Keep the probe target reachable through the gateway
+		c.probeRoute = c.config.HealthCheck.ProbeRoute(link, routers[0], routes.ProtocolDHCP)
+		routes.SetTable([]*netlink.Route{c.probeRoute}, c.vrfTable)
+		if c.probeRoute != nil {
+			if _, err := routes.Ensure([]*netlink.Route{c.probeRoute}, logger); err != nil {
+				return fmt.Errorf("failed to add health check route: %w", err)
+			}
+		}
+	} else if len(routers) > 0 {
 		gateway := routers[0]
 		logger.WithField("gateway", gateway.String()).Info("Setting default gateway")
 
@@ -230,6 +769,43 @@ func (c *Client) applyDHCPLease(ack *dhcpv4.DHCPv4) error {
 		}
 	}
 
+	// Install configured static routes once the lease address is in place
+	if len(c.config.Routes) > 0 {
+		desired, err := routes.Build(link, c.config.Routes, routes.ProtocolDHCP)
+		if err != nil {
+			return fmt.Errorf("invalid route configuration: %w", err)
+		}
+		routes.SetTable(desired, c.vrfTable)
+		c.routes = desired
+		if err := routes.Claim(c.inventory, desired); err != nil {
+			logger.WithError(err).Warn("Failed to update route inventory")
+		}
+		if _, err := routes.Ensure(desired, logger); err != nil {
+			return fmt.Errorf("failed to configure routes: %w", err)
+		}
+	}
+
+	// Install the lease into the dedicated table of the interface
+	c.policyRoutes = nil
+	if c.config.PolicyRouting != nil {
+		if err := c.configurePolicyRouting(link, ipNet, routers); err != nil {
+			return fmt.Errorf("failed to configure policy routing: %w", err)
+		}
+	}
+
+	// Remove routes installed for a previous lease
+	owned := append([]*netlink.Route{}, c.routes...)
+	owned = append(owned, c.policyRoutes...)
+	if c.defaultRoute != nil {
+		owned = append(owned, c.defaultRoute)
+	}
+	if c.probeRoute != nil {
+		owned = append(owned, c.probeRoute)
+	}
+	if err := routes.RemoveStale(link, routes.ProtocolDHCP, owned, c.inventory, logger); err != nil {
+		logger.WithError(err).Warn("Failed to remove stale routes")
+	}
+
 	// Log DNS servers if provided
 	dnsServers := ack.DNS()
 	if len(dnsServers) > 0 {
@@ -238,99 +814,221 @@ func (c *Client) applyDHCPLease(ack *dhcpv4.DHCPv4) error {
 			dnsStrings = append(dnsStrings, dns.String())
 		}
 		logger.WithField("dns_servers", strings.Join(dnsStrings, ", ")).Info("DNS servers received")
+	}
 
-		// Configure DNS (write to /etc/resolv.conf)
-		if err := c.configureDNS(dnsServers); err != nil {
-			logger.WithError(err).Warn("Failed to configure DNS")
-		}
+	// Configure DNS (write to /etc/resolv.conf)
+	if err := c.configureDNS(ack); err != nil {
+		logger.WithError(err).Warn("Failed to configure DNS")
 	}
 
 	return nil
 }
 
-// configureDefaultRoute configures the default route using netlink
+// configureDefaultRoute installs the default route through the gateway with the interface metric.
+// Only default routes on this link that the daemon installed are replaced, unless the interface is
+// managed exclusively, so that several interfaces and foreign routes can coexist.
 func (c *Client) configureDefaultRoute(link netlink.Link, gateway net.IP) error {
 	logger := logging.WithComponentAndInterface("dhcp", c.Iface.Name).WithField("gateway", gateway.String())
 
-	// List existing routes to check if our desired route already exists
-	routes, err := netlink.RouteList(nil, netlink.FAMILY_V4)
+	route := routes.DefaultRoute(link, gateway, c.defaultRouteMetric(), routes.ProtocolDHCP)
+	routes.SetTable([]*netlink.Route{route}, c.vrfTable)
+	logger = logger.WithField("metric", route.Priority)
+
+	// Check if the desired default route already exists
+	exists, err := routes.Exists(route)
 	if err != nil {
-		return fmt.Errorf("failed to list routes: %w", err)
+		return err
+	}
+	if exists {
+		logger.Debug("Default route already configured, skipping")
+		c.defaultRoute = route
+		return nil
 	}
 
-	// Check if the desired default route already exists
-	targetRouteExists := false
-	for _, route := range routes {
-		if (route.Dst == nil || route.Dst.String() == "0.0.0.0/0") &&
-			route.Gw != nil && route.Gw.Equal(gateway) &&
-			route.LinkIndex == link.Attrs().Index {
-			logger.Info("Default route already exists, skipping")
-			targetRouteExists = true
-			break
-		}
+	// Remove the default routes installed before, e.g. through a previous gateway
+	if err := routes.RemoveOtherDefaults(link, route, c.config.Exclusive, logger); err != nil {
+		return err
 	}
 
-	// Only modify routes if the target route doesn't exist
-	if !targetRouteExists {
-		// Remove existing default routes that don't match our target
-		for _, route := range routes {
-			if route.Dst == nil || route.Dst.String() == "0.0.0.0/0" {
-				// Skip if this is already our desired route
-				if route.Gw != nil && route.Gw.Equal(gateway) && route.LinkIndex == link.Attrs().Index {
-					continue
-				}
-
-				if err := netlink.RouteDel(&route); err != nil {
-					logger.WithError(err).Warn("Failed to remove existing default route")
-				} else {
-					if route.Gw != nil {
-						logger.WithField("old_gateway", route.Gw.String()).Debug("Removed existing default route")
-					} else {
-						logger.Debug("Removed existing default route")
-					}
-				}
-			}
+	if err := netlink.RouteAdd(route); err != nil {
+		if errors.Is(err, unix.EEXIST) {
+			return fmt.Errorf("another default route with metric %d exists, configure a distinct metric: %w", route.Priority, err)
 		}
+		return fmt.Errorf("failed to add default route: %w", err)
+	}
+	c.defaultRoute = route
 
-		// Add new default route
-		route := &netlink.Route{
-			LinkIndex: link.Attrs().Index,
-			Gw:        gateway,
-		}
+	logger.Info("Successfully added default route")
+	return nil
+}
 
-		if err := netlink.RouteAdd(route); err != nil {
-			return fmt.Errorf("failed to add default route: %w", err)
-		}
+// defaultRouteMetric returns the metric of the default route, raised while the gateway is down.
+func (c *Client) defaultRouteMetric() int {
+	if c.gatewayDown && c.config.HealthCheck != nil {
+		return c.config.HealthCheck.Metric(c.config.Metric)
+	}
+	return c.config.Metric
+}
+
+// defaultRouteWithdrawn reports whether the default route is removed because the gateway is down.
+func (c *Client) defaultRouteWithdrawn() bool {
+	return c.gatewayDown && c.config.HealthCheck != nil && c.config.HealthCheck.Withdraws()
+}
+
+// startHealthCheck probes the gateway of the lease, restarting the check when the gateway changes.
+// The caller must hold mu.
+func (c *Client) startHealthCheck(ctx context.Context, routers []net.IP) {
+	if c.config.HealthCheck == nil {
+		return
+	}
+
+	var gateway net.IP
+	if len(routers) > 0 {
+		gateway = routers[0]
+	}
+	if c.health != nil && c.gateway.Equal(gateway) {
+		return
+	}
+
+	// A new gateway starts out healthy
+	c.stopHealthCheck()
+	c.gateway = gateway
+	if gateway == nil {
+		return
+	}
 
-		logger.Info("Successfully added default route")
+	checkCtx, cancel := context.WithCancel(ctx)
+	var checker *health.Checker
+	checker = health.NewChecker(*c.config.HealthCheck, c.Iface, gateway, func(state health.State) {
+		c.onHealthChange(checker, state)
+	})
+	c.health = checker
+	c.stopHealth = cancel
+	namespace.Go(checkCtx, func() { checker.Run(checkCtx) })
+}
+
+// stopHealthCheck stops probing the gateway. The caller must hold mu.
+func (c *Client) stopHealthCheck() {
+	if c.stopHealth != nil {
+		c.stopHealth()
+		c.stopHealth = nil
+	}
+	c.health = nil
+	c.gateway = nil
+	c.gatewayDown = false
+}
+
+// onHealthChange fails the default route over when the gateway goes down and restores it when it recovers.
+func (c *Client) onHealthChange(checker *health.Checker, state health.State) {
+	logger := logging.WithComponentAndInterface("dhcp", c.Iface.Name).WithField("state", state)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Ignore checks of a gateway from a previous lease
+	if checker != c.health || c.lease == nil {
+		return
+	}
+
+	c.gatewayDown = state == health.StateDown
+	if err := c.applyDHCPLease(c.lease); err != nil {
+		logger.WithError(err).Error("Failed to update default route after gateway health change")
+		return
+	}
+	logger.Info("Updated default route after gateway health change")
+}
+
+// HealthStatus returns the state of the gateway health check, or nil if none is running.
+func (c *Client) HealthStatus() *health.Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.health == nil {
+		return nil
+	}
+	status := c.health.Status()
+	return &status
+}
+
+// configurePolicyRouting installs the subnet and gateway of the lease into the table of the
+// interface and adds the rules steering traffic into it.
+func (c *Client) configurePolicyRouting(link netlink.Link, addr *net.IPNet, routers []net.IP) error {
+	logger := logging.WithComponentAndInterface("dhcp", c.Iface.Name).WithField("table", c.config.PolicyRouting.Table)
+
+	var gateways []net.IP
+	if len(routers) > 0 {
+		gateways = routers[:1]
 	}
 
+	addrs := []*net.IPNet{addr}
+	c.policyRoutes = c.config.PolicyRouting.TableRoutes(link, addrs, gateways, c.config.Metric, routes.ProtocolDHCP)
+	if _, err := routes.Ensure(c.policyRoutes, logger); err != nil {
+		return err
+	}
+	if _, err := policy.Apply(*c.config.PolicyRouting, addrs, routes.ProtocolDHCP, logger); err != nil {
+		return err
+	}
 	return nil
 }
 
-// configureDNS writes DNS servers to /etc/resolv.conf
-func (c *Client) configureDNS(dnsServers []net.IP) error {
+// removeRoutes removes the default and configured static routes and the policy routing state
+// installed by the client.
+func (c *Client) removeRoutes() {
+	logger := logging.WithComponentAndInterface("dhcp", c.Iface.Name)
+
+	if c.defaultRoute != nil {
+		routes.Remove([]*netlink.Route{c.defaultRoute}, logger)
+		c.defaultRoute = nil
+	}
+	if c.config.PolicyRouting != nil {
+		policy.Teardown(*c.config.PolicyRouting, routes.ProtocolDHCP, logger)
+		routes.Remove(c.policyRoutes, logger)
+		c.policyRoutes = nil
+	}
+	if len(c.routes) > 0 {
+		routes.Remove(c.routes, logger)
+		if err := routes.Release(c.inventory, c.routes); err != nil {
+			logger.WithError(err).Warn("Failed to update route inventory")
+		}
+		c.routes = nil
+	}
+	if c.probeRoute != nil {
+		routes.Remove([]*netlink.Route{c.probeRoute}, logger)
+		c.probeRoute = nil
+	}
+}
+
+// configureDNS hands the DNS servers, domain name and search list from the lease to the resolver
+func (c *Client) configureDNS(ack *dhcpv4.DHCPv4) error {
 	logger := logging.WithComponentAndInterface("dhcp", c.Iface.Name)
 
-	// Generate the new DNS configuration content
-	newContent := "# Generated by golang-dhcpcd\n"
-	for _, dns := range dnsServers {
-		newContent += fmt.Sprintf("nameserver %s\n", dns.String())
+	resolverConfig := resolver.Config{
+		Nameservers: ack.DNS(),
+		Domain:      ack.DomainName(),
+	}
+	if search := ack.DomainSearch(); search != nil {
+		resolverConfig.Search = search.Labels
 	}
 
-	// Check if the current /etc/resolv.conf already has the same content
-	if currentContent, err := os.ReadFile("/etc/resolv.conf"); err == nil {
-		if string(currentContent) == newContent {
-			logger.Debug("DNS configuration already up to date, skipping")
-			return nil
+	// A lease without DNS settings leaves the resolver configuration of the host alone, only
+	// dropping what a previous lease of the interface contributed
+	if resolverConfig.IsEmpty() {
+		removed, err := c.resolver.Remove(c.Iface.Name)
+		if removed {
+			logger.WithField("file", c.resolver.Path()).Info("Lease carries no DNS settings, removed those of the previous lease")
 		}
+		return err
 	}
 
-	// Write the new DNS configuration
-	if err := os.WriteFile("/etc/resolv.conf", []byte(newContent), 0644); err != nil {
-		return fmt.Errorf("failed to write /etc/resolv.conf: %w", err)
+	updated, err := c.resolver.Update(c.Iface.Name, resolverConfig)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		logger.Debug("DNS configuration already up to date, skipping")
+		return nil
 	}
 
-	logger.Info("Updated /etc/resolv.conf with DNS servers")
+	logger.WithField("file", c.resolver.Path()).Info("Updated resolver configuration with DNS servers")
 	return nil
 }
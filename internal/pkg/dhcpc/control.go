@@ -0,0 +1,210 @@
+package dhcpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"golang-dhcpcd/internal/pkg/health"
+	"golang-dhcpcd/internal/pkg/namespace"
+)
+
+// Lease states reported by Status.
+const (
+	StateInit     = "init"     // No lease, discovery is running
+	StateBound    = "bound"    // The lease is configured and renewed
+	StateSuspect  = "suspect"  // The carrier was lost, the lease is verified once it returns
+	StateReleased = "released" // The lease was released on request, no new one is acquired until requested
+)
+
+// Operations requested from the lease loop of Run.
+const (
+	opRenew   = "renew"
+	opRebind  = "rebind"
+	opRelease = "release"
+)
+
+// errStopped reports a request to a client that is not running.
+var errStopped = errors.New("DHCP client is not running")
+
+// Status describes the lease state of a client.
+type Status struct {
+	State  string         `json:"state"`
+	MAC    string         `json:"mac"`
+	Lease  *LeaseStatus   `json:"lease,omitempty"`
+	Health *health.Status `json:"health,omitempty"`
+}
+
+// LeaseStatus describes the current lease.
+type LeaseStatus struct {
+	Address  string    `json:"address"`
+	Gateway  string    `json:"gateway,omitempty"`
+	Server   string    `json:"server,omitempty"`
+	DNS      []string  `json:"dns,omitempty"`
+	Obtained time.Time `json:"obtained"`
+	Renew    time.Time `json:"renew"`
+	Rebind   time.Time `json:"rebind"`
+	Expires  time.Time `json:"expires"`
+}
+
+// request asks the lease loop for an operation and receives its outcome.
+type request struct {
+	op   string
+	done chan error
+}
+
+// reply reports the outcome of the request, if there is one.
+func (r *request) reply(err error) {
+	if r != nil {
+		r.done <- err
+	}
+}
+
+// Status returns the lease state of the client.
+func (c *Client) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status := Status{State: StateInit, MAC: c.Iface.HardwareAddr.String()}
+	switch {
+	case c.released:
+		status.State = StateReleased
+	case c.lease != nil && c.suspect:
+		status.State = StateSuspect
+	case c.lease != nil:
+		status.State = StateBound
+	}
+
+	if c.lease != nil {
+		ack := c.lease.ACK
+		t1, t2, expiry := leaseTimes(c.lease)
+		status.Lease = &LeaseStatus{
+			Address:  (&net.IPNet{IP: ack.YourIPAddr, Mask: leaseMask(ack)}).String(),
+			Obtained: c.lease.CreationTime,
+			Renew:    t1,
+			Rebind:   t2,
+			Expires:  expiry,
+		}
+		if routers := ack.Router(); len(routers) > 0 {
+			status.Lease.Gateway = routers[0].String()
+		}
+		if server := ack.ServerIdentifier(); server != nil {
+			status.Lease.Server = server.String()
+		}
+		for _, dns := range ack.DNS() {
+			status.Lease.DNS = append(status.Lease.DNS, dns.String())
+		}
+	}
+
+	if c.health != nil {
+		checkStatus := c.health.Status()
+		status.Health = &checkStatus
+	}
+	return status
+}
+
+// Renew extends the lease with the server that granted it right away, or restarts discovery if
+// the client holds no lease. It returns once the attempt finished.
+func (c *Client) Renew(ctx context.Context) error {
+	return c.submit(ctx, opRenew)
+}
+
+// Rebind extends the lease with any server right away, or restarts discovery if the client holds
+// no lease. It returns once the attempt finished.
+func (c *Client) Rebind(ctx context.Context) error {
+	return c.submit(ctx, opRebind)
+}
+
+// Release gives the lease back to the server and removes its configuration. No new lease is
+// acquired until Renew or Rebind is called.
+func (c *Client) Release(ctx context.Context) error {
+	return c.submit(ctx, opRelease)
+}
+
+// Reapply configures the interface with the current lease again. It enters the namespace of the
+// interface, callers such as the control socket run in the namespace of the daemon.
+func (c *Client) Reapply() error {
+	var err error
+	if nsErr := namespace.Do(namespace.NewContext(context.Background(), c.ns), func() { err = c.reapply() }); nsErr != nil {
+		return nsErr
+	}
+	return err
+}
+
+// reapply configures the interface with the current lease again inside its namespace.
+func (c *Client) reapply() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.config.LeaseOnly {
+		return errors.New("the client does not configure the interface")
+	}
+	if c.lease == nil {
+		return errors.New("no lease to apply")
+	}
+	return c.applyDHCPLease(c.lease)
+}
+
+// submit hands an operation to the lease loop and waits for its outcome.
+func (c *Client) submit(ctx context.Context, op string) error {
+	req := &request{op: op, done: make(chan error, 1)}
+
+	select {
+	case c.requests <- req:
+	case <-c.stopped:
+		return errStopped
+	case <-ctx.Done():
+		return fmt.Errorf("DHCP client is busy: %w", ctx.Err())
+	}
+
+	select {
+	case err := <-req.done:
+		return err
+	case <-c.stopped:
+		return errStopped
+	case <-ctx.Done():
+		return fmt.Errorf("no outcome yet: %w", ctx.Err())
+	}
+}
+
+// awaitRequest waits up to the given duration for a request, indefinitely if zero. It returns nil
+// if none arrived or the context was cancelled.
+func (c *Client) awaitRequest(ctx context.Context, d time.Duration) *request {
+	var expired <-chan time.Time
+	if d > 0 {
+		timer := time.NewTimer(d)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-expired:
+		return nil
+	case req := <-c.requests:
+		return req
+	}
+}
+
+// awaitAcquire waits while the lease is released, until a renew or rebind request arrives. It
+// returns nil if the context was cancelled first.
+func (c *Client) awaitAcquire(ctx context.Context) *request {
+	for {
+		req := c.awaitRequest(ctx, 0)
+		if req == nil {
+			return nil
+		}
+		if req.op == opRelease {
+			req.reply(nil)
+			continue
+		}
+
+		c.mu.Lock()
+		c.released = false
+		c.mu.Unlock()
+		return req
+	}
+}
@@ -0,0 +1,158 @@
+package dhcpc
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+)
+
+// testClient returns a client that is not running.
+func testClient() *Client {
+	mac, _ := net.ParseMAC("52:54:00:12:34:56")
+	return &Client{
+		Iface:    &net.Interface{Name: "eth0", HardwareAddr: mac},
+		requests: make(chan *request),
+		stopped:  make(chan struct{}),
+	}
+}
+
+func TestStatus(t *testing.T) {
+	ack, err := dhcpv4.New(
+		dhcpv4.WithYourIP(net.IPv4(192, 168, 1, 10)),
+		dhcpv4.WithNetmask(net.CIDRMask(24, 32)),
+		dhcpv4.WithRouter(net.IPv4(192, 168, 1, 1)),
+		dhcpv4.WithServerIP(net.IPv4(192, 168, 1, 1)),
+		dhcpv4.WithOption(dhcpv4.OptServerIdentifier(net.IPv4(192, 168, 1, 1))),
+		dhcpv4.WithDNS(net.IPv4(192, 168, 1, 53)),
+		dhcpv4.WithLeaseTime(3600),
+	)
+	if err != nil {
+		t.Fatalf("Failed to build ACK: %v", err)
+	}
+	lease := &nclient4.Lease{ACK: ack, CreationTime: time.Now()}
+
+	tests := []struct {
+		name     string
+		lease    *nclient4.Lease
+		suspect  bool
+		released bool
+		want     string
+	}{
+		{"init", nil, false, false, StateInit},
+		{"bound", lease, false, false, StateBound},
+		{"suspect", lease, true, false, StateSuspect},
+		{"released", nil, false, true, StateReleased},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			client := testClient()
+			client.lease, client.suspect, client.released = tt.lease, tt.suspect, tt.released
+
+			status := client.Status()
+			if status.State != tt.want || status.MAC != "52:54:00:12:34:56" {
+				t.Errorf("Status() = %s with MAC %s, want %s", status.State, status.MAC, tt.want)
+			}
+			if (status.Lease != nil) != (tt.lease != nil) {
+				t.Fatalf("Status() lease = %+v, want one %v", status.Lease, tt.lease != nil)
+			}
+			if status.Lease == nil {
+				return
+			}
+			if status.Lease.Address != "192.168.1.10/24" || status.Lease.Gateway != "192.168.1.1" || status.Lease.Server != "192.168.1.1" {
+				t.Errorf("Lease = %+v", status.Lease)
+			}
+			if len(status.Lease.DNS) != 1 || status.Lease.DNS[0] != "192.168.1.53" {
+				t.Errorf("Lease DNS = %v, want [192.168.1.53]", status.Lease.DNS)
+			}
+			if !status.Lease.Expires.Equal(lease.CreationTime.Add(time.Hour)) || !status.Lease.Renew.Before(status.Lease.Rebind) {
+				t.Errorf("Lease times = %+v", status.Lease)
+			}
+		})
+	}
+}
+
+func TestSubmit(t *testing.T) {
+	client := testClient()
+	go func() {
+		for req := range client.requests {
+			if req.op == opRebind {
+				req.reply(errors.New("no server answered"))
+				continue
+			}
+			req.reply(nil)
+		}
+	}()
+	defer close(client.requests)
+
+	ctx := context.Background()
+	if err := client.Renew(ctx); err != nil {
+		t.Errorf("Renew() = %v, want no error", err)
+	}
+	if err := client.Rebind(ctx); err == nil || err.Error() != "no server answered" {
+		t.Errorf("Rebind() = %v, want the outcome of the lease loop", err)
+	}
+}
+
+func TestSubmitStopped(t *testing.T) {
+	client := testClient()
+	close(client.stopped)
+	if err := client.Release(context.Background()); !errors.Is(err, errStopped) {
+		t.Errorf("Release() = %v, want %v", err, errStopped)
+	}
+
+	busy := testClient()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if err := busy.Renew(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Renew() = %v, want the deadline to pass", err)
+	}
+}
+
+func TestAwaitAcquire(t *testing.T) {
+	client := testClient()
+	client.released = true
+
+	done := make(chan error, 1)
+	go func() {
+		// A release while released is answered right away
+		done <- client.submit(context.Background(), opRelease)
+		client.submit(context.Background(), opRenew)
+	}()
+
+	req := client.awaitAcquire(context.Background())
+	if err := <-done; err != nil {
+		t.Errorf("Release while released = %v, want no error", err)
+	}
+	if req == nil || req.op != opRenew {
+		t.Fatalf("awaitAcquire() = %v, want the renew request", req)
+	}
+	if client.Status().State == StateReleased {
+		t.Error("Client is still released after a renew request")
+	}
+	req.reply(nil)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if req := client.awaitAcquire(ctx); req != nil {
+		t.Errorf("awaitAcquire() = %v after cancellation, want nil", req)
+	}
+}
+
+func TestReapplyLeaseOnly(t *testing.T) {
+	client := testClient()
+	client.config.LeaseOnly = true
+	if err := client.Reapply(); err == nil {
+		t.Error("Reapply() succeeded for a lease-only client")
+	}
+
+	client.config.LeaseOnly = false
+	if err := client.Reapply(); err == nil {
+		t.Error("Reapply() succeeded without a lease")
+	}
+}
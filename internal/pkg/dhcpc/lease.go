@@ -0,0 +1,269 @@
+package dhcpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"golang-dhcpcd/internal/pkg/logging"
+	"golang-dhcpcd/internal/pkg/metrics"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+)
+
+// Retry settings of the DISCOVER/OFFER and REQUEST/ACK exchanges.
+const (
+	maxRetries = 3
+	retryDelay = 2 * time.Second
+)
+
+// minRetransmit is the shortest wait between RENEWING and REBINDING attempts (RFC 2131, section 4.4.5).
+const minRetransmit = 60 * time.Second
+
+// errNoLease reports that no server handed out a lease after all attempts.
+var errNoLease = errors.New("no lease obtained")
+
+// requestedCodes are the options asked for in every request.
+var requestedCodes = dhcpv4.OptionCodeList{
+	dhcpv4.OptionSubnetMask,
+	dhcpv4.OptionRouter,
+	dhcpv4.OptionDomainName,
+	dhcpv4.OptionDomainNameServer,
+	dhcpv4.OptionDNSDomainSearchList,
+}
+
+// requestedOptions asks for the requestedCodes.
+var requestedOptions = dhcpv4.WithRequestedOptions(requestedCodes...)
+
+// leaseTimes returns when the lease has to be renewed (T1) and rebound (T2), and when it expires.
+// Servers omitting T1 and T2 get the defaults of half and seven eighths of the lease time.
+func leaseTimes(lease *nclient4.Lease) (t1, t2, expiry time.Time) {
+	leaseTime := lease.ACK.IPAddressLeaseTime(60 * time.Second)
+	renewal := lease.ACK.IPAddressRenewalTime(leaseTime / 2)
+	rebinding := lease.ACK.IPAddressRebindingTime(leaseTime * 7 / 8)
+	return lease.CreationTime.Add(renewal), lease.CreationTime.Add(rebinding), lease.CreationTime.Add(leaseTime)
+}
+
+// obtainLease runs the DISCOVER/OFFER and REQUEST/ACK exchanges with retries. It returns errNoLease
+// if no server answered, and nil without an error if the context was cancelled.
+func (c *Client) obtainLease(ctx context.Context) (*nclient4.Lease, error) {
+	logger := logging.WithComponentAndInterface("dhcp", c.Iface.Name)
+
+	var offer *dhcpv4.DHCPv4
+
+	// Retry DISCOVER/OFFER up to maxRetries times
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		logger.WithField("attempt", fmt.Sprintf("%d/%d", attempt, maxRetries)).Debug("Attempting to get DHCP lease")
+
+		// Create DHCP client using the nclient4 library
+		client, err := nclient4.New(c.Iface.Name, nclient4.WithTimeout(15*time.Second))
+		if err != nil {
+			logger.WithError(err).Error("Failed to create DHCP client")
+			if attempt < maxRetries {
+				logger.WithField("delay", retryDelay).Debug("Retrying...")
+				if !sleep(ctx, retryDelay) {
+					return nil, nil
+				}
+				continue
+			}
+			return nil, fmt.Errorf("failed to create DHCP client after %d attempts: %w", maxRetries, err)
+		}
+
+		logger.Debug("Created DHCP client")
+
+		// Perform DHCP DISCOVER/OFFER exchange
+		start := time.Now()
+		offer, err = client.DiscoverOffer(ctx, c.requestedOptions())
+		client.Close()
+		c.observe(ctx, metrics.ExchangeDiscover, start, err)
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil
+			}
+			logger.WithError(err).WithField("attempt", attempt).Error("DISCOVER/OFFER failed")
+			if attempt < maxRetries {
+				logger.WithField("delay", retryDelay).Debug("Retrying...")
+				if !sleep(ctx, retryDelay) {
+					return nil, nil
+				}
+			}
+			continue
+		}
+
+		logger.WithFields(map[string]interface{}{
+			"attempt": attempt,
+			"ip":      offer.YourIPAddr.String(),
+		}).Info("Successfully received OFFER")
+		break
+	}
+
+	// If no valid offer received after all retries, let the caller wait and restart
+	if offer == nil {
+		logger.WithField("attempts", maxRetries).Warn("All attempts failed, waiting before full retry")
+		c.fail(metrics.ReasonNoOffer)
+		return nil, errNoLease
+	}
+
+	// Perform REQUEST/ACK exchange with retry mechanism
+	for attempt := 1; attempt <= maxRetries; attempt++ {
+		// Create a new client for REQUEST/ACK
+		client, err := nclient4.New(c.Iface.Name, nclient4.WithTimeout(10*time.Second))
+		if err != nil {
+			logger.WithError(err).Error("Failed to create DHCP client for REQUEST")
+			break
+		}
+
+		// Send REQUEST and wait for ACK
+		start := time.Now()
+		lease, err := client.RequestFromOffer(ctx, offer, c.requestedOptions())
+		client.Close()
+		c.observe(ctx, metrics.ExchangeRequest, start, err)
+
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil, nil
+			}
+			logger.WithError(err).WithField("attempt", attempt).Error("REQUEST/ACK failed")
+			if attempt < maxRetries {
+				logger.WithField("delay", retryDelay).Debug("Retrying REQUEST...")
+				if !sleep(ctx, retryDelay) {
+					return nil, nil
+				}
+				continue
+			}
+			break
+		}
+
+		logger.WithField("ip", lease.ACK.YourIPAddr.String()).Info("Received ACK")
+		return lease, nil
+	}
+
+	// If no valid ACK received after all retries, restart the whole process
+	logger.Error("Failed to receive ACK after all attempts, restarting DHCP process")
+	c.fail(metrics.ReasonNoAck)
+	return nil, errNoLease
+}
+
+// rebootLease verifies a lease that is still valid with an INIT-REBOOT request for its address
+// (RFC 2131, section 3.2), e.g. after the carrier came back. A NAK means the address is not valid
+// on the current network.
+func (c *Client) rebootLease(ctx context.Context, lease *nclient4.Lease) (*nclient4.Lease, error) {
+	client, err := nclient4.New(c.Iface.Name, nclient4.WithTimeout(2*time.Second), nclient4.WithRetry(2))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DHCP client: %w", err)
+	}
+	defer client.Close()
+
+	request, err := dhcpv4.New(
+		dhcpv4.WithHwAddr(c.Iface.HardwareAddr),
+		dhcpv4.WithMessageType(dhcpv4.MessageTypeRequest),
+		dhcpv4.WithOption(dhcpv4.OptRequestedIPAddress(lease.ACK.YourIPAddr)),
+		dhcpv4.WithOption(dhcpv4.OptMaxMessageSize(nclient4.MaxMessageSize)),
+		c.requestedOptions(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create INIT-REBOOT request: %w", err)
+	}
+
+	response, err := client.SendAndRead(ctx, client.RemoteAddr(), request,
+		nclient4.IsMessageType(dhcpv4.MessageTypeAck, dhcpv4.MessageTypeNak))
+	if err != nil {
+		return nil, fmt.Errorf("INIT-REBOOT failed: %w", err)
+	}
+	if response.MessageType() == dhcpv4.MessageTypeNak {
+		return nil, &nclient4.ErrNak{Offer: lease.Offer, Nak: response}
+	}
+	return &nclient4.Lease{Offer: lease.Offer, ACK: response, CreationTime: time.Now()}, nil
+}
+
+// renewLease extends the lease with the server that granted it (RENEWING), or with any server once
+// T2 has passed (REBINDING).
+func (c *Client) renewLease(ctx context.Context, lease *nclient4.Lease, rebind bool) (*nclient4.Lease, error) {
+	client, err := nclient4.New(c.Iface.Name, nclient4.WithTimeout(10*time.Second))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DHCP client: %w", err)
+	}
+	defer client.Close()
+
+	if !rebind {
+		return client.Renew(ctx, lease, c.requestedOptions())
+	}
+
+	request, err := dhcpv4.NewRenewFromAck(lease.ACK,
+		dhcpv4.WithOption(dhcpv4.OptMaxMessageSize(nclient4.MaxMessageSize)),
+		c.requestedOptions(),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create REBINDING request: %w", err)
+	}
+	response, err := client.SendAndRead(ctx, client.RemoteAddr(), request,
+		nclient4.IsMessageType(dhcpv4.MessageTypeAck, dhcpv4.MessageTypeNak))
+	if err != nil {
+		return nil, fmt.Errorf("REBINDING failed: %w", err)
+	}
+	if response.MessageType() == dhcpv4.MessageTypeNak {
+		return nil, &nclient4.ErrNak{Offer: lease.Offer, Nak: response}
+	}
+	return &nclient4.Lease{Offer: lease.Offer, ACK: response, CreationTime: time.Now()}, nil
+}
+
+// releaseLease gives the lease back to the server that granted it (DHCPRELEASE).
+func (c *Client) releaseLease(lease *nclient4.Lease) {
+	logger := logging.WithComponentAndInterface("dhcp", c.Iface.Name).WithField("ip", lease.ACK.YourIPAddr.String())
+
+	client, err := nclient4.New(c.Iface.Name)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to create DHCP client for RELEASE")
+		return
+	}
+	defer client.Close()
+
+	start := time.Now()
+	err = client.Release(lease)
+	c.observe(context.Background(), metrics.ExchangeRelease, start, err)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to release lease")
+		return
+	}
+	logger.Info("Released lease")
+}
+
+// observe records the result and duration of an exchange with a server, unless it was cancelled.
+func (c *Client) observe(ctx context.Context, exchange string, start time.Time, err error) {
+	if ctx.Err() != nil {
+		return
+	}
+	iface, netns := c.Iface.Name, c.metricsNetNS()
+	metrics.DHCPExchangeDuration.WithLabelValues(iface, netns, exchange).Observe(time.Since(start).Seconds())
+
+	result := metrics.ResultSuccess
+	switch {
+	case isNak(err):
+		result = metrics.ResultNak
+	case err != nil:
+		result = metrics.ResultFailure
+	}
+	metrics.DHCPExchanges.WithLabelValues(iface, netns, exchange, result).Inc()
+}
+
+// fail records why a lease could not be obtained or kept.
+func (c *Client) fail(reason string) {
+	metrics.DHCPFailures.WithLabelValues(c.Iface.Name, c.metricsNetNS(), reason).Inc()
+}
+
+// metricsNetNS returns the netns label of the metrics of the client. Lease-only clients run in
+// container namespaces that come and go, a label per namespace would grow without bound.
+func (c *Client) metricsNetNS() string {
+	if c.config.LeaseOnly {
+		return metrics.NetNSContainers
+	}
+	return c.ns.String()
+}
+
+// isNak reports whether the server rejected a request.
+func isNak(err error) bool {
+	var nak *nclient4.ErrNak
+	return errors.As(err, &nak)
+}
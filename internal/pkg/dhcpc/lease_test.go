@@ -0,0 +1,83 @@
+package dhcpc
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+)
+
+func TestLeaseTimes(t *testing.T) {
+	obtained := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name      string
+		options   []dhcpv4.Option
+		t1, t2    time.Duration
+		leaseTime time.Duration
+	}{
+		{"defaults", nil, 30 * time.Second, 52500 * time.Millisecond, time.Minute},
+		{"lease time only", []dhcpv4.Option{
+			dhcpv4.OptIPAddressLeaseTime(time.Hour),
+		}, 30 * time.Minute, 52*time.Minute + 30*time.Second, time.Hour},
+		{"renewal and rebinding", []dhcpv4.Option{
+			dhcpv4.OptIPAddressLeaseTime(time.Hour),
+			dhcpv4.OptRenewTimeValue(10 * time.Minute),
+			dhcpv4.OptRebindingTimeValue(40 * time.Minute),
+		}, 10 * time.Minute, 40 * time.Minute, time.Hour},
+		{"renewal only", []dhcpv4.Option{
+			dhcpv4.OptIPAddressLeaseTime(time.Hour),
+			dhcpv4.OptRenewTimeValue(20 * time.Minute),
+		}, 20 * time.Minute, 52*time.Minute + 30*time.Second, time.Hour},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ack, err := dhcpv4.New(dhcpv4.WithMessageType(dhcpv4.MessageTypeAck))
+			if err != nil {
+				t.Fatalf("Failed to build ACK: %v", err)
+			}
+			for _, option := range tt.options {
+				ack.UpdateOption(option)
+			}
+
+			t1, t2, expiry := leaseTimes(&nclient4.Lease{ACK: ack, CreationTime: obtained})
+			if want := obtained.Add(tt.t1); !t1.Equal(want) {
+				t.Errorf("T1 = %v, want %v", t1, want)
+			}
+			if want := obtained.Add(tt.t2); !t2.Equal(want) {
+				t.Errorf("T2 = %v, want %v", t2, want)
+			}
+			if want := obtained.Add(tt.leaseTime); !expiry.Equal(want) {
+				t.Errorf("Expiry = %v, want %v", expiry, want)
+			}
+		})
+	}
+}
+
+func TestIsNak(t *testing.T) {
+	nak := &nclient4.ErrNak{}
+
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"nil", nil, false},
+		{"nak", nak, true},
+		{"wrapped nak", fmt.Errorf("failed to renew lease: %w", nak), true},
+		{"timeout", nclient4.ErrNoResponse, false},
+		{"other error", errors.New("network is unreachable"), false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := isNak(tt.err); got != tt.want {
+				t.Errorf("isNak(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
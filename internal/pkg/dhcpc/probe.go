@@ -0,0 +1,127 @@
+package dhcpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+)
+
+// probeTimeout is how long Probe waits for the first answer to a message before retransmitting it,
+// doubling on every retransmission.
+const probeTimeout = 5 * time.Second
+
+// ProbeConfig selects the exchanges of Probe.
+type ProbeConfig struct {
+	// DiscoverOnly stops after the OFFER, so that no server commits a lease
+	DiscoverOnly bool
+
+	// Window collects every offer to a single DISCOVER received within this time, only the first
+	// offer is taken when unset. The first offer is requested.
+	Window time.Duration
+
+	// Options are requested in addition to those the client always asks for
+	Options []dhcpv4.OptionCode
+}
+
+// ProbeResult holds the messages received by Probe.
+type ProbeResult struct {
+	Offers []*dhcpv4.DHCPv4
+	Reply  *dhcpv4.DHCPv4 // ACK or NAK of the REQUEST, nil with DiscoverOnly
+}
+
+// Probe runs DISCOVER and REQUEST on the interface and returns the answers of the servers. It never
+// configures the interface, which has to be up. A NAK is returned in the result, not as an error.
+func Probe(ctx context.Context, ifaceName string, config ProbeConfig) (*ProbeResult, error) {
+	iface, err := net.InterfaceByName(ifaceName)
+	if err != nil {
+		return nil, fmt.Errorf("interface not found: %w", err)
+	}
+	if iface.Flags&net.FlagUp == 0 {
+		return nil, fmt.Errorf("interface %s is down", ifaceName)
+	}
+
+	requested := requestedOptions
+	if len(config.Options) > 0 {
+		codes := append(dhcpv4.OptionCodeList{}, requestedCodes...)
+		codes.Add(config.Options...)
+		requested = dhcpv4.WithRequestedOptions(codes...)
+	}
+
+	result := &ProbeResult{}
+	if config.Window > 0 {
+		result.Offers, err = collectOffers(ctx, ifaceName, config.Window, requested)
+	} else {
+		result.Offers, err = firstOffer(ctx, ifaceName, requested)
+	}
+	if err != nil || config.DiscoverOnly {
+		return result, err
+	}
+
+	client, err := nclient4.New(ifaceName, nclient4.WithTimeout(probeTimeout))
+	if err != nil {
+		return result, fmt.Errorf("failed to create DHCP client: %w", err)
+	}
+	defer client.Close()
+
+	lease, err := client.RequestFromOffer(ctx, result.Offers[0], requested)
+	var nak *nclient4.ErrNak
+	switch {
+	case errors.As(err, &nak):
+		result.Reply = nak.Nak
+	case err != nil:
+		return result, fmt.Errorf("no answer to REQUEST: %w", err)
+	default:
+		result.Reply = lease.ACK
+	}
+	return result, nil
+}
+
+// firstOffer returns the first offer to DISCOVER.
+func firstOffer(ctx context.Context, ifaceName string, requested dhcpv4.Modifier) ([]*dhcpv4.DHCPv4, error) {
+	client, err := nclient4.New(ifaceName, nclient4.WithTimeout(probeTimeout))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DHCP client: %w", err)
+	}
+	defer client.Close()
+
+	offer, err := client.DiscoverOffer(ctx, requested)
+	if err != nil {
+		return nil, fmt.Errorf("no answer to DISCOVER: %w", err)
+	}
+	return []*dhcpv4.DHCPv4{offer}, nil
+}
+
+// collectOffers sends a single DISCOVER and returns every offer received within the window, in the
+// order they arrived.
+func collectOffers(ctx context.Context, ifaceName string, window time.Duration, requested dhcpv4.Modifier) ([]*dhcpv4.DHCPv4, error) {
+	client, err := nclient4.New(ifaceName, nclient4.WithTimeout(window), nclient4.WithRetry(1))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DHCP client: %w", err)
+	}
+	defer client.Close()
+
+	discover, err := dhcpv4.NewDiscovery(client.InterfaceAddr(), requested,
+		dhcpv4.WithOption(dhcpv4.OptMaxMessageSize(nclient4.MaxMessageSize)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DISCOVER: %w", err)
+	}
+
+	// Rejecting every offer keeps the client listening until the window closes. Offers are not
+	// merged by server identifier, so that servers posing as another one show up.
+	var offers []*dhcpv4.DHCPv4
+	_, err = client.SendAndRead(ctx, client.RemoteAddr(), discover, func(msg *dhcpv4.DHCPv4) bool {
+		if msg.MessageType() == dhcpv4.MessageTypeOffer {
+			offers = append(offers, msg)
+		}
+		return false
+	})
+	if len(offers) == 0 {
+		return nil, fmt.Errorf("no answer to DISCOVER: %w", err)
+	}
+	return offers, nil
+}
@@ -0,0 +1,16 @@
+package dhcpc
+
+import (
+	"context"
+	"testing"
+)
+
+func TestProbeMissingInterface(t *testing.T) {
+	result, err := Probe(context.Background(), "nonexistent0", ProbeConfig{DiscoverOnly: true})
+	if err == nil {
+		t.Fatal("Probe() succeeded on a missing interface")
+	}
+	if result != nil {
+		t.Errorf("Probe() = %+v, want no result", result)
+	}
+}
@@ -0,0 +1,314 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"golang-dhcpcd/internal/pkg/logging"
+	"golang-dhcpcd/internal/pkg/routes"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+)
+
+// Probe types.
+const (
+	ProbeARP  = "arp"  // ARP request for the gateway
+	ProbeICMP = "icmp" // ICMP echo to the target, the gateway by default
+	ProbeTCP  = "tcp"  // TCP connect to the target host:port
+)
+
+// Actions taken while the gateway is down.
+const (
+	ActionWithdraw = "withdraw" // Remove the default routes of the interface
+	ActionMetric   = "metric"   // Raise the metric of the default routes by the penalty
+)
+
+// Defaults for the optional settings.
+const (
+	DefaultInterval      = 5 * time.Second
+	DefaultTimeout       = time.Second
+	DefaultFailures      = 3
+	DefaultSuccesses     = 2
+	DefaultMetricPenalty = 10000
+)
+
+// Config represents the gateway health check of an interface.
+type Config struct {
+	Type          string        `yaml:"type"`
+	Target        string        `yaml:"target,omitempty"` // IP for icmp, IP:port for tcp
+	Interval      time.Duration `yaml:"interval,omitempty"`
+	Timeout       time.Duration `yaml:"timeout,omitempty"`
+	Failures      int           `yaml:"failures,omitempty"`  // Consecutive failures before the gateway is down
+	Successes     int           `yaml:"successes,omitempty"` // Consecutive successes before the gateway is up again
+	Action        string        `yaml:"action,omitempty"`
+	MetricPenalty int           `yaml:"metric_penalty,omitempty"`
+}
+
+// Validate checks the health check settings.
+func (c Config) Validate() error {
+	switch c.Type {
+	case ProbeARP:
+		if c.Target != "" {
+			return fmt.Errorf("arp health checks always probe the gateway, target must not be set")
+		}
+	case ProbeICMP:
+		if c.Target != "" && net.ParseIP(c.Target) == nil {
+			return fmt.Errorf("invalid icmp health check target %q: must be an IP address", c.Target)
+		}
+	case ProbeTCP:
+		if _, err := netip.ParseAddrPort(c.Target); err != nil {
+			return fmt.Errorf("invalid tcp health check target %q: must be IP:port", c.Target)
+		}
+	default:
+		return fmt.Errorf("invalid health check type %q: must be arp, icmp or tcp", c.Type)
+	}
+
+	switch c.Action {
+	case "", ActionWithdraw, ActionMetric:
+	default:
+		return fmt.Errorf("invalid health check action %q: must be withdraw or metric", c.Action)
+	}
+
+	if c.Interval < 0 || c.Timeout < 0 || c.Failures < 0 || c.Successes < 0 || c.MetricPenalty < 0 {
+		return fmt.Errorf("health check interval, timeout, failures, successes and metric_penalty must not be negative")
+	}
+	if c.Interval > 0 && c.Timeout > c.Interval {
+		return fmt.Errorf("health check timeout must not exceed the interval")
+	}
+	return nil
+}
+
+// withDefaults returns the settings with the defaults filled in.
+func (c Config) withDefaults() Config {
+	if c.Interval == 0 {
+		c.Interval = DefaultInterval
+	}
+	if c.Timeout == 0 {
+		c.Timeout = min(DefaultTimeout, c.Interval)
+	}
+	if c.Failures == 0 {
+		c.Failures = DefaultFailures
+	}
+	if c.Successes == 0 {
+		c.Successes = DefaultSuccesses
+	}
+	if c.Action == "" {
+		c.Action = ActionWithdraw
+	}
+	if c.MetricPenalty == 0 {
+		c.MetricPenalty = DefaultMetricPenalty
+	}
+	return c
+}
+
+// Withdraws reports whether the default routes are removed while the gateway is down.
+func (c Config) Withdraws() bool {
+	return c.withDefaults().Action == ActionWithdraw
+}
+
+// Metric returns the default route metric to use while the gateway is down.
+func (c Config) Metric(metric int) int {
+	return metric + c.withDefaults().MetricPenalty
+}
+
+// target returns the address probed through the gateway.
+func (c Config) target(gateway net.IP) net.IP {
+	switch c.Type {
+	case ProbeICMP:
+		if ip := net.ParseIP(c.Target); ip != nil {
+			return ip
+		}
+	case ProbeTCP:
+		if addrPort, err := netip.ParseAddrPort(c.Target); err == nil {
+			return net.IP(addrPort.Addr().Unmap().AsSlice())
+		}
+	}
+	return gateway
+}
+
+// ProbeRoute returns the host route that keeps an off-link probe target reachable through the
+// gateway while the default routes are withdrawn, or nil if none is needed.
+func (c Config) ProbeRoute(link netlink.Link, gateway net.IP, protocol netlink.RouteProtocol) *netlink.Route {
+	target := c.target(gateway)
+	if target.Equal(gateway) || (target.To4() == nil) != (gateway.To4() == nil) {
+		return nil
+	}
+
+	bits := 128
+	if target.To4() != nil {
+		bits = 32
+	}
+	route, err := routes.Route{
+		Destination: fmt.Sprintf("%s/%d", target, bits),
+		Gateway:     gateway.String(),
+	}.ToNetlink(link)
+	if err != nil {
+		return nil
+	}
+	route.Protocol = protocol
+	return route
+}
+
+// State is the health of a gateway.
+type State string
+
+// Gateway states.
+const (
+	StateUnknown State = "unknown"
+	StateUp      State = "up"
+	StateDown    State = "down"
+)
+
+// Status is a snapshot of a health check.
+type Status struct {
+	Type      string    `json:"type"`
+	Gateway   string    `json:"gateway"`
+	Target    string    `json:"target"`
+	State     State     `json:"state"`
+	Since     time.Time `json:"since"`
+	Failures  int       `json:"failures"`
+	LastError string    `json:"last_error,omitempty"`
+}
+
+// Checker probes a gateway periodically and reports when it goes down or comes back up.
+type Checker struct {
+	config   Config
+	iface    *net.Interface
+	gateway  net.IP
+	onChange func(State)
+	logger   *logrus.Entry
+
+	mu        sync.Mutex
+	status    Status
+	successes int
+}
+
+// NewChecker creates a checker for the gateway of the interface. onChange is called from the
+// goroutine running the checker whenever the gateway goes down or recovers.
+func NewChecker(config Config, iface *net.Interface, gateway net.IP, onChange func(State)) *Checker {
+	config = config.withDefaults()
+	target := config.target(gateway)
+	if config.Type == ProbeTCP {
+		target = nil
+	}
+
+	status := Status{
+		Type:    config.Type,
+		Gateway: gateway.String(),
+		Target:  config.Target,
+		State:   StateUnknown,
+		Since:   time.Now(),
+	}
+	if target != nil {
+		status.Target = target.String()
+	}
+
+	return &Checker{
+		config:   config,
+		iface:    iface,
+		gateway:  gateway,
+		onChange: onChange,
+		logger: logging.WithComponentAndInterface("health", iface.Name).WithFields(logrus.Fields{
+			"type":   config.Type,
+			"target": status.Target,
+		}),
+		status: status,
+	}
+}
+
+// Status returns a snapshot of the health check.
+func (c *Checker) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.status
+}
+
+// Run probes the gateway until the context is cancelled.
+func (c *Checker) Run(ctx context.Context) {
+	c.logger.WithField("interval", c.config.Interval).Info("Starting gateway health checks")
+
+	ticker := time.NewTicker(c.config.Interval)
+	defer ticker.Stop()
+
+	for seq := 1; ; seq++ {
+		err := c.probe(ctx, seq)
+		if ctx.Err() != nil {
+			c.logger.Info("Stopping gateway health checks")
+			return
+		}
+		c.record(err)
+
+		select {
+		case <-ctx.Done():
+			c.logger.Info("Stopping gateway health checks")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// probe runs a single probe of the configured type.
+func (c *Checker) probe(ctx context.Context, seq int) error {
+	ctx, cancel := context.WithTimeout(ctx, c.config.Timeout)
+	defer cancel()
+
+	switch c.config.Type {
+	case ProbeARP:
+		return probeARP(ctx, c.iface, c.gateway)
+	case ProbeICMP:
+		return probeICMP(ctx, c.iface, c.config.target(c.gateway), seq)
+	case ProbeTCP:
+		return probeTCP(ctx, c.iface, c.config.Target)
+	}
+	return fmt.Errorf("unsupported health check type %q", c.config.Type)
+}
+
+// record updates the state with the result of a probe and reports transitions.
+func (c *Checker) record(err error) {
+	c.mu.Lock()
+	previous := c.status.State
+	if err != nil {
+		c.successes = 0
+		c.status.Failures++
+		c.status.LastError = err.Error()
+		c.logger.WithError(err).WithField("failures", c.status.Failures).Debug("Gateway health check failed")
+		if previous != StateDown && c.status.Failures >= c.config.Failures {
+			c.status.State = StateDown
+			c.status.Since = time.Now()
+		}
+	} else {
+		c.successes++
+		c.status.Failures = 0
+		c.status.LastError = ""
+		if previous == StateUnknown || (previous == StateDown && c.successes >= c.config.Successes) {
+			c.status.State = StateUp
+			c.status.Since = time.Now()
+		}
+	}
+	current := c.status.State
+	c.mu.Unlock()
+
+	if current == previous {
+		return
+	}
+
+	switch current {
+	case StateDown:
+		c.logger.WithError(err).Warn("Gateway is unreachable, failing over")
+	case StateUp:
+		if previous == StateUnknown {
+			c.logger.Info("Gateway is reachable")
+			return
+		}
+		c.logger.Info("Gateway recovered, restoring default route")
+	}
+	if c.onChange != nil {
+		c.onChange(current)
+	}
+}
@@ -0,0 +1,107 @@
+package health
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCheckerStates(t *testing.T) {
+	errProbe := errors.New("no answer")
+
+	tests := []struct {
+		name    string
+		results []error
+		want    State
+		changes []State
+	}{
+		{"initial", nil, StateUnknown, nil},
+		{"up on first success", []error{nil}, StateUp, nil},
+		{"failures below threshold", []error{errProbe, errProbe}, StateUnknown, nil},
+		{"down after failures", []error{errProbe, errProbe, errProbe}, StateDown, []State{StateDown}},
+		{"down reported once", []error{errProbe, errProbe, errProbe, errProbe, errProbe}, StateDown, []State{StateDown}},
+		{"success resets failures", []error{nil, errProbe, errProbe, nil, errProbe, errProbe}, StateUp, nil},
+		{"single success does not recover", []error{nil, errProbe, errProbe, errProbe, nil}, StateDown, []State{StateDown}},
+		{"recovers after successes", []error{nil, errProbe, errProbe, errProbe, nil, nil}, StateUp, []State{StateDown, StateUp}},
+		{"failure interrupts recovery", []error{errProbe, errProbe, errProbe, nil, errProbe, nil}, StateDown, []State{StateDown}},
+		{"goes down again", []error{errProbe, errProbe, errProbe, nil, nil, errProbe, errProbe, errProbe}, StateDown, []State{StateDown, StateUp, StateDown}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var changes []State
+			config := Config{Type: ProbeARP, Failures: 3, Successes: 2}
+			checker := NewChecker(config, &net.Interface{Name: "eth0"}, net.IPv4(192, 0, 2, 1), func(state State) {
+				changes = append(changes, state)
+			})
+
+			for _, err := range tt.results {
+				checker.record(err)
+			}
+
+			status := checker.Status()
+			if status.State != tt.want {
+				t.Errorf("State = %s, want %s", status.State, tt.want)
+			}
+			if !slices.Equal(changes, tt.changes) {
+				t.Errorf("Reported changes = %v, want %v", changes, tt.changes)
+			}
+		})
+	}
+}
+
+func TestCheckerStatus(t *testing.T) {
+	checker := NewChecker(Config{Type: ProbeICMP}, &net.Interface{Name: "eth0"}, net.IPv4(192, 0, 2, 1), nil)
+	if status := checker.Status(); status.Target != "192.0.2.1" {
+		t.Errorf("Target = %q, want the gateway", status.Target)
+	}
+
+	checker.record(errors.New("no answer"))
+	status := checker.Status()
+	if status.Failures != 1 || status.LastError != "no answer" {
+		t.Errorf("After a failure: failures = %d, last error = %q", status.Failures, status.LastError)
+	}
+
+	before := status.Since
+	time.Sleep(time.Millisecond)
+	checker.record(nil)
+	status = checker.Status()
+	if status.Failures != 0 || status.LastError != "" {
+		t.Errorf("After a success: failures = %d, last error = %q", status.Failures, status.LastError)
+	}
+	if !status.Since.After(before) {
+		t.Error("Since was not updated on the state change")
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"arp", Config{Type: ProbeARP}, false},
+		{"arp with target", Config{Type: ProbeARP, Target: "192.0.2.1"}, true},
+		{"icmp to gateway", Config{Type: ProbeICMP}, false},
+		{"icmp to target", Config{Type: ProbeICMP, Target: "2001:db8::1"}, false},
+		{"icmp to hostname", Config{Type: ProbeICMP, Target: "example.com"}, true},
+		{"tcp", Config{Type: ProbeTCP, Target: "192.0.2.1:443"}, false},
+		{"tcp without port", Config{Type: ProbeTCP, Target: "192.0.2.1"}, true},
+		{"unknown type", Config{Type: "http"}, true},
+		{"metric action", Config{Type: ProbeARP, Action: ActionMetric}, false},
+		{"unknown action", Config{Type: ProbeARP, Action: "reboot"}, true},
+		{"negative failures", Config{Type: ProbeARP, Failures: -1}, true},
+		{"timeout exceeds interval", Config{Type: ProbeARP, Interval: time.Second, Timeout: 2 * time.Second}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
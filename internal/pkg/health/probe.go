@@ -0,0 +1,124 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/netip"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/mdlayher/arp"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+	"golang.org/x/sys/unix"
+)
+
+// bindToDevice returns a socket control function that binds sockets to the interface,
+// so that probes leave through it regardless of the other default routes.
+func bindToDevice(ifaceName string) func(network, address string, c syscall.RawConn) error {
+	return func(network, address string, c syscall.RawConn) error {
+		var sockErr error
+		if err := c.Control(func(fd uintptr) {
+			sockErr = unix.SetsockoptString(int(fd), unix.SOL_SOCKET, unix.SO_BINDTODEVICE, ifaceName)
+		}); err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
+
+// deadline returns the deadline of the context, which always has one for probes.
+func deadline(ctx context.Context) time.Time {
+	if d, ok := ctx.Deadline(); ok {
+		return d
+	}
+	return time.Now().Add(DefaultTimeout)
+}
+
+// probeARP resolves the gateway with an ARP request on the interface.
+func probeARP(ctx context.Context, iface *net.Interface, gateway net.IP) error {
+	addr, ok := netip.AddrFromSlice(gateway.To4())
+	if !ok {
+		return fmt.Errorf("arp health checks require an IPv4 gateway")
+	}
+
+	client, err := arp.Dial(iface)
+	if err != nil {
+		return fmt.Errorf("failed to open ARP socket: %w", err)
+	}
+	defer client.Close()
+
+	if err := client.SetDeadline(deadline(ctx)); err != nil {
+		return fmt.Errorf("failed to set ARP deadline: %w", err)
+	}
+	if _, err := client.Resolve(addr); err != nil {
+		return fmt.Errorf("gateway %s did not answer ARP request: %w", gateway, err)
+	}
+	return nil
+}
+
+// probeICMP sends an ICMP echo request to the target through the interface and waits for the reply.
+func probeICMP(ctx context.Context, iface *net.Interface, target net.IP, seq int) error {
+	network, proto := "ip4:icmp", 1
+	var requestType, replyType icmp.Type = ipv4.ICMPTypeEcho, ipv4.ICMPTypeEchoReply
+	if target.To4() == nil {
+		network, proto = "ip6:ipv6-icmp", 58
+		requestType, replyType = ipv6.ICMPTypeEchoRequest, ipv6.ICMPTypeEchoReply
+	}
+
+	lc := net.ListenConfig{Control: bindToDevice(iface.Name)}
+	conn, err := lc.ListenPacket(ctx, network, "")
+	if err != nil {
+		return fmt.Errorf("failed to open ICMP socket: %w", err)
+	}
+	defer conn.Close()
+
+	id := (os.Getpid() ^ rand.Intn(0xffff)) & 0xffff
+	request, err := (&icmp.Message{
+		Type: requestType,
+		Body: &icmp.Echo{ID: id, Seq: seq & 0xffff, Data: []byte("golang-dhcpcd")},
+	}).Marshal(nil)
+	if err != nil {
+		return fmt.Errorf("failed to encode ICMP echo request: %w", err)
+	}
+
+	if err := conn.SetDeadline(deadline(ctx)); err != nil {
+		return fmt.Errorf("failed to set ICMP deadline: %w", err)
+	}
+	if _, err := conn.WriteTo(request, &net.IPAddr{IP: target, Zone: iface.Name}); err != nil {
+		return fmt.Errorf("failed to send ICMP echo request to %s: %w", target, err)
+	}
+
+	// The socket sees every ICMP packet on the interface, wait for our reply
+	buf := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return fmt.Errorf("no ICMP echo reply from %s: %w", target, err)
+		}
+		if addr, ok := from.(*net.IPAddr); !ok || !addr.IP.Equal(target) {
+			continue
+		}
+		reply, err := icmp.ParseMessage(proto, buf[:n])
+		if err != nil || reply.Type != replyType {
+			continue
+		}
+		if echo, ok := reply.Body.(*icmp.Echo); ok && echo.ID == id && echo.Seq == seq&0xffff {
+			return nil
+		}
+	}
+}
+
+// probeTCP connects to the target through the interface.
+func probeTCP(ctx context.Context, iface *net.Interface, target string) error {
+	dialer := net.Dialer{Control: bindToDevice(iface.Name)}
+	conn, err := dialer.DialContext(ctx, "tcp", target)
+	if err != nil {
+		return fmt.Errorf("failed to connect to %s: %w", target, err)
+	}
+	return conn.Close()
+}
@@ -0,0 +1,420 @@
+package hotplug
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"golang-dhcpcd/internal/pkg/logging"
+	"golang-dhcpcd/internal/pkg/namespace"
+	"golang-dhcpcd/internal/pkg/netmon"
+
+	"github.com/vishvananda/netlink"
+)
+
+// settleDelay lets renames by udev finish before interfaces are matched.
+const settleDelay = 200 * time.Millisecond
+
+// rescanInterval is how often the links are listed in case netlink events are lost.
+const rescanInterval = 30 * time.Second
+
+// Failed managers are started again after a delay doubling from minRetryDelay up to maxRetryDelay.
+// Managers that ran for maxRetryDelay start over at minRetryDelay.
+const (
+	minRetryDelay = time.Second
+	maxRetryDelay = time.Minute
+)
+
+// ErrLinkGone is the cause of the cancellation of an interface whose link was removed or no longer matches.
+var ErrLinkGone = errors.New("link removed or no longer matching")
+
+// Rule selects the links an entry of the configuration applies to. Empty fields match any value,
+// the others are shell patterns.
+type Rule struct {
+	Entry  string // Name of the configuration entry
+	Name   string
+	MAC    string
+	Driver string
+	Path   string // Bus address of the device, e.g. 0000:02:00.0
+	Kind   string // Link type reported by netlink, e.g. device, vlan or bond
+}
+
+// Device describes a link for matching.
+type Device struct {
+	Index  int
+	Name   string
+	MAC    string
+	Driver string
+	Path   string
+	Kind   string
+}
+
+// Matches reports whether the device satisfies every field of the rule.
+func (r Rule) Matches(device Device) bool {
+	return match(r.Name, device.Name) &&
+		match(strings.ToLower(r.MAC), strings.ToLower(device.MAC)) &&
+		match(r.Driver, device.Driver) &&
+		match(r.Path, device.Path) &&
+		match(r.Kind, device.Kind)
+}
+
+// literal reports whether the rule selects a single interface by its exact name.
+func (r Rule) literal() bool {
+	return r.Name != "" && !IsPattern(r.Name) && r.MAC == "" && r.Driver == "" && r.Path == "" && r.Kind == ""
+}
+
+// IsPattern reports whether the name contains shell pattern characters.
+func IsPattern(name string) bool {
+	return strings.ContainsAny(name, "*?[\\")
+}
+
+// match reports whether the value matches the pattern, an empty pattern matches anything.
+func match(pattern, value string) bool {
+	if pattern == "" {
+		return true
+	}
+	matched, err := path.Match(pattern, value)
+	return err == nil && matched
+}
+
+// deviceInfo collects the attributes of a link used for matching. Driver and path are only known
+// in the namespace of the daemon, as sysfs shows the namespace it was mounted in.
+func deviceInfo(link netlink.Link, sysfs bool) Device {
+	attrs := link.Attrs()
+	device := Device{
+		Index: attrs.Index,
+		Name:  attrs.Name,
+		MAC:   attrs.HardwareAddr.String(),
+		Kind:  link.Type(),
+	}
+
+	if !sysfs {
+		return device
+	}
+	dir := filepath.Join("/sys/class/net", attrs.Name, "device")
+	if driver, err := os.Readlink(filepath.Join(dir, "driver")); err == nil {
+		device.Driver = filepath.Base(driver)
+	}
+	if resolved, err := filepath.EvalSymlinks(dir); err == nil {
+		device.Path = filepath.Base(resolved)
+	}
+	return device
+}
+
+// StartFunc manages an interface until the context is cancelled. Managers returning an error
+// before are started again after a delay.
+type StartFunc func(ctx context.Context, entry, ifaceName string) error
+
+// instance is a running manager of one link.
+type instance struct {
+	entry   string
+	name    string
+	started time.Time
+	cancel  context.CancelCauseFunc
+	done    chan struct{}
+	err     error // Why the manager failed, set before done is closed
+}
+
+// retry delays starting the manager of a link again after it failed.
+type retry struct {
+	entry string
+	delay time.Duration
+	at    time.Time
+}
+
+// Watcher starts a manager for every link matching a rule and stops it when the link disappears.
+type Watcher struct {
+	rules   []Rule
+	start   StartFunc
+	running map[int]*instance
+	retries map[int]retry
+	waiting map[string]bool
+	failed  chan struct{}
+
+	// mu guards the update handed over to Run
+	mu      sync.Mutex
+	pending []Rule
+	restart map[string]bool
+	updated chan struct{}
+}
+
+// NewWatcher creates a watcher for the rules. Rules naming a single interface take precedence,
+// the others are tried in the given order.
+func NewWatcher(rules []Rule, start StartFunc) *Watcher {
+	return &Watcher{
+		rules:   orderRules(rules),
+		start:   start,
+		running: make(map[int]*instance),
+		retries: make(map[int]retry),
+		waiting: make(map[string]bool),
+		failed:  make(chan struct{}, 1),
+		restart: make(map[string]bool),
+		updated: make(chan struct{}, 1),
+	}
+}
+
+// orderRules puts the rules naming a single interface first.
+func orderRules(rules []Rule) []Rule {
+	ordered := append([]Rule{}, rules...)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return ordered[i].literal() && !ordered[j].literal()
+	})
+	return ordered
+}
+
+// Update replaces the rules and restarts the managers of the given entries, e.g. after their
+// configuration changed. Managers of links no longer matched are stopped, newly matched links started.
+func (w *Watcher) Update(rules []Rule, restart []string) {
+	w.mu.Lock()
+	w.pending = orderRules(rules)
+	for _, entry := range restart {
+		w.restart[entry] = true
+	}
+	w.mu.Unlock()
+
+	select {
+	case w.updated <- struct{}{}:
+	default:
+	}
+}
+
+// applyUpdate takes over the rules handed to Update and stops the managers of removed and changed entries.
+func (w *Watcher) applyUpdate() {
+	w.mu.Lock()
+	w.rules = w.pending
+	restart := w.restart
+	w.restart = make(map[string]bool)
+	w.mu.Unlock()
+
+	configured := make(map[string]bool)
+	for _, rule := range w.rules {
+		configured[rule.Entry] = true
+	}
+	for entry := range w.waiting {
+		if !configured[entry] {
+			delete(w.waiting, entry)
+		}
+	}
+
+	// Failed managers of changed entries start again right away
+	for index, r := range w.retries {
+		if !configured[r.entry] || restart[r.entry] {
+			delete(w.retries, index)
+		}
+	}
+
+	// Managers of removed entries stop as their link no longer matches, the others start again
+	// with the new configuration
+	for index, inst := range w.running {
+		logger := logging.WithComponentAndInterface("hotplug", inst.name).WithField("entry", inst.entry)
+		switch {
+		case !configured[inst.entry]:
+			logger.Info("Configuration removed, stopping")
+			inst.cancel(ErrLinkGone)
+		case restart[inst.entry]:
+			logger.Info("Configuration changed, restarting")
+			inst.cancel(context.Canceled)
+		default:
+			continue
+		}
+		<-inst.done
+		delete(w.running, index)
+	}
+}
+
+// Run matches the present links and follows link changes until the context is cancelled.
+// It returns once every manager has stopped.
+func (w *Watcher) Run(ctx context.Context) error {
+	logger := logging.WithComponent("hotplug")
+
+	events, err := netmon.Subscribe(ctx, 0)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to subscribe to link changes, rescanning periodically")
+	}
+	ticker := time.NewTicker(rescanInterval)
+	defer ticker.Stop()
+
+	for {
+		w.reconcile(ctx)
+
+		select {
+		case <-w.failed:
+		case <-w.nextRetry():
+		case <-ctx.Done():
+			w.stopAll()
+			return nil
+		case event := <-events:
+			if event.Link == nil && !event.Resync {
+				continue
+			}
+			if !netmon.Settle(ctx, events, settleDelay) {
+				w.stopAll()
+				return nil
+			}
+		case <-w.updated:
+			w.applyUpdate()
+		case <-ticker.C:
+		}
+	}
+}
+
+// reconcile starts managers for new matching links and stops those of links that are gone,
+// renamed or matched by another rule. Managers that failed are started again after a delay.
+func (w *Watcher) reconcile(ctx context.Context) {
+	ns := namespace.FromContext(ctx)
+	logger := logging.WithComponent("hotplug")
+
+	links, err := netlink.LinkList()
+	if err != nil {
+		logger.WithError(err).Warn("Failed to list links")
+		return
+	}
+
+	wanted := make(map[int]Device)
+	entries := make(map[int]string)
+	for _, link := range links {
+		device := deviceInfo(link, ns == nil)
+		for _, rule := range w.rules {
+			if rule.Matches(device) {
+				wanted[device.Index] = device
+				entries[device.Index] = rule.Entry
+				break
+			}
+		}
+	}
+
+	// Failed managers start again once their delay passed, unless their link is gone
+	w.reapFailed(time.Now())
+	for index := range w.retries {
+		if _, exists := wanted[index]; !exists {
+			delete(w.retries, index)
+		}
+	}
+
+	// Stop managers whose link is gone or changed
+	for index, inst := range w.running {
+		device, exists := wanted[index]
+		if exists && device.Name == inst.name && entries[index] == inst.entry {
+			continue
+		}
+		logging.WithComponentAndInterface("hotplug", inst.name).WithField("entry", inst.entry).Info("Interface gone, stopping")
+		inst.cancel(ErrLinkGone)
+		<-inst.done
+		delete(w.running, index)
+	}
+
+	// Start managers for new links
+	present := make(map[string]bool)
+	for index, device := range wanted {
+		present[entries[index]] = true
+		if _, exists := w.running[index]; exists {
+			continue
+		}
+		if r, exists := w.retries[index]; exists && r.entry == entries[index] && time.Now().Before(r.at) {
+			continue
+		}
+
+		ifaceLogger := logging.WithComponentAndInterface("hotplug", device.Name)
+		if ns != nil {
+			ifaceLogger = ifaceLogger.WithField("netns", ns.String())
+		}
+		ifaceLogger.WithField("entry", entries[index]).Info("Interface matched, starting")
+		instCtx, cancel := context.WithCancelCause(ctx)
+		inst := &instance{entry: entries[index], name: device.Name, started: time.Now(), cancel: cancel, done: make(chan struct{})}
+		w.running[index] = inst
+		go func() {
+			var err error
+			if doErr := namespace.Do(instCtx, func() { err = w.start(instCtx, inst.entry, inst.name) }); doErr != nil {
+				ifaceLogger.WithError(doErr).Error("Failed to start interface")
+				err = doErr
+			}
+			if err != nil && instCtx.Err() == nil {
+				inst.err = err
+			}
+			close(inst.done)
+			if inst.err != nil {
+				select {
+				case w.failed <- struct{}{}:
+				default:
+				}
+			}
+		}()
+	}
+
+	// Tell once about interfaces named in the configuration that are not there yet
+	for _, rule := range w.rules {
+		if !rule.literal() {
+			continue
+		}
+		if present[rule.Entry] {
+			delete(w.waiting, rule.Entry)
+		} else if !w.waiting[rule.Entry] {
+			w.waiting[rule.Entry] = true
+			logging.WithComponentAndInterface("hotplug", rule.Name).Info("Interface not present, waiting for it to appear")
+		}
+	}
+}
+
+// reapFailed forgets the managers that failed and schedules starting them again. Managers that
+// returned without an error keep their link until it is gone.
+func (w *Watcher) reapFailed(now time.Time) {
+	for index, inst := range w.running {
+		select {
+		case <-inst.done:
+		default:
+			continue
+		}
+		if inst.err == nil {
+			continue
+		}
+
+		delay := minRetryDelay
+		if r, exists := w.retries[index]; exists && r.entry == inst.entry && now.Sub(inst.started) < maxRetryDelay {
+			delay = min(2*r.delay, maxRetryDelay)
+		}
+		w.retries[index] = retry{entry: inst.entry, delay: delay, at: now.Add(delay)}
+		inst.cancel(context.Canceled)
+		delete(w.running, index)
+		logging.WithComponentAndInterface("hotplug", inst.name).WithField("entry", inst.entry).
+			WithField("delay", delay).WithError(inst.err).Warn("Interface failed, restarting later")
+	}
+}
+
+// nextRetry returns a channel receiving once the next failed manager is due, nil without any.
+func (w *Watcher) nextRetry() <-chan time.Time {
+	now := time.Now()
+	var next time.Time
+	for _, r := range w.retries {
+		if !r.at.After(now) {
+			continue
+		}
+		if next.IsZero() || r.at.Before(next) {
+			next = r.at
+		}
+	}
+	if next.IsZero() {
+		return nil
+	}
+	return time.After(time.Until(next))
+}
+
+// stopAll stops every manager and waits for them in parallel.
+func (w *Watcher) stopAll() {
+	var wg sync.WaitGroup
+	for index, inst := range w.running {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			inst.cancel(context.Canceled)
+			<-inst.done
+		}()
+		delete(w.running, index)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,212 @@
+package hotplug
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestRuleMatches(t *testing.T) {
+	device := Device{
+		Index:  2,
+		Name:   "enp2s0",
+		MAC:    "52:54:00:ab:cd:ef",
+		Driver: "virtio_net",
+		Path:   "0000:02:00.0",
+		Kind:   "device",
+	}
+
+	tests := []struct {
+		name string
+		rule Rule
+		want bool
+	}{
+		{"empty rule", Rule{}, true},
+		{"exact name", Rule{Name: "enp2s0"}, true},
+		{"other name", Rule{Name: "enp3s0"}, false},
+		{"name pattern", Rule{Name: "enp*"}, true},
+		{"name class", Rule{Name: "enp[0-9]s0"}, true},
+		{"name pattern mismatch", Rule{Name: "eth*"}, false},
+		{"invalid pattern", Rule{Name: "enp[2"}, false},
+		{"mac", Rule{MAC: "52:54:00:ab:cd:ef"}, true},
+		{"mac in upper case", Rule{MAC: "52:54:00:AB:CD:EF"}, true},
+		{"mac pattern", Rule{MAC: "52:54:00:*"}, true},
+		{"other mac", Rule{MAC: "52:54:00:00:00:01"}, false},
+		{"driver", Rule{Driver: "virtio_*"}, true},
+		{"other driver", Rule{Driver: "e1000e"}, false},
+		{"path", Rule{Path: "0000:02:00.?"}, true},
+		{"kind", Rule{Kind: "device"}, true},
+		{"other kind", Rule{Kind: "vlan"}, false},
+		{"every field", Rule{Name: "enp*", MAC: "52:54:00:*", Driver: "virtio_net", Path: "0000:02:00.0", Kind: "device"}, true},
+		{"one field differs", Rule{Name: "enp*", Driver: "virtio_net", Kind: "bond"}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.rule.Matches(device); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Driver and path are unknown in other namespaces, only empty fields match them
+	inNetNS := Device{Index: 2, Name: "eth0", MAC: device.MAC, Kind: "veth"}
+	if (Rule{Name: "eth0", Driver: "veth"}).Matches(inNetNS) {
+		t.Error("Rule with a driver matched a device without a known driver")
+	}
+}
+
+func TestIsPattern(t *testing.T) {
+	tests := []struct {
+		name string
+		want bool
+	}{
+		{"eth0", false},
+		{"br-lan", false},
+		{"eth*", true},
+		{"eth?", true},
+		{"eth[01]", true},
+		{"eth\\0", true},
+	}
+
+	for _, tt := range tests {
+		if got := IsPattern(tt.name); got != tt.want {
+			t.Errorf("IsPattern(%q) = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestOrderRules(t *testing.T) {
+	rules := []Rule{
+		{Entry: "lan", Name: "enp*"},
+		{Entry: "uplink", Name: "enp2s0"},
+		{Entry: "mgmt", MAC: "52:54:00:*"},
+		{Entry: "wan", Name: "eth0"},
+		{Entry: "vlan", Name: "eth0.10", Kind: "vlan"},
+	}
+
+	ordered := orderRules(rules)
+
+	want := []string{"uplink", "wan", "lan", "mgmt", "vlan"}
+	if len(ordered) != len(want) {
+		t.Fatalf("orderRules() returned %d rules, want %d", len(ordered), len(want))
+	}
+	for i, rule := range ordered {
+		if rule.Entry != want[i] {
+			t.Errorf("Rule %d is %s, want %s", i, rule.Entry, want[i])
+		}
+	}
+	if rules[0].Entry != "lan" {
+		t.Error("orderRules() reordered the rules of the caller")
+	}
+}
+
+func TestApplyUpdate(t *testing.T) {
+	w := NewWatcher([]Rule{
+		{Entry: "lan", Name: "enp*"},
+		{Entry: "wan", Name: "eth0"},
+		{Entry: "mgmt", Name: "eth1"},
+	}, nil)
+
+	// Each manager stops once its context is cancelled and remembers why
+	causes := make(map[string]error)
+	for index, entry := range []string{"lan", "wan", "mgmt"} {
+		ctx, cancel := context.WithCancelCause(context.Background())
+		inst := &instance{entry: entry, name: entry, cancel: cancel, done: make(chan struct{})}
+		go func() {
+			<-ctx.Done()
+			causes[entry] = context.Cause(ctx)
+			close(inst.done)
+		}()
+		w.running[index+1] = inst
+	}
+	w.waiting["old"] = true
+	w.waiting["lan"] = true
+
+	w.Update([]Rule{{Entry: "lan", Name: "enp*"}, {Entry: "wan", Name: "eth0"}}, []string{"wan"})
+	w.applyUpdate()
+
+	if _, ok := w.running[1]; !ok {
+		t.Error("Manager of the unchanged entry was stopped")
+	}
+	if len(w.running) != 1 {
+		t.Errorf("%d managers running, want 1", len(w.running))
+	}
+	if cause := causes["wan"]; !errors.Is(cause, context.Canceled) {
+		t.Errorf("Changed entry stopped with %v, want %v", cause, context.Canceled)
+	}
+	if cause := causes["mgmt"]; !errors.Is(cause, ErrLinkGone) {
+		t.Errorf("Removed entry stopped with %v, want %v", cause, ErrLinkGone)
+	}
+	if w.waiting["old"] || !w.waiting["lan"] {
+		t.Errorf("Waiting entries = %v, want only lan", w.waiting)
+	}
+	if len(w.rules) != 2 || w.rules[0].Entry != "wan" {
+		t.Errorf("Rules = %v, want wan before lan", w.rules)
+	}
+
+	// Stop the manager left running
+	w.running[1].cancel(context.Canceled)
+	<-w.running[1].done
+}
+
+func TestReapFailed(t *testing.T) {
+	w := NewWatcher(nil, nil)
+	now := time.Now()
+
+	// The manager of lan fails, the one of wan returns without an error and the one of mgmt keeps running
+	finished := func(entry string, started time.Time, err error) *instance {
+		_, cancel := context.WithCancelCause(context.Background())
+		inst := &instance{entry: entry, name: entry, started: started, cancel: cancel, done: make(chan struct{}), err: err}
+		close(inst.done)
+		return inst
+	}
+	failure := errors.New("failed")
+	w.running[2] = finished("wan", now, nil)
+	_, cancel := context.WithCancelCause(context.Background())
+	w.running[3] = &instance{entry: "mgmt", name: "mgmt", started: now, cancel: cancel, done: make(chan struct{})}
+
+	tests := []struct {
+		name    string
+		started time.Time
+		want    time.Duration
+	}{
+		{"first failure", now, minRetryDelay},
+		{"second failure", now, 2 * minRetryDelay},
+		{"third failure", now, 4 * minRetryDelay},
+		{"after running long", now.Add(-maxRetryDelay), minRetryDelay},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w.running[1] = finished("lan", tt.started, failure)
+			w.reapFailed(now)
+
+			if _, ok := w.running[1]; ok {
+				t.Error("Failed manager was kept")
+			}
+			if _, ok := w.running[2]; !ok {
+				t.Error("Finished manager was forgotten")
+			}
+			if _, ok := w.running[3]; !ok {
+				t.Error("Running manager was forgotten")
+			}
+			if r := w.retries[1]; r.delay != tt.want || !r.at.Equal(now.Add(tt.want)) {
+				t.Errorf("Retry delay = %v, want %v", r.delay, tt.want)
+			}
+		})
+	}
+
+	if w.nextRetry() == nil {
+		t.Error("nextRetry() = nil, want the pending retry")
+	}
+	w.Update([]Rule{{Entry: "lan", Name: "enp*"}, {Entry: "wan", Name: "eth0"}, {Entry: "mgmt", Name: "eth1"}}, []string{"lan"})
+	w.applyUpdate()
+	if len(w.retries) != 0 {
+		t.Errorf("Retries = %v after the entry changed, want none", w.retries)
+	}
+	if w.nextRetry() != nil {
+		t.Error("nextRetry() without retries, want nil")
+	}
+}
@@ -0,0 +1,215 @@
+package inventory
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// DefaultStateDir is where inventories are persisted unless configured otherwise.
+const DefaultStateDir = "/run/golang-dhcpcd"
+
+var (
+	stateDirMu sync.Mutex
+	stateDir   = DefaultStateDir
+)
+
+// SetStateDir changes the directory inventories are persisted in. An empty value restores the default.
+func SetStateDir(dir string) {
+	stateDirMu.Lock()
+	defer stateDirMu.Unlock()
+
+	if dir == "" {
+		dir = DefaultStateDir
+	}
+	stateDir = dir
+}
+
+// StateDir returns the directory state is persisted in.
+func StateDir() string {
+	stateDirMu.Lock()
+	defer stateDirMu.Unlock()
+
+	return stateDir
+}
+
+// Inventory records the addresses the daemon installed on an interface, the routes it installed
+// for the interface that point out of no interface and the VRF it put the interface into, so that
+// cleanup only ever touches what it owns. It survives daemon restarts.
+type Inventory struct {
+	mu        sync.Mutex
+	path      string
+	addresses map[string]bool
+	routes    map[string]bool
+	vrf       string
+}
+
+// state is the on-disk representation of an inventory.
+type state struct {
+	Addresses []string `json:"addresses"`
+	Routes    []string `json:"routes,omitempty"`
+	VRF       string   `json:"vrf,omitempty"`
+}
+
+// Open loads the inventory of an interface, starting empty if none was persisted yet.
+func Open(ifaceName string) (*Inventory, error) {
+	inv := &Inventory{
+		path:      filepath.Join(StateDir(), "addresses", ifaceName+".json"),
+		addresses: make(map[string]bool),
+		routes:    make(map[string]bool),
+	}
+
+	data, err := os.ReadFile(inv.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return inv, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read inventory %s: %w", inv.path, err)
+	}
+
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse inventory %s: %w", inv.path, err)
+	}
+	for _, addr := range s.Addresses {
+		inv.addresses[addr] = true
+	}
+	for _, route := range s.Routes {
+		inv.routes[route] = true
+	}
+	inv.vrf = s.VRF
+	return inv, nil
+}
+
+// Owns reports whether the address was installed by the daemon.
+func (i *Inventory) Owns(addr *net.IPNet) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.addresses[addr.String()]
+}
+
+// Add records an address as owned and persists the inventory.
+func (i *Inventory) Add(addr *net.IPNet) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.addresses[addr.String()] {
+		return nil
+	}
+	i.addresses[addr.String()] = true
+	return i.save()
+}
+
+// Remove forgets an address and persists the inventory.
+func (i *Inventory) Remove(addr *net.IPNet) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if !i.addresses[addr.String()] {
+		return nil
+	}
+	delete(i.addresses, addr.String())
+	return i.save()
+}
+
+// Addresses returns the owned addresses in CIDR notation.
+func (i *Inventory) Addresses() []string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	addresses := make([]string, 0, len(i.addresses))
+	for addr := range i.addresses {
+		addresses = append(addresses, addr)
+	}
+	sort.Strings(addresses)
+	return addresses
+}
+
+// OwnsRoute reports whether the route, identified by its key, was installed by the daemon.
+func (i *Inventory) OwnsRoute(key string) bool {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.routes[key]
+}
+
+// AddRoute records a route as owned and persists the inventory.
+func (i *Inventory) AddRoute(key string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.routes[key] {
+		return nil
+	}
+	i.routes[key] = true
+	return i.save()
+}
+
+// RemoveRoute forgets a route and persists the inventory.
+func (i *Inventory) RemoveRoute(key string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if !i.routes[key] {
+		return nil
+	}
+	delete(i.routes, key)
+	return i.save()
+}
+
+// VRF returns the VRF the daemon put the interface into, empty if none.
+func (i *Inventory) VRF() string {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	return i.vrf
+}
+
+// SetVRF records the VRF the daemon put the interface into, empty once it released it, and
+// persists the inventory.
+func (i *Inventory) SetVRF(name string) error {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+
+	if i.vrf == name {
+		return nil
+	}
+	i.vrf = name
+	return i.save()
+}
+
+// save writes the inventory atomically. The caller must hold mu.
+func (i *Inventory) save() error {
+	s := state{Addresses: make([]string, 0, len(i.addresses)), VRF: i.vrf}
+	for addr := range i.addresses {
+		s.Addresses = append(s.Addresses, addr)
+	}
+	sort.Strings(s.Addresses)
+	for route := range i.routes {
+		s.Routes = append(s.Routes, route)
+	}
+	sort.Strings(s.Routes)
+
+	data, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode inventory: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(i.path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	tmp := i.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write inventory %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, i.path); err != nil {
+		return fmt.Errorf("failed to write inventory %s: %w", i.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,175 @@
+package inventory
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+// mustParse parses an address in CIDR notation, keeping the host bits.
+func mustParse(t *testing.T, cidr string) *net.IPNet {
+	t.Helper()
+
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("Invalid address %s: %v", cidr, err)
+	}
+	ipNet.IP = ip
+	return ipNet
+}
+
+func TestInventory(t *testing.T) {
+	dir := t.TempDir()
+	SetStateDir(dir)
+	t.Cleanup(func() { SetStateDir("") })
+
+	inv, err := Open("eth0")
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	if len(inv.Addresses()) != 0 {
+		t.Errorf("New inventory owns %v", inv.Addresses())
+	}
+
+	owned := mustParse(t, "10.0.0.5/24")
+	other := mustParse(t, "10.0.0.6/24")
+	for _, addr := range []*net.IPNet{owned, other, owned} {
+		if err := inv.Add(addr); err != nil {
+			t.Fatalf("Add(%s) failed: %v", addr, err)
+		}
+	}
+	if err := inv.Remove(other); err != nil {
+		t.Fatalf("Remove() failed: %v", err)
+	}
+	if err := inv.Remove(mustParse(t, "10.0.0.7/24")); err != nil {
+		t.Fatalf("Remove() of an unknown address failed: %v", err)
+	}
+
+	if !inv.Owns(owned) || inv.Owns(other) {
+		t.Errorf("Owns() = %v and %v, want true and false", inv.Owns(owned), inv.Owns(other))
+	}
+	// The prefix length is part of the address
+	if inv.Owns(mustParse(t, "10.0.0.5/16")) {
+		t.Error("Owns() matched an address with another prefix length")
+	}
+
+	// The inventory survives a restart
+	reopened, err := Open("eth0")
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	if got := reopened.Addresses(); !slices.Equal(got, []string{"10.0.0.5/24"}) {
+		t.Errorf("Reopened inventory owns %v, want [10.0.0.5/24]", got)
+	}
+
+	// Other interfaces have their own inventory
+	eth1, err := Open("eth1")
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	if eth1.Owns(owned) {
+		t.Error("Inventory of eth1 owns the address of eth0")
+	}
+}
+
+func TestOpenCorrupt(t *testing.T) {
+	dir := t.TempDir()
+	SetStateDir(dir)
+	t.Cleanup(func() { SetStateDir("") })
+
+	path := filepath.Join(dir, "addresses", "eth0.json")
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("{"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open("eth0"); err == nil {
+		t.Error("Open() accepted a corrupt inventory")
+	}
+}
+
+func TestSetStateDir(t *testing.T) {
+	dir := t.TempDir()
+	SetStateDir(dir)
+	if got := StateDir(); got != dir {
+		t.Errorf("StateDir() = %s, want %s", got, dir)
+	}
+
+	SetStateDir("")
+	if got := StateDir(); got != DefaultStateDir {
+		t.Errorf("StateDir() = %s, want the default %s", got, DefaultStateDir)
+	}
+}
+
+func TestInventoryRoutes(t *testing.T) {
+	SetStateDir(t.TempDir())
+	t.Cleanup(func() { SetStateDir("") })
+
+	inv, err := Open("eth0")
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+
+	const blackhole = "blackhole 10.2.0.0/16 table 254 metric 0"
+	const unreachable = "unreachable 10.3.0.0/16 table 254 metric 0"
+	for _, key := range []string{blackhole, unreachable, blackhole} {
+		if err := inv.AddRoute(key); err != nil {
+			t.Fatalf("AddRoute(%s) failed: %v", key, err)
+		}
+	}
+	if err := inv.RemoveRoute(unreachable); err != nil {
+		t.Fatalf("RemoveRoute() failed: %v", err)
+	}
+	if err := inv.RemoveRoute("prohibit 10.4.0.0/16 table 254 metric 0"); err != nil {
+		t.Fatalf("RemoveRoute() of an unknown route failed: %v", err)
+	}
+
+	// Routes are kept apart from addresses and survive a restart
+	reopened, err := Open("eth0")
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	if !reopened.OwnsRoute(blackhole) || reopened.OwnsRoute(unreachable) {
+		t.Errorf("OwnsRoute() = %v and %v, want true and false", reopened.OwnsRoute(blackhole), reopened.OwnsRoute(unreachable))
+	}
+	if got := reopened.Addresses(); len(got) != 0 {
+		t.Errorf("Reopened inventory owns addresses %v", got)
+	}
+}
+
+func TestInventoryVRF(t *testing.T) {
+	SetStateDir(t.TempDir())
+	t.Cleanup(func() { SetStateDir("") })
+
+	inv, err := Open("eth0")
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	if got := inv.VRF(); got != "" {
+		t.Errorf("VRF() = %q, want none", got)
+	}
+	if err := inv.SetVRF("blue"); err != nil {
+		t.Fatalf("SetVRF() failed: %v", err)
+	}
+
+	// The joined VRF survives a restart until it is released
+	reopened, err := Open("eth0")
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	if got := reopened.VRF(); got != "blue" {
+		t.Errorf("VRF() = %q, want blue", got)
+	}
+	if err := reopened.SetVRF(""); err != nil {
+		t.Fatalf("SetVRF() failed: %v", err)
+	}
+	if reopened, err = Open("eth0"); err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	if got := reopened.VRF(); got != "" {
+		t.Errorf("VRF() = %q after release, want none", got)
+	}
+}
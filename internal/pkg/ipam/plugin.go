@@ -0,0 +1,96 @@
+package ipam
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"time"
+)
+
+// versionInfo is the result of the VERSION command.
+type versionInfo struct {
+	CNIVersion        string   `json:"cniVersion"`
+	SupportedVersions []string `json:"supportedVersions"`
+}
+
+// RunPlugin executes the CNI command given in the environment: it reads the network configuration
+// from stdin, forwards the request to the daemon and writes the result to stdout. Errors are *Error
+// values to be written to stdout as the CNI error result.
+func RunPlugin(getenv func(string) string, stdin io.Reader, stdout io.Writer) error {
+	command := getenv("CNI_COMMAND")
+	if command == CommandVersion {
+		return json.NewEncoder(stdout).Encode(versionInfo{CNIVersion: "1.0.0", SupportedVersions: SupportedVersions})
+	}
+	if command != CommandAdd && command != CommandDel && command != CommandCheck {
+		return &Error{Code: CodeInvalidEnvironment, Msg: fmt.Sprintf("unsupported CNI_COMMAND %q", command)}
+	}
+
+	data, err := io.ReadAll(stdin)
+	if err != nil {
+		return &Error{Code: CodeIOFailure, Msg: "failed to read network configuration", Details: err.Error()}
+	}
+	conf, err := ParseNetConf(data)
+	if err != nil {
+		return err
+	}
+
+	request := Request{
+		Command:     command,
+		ContainerID: getenv("CNI_CONTAINERID"),
+		NetNS:       getenv("CNI_NETNS"),
+		IfName:      getenv("CNI_IFNAME"),
+		Config:      data,
+	}
+	if request.ContainerID == "" || request.IfName == "" {
+		return &Error{CNIVersion: conf.CNIVersion, Code: CodeInvalidEnvironment, Msg: "CNI_CONTAINERID and CNI_IFNAME are required"}
+	}
+
+	response, err := call(conf.IPAM.Socket, request)
+	if err != nil {
+		return &Error{CNIVersion: conf.CNIVersion, Code: CodeTryAgainLater, Msg: "failed to reach golang-dhcpcd", Details: err.Error()}
+	}
+	if response.Error != nil {
+		response.Error.CNIVersion = conf.CNIVersion
+		return response.Error
+	}
+	if command == CommandAdd {
+		if response.Result == nil {
+			return &Error{CNIVersion: conf.CNIVersion, Code: CodeIOFailure, Msg: "daemon returned no result"}
+		}
+		return json.NewEncoder(stdout).Encode(response.Result)
+	}
+	return nil
+}
+
+// call sends the request to the daemon and reads its response.
+func call(socket string, request Request) (*Response, error) {
+	conn, err := net.DialTimeout("unix", socket, connTimeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(AddTimeout + 2*connTimeout))
+
+	if err := json.NewEncoder(conn).Encode(request); err != nil {
+		return nil, fmt.Errorf("failed to send request: %w", err)
+	}
+	var response Response
+	if err := json.NewDecoder(conn).Decode(&response); err != nil {
+		return nil, fmt.Errorf("failed to read response: %w", err)
+	}
+	return &response, nil
+}
+
+// WriteError writes the error as CNI error result, wrapping errors that are not *Error.
+func WriteError(stdout io.Writer, err error) {
+	var cniErr *Error
+	if !errors.As(err, &cniErr) {
+		cniErr = &Error{Code: CodeIOFailure, Msg: err.Error()}
+	}
+	if cniErr.CNIVersion == "" {
+		cniErr.CNIVersion = "1.0.0"
+	}
+	json.NewEncoder(stdout).Encode(cniErr)
+}
@@ -0,0 +1,123 @@
+package ipam
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// environment returns a getenv function for the plugin.
+func environment(vars map[string]string) func(string) string {
+	return func(key string) string {
+		return vars[key]
+	}
+}
+
+func TestRunPluginVersion(t *testing.T) {
+	var stdout bytes.Buffer
+	if err := RunPlugin(environment(map[string]string{"CNI_COMMAND": CommandVersion}), strings.NewReader(""), &stdout); err != nil {
+		t.Fatalf("RunPlugin() failed: %v", err)
+	}
+
+	var info versionInfo
+	if err := json.Unmarshal(stdout.Bytes(), &info); err != nil {
+		t.Fatalf("Invalid version result %q: %v", stdout.String(), err)
+	}
+	if info.CNIVersion != "1.0.0" || len(info.SupportedVersions) != len(SupportedVersions) {
+		t.Errorf("Version result = %+v", info)
+	}
+}
+
+func TestRunPluginErrors(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "missing.sock")
+	config := `{"cniVersion": "1.0.0", "name": "lan", "ipam": {"type": "golang-dhcpcd-ipam", "socket": "` + missing + `"}}`
+
+	tests := []struct {
+		name     string
+		env      map[string]string
+		config   string
+		wantCode uint
+	}{
+		{"unknown command", map[string]string{"CNI_COMMAND": "GC"}, config, CodeInvalidEnvironment},
+		{"invalid config", map[string]string{"CNI_COMMAND": CommandAdd}, "{", CodeDecodeFailure},
+		{"no container", map[string]string{"CNI_COMMAND": CommandAdd, "CNI_IFNAME": "eth0"}, config, CodeInvalidEnvironment},
+		{"daemon unreachable", map[string]string{"CNI_COMMAND": CommandAdd, "CNI_CONTAINERID": "abc123", "CNI_IFNAME": "eth0"}, config, CodeTryAgainLater},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := RunPlugin(environment(tt.env), strings.NewReader(tt.config), &bytes.Buffer{})
+			var cniErr *Error
+			if !errors.As(err, &cniErr) || cniErr.Code != tt.wantCode {
+				t.Errorf("RunPlugin() error = %v, want code %d", err, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestWriteError(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want Error
+	}{
+		{"cni error", &Error{CNIVersion: "0.4.0", Code: CodeTryAgainLater, Msg: "busy"}, Error{CNIVersion: "0.4.0", Code: CodeTryAgainLater, Msg: "busy"}},
+		{"without version", &Error{Code: CodeInvalidConfig, Msg: "bad"}, Error{CNIVersion: "1.0.0", Code: CodeInvalidConfig, Msg: "bad"}},
+		{"other error", errors.New("broken pipe"), Error{CNIVersion: "1.0.0", Code: CodeIOFailure, Msg: "broken pipe"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var stdout bytes.Buffer
+			WriteError(&stdout, tt.err)
+
+			var got Error
+			if err := json.Unmarshal(stdout.Bytes(), &got); err != nil {
+				t.Fatalf("Invalid error result %q: %v", stdout.String(), err)
+			}
+			if got != tt.want {
+				t.Errorf("Error result = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPluginServer(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "ipam.sock")
+	server := NewServer(socket)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- server.Run(ctx) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Run() failed: %v", err)
+		}
+	}()
+
+	config := `{"cniVersion": "1.0.0", "name": "lan", "ipam": {"type": "golang-dhcpcd-ipam", "socket": "` + socket + `"}}`
+	run := func(command string) error {
+		env := map[string]string{"CNI_COMMAND": command, "CNI_CONTAINERID": "abc123", "CNI_NETNS": "/proc/1/ns/net", "CNI_IFNAME": "eth0"}
+		return RunPlugin(environment(env), strings.NewReader(config), &bytes.Buffer{})
+	}
+
+	// Wait for the server to listen
+	deadline := time.Now().Add(5 * time.Second)
+	for err := run(CommandDel); err != nil; err = run(CommandDel) {
+		if time.Now().After(deadline) {
+			t.Fatalf("Server did not answer: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	var cniErr *Error
+	if err := run(CommandCheck); !errors.As(err, &cniErr) || cniErr.Code != CodeUnknownContainer || cniErr.CNIVersion != "1.0.0" {
+		t.Errorf("CHECK without lease = %v, want code %d", err, CodeUnknownContainer)
+	}
+}
@@ -0,0 +1,156 @@
+package ipam
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+)
+
+// DefaultSocket is where the daemon listens for the IPAM plugin unless configured otherwise.
+const DefaultSocket = "/run/golang-dhcpcd/ipam.sock"
+
+// PluginName is the name of the plugin binary, a link to the daemon binary that runs the ipam command.
+const PluginName = "golang-dhcpcd-ipam"
+
+// SupportedVersions are the CNI specification versions the plugin implements.
+var SupportedVersions = []string{"0.3.0", "0.3.1", "0.4.0", "1.0.0"}
+
+// CNI commands.
+const (
+	CommandAdd     = "ADD"
+	CommandDel     = "DEL"
+	CommandCheck   = "CHECK"
+	CommandVersion = "VERSION"
+)
+
+// Error codes of the CNI specification.
+const (
+	CodeIncompatibleVersion = 1
+	CodeUnknownContainer    = 3
+	CodeInvalidEnvironment  = 4
+	CodeIOFailure           = 5
+	CodeDecodeFailure       = 6
+	CodeInvalidConfig       = 7
+	CodeTryAgainLater       = 11
+)
+
+// NetConf is the part of the network configuration the plugin reads from stdin.
+type NetConf struct {
+	CNIVersion string `json:"cniVersion"`
+	Name       string `json:"name"`
+	IPAM       struct {
+		Type   string `json:"type"`
+		Socket string `json:"socket,omitempty"` // Defaults to DefaultSocket
+	} `json:"ipam"`
+}
+
+// ParseNetConf decodes the network configuration and checks its version.
+func ParseNetConf(data []byte) (*NetConf, error) {
+	var conf NetConf
+	if err := json.Unmarshal(data, &conf); err != nil {
+		return nil, &Error{Code: CodeDecodeFailure, Msg: "failed to decode network configuration", Details: err.Error()}
+	}
+	if !slices.Contains(SupportedVersions, conf.CNIVersion) {
+		return nil, &Error{Code: CodeIncompatibleVersion, Msg: fmt.Sprintf("unsupported CNI version %q", conf.CNIVersion)}
+	}
+	if conf.IPAM.Socket == "" {
+		conf.IPAM.Socket = DefaultSocket
+	}
+	return &conf, nil
+}
+
+// Request is sent by the plugin to the daemon, one per connection.
+type Request struct {
+	Command     string          `json:"command"`
+	ContainerID string          `json:"container_id"`
+	NetNS       string          `json:"netns"`
+	IfName      string          `json:"ifname"`
+	Config      json.RawMessage `json:"config"`
+}
+
+// key identifies an attachment as the CNI specification does: container, network and interface.
+func (r Request) key(network string) string {
+	return r.ContainerID + "/" + network + "/" + r.IfName
+}
+
+// Response is the answer of the daemon, carrying either the CNI result or an error.
+type Response struct {
+	Result *Result `json:"result,omitempty"`
+	Error  *Error  `json:"error,omitempty"`
+}
+
+// Error is a CNI error result.
+type Error struct {
+	CNIVersion string `json:"cniVersion,omitempty"`
+	Code       uint   `json:"code"`
+	Msg        string `json:"msg"`
+	Details    string `json:"details,omitempty"`
+}
+
+func (e *Error) Error() string {
+	if e.Details != "" {
+		return e.Msg + ": " + e.Details
+	}
+	return e.Msg
+}
+
+// Result is the CNI IPAM result.
+type Result struct {
+	CNIVersion string  `json:"cniVersion"`
+	IPs        []IP    `json:"ips"`
+	Routes     []Route `json:"routes,omitempty"`
+	DNS        DNS     `json:"dns,omitempty"`
+}
+
+// IP is an address of the result. Version is only part of results before CNI 1.0.0.
+type IP struct {
+	Version string `json:"version,omitempty"`
+	Address string `json:"address"`
+	Gateway string `json:"gateway,omitempty"`
+}
+
+// Route is a route of the result.
+type Route struct {
+	Dst string `json:"dst"`
+	GW  string `json:"gw,omitempty"`
+}
+
+// DNS holds the resolver settings of the result.
+type DNS struct {
+	Nameservers []string `json:"nameservers,omitempty"`
+	Domain      string   `json:"domain,omitempty"`
+	Search      []string `json:"search,omitempty"`
+}
+
+// resultFromLease converts a lease into the result of the given CNI version.
+func resultFromLease(lease *nclient4.Lease, cniVersion string) *Result {
+	ack := lease.ACK
+	mask := ack.SubnetMask()
+	if mask == nil {
+		mask = ack.YourIPAddr.DefaultMask()
+	}
+
+	ip := IP{Address: (&net.IPNet{IP: ack.YourIPAddr, Mask: mask}).String()}
+	if strings.HasPrefix(cniVersion, "0.") {
+		ip.Version = "4"
+	}
+	result := &Result{CNIVersion: cniVersion, IPs: []IP{ip}}
+
+	if routers := ack.Router(); len(routers) > 0 {
+		result.IPs[0].Gateway = routers[0].String()
+		result.Routes = append(result.Routes, Route{Dst: "0.0.0.0/0", GW: routers[0].String()})
+	}
+
+	for _, server := range ack.DNS() {
+		result.DNS.Nameservers = append(result.DNS.Nameservers, server.String())
+	}
+	result.DNS.Domain = ack.DomainName()
+	if search := ack.DomainSearch(); search != nil {
+		result.DNS.Search = search.Labels
+	}
+	return result
+}
@@ -0,0 +1,129 @@
+package ipam
+
+import (
+	"errors"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+)
+
+func TestParseNetConf(t *testing.T) {
+	tests := []struct {
+		name     string
+		config   string
+		socket   string
+		wantCode uint
+	}{
+		{"default socket", `{"cniVersion": "1.0.0", "name": "lan", "ipam": {"type": "golang-dhcpcd-ipam"}}`, DefaultSocket, 0},
+		{"socket", `{"cniVersion": "0.4.0", "name": "lan", "ipam": {"type": "golang-dhcpcd-ipam", "socket": "/run/ipam.sock"}}`, "/run/ipam.sock", 0},
+		{"invalid json", `{"cniVersion": `, "", CodeDecodeFailure},
+		{"unsupported version", `{"cniVersion": "0.2.0", "name": "lan"}`, "", CodeIncompatibleVersion},
+		{"no version", `{"name": "lan"}`, "", CodeIncompatibleVersion},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf, err := ParseNetConf([]byte(tt.config))
+			if tt.wantCode != 0 {
+				var cniErr *Error
+				if !errors.As(err, &cniErr) || cniErr.Code != tt.wantCode {
+					t.Fatalf("ParseNetConf() error = %v, want code %d", err, tt.wantCode)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseNetConf() failed: %v", err)
+			}
+			if conf.Name != "lan" || conf.IPAM.Socket != tt.socket {
+				t.Errorf("ParseNetConf() = %+v, want network lan on socket %s", conf, tt.socket)
+			}
+		})
+	}
+}
+
+func TestRequestKey(t *testing.T) {
+	request := Request{ContainerID: "abc123", IfName: "eth0"}
+	if got := request.key("lan"); got != "abc123/lan/eth0" {
+		t.Errorf("key() = %s, want abc123/lan/eth0", got)
+	}
+	if request.key("lan") == request.key("wan") {
+		t.Error("key() is the same for two networks")
+	}
+}
+
+func TestError(t *testing.T) {
+	tests := []struct {
+		err  *Error
+		want string
+	}{
+		{&Error{Code: CodeTryAgainLater, Msg: "no lease"}, "no lease"},
+		{&Error{Code: CodeIOFailure, Msg: "failed to reach golang-dhcpcd", Details: "connection refused"}, "failed to reach golang-dhcpcd: connection refused"},
+	}
+
+	for _, tt := range tests {
+		if got := tt.err.Error(); got != tt.want {
+			t.Errorf("Error() = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+// testLease returns a lease with the given options.
+func testLease(t *testing.T, modifiers ...dhcpv4.Modifier) *nclient4.Lease {
+	t.Helper()
+
+	modifiers = append([]dhcpv4.Modifier{dhcpv4.WithYourIP(net.IPv4(10, 0, 0, 5))}, modifiers...)
+	ack, err := dhcpv4.New(modifiers...)
+	if err != nil {
+		t.Fatalf("Failed to build ACK: %v", err)
+	}
+	return &nclient4.Lease{ACK: ack}
+}
+
+func TestResultFromLease(t *testing.T) {
+	full := testLease(t,
+		dhcpv4.WithNetmask(net.CIDRMask(24, 32)),
+		dhcpv4.WithRouter(net.IPv4(10, 0, 0, 1)),
+		dhcpv4.WithDNS(net.IPv4(10, 0, 0, 53)),
+		dhcpv4.WithDomainSearchList("lan"),
+		dhcpv4.WithOption(dhcpv4.OptDomainName("example.com")),
+	)
+
+	tests := []struct {
+		name       string
+		lease      *nclient4.Lease
+		cniVersion string
+		want       Result
+	}{
+		{"full lease", full, "1.0.0", Result{
+			CNIVersion: "1.0.0",
+			IPs:        []IP{{Address: "10.0.0.5/24", Gateway: "10.0.0.1"}},
+			Routes:     []Route{{Dst: "0.0.0.0/0", GW: "10.0.0.1"}},
+			DNS:        DNS{Nameservers: []string{"10.0.0.53"}, Domain: "example.com", Search: []string{"lan"}},
+		}},
+		{"version of old results", full, "0.4.0", Result{
+			CNIVersion: "0.4.0",
+			IPs:        []IP{{Version: "4", Address: "10.0.0.5/24", Gateway: "10.0.0.1"}},
+			Routes:     []Route{{Dst: "0.0.0.0/0", GW: "10.0.0.1"}},
+			DNS:        DNS{Nameservers: []string{"10.0.0.53"}, Domain: "example.com", Search: []string{"lan"}},
+		}},
+		{"classful mask without router", testLease(t), "1.0.0", Result{
+			CNIVersion: "1.0.0",
+			IPs:        []IP{{Address: "10.0.0.5/8"}},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := resultFromLease(tt.lease, tt.cniVersion)
+			if got.CNIVersion != tt.want.CNIVersion || !slices.Equal(got.IPs, tt.want.IPs) || !slices.Equal(got.Routes, tt.want.Routes) {
+				t.Errorf("resultFromLease() = %+v, want %+v", got, tt.want)
+			}
+			if !slices.Equal(got.DNS.Nameservers, tt.want.DNS.Nameservers) || got.DNS.Domain != tt.want.DNS.Domain || !slices.Equal(got.DNS.Search, tt.want.DNS.Search) {
+				t.Errorf("DNS = %+v, want %+v", got.DNS, tt.want.DNS)
+			}
+		})
+	}
+}
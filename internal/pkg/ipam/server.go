@@ -0,0 +1,472 @@
+package ipam
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"golang-dhcpcd/internal/pkg/dhcpc"
+	"golang-dhcpcd/internal/pkg/inventory"
+	"golang-dhcpcd/internal/pkg/logging"
+	"golang-dhcpcd/internal/pkg/namespace"
+	"golang-dhcpcd/internal/pkg/netmon"
+
+	"github.com/insomniacslk/dhcp/dhcpv4"
+	"github.com/insomniacslk/dhcp/dhcpv4/nclient4"
+)
+
+// AddTimeout is how long an ADD waits for the first lease.
+const AddTimeout = 30 * time.Second
+
+// connTimeout limits how long a plugin connection may take beyond the lease wait.
+const connTimeout = 10 * time.Second
+
+// Failed accepts are retried after a delay doubling from minAcceptDelay up to maxAcceptDelay.
+const (
+	minAcceptDelay = 5 * time.Millisecond
+	maxAcceptDelay = time.Second
+)
+
+// attachment is a lease held for a container interface. It holds its key as a placeholder while
+// its client starts, the other fields are only valid once ready is closed without an error.
+type attachment struct {
+	netns  string
+	ifName string
+	ready  chan struct{}
+	err    *Error // Why the client failed to start, set before ready is closed
+	ns     *namespace.Namespace
+	client *dhcpc.Client
+	cancel context.CancelCauseFunc
+	done   chan struct{}
+}
+
+// newAttachment returns a placeholder attachment whose client is not started yet.
+func newAttachment(netns, ifName string) *attachment {
+	return &attachment{netns: netns, ifName: ifName, ready: make(chan struct{})}
+}
+
+// started reports whether the client of the attachment is running.
+func (a *attachment) started() bool {
+	select {
+	case <-a.ready:
+		return a.err == nil
+	default:
+		return false
+	}
+}
+
+// savedAttachment is an attachment recorded in the state directory, along with its last lease.
+type savedAttachment struct {
+	Key      string    `json:"key"`
+	NetNS    string    `json:"netns"`
+	IfName   string    `json:"ifname"`
+	Offer    []byte    `json:"offer,omitempty"`
+	ACK      []byte    `json:"ack,omitempty"`
+	Obtained time.Time `json:"obtained,omitempty"`
+}
+
+// Server holds leases for container interfaces on behalf of the IPAM plugin.
+type Server struct {
+	path        string
+	statePath   string
+	mu          sync.Mutex
+	attachments map[string]*attachment
+}
+
+// NewServer creates a server listening on the socket path, DefaultSocket if empty.
+func NewServer(path string) *Server {
+	if path == "" {
+		path = DefaultSocket
+	}
+	return &Server{
+		path:        path,
+		statePath:   filepath.Join(inventory.StateDir(), "ipam.json"),
+		attachments: make(map[string]*attachment),
+	}
+}
+
+// Run answers plugin requests until the context is cancelled. Leases are not released on return
+// but recorded in the state directory, and the next run resumes renewing them, so that containers
+// keep their addresses while the daemon restarts.
+func (s *Server) Run(ctx context.Context) error {
+	logger := logging.WithComponent("ipam").WithField("socket", s.path)
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0755); err != nil {
+		return fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	// A socket left behind by a previous run refuses connections
+	if err := os.Remove(s.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	listener, err := net.Listen("unix", s.path)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.path, err)
+	}
+	if err := os.Chmod(s.path, 0600); err != nil {
+		listener.Close()
+		return fmt.Errorf("failed to restrict socket permissions: %w", err)
+	}
+	logger.Info("Listening for IPAM plugin requests")
+
+	s.resume(ctx)
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	var wg sync.WaitGroup
+	var delay time.Duration
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				break
+			}
+			// Running out of file descriptors and the like are temporary
+			delay = nextAcceptDelay(delay)
+			logger.WithError(err).WithField("delay", delay).Warn("Failed to accept connection, retrying")
+			select {
+			case <-ctx.Done():
+			case <-time.After(delay):
+			}
+			continue
+		}
+		delay = 0
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.serve(ctx, conn)
+		}()
+	}
+	wg.Wait()
+
+	// Record the latest leases for the next run before the clients stop
+	s.save()
+	s.mu.Lock()
+	attachments := s.attachments
+	s.attachments = make(map[string]*attachment)
+	s.mu.Unlock()
+	for _, att := range attachments {
+		att.stop(context.Canceled)
+	}
+	os.Remove(s.path)
+	return nil
+}
+
+// nextAcceptDelay returns the delay before retrying a failed accept given the previous delay, which
+// is 0 when the last accept succeeded.
+func nextAcceptDelay(previous time.Duration) time.Duration {
+	return min(max(2*previous, minAcceptDelay), maxAcceptDelay)
+}
+
+// serve answers the request of one connection.
+func (s *Server) serve(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(AddTimeout + connTimeout))
+
+	var request Request
+	var response Response
+	if err := json.NewDecoder(conn).Decode(&request); err != nil {
+		response.Error = &Error{Code: CodeDecodeFailure, Msg: "failed to decode request", Details: err.Error()}
+	} else {
+		response.Result, response.Error = s.handle(ctx, request)
+	}
+
+	if err := json.NewEncoder(conn).Encode(response); err != nil {
+		logging.WithComponent("ipam").WithError(err).Warn("Failed to send response")
+	}
+}
+
+// handle executes a plugin request.
+func (s *Server) handle(ctx context.Context, request Request) (*Result, *Error) {
+	conf, err := ParseNetConf(request.Config)
+	if err != nil {
+		return nil, err.(*Error)
+	}
+	key := request.key(conf.Name)
+	logger := logging.WithComponentAndInterface("ipam", request.IfName).
+		WithField("container", request.ContainerID).WithField("network", conf.Name)
+
+	switch request.Command {
+	case CommandAdd:
+		lease, err := s.add(ctx, key, request)
+		if err != nil {
+			logger.WithError(err).Warn("Failed to add attachment")
+			return nil, err
+		}
+		logger.WithField("ip", lease.ACK.YourIPAddr.String()).Info("Added attachment")
+		s.save()
+		return resultFromLease(lease, conf.CNIVersion), nil
+
+	case CommandDel:
+		if s.remove(key) {
+			logger.Info("Deleted attachment")
+			s.save()
+		}
+		return nil, nil
+
+	case CommandCheck:
+		s.mu.Lock()
+		att, exists := s.attachments[key]
+		s.mu.Unlock()
+		if !exists {
+			return nil, &Error{Code: CodeUnknownContainer, Msg: "no lease held for the attachment"}
+		}
+		if !att.started() {
+			return nil, &Error{Code: CodeTryAgainLater, Msg: "lease is being acquired"}
+		}
+		lease := att.client.Lease()
+		if lease == nil {
+			return nil, &Error{Code: CodeTryAgainLater, Msg: "lease was lost and is being reacquired"}
+		}
+		return nil, nil
+	}
+	return nil, &Error{Code: CodeInvalidEnvironment, Msg: fmt.Sprintf("unsupported command %q", request.Command)}
+}
+
+// add starts a lease-only DHCP client for the attachment, or reuses the running one, and waits for
+// its lease.
+func (s *Server) add(ctx context.Context, key string, request Request) (*nclient4.Lease, *Error) {
+	if request.NetNS == "" || request.IfName == "" {
+		return nil, &Error{Code: CodeInvalidEnvironment, Msg: "CNI_NETNS and CNI_IFNAME are required"}
+	}
+
+	s.mu.Lock()
+	att, exists := s.attachments[key]
+	var stale *attachment
+	if exists && att.netns != request.NetNS {
+		// The lease of the previous namespace is released once the lock is no longer held
+		stale, exists = att, false
+	}
+	if !exists {
+		// The placeholder holds the key while the client starts without the lock
+		att = newAttachment(request.NetNS, request.IfName)
+		s.attachments[key] = att
+	}
+	s.mu.Unlock()
+	if stale != nil {
+		stale.stop(dhcpc.ErrRelease)
+	}
+
+	waitCtx, cancel := context.WithTimeout(ctx, AddTimeout)
+	defer cancel()
+	if !exists {
+		att.start(ctx, nil)
+	}
+	select {
+	case <-att.ready:
+	case <-waitCtx.Done():
+		return nil, &Error{Code: CodeTryAgainLater, Msg: "DHCP client is still starting"}
+	}
+	if att.err != nil {
+		s.mu.Lock()
+		if s.attachments[key] == att {
+			delete(s.attachments, key)
+		}
+		s.mu.Unlock()
+		return nil, att.err
+	}
+
+	// Stop waiting when the attachment is deleted meanwhile
+	go func() {
+		select {
+		case <-att.done:
+			cancel()
+		case <-waitCtx.Done():
+		}
+	}()
+
+	lease, err := att.client.WaitLease(waitCtx)
+	if err == nil && lease == nil {
+		err = errors.New("lease was lost")
+	}
+	if err != nil {
+		s.mu.Lock()
+		current := s.attachments[key] == att
+		if current {
+			delete(s.attachments, key)
+		}
+		s.mu.Unlock()
+		if current {
+			att.stop(dhcpc.ErrRelease)
+		}
+		return nil, &Error{Code: CodeTryAgainLater, Msg: "no DHCP lease obtained", Details: err.Error()}
+	}
+	return lease, nil
+}
+
+// start runs a lease-only DHCP client for the interface inside the namespace of the container,
+// resuming the lease if one is given, and closes ready. It must not be called with the lock of the
+// server held.
+func (a *attachment) start(ctx context.Context, lease *nclient4.Lease) {
+	defer close(a.ready)
+
+	ns, err := namespace.Open(a.netns)
+	if err != nil {
+		a.err = &Error{Code: CodeUnknownContainer, Msg: "failed to open container network namespace", Details: err.Error()}
+		return
+	}
+
+	runCtx, cancel := context.WithCancelCause(namespace.NewContext(ctx, ns))
+	runCtx = netmon.NewContext(runCtx, netmon.NewMonitor(runCtx, ns.Handle()))
+	a.ns, a.cancel, a.done = ns, cancel, make(chan struct{})
+
+	// Room for a failure to restore the namespace after the client was created
+	created := make(chan error, 2)
+	go func() {
+		defer close(a.done)
+		err := namespace.Do(runCtx, func() {
+			client, err := dhcpc.NewClient(runCtx, a.ifName)
+			if err != nil {
+				created <- err
+				return
+			}
+			a.client = client
+			created <- nil
+			if err := client.Run(runCtx, dhcpc.Config{LeaseOnly: true, Lease: lease}); err != nil {
+				logging.WithComponentAndInterface("ipam", a.ifName).WithError(err).Error("DHCP client failed")
+			}
+		})
+		if err != nil {
+			created <- err
+		}
+	}()
+
+	if err := <-created; err != nil {
+		cancel(context.Canceled)
+		<-a.done
+		ns.Close()
+		a.err = &Error{Code: CodeIOFailure, Msg: "failed to start DHCP client", Details: err.Error()}
+	}
+}
+
+// remove stops the client of an attachment and releases its lease, reporting whether one existed.
+func (s *Server) remove(key string) bool {
+	s.mu.Lock()
+	att, exists := s.attachments[key]
+	delete(s.attachments, key)
+	s.mu.Unlock()
+
+	if !exists {
+		return false
+	}
+	att.stop(dhcpc.ErrRelease)
+	return true
+}
+
+// stop cancels the client with the cause and waits for it, once it started. It must not be called
+// with the lock of the server held, releasing the lease takes a round trip to the server.
+func (a *attachment) stop(cause error) {
+	if <-a.ready; a.err != nil {
+		return
+	}
+	a.cancel(cause)
+	<-a.done
+	a.ns.Close()
+}
+
+// resume restarts the clients of the attachments recorded by a previous run. Attachments whose
+// container namespace is gone are dropped.
+func (s *Server) resume(ctx context.Context) {
+	logger := logging.WithComponent("ipam").WithField("file", s.statePath)
+
+	data, err := os.ReadFile(s.statePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return
+	}
+	if err != nil {
+		logger.WithError(err).Warn("Failed to read IPAM state")
+		return
+	}
+	var saved []savedAttachment
+	if err := json.Unmarshal(data, &saved); err != nil {
+		logger.WithError(err).Warn("Failed to parse IPAM state")
+		return
+	}
+
+	for _, entry := range saved {
+		entryLogger := logging.WithComponentAndInterface("ipam", entry.IfName).WithField("netns", entry.NetNS)
+		lease, err := decodeLease(entry)
+		if err != nil {
+			entryLogger.WithError(err).Warn("Failed to decode saved lease, obtaining a new one")
+		}
+		att := newAttachment(entry.NetNS, entry.IfName)
+		if att.start(ctx, lease); att.err != nil {
+			entryLogger.WithError(att.err).Info("Dropped attachment of a previous run")
+			continue
+		}
+		s.mu.Lock()
+		s.attachments[entry.Key] = att
+		s.mu.Unlock()
+		entryLogger.Info("Resumed attachment of a previous run")
+	}
+	s.save()
+}
+
+// decodeLease returns the lease of a saved attachment, nil if none was recorded.
+func decodeLease(entry savedAttachment) (*nclient4.Lease, error) {
+	if entry.ACK == nil {
+		return nil, nil
+	}
+	ack, err := dhcpv4.FromBytes(entry.ACK)
+	if err != nil {
+		return nil, err
+	}
+	offer, err := dhcpv4.FromBytes(entry.Offer)
+	if err != nil {
+		return nil, err
+	}
+	return &nclient4.Lease{Offer: offer, ACK: ack, CreationTime: entry.Obtained}, nil
+}
+
+// save records the attachments and their current leases in the state directory.
+func (s *Server) save() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	saved := make([]savedAttachment, 0, len(s.attachments))
+	for key, att := range s.attachments {
+		entry := savedAttachment{Key: key, NetNS: att.netns, IfName: att.ifName}
+		if !att.started() {
+			// Attachments still starting are recorded without a lease
+			saved = append(saved, entry)
+			continue
+		}
+		if lease := att.client.Lease(); lease != nil {
+			entry.Offer = lease.Offer.ToBytes()
+			entry.ACK = lease.ACK.ToBytes()
+			entry.Obtained = lease.CreationTime
+		}
+		saved = append(saved, entry)
+	}
+
+	if err := writeState(s.statePath, saved); err != nil {
+		logging.WithComponent("ipam").WithError(err).Warn("Failed to save IPAM state")
+	}
+}
+
+// writeState replaces the state file atomically.
+func writeState(path string, saved []savedAttachment) error {
+	data, err := json.MarshalIndent(saved, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode IPAM state: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write IPAM state %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		return fmt.Errorf("failed to write IPAM state %s: %w", path, err)
+	}
+	return nil
+}
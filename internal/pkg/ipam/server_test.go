@@ -0,0 +1,87 @@
+package ipam
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestAddFailure(t *testing.T) {
+	missing := filepath.Join(t.TempDir(), "netns")
+	tests := []struct {
+		name    string
+		request Request
+		code    uint
+	}{
+		{"no namespace", Request{IfName: "eth0"}, CodeInvalidEnvironment},
+		{"no interface", Request{NetNS: missing}, CodeInvalidEnvironment},
+		{"missing namespace", Request{NetNS: missing, IfName: "eth0"}, CodeUnknownContainer},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Server{statePath: filepath.Join(t.TempDir(), "ipam.json"), attachments: make(map[string]*attachment)}
+			lease, err := s.add(context.Background(), "net/container/eth0", tt.request)
+			if lease != nil || err == nil || err.Code != tt.code {
+				t.Fatalf("add() = %v, %v, want error code %d", lease, err, tt.code)
+			}
+			// The placeholder of a client that failed to start is dropped
+			if len(s.attachments) != 0 {
+				t.Errorf("Attachments = %v, want none", s.attachments)
+			}
+		})
+	}
+}
+
+func TestPlaceholder(t *testing.T) {
+	s := &Server{statePath: filepath.Join(t.TempDir(), "ipam.json"), attachments: make(map[string]*attachment)}
+	att := newAttachment("/var/run/netns/container", "eth0")
+	s.attachments["net/container/eth0"] = att
+
+	if att.started() {
+		t.Error("started() = true before the client started")
+	}
+
+	// Attachments still starting are saved without a lease
+	s.save()
+	data, err := os.ReadFile(s.statePath)
+	if err != nil {
+		t.Fatalf("Failed to read state: %v", err)
+	}
+	var saved []savedAttachment
+	if err := json.Unmarshal(data, &saved); err != nil {
+		t.Fatalf("Failed to parse state: %v", err)
+	}
+	if len(saved) != 1 || saved[0].Key != "net/container/eth0" || saved[0].ACK != nil {
+		t.Errorf("Saved state = %+v, want the attachment without a lease", saved)
+	}
+
+	// Stopping an attachment whose client failed to start returns right away
+	att.err = &Error{Code: CodeIOFailure, Msg: "failed to start DHCP client"}
+	close(att.ready)
+	if att.started() {
+		t.Error("started() = true after the client failed to start")
+	}
+	att.stop(context.Canceled)
+}
+
+func TestNextAcceptDelay(t *testing.T) {
+	tests := []struct {
+		previous time.Duration
+		want     time.Duration
+	}{
+		{0, minAcceptDelay},
+		{minAcceptDelay, 2 * minAcceptDelay},
+		{maxAcceptDelay / 2, maxAcceptDelay},
+		{maxAcceptDelay, maxAcceptDelay},
+	}
+
+	for _, tt := range tests {
+		if got := nextAcceptDelay(tt.previous); got != tt.want {
+			t.Errorf("nextAcceptDelay(%v) = %v, want %v", tt.previous, got, tt.want)
+		}
+	}
+}
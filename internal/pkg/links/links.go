@@ -0,0 +1,448 @@
+package links
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Supported link kinds.
+const (
+	KindVLAN    = "vlan"
+	KindBridge  = "bridge"
+	KindBond    = "bond"
+	KindMacvlan = "macvlan"
+	KindIPVlan  = "ipvlan"
+	KindVRF     = "vrf"
+
+	KindVXLAN     = "vxlan"
+	KindGRE       = "gre"
+	KindIPIP      = "ipip"
+	KindWireGuard = "wireguard"
+)
+
+// defaultVXLANPort is the IANA assigned VXLAN port.
+const defaultVXLANPort = 4789
+
+// maxNameLength is the longest interface name the kernel accepts (IFNAMSIZ without the terminator).
+const maxNameLength = 15
+
+// Config declares a virtual link created by the daemon.
+type Config struct {
+	Name    string
+	Kind    string
+	Parent  string   // Lower link of vlan, macvlan and ipvlan links, underlying link of tunnels
+	ID      int      // VLAN ID or VXLAN network identifier
+	Members []string // Ports of bridges, bonds and VRFs
+	Mode    string   // Bond, macvlan or ipvlan mode
+	MIIMon  int      // Bond link monitoring interval in milliseconds
+	Table   int      // Routing table of a VRF
+	MTU     int
+
+	// Tunnel endpoints. The local address defaults to the first IPv4 address of the parent, so
+	// tunnels follow the address of a DHCP interface. VXLAN remotes may be multicast groups.
+	Local  string
+	Remote string
+	Port   int    // VXLAN destination port, defaults to 4789
+	TTL    int    // Tunnel TTL, inherited from the inner packet when unset
+	Key    uint32 // GRE key
+
+	WireGuard *WireGuardConfig
+}
+
+var macvlanModes = map[string]netlink.MacvlanMode{
+	"private":  netlink.MACVLAN_MODE_PRIVATE,
+	"vepa":     netlink.MACVLAN_MODE_VEPA,
+	"bridge":   netlink.MACVLAN_MODE_BRIDGE,
+	"passthru": netlink.MACVLAN_MODE_PASSTHRU,
+	"source":   netlink.MACVLAN_MODE_SOURCE,
+}
+
+var ipvlanModes = map[string]netlink.IPVlanMode{
+	"l2":  netlink.IPVLAN_MODE_L2,
+	"l3":  netlink.IPVLAN_MODE_L3,
+	"l3s": netlink.IPVLAN_MODE_L3S,
+}
+
+// Validate checks the link declaration.
+func (c Config) Validate() error {
+	if c.Name == "" || len(c.Name) > maxNameLength {
+		return fmt.Errorf("link name %q must have 1 to %d characters", c.Name, maxNameLength)
+	}
+
+	switch c.Kind {
+	case KindVLAN, KindMacvlan, KindIPVlan:
+		if c.Parent == "" {
+			return fmt.Errorf("link %s: %s requires a parent", c.Name, c.Kind)
+		}
+	case KindBridge, KindBond, KindVRF, KindWireGuard:
+		if c.Parent != "" {
+			return fmt.Errorf("link %s: %s cannot have a parent", c.Name, c.Kind)
+		}
+	case KindVXLAN, KindGRE, KindIPIP:
+	default:
+		return fmt.Errorf("link %s: invalid kind %q: must be vlan, bridge, bond, macvlan, ipvlan, vrf, vxlan, gre, ipip or wireguard", c.Name, c.Kind)
+	}
+	if len(c.Members) > 0 && c.Kind != KindBridge && c.Kind != KindBond && c.Kind != KindVRF {
+		return fmt.Errorf("link %s: only bridges, bonds and VRFs have members", c.Name)
+	}
+
+	if c.Kind == KindVLAN && (c.ID < 1 || c.ID > 4094) {
+		return fmt.Errorf("link %s: VLAN ID must be between 1 and 4094", c.Name)
+	}
+	if c.Kind == KindVXLAN && (c.ID < 1 || c.ID > 1<<24-1) {
+		return fmt.Errorf("link %s: VXLAN ID must be between 1 and 16777215", c.Name)
+	}
+	if c.Kind != KindVLAN && c.Kind != KindVXLAN && c.ID != 0 {
+		return fmt.Errorf("link %s: only VLANs and VXLANs have an ID", c.Name)
+	}
+	if err := c.validateTunnel(); err != nil {
+		return fmt.Errorf("link %s: %w", c.Name, err)
+	}
+	if c.Kind == KindVRF && (c.Table <= 0 || (c.Table >= unix.RT_TABLE_COMPAT && c.Table <= unix.RT_TABLE_LOCAL)) {
+		return fmt.Errorf("link %s: VRF table must be positive and not one of the reserved tables 252-255", c.Name)
+	}
+	if c.Kind != KindVRF && c.Table != 0 {
+		return fmt.Errorf("link %s: only VRFs have a table", c.Name)
+	}
+	if c.Kind != KindBond && c.MIIMon != 0 {
+		return fmt.Errorf("link %s: only bonds have miimon", c.Name)
+	}
+	if c.MIIMon < 0 || c.MTU < 0 {
+		return fmt.Errorf("link %s: miimon and mtu must not be negative", c.Name)
+	}
+
+	if c.Mode != "" {
+		valid := false
+		switch c.Kind {
+		case KindBond:
+			valid = netlink.StringToBondMode(c.Mode) != netlink.BOND_MODE_UNKNOWN
+		case KindMacvlan:
+			_, valid = macvlanModes[c.Mode]
+		case KindIPVlan:
+			_, valid = ipvlanModes[c.Mode]
+		}
+		if !valid {
+			return fmt.Errorf("link %s: invalid %s mode %q", c.Name, c.Kind, c.Mode)
+		}
+	}
+	for _, member := range c.Members {
+		if member == c.Name {
+			return fmt.Errorf("link %s: cannot be a member of itself", c.Name)
+		}
+	}
+	return nil
+}
+
+// isTunnel reports whether the link encapsulates traffic between a local and a remote address.
+func (c Config) isTunnel() bool {
+	return c.Kind == KindVXLAN || c.Kind == KindGRE || c.Kind == KindIPIP
+}
+
+// validateTunnel checks the endpoint and WireGuard settings.
+func (c Config) validateTunnel() error {
+	if !c.isTunnel() {
+		if c.Local != "" || c.Remote != "" || c.Port != 0 || c.TTL != 0 || c.Key != 0 {
+			return fmt.Errorf("only vxlan, gre and ipip links have tunnel endpoints")
+		}
+	}
+	if c.Kind == KindWireGuard {
+		if c.WireGuard == nil {
+			return fmt.Errorf("wireguard requires a private key file")
+		}
+		return c.WireGuard.Validate()
+	}
+	if c.WireGuard != nil {
+		return fmt.Errorf("only wireguard links have keys and peers")
+	}
+
+	for _, endpoint := range []string{c.Local, c.Remote} {
+		if endpoint == "" {
+			continue
+		}
+		ip := net.ParseIP(endpoint)
+		if ip == nil {
+			return fmt.Errorf("invalid tunnel address %q", endpoint)
+		}
+		if c.Kind != KindVXLAN && ip.To4() == nil {
+			return fmt.Errorf("%s tunnels only support IPv4 addresses", c.Kind)
+		}
+	}
+	if c.Kind == KindVXLAN && c.Remote == "" {
+		return fmt.Errorf("vxlan requires a remote address or multicast group")
+	}
+	if c.Port < 0 || c.Port > 65535 || c.TTL < 0 || c.TTL > 255 {
+		return fmt.Errorf("port must be between 0 and 65535 and ttl between 0 and 255")
+	}
+	if c.Port != 0 && c.Kind != KindVXLAN {
+		return fmt.Errorf("only vxlan links have a port")
+	}
+	if c.Key != 0 && c.Kind != KindGRE {
+		return fmt.Errorf("only gre links have a key")
+	}
+	return nil
+}
+
+// dependencies returns the links that have to exist before this one is complete.
+func (c Config) dependencies() []string {
+	if c.Parent != "" {
+		return []string{c.Parent}
+	}
+	return c.Members
+}
+
+// Order sorts the links so that parents and members come before the links built on them.
+func Order(configs []Config) ([]Config, error) {
+	byName := make(map[string]Config, len(configs))
+	names := make([]string, 0, len(configs))
+	for _, config := range configs {
+		byName[config.Name] = config
+		names = append(names, config.Name)
+	}
+	sort.Strings(names)
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var ordered []Config
+
+	var visit func(name string) error
+	visit = func(name string) error {
+		config, declared := byName[name]
+		if !declared || state[name] == visited {
+			return nil
+		}
+		if state[name] == visiting {
+			return fmt.Errorf("link %s depends on itself", name)
+		}
+		state[name] = visiting
+		for _, dependency := range config.dependencies() {
+			if err := visit(dependency); err != nil {
+				return err
+			}
+		}
+		state[name] = visited
+		ordered = append(ordered, config)
+		return nil
+	}
+
+	for _, name := range names {
+		if err := visit(name); err != nil {
+			return nil, err
+		}
+	}
+	return ordered, nil
+}
+
+// build returns the netlink representation of the link on the given parent, tunnels are
+// built with the given local address.
+func (c Config) build(parentIndex int, local net.IP) netlink.Link {
+	attrs := netlink.NewLinkAttrs()
+	attrs.Name = c.Name
+	attrs.MTU = c.MTU
+	if !c.isTunnel() {
+		attrs.ParentIndex = parentIndex
+	}
+
+	switch c.Kind {
+	case KindVLAN:
+		return &netlink.Vlan{LinkAttrs: attrs, VlanId: c.ID}
+	case KindBridge:
+		return &netlink.Bridge{LinkAttrs: attrs}
+	case KindBond:
+		bond := netlink.NewLinkBond(attrs)
+		if c.Mode != "" {
+			bond.Mode = netlink.StringToBondMode(c.Mode)
+		}
+		if c.MIIMon > 0 {
+			bond.Miimon = c.MIIMon
+		}
+		return bond
+	case KindMacvlan:
+		mode := netlink.MACVLAN_MODE_BRIDGE
+		if c.Mode != "" {
+			mode = macvlanModes[c.Mode]
+		}
+		return &netlink.Macvlan{LinkAttrs: attrs, Mode: mode}
+	case KindIPVlan:
+		mode := netlink.IPVLAN_MODE_L2
+		if c.Mode != "" {
+			mode = ipvlanModes[c.Mode]
+		}
+		return &netlink.IPVlan{LinkAttrs: attrs, Mode: mode}
+	case KindVRF:
+		return &netlink.Vrf{LinkAttrs: attrs, Table: uint32(c.Table)}
+	case KindVXLAN:
+		return &netlink.Vxlan{
+			LinkAttrs:    attrs,
+			VxlanId:      c.ID,
+			VtepDevIndex: parentIndex,
+			SrcAddr:      local,
+			Group:        net.ParseIP(c.Remote),
+			TTL:          c.TTL,
+			Port:         c.vxlanPort(),
+			Learning:     true,
+		}
+	case KindGRE:
+		return &netlink.Gretun{
+			LinkAttrs: attrs,
+			Link:      uint32(parentIndex),
+			Local:     ipv4OrAny(local),
+			Remote:    ipv4OrAny(net.ParseIP(c.Remote)),
+			Ttl:       uint8(c.TTL),
+			IKey:      c.Key,
+			OKey:      c.Key,
+		}
+	case KindIPIP:
+		return &netlink.Iptun{
+			LinkAttrs: attrs,
+			Link:      uint32(parentIndex),
+			Local:     ipv4OrAny(local),
+			Remote:    ipv4OrAny(net.ParseIP(c.Remote)),
+			Ttl:       uint8(c.TTL),
+		}
+	case KindWireGuard:
+		return &netlink.Wireguard{LinkAttrs: attrs}
+	}
+	return nil
+}
+
+// vxlanPort returns the destination port of a VXLAN link.
+func (c Config) vxlanPort() int {
+	if c.Port == 0 {
+		return defaultVXLANPort
+	}
+	return c.Port
+}
+
+// ipv4OrAny returns the IPv4 address, or 0.0.0.0 if unset. netlink treats GRE links without an
+// IPv4 local address as ip6gre.
+func ipv4OrAny(ip net.IP) net.IP {
+	if ip4 := ip.To4(); ip4 != nil {
+		return ip4
+	}
+	return net.IPv4zero.To4()
+}
+
+// matches reports whether an existing link has the kind and settings of the declaration.
+// Settings left to their default are not compared.
+func (c Config) matches(link netlink.Link, parentIndex int, local net.IP) bool {
+	if link.Type() != c.Kind {
+		return false
+	}
+	if c.Parent != "" && !c.isTunnel() && link.Attrs().ParentIndex != parentIndex {
+		return false
+	}
+
+	switch l := link.(type) {
+	case *netlink.Vlan:
+		return l.VlanId == c.ID
+	case *netlink.Bond:
+		if c.Mode != "" && l.Mode != netlink.StringToBondMode(c.Mode) {
+			return false
+		}
+		return c.MIIMon == 0 || l.Miimon == c.MIIMon
+	case *netlink.Macvlan:
+		return c.Mode == "" || l.Mode == macvlanModes[c.Mode]
+	case *netlink.IPVlan:
+		return c.Mode == "" || l.Mode == ipvlanModes[c.Mode]
+	case *netlink.Vrf:
+		return int(l.Table) == c.Table
+	case *netlink.Vxlan:
+		return l.VxlanId == c.ID && l.VtepDevIndex == parentIndex && l.Port == c.vxlanPort() &&
+			l.TTL == c.TTL && sameAddress(l.SrcAddr, local) && sameAddress(l.Group, net.ParseIP(c.Remote))
+	case *netlink.Gretun:
+		return l.Link == uint32(parentIndex) && int(l.Ttl) == c.TTL && l.IKey == c.Key && l.OKey == c.Key &&
+			sameAddress(l.Local, ipv4OrAny(local)) && sameAddress(l.Remote, ipv4OrAny(net.ParseIP(c.Remote)))
+	case *netlink.Iptun:
+		return l.Link == uint32(parentIndex) && int(l.Ttl) == c.TTL &&
+			sameAddress(l.Local, ipv4OrAny(local)) && sameAddress(l.Remote, ipv4OrAny(net.ParseIP(c.Remote)))
+	}
+	return true
+}
+
+// sameAddress compares tunnel addresses, treating unset and unspecified addresses alike.
+func sameAddress(a, b net.IP) bool {
+	if len(a) == 0 || a.IsUnspecified() {
+		return len(b) == 0 || b.IsUnspecified()
+	}
+	return a.Equal(b)
+}
+
+// localAddress returns the local address of a tunnel: the configured one, or else the first
+// global IPv4 address of the parent. It reports false while the parent has no address yet.
+func (c Config) localAddress(parent netlink.Link) (net.IP, bool) {
+	if c.Local != "" {
+		return net.ParseIP(c.Local), true
+	}
+	if parent == nil {
+		return nil, true
+	}
+	addrs, err := netlink.AddrList(parent, netlink.FAMILY_V4)
+	if err != nil {
+		return nil, false
+	}
+	for _, addr := range addrs {
+		if addr.Scope == unix.RT_SCOPE_UNIVERSE {
+			return addr.IP, true
+		}
+	}
+	return nil, false
+}
+
+// JoinVRF enslaves the link to the named VRF if it is not a member yet and returns the routing
+// table of the VRF.
+func JoinVRF(link netlink.Link, name string, logger *logrus.Entry) (int, error) {
+	master, err := netlink.LinkByName(name)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get VRF %s: %w", name, err)
+	}
+	vrf, ok := master.(*netlink.Vrf)
+	if !ok {
+		return 0, errors.New(name + " is not a VRF")
+	}
+
+	if link.Attrs().MasterIndex != vrf.Index {
+		if err := netlink.LinkSetMasterByIndex(link, vrf.Index); err != nil {
+			return 0, fmt.Errorf("failed to add link to VRF %s: %w", name, err)
+		}
+		logger.WithField("vrf", name).WithField("table", vrf.Table).Info("Added interface to VRF")
+	}
+	return int(vrf.Table), nil
+}
+
+// LeaveVRF releases the link from the VRF it is enslaved to if that is one of the given VRFs, e.g.
+// those the daemon declared or put the link into. Links enslaved to other VRFs, or other masters
+// such as bridges and bonds, are left alone.
+func LeaveVRF(link netlink.Link, vrfs []string, logger *logrus.Entry) error {
+	if link.Attrs().MasterIndex == 0 {
+		return nil
+	}
+	master, err := netlink.LinkByIndex(link.Attrs().MasterIndex)
+	if err != nil {
+		return fmt.Errorf("failed to get master of link: %w", err)
+	}
+	if _, ok := master.(*netlink.Vrf); !ok || !slices.Contains(vrfs, master.Attrs().Name) {
+		return nil
+	}
+
+	if err := netlink.LinkSetNoMaster(link); err != nil {
+		return fmt.Errorf("failed to remove link from VRF %s: %w", master.Attrs().Name, err)
+	}
+	logger.WithField("vrf", master.Attrs().Name).Info("Removed interface from VRF")
+	return nil
+}
+
+// InVRF reports whether the link is enslaved to the named VRF.
+func InVRF(link netlink.Link, name string) bool {
+	vrf, err := netlink.LinkByName(name)
+	return err == nil && link.Attrs().MasterIndex == vrf.Attrs().Index
+}
@@ -0,0 +1,217 @@
+package links
+
+import (
+	"net"
+	"testing"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"vlan", Config{Name: "eth0.100", Kind: KindVLAN, Parent: "eth0", ID: 100}, false},
+		{"bridge", Config{Name: "br0", Kind: KindBridge, Members: []string{"eth1", "eth2"}}, false},
+		{"bond", Config{Name: "bond0", Kind: KindBond, Members: []string{"eth1"}, Mode: "802.3ad", MIIMon: 100}, false},
+		{"macvlan", Config{Name: "mv0", Kind: KindMacvlan, Parent: "eth0", Mode: "private"}, false},
+		{"ipvlan", Config{Name: "ipv0", Kind: KindIPVlan, Parent: "eth0", Mode: "l3s"}, false},
+		{"vrf", Config{Name: "vrf-blue", Kind: KindVRF, Members: []string{"eth1"}, Table: 10}, false},
+		{"vxlan", Config{Name: "vx0", Kind: KindVXLAN, Parent: "eth0", ID: 42, Remote: "239.1.1.1", Port: 8472}, false},
+		{"ipv6 vxlan", Config{Name: "vx0", Kind: KindVXLAN, ID: 42, Local: "2001:db8::1", Remote: "2001:db8::2"}, false},
+		{"gre", Config{Name: "gre0", Kind: KindGRE, Remote: "198.51.100.1", Key: 7, TTL: 64}, false},
+		{"ipip", Config{Name: "ipip0", Kind: KindIPIP, Parent: "eth0", Remote: "198.51.100.1"}, false},
+		{"wireguard", Config{Name: "wg0", Kind: KindWireGuard, WireGuard: &WireGuardConfig{PrivateKeyFile: "/etc/wg0.key"}}, false},
+		{"no name", Config{Kind: KindBridge}, true},
+		{"name too long", Config{Name: "bridge-with-a-long-name", Kind: KindBridge}, true},
+		{"invalid kind", Config{Name: "tun0", Kind: "tun"}, true},
+		{"vlan without parent", Config{Name: "vlan100", Kind: KindVLAN, ID: 100}, true},
+		{"vlan without id", Config{Name: "eth0.0", Kind: KindVLAN, Parent: "eth0"}, true},
+		{"vlan id too large", Config{Name: "eth0.5000", Kind: KindVLAN, Parent: "eth0", ID: 5000}, true},
+		{"vlan with members", Config{Name: "eth0.100", Kind: KindVLAN, Parent: "eth0", ID: 100, Members: []string{"eth1"}}, true},
+		{"bridge with parent", Config{Name: "br0", Kind: KindBridge, Parent: "eth0"}, true},
+		{"bridge with id", Config{Name: "br0", Kind: KindBridge, ID: 100}, true},
+		{"bridge with miimon", Config{Name: "br0", Kind: KindBridge, MIIMon: 100}, true},
+		{"negative mtu", Config{Name: "br0", Kind: KindBridge, MTU: -1}, true},
+		{"invalid bond mode", Config{Name: "bond0", Kind: KindBond, Mode: "fastest"}, true},
+		{"invalid macvlan mode", Config{Name: "mv0", Kind: KindMacvlan, Parent: "eth0", Mode: "l2"}, true},
+		{"bridge mode", Config{Name: "br0", Kind: KindBridge, Mode: "stp"}, true},
+		{"member of itself", Config{Name: "br0", Kind: KindBridge, Members: []string{"br0"}}, true},
+		{"vrf without table", Config{Name: "vrf-blue", Kind: KindVRF}, true},
+		{"vrf with main table", Config{Name: "vrf-blue", Kind: KindVRF, Table: 254}, true},
+		{"bridge with table", Config{Name: "br0", Kind: KindBridge, Table: 10}, true},
+		{"vxlan without id", Config{Name: "vx0", Kind: KindVXLAN, Remote: "239.1.1.1"}, true},
+		{"vxlan id too large", Config{Name: "vx0", Kind: KindVXLAN, ID: 1 << 24, Remote: "239.1.1.1"}, true},
+		{"vxlan without remote", Config{Name: "vx0", Kind: KindVXLAN, ID: 42}, true},
+		{"vxlan with members", Config{Name: "vx0", Kind: KindVXLAN, ID: 42, Remote: "239.1.1.1", Members: []string{"eth1"}}, true},
+		{"invalid remote", Config{Name: "gre0", Kind: KindGRE, Remote: "peer"}, true},
+		{"ipv6 gre", Config{Name: "gre0", Kind: KindGRE, Remote: "2001:db8::2"}, true},
+		{"ttl too large", Config{Name: "gre0", Kind: KindGRE, Remote: "198.51.100.1", TTL: 256}, true},
+		{"gre with port", Config{Name: "gre0", Kind: KindGRE, Remote: "198.51.100.1", Port: 4789}, true},
+		{"ipip with key", Config{Name: "ipip0", Kind: KindIPIP, Remote: "198.51.100.1", Key: 7}, true},
+		{"bridge with remote", Config{Name: "br0", Kind: KindBridge, Remote: "198.51.100.1"}, true},
+		{"wireguard without key", Config{Name: "wg0", Kind: KindWireGuard}, true},
+		{"wireguard with parent", Config{Name: "wg0", Kind: KindWireGuard, Parent: "eth0", WireGuard: &WireGuardConfig{PrivateKeyFile: "/etc/wg0.key"}}, true},
+		{"keys of a bridge", Config{Name: "br0", Kind: KindBridge, WireGuard: &WireGuardConfig{PrivateKeyFile: "/etc/wg0.key"}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestOrder(t *testing.T) {
+	tests := []struct {
+		name    string
+		configs []Config
+		want    []string
+		wantErr bool
+	}{
+		{"independent links by name", []Config{{Name: "br1"}, {Name: "br0"}}, []string{"br0", "br1"}, false},
+		{"parent first", []Config{{Name: "bond0.10", Parent: "bond0"}, {Name: "bond0", Members: []string{"eth1"}}}, []string{"bond0", "bond0.10"}, false},
+		{"members first", []Config{{Name: "br0", Members: []string{"vlan10", "vlan20"}}, {Name: "vlan20", Parent: "eth0"}, {Name: "vlan10", Parent: "eth0"}},
+			[]string{"vlan10", "vlan20", "br0"}, false},
+		{"undeclared dependencies", []Config{{Name: "eth0.100", Parent: "eth0"}}, []string{"eth0.100"}, false},
+		{"cycle", []Config{{Name: "br0", Members: []string{"br1"}}, {Name: "br1", Members: []string{"br0"}}}, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ordered, err := Order(tt.configs)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Order() error = %v, want error %v", err, tt.wantErr)
+			}
+			var names []string
+			for _, config := range ordered {
+				names = append(names, config.Name)
+			}
+			if len(names) != len(tt.want) {
+				t.Fatalf("Order() = %v, want %v", names, tt.want)
+			}
+			for i := range names {
+				if names[i] != tt.want[i] {
+					t.Errorf("Order() = %v, want %v", names, tt.want)
+					break
+				}
+			}
+		})
+	}
+}
+
+func TestBuildMatches(t *testing.T) {
+	local := net.ParseIP("192.0.2.10")
+
+	tests := []struct {
+		name   string
+		config Config
+		local  net.IP
+	}{
+		{"vlan", Config{Name: "eth0.100", Kind: KindVLAN, Parent: "eth0", ID: 100}, nil},
+		{"bridge", Config{Name: "br0", Kind: KindBridge, MTU: 9000}, nil},
+		{"bond", Config{Name: "bond0", Kind: KindBond, Mode: "active-backup", MIIMon: 100}, nil},
+		{"macvlan", Config{Name: "mv0", Kind: KindMacvlan, Parent: "eth0", Mode: "private"}, nil},
+		{"ipvlan", Config{Name: "ipv0", Kind: KindIPVlan, Parent: "eth0", Mode: "l3"}, nil},
+		{"vrf", Config{Name: "vrf-blue", Kind: KindVRF, Table: 10}, nil},
+		{"vxlan", Config{Name: "vx0", Kind: KindVXLAN, Parent: "eth0", ID: 42, Remote: "239.1.1.1"}, local},
+		{"gre", Config{Name: "gre0", Kind: KindGRE, Parent: "eth0", Remote: "198.51.100.1", Key: 7, TTL: 64}, local},
+		{"gre without local address", Config{Name: "gre0", Kind: KindGRE, Remote: "198.51.100.1"}, nil},
+		{"ipip", Config{Name: "ipip0", Kind: KindIPIP, Parent: "eth0", Remote: "198.51.100.1"}, local},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			link := tt.config.build(2, tt.local)
+			if link.Attrs().Name != tt.config.Name || link.Attrs().MTU != tt.config.MTU {
+				t.Errorf("build() = %+v", link.Attrs())
+			}
+			if !tt.config.matches(link, 2, tt.local) {
+				t.Errorf("matches() = false for the built link")
+			}
+			if tt.config.Parent != "" && tt.config.matches(link, 3, tt.local) {
+				t.Errorf("matches() = true for another parent")
+			}
+		})
+	}
+}
+
+func TestMatches(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		link   netlink.Link
+		want   bool
+	}{
+		{"other kind", Config{Kind: KindBridge}, &netlink.Dummy{}, false},
+		{"other vlan id", Config{Kind: KindVLAN, Parent: "eth0", ID: 100}, &netlink.Vlan{LinkAttrs: netlink.LinkAttrs{ParentIndex: 2}, VlanId: 200}, false},
+		{"other bond mode", Config{Kind: KindBond, Mode: "802.3ad"}, &netlink.Bond{Mode: netlink.BOND_MODE_ACTIVE_BACKUP}, false},
+		{"default bond mode", Config{Kind: KindBond}, &netlink.Bond{Mode: netlink.BOND_MODE_ACTIVE_BACKUP}, true},
+		{"other miimon", Config{Kind: KindBond, MIIMon: 100}, &netlink.Bond{Miimon: 200}, false},
+		{"other macvlan mode", Config{Kind: KindMacvlan, Parent: "eth0", Mode: "vepa"}, &netlink.Macvlan{LinkAttrs: netlink.LinkAttrs{ParentIndex: 2}, Mode: netlink.MACVLAN_MODE_BRIDGE}, false},
+		{"other vrf table", Config{Kind: KindVRF, Table: 10}, &netlink.Vrf{Table: 20}, false},
+		{"other vxlan local address", Config{Kind: KindVXLAN, ID: 42, Remote: "239.1.1.1"},
+			&netlink.Vxlan{VxlanId: 42, VtepDevIndex: 2, Port: defaultVXLANPort, SrcAddr: net.ParseIP("192.0.2.11"), Group: net.ParseIP("239.1.1.1")}, false},
+		{"other gre key", Config{Kind: KindGRE, Remote: "198.51.100.1", Key: 7},
+			&netlink.Gretun{Link: 2, IKey: 8, OKey: 8, Local: net.IPv4zero, Remote: net.ParseIP("198.51.100.1")}, false},
+		{"other ipip remote", Config{Kind: KindIPIP, Remote: "198.51.100.1"},
+			&netlink.Iptun{Link: 2, Local: net.IPv4zero, Remote: net.ParseIP("198.51.100.2")}, false},
+		{"default ipvlan mode", Config{Kind: KindIPVlan, Parent: "eth0"}, &netlink.IPVlan{LinkAttrs: netlink.LinkAttrs{ParentIndex: 2}, Mode: netlink.IPVLAN_MODE_L3}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.config.matches(tt.link, 2, nil); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSameAddress(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b net.IP
+		want bool
+	}{
+		{"equal", net.ParseIP("192.0.2.1"), net.IPv4(192, 0, 2, 1).To4(), true},
+		{"different", net.ParseIP("192.0.2.1"), net.ParseIP("192.0.2.2"), false},
+		{"unset and unspecified", nil, net.IPv4zero, true},
+		{"unspecified ipv6 and unset", net.IPv6unspecified, nil, true},
+		{"unset and set", nil, net.ParseIP("192.0.2.1"), false},
+		{"set and unset", net.ParseIP("192.0.2.1"), nil, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sameAddress(tt.a, tt.b); got != tt.want {
+				t.Errorf("sameAddress(%v, %v) = %v, want %v", tt.a, tt.b, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestIPv4OrAny(t *testing.T) {
+	if got := ipv4OrAny(net.ParseIP("192.0.2.1")); len(got) != net.IPv4len || !got.Equal(net.ParseIP("192.0.2.1")) {
+		t.Errorf("ipv4OrAny() = %v, want the 4 byte address", got)
+	}
+	for _, ip := range []net.IP{nil, net.ParseIP("2001:db8::1")} {
+		if got := ipv4OrAny(ip); !got.Equal(net.IPv4zero) || len(got) != net.IPv4len {
+			t.Errorf("ipv4OrAny(%v) = %v, want 0.0.0.0", ip, got)
+		}
+	}
+}
+
+func TestVXLANPort(t *testing.T) {
+	if got := (Config{}).vxlanPort(); got != defaultVXLANPort {
+		t.Errorf("vxlanPort() = %d, want %d", got, defaultVXLANPort)
+	}
+	if got := (Config{Port: 8472}).vxlanPort(); got != 8472 {
+		t.Errorf("vxlanPort() = %d, want 8472", got)
+	}
+}
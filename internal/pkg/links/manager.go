@@ -0,0 +1,361 @@
+package links
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"golang-dhcpcd/internal/pkg/inventory"
+	"golang-dhcpcd/internal/pkg/logging"
+	"golang-dhcpcd/internal/pkg/netmon"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// settleDelay groups the link changes caused by the manager itself into one reconciliation.
+const settleDelay = 200 * time.Millisecond
+
+// Manager creates the declared links, enslaves their members and deletes the links it created
+// that are no longer declared. The links it created are recorded in the state directory.
+type Manager struct {
+	mu      sync.Mutex
+	path    string
+	owned   map[string]bool
+	configs []Config
+}
+
+// state is the on-disk representation of the created links.
+type state struct {
+	Links []string `json:"links"`
+}
+
+// NewManager validates the declarations and loads the links created by a previous run.
+func NewManager(configs []Config) (*Manager, error) {
+	ordered, err := validate(configs)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Manager{
+		path:    filepath.Join(inventory.StateDir(), "links.json"),
+		owned:   make(map[string]bool),
+		configs: ordered,
+	}
+
+	data, err := os.ReadFile(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return m, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read link state %s: %w", m.path, err)
+	}
+	var s state
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse link state %s: %w", m.path, err)
+	}
+	for _, name := range s.Links {
+		m.owned[name] = true
+	}
+	return m, nil
+}
+
+// validate checks the declarations and returns them in dependency order.
+func validate(configs []Config) ([]Config, error) {
+	for _, config := range configs {
+		if err := config.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	return Order(configs)
+}
+
+// SetConfigs replaces the declarations, e.g. when the configuration is reloaded. The links are
+// changed accordingly by the next Apply.
+func (m *Manager) SetConfigs(configs []Config) error {
+	ordered, err := validate(configs)
+	if err != nil {
+		return err
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.configs = ordered
+	return nil
+}
+
+// Run reconciles the links whenever links change, e.g. when a member is plugged in or a created
+// link was deleted, until the context is cancelled. Address changes only trigger a reconciliation
+// while a tunnel follows the address of its parent. Links are kept on return.
+func (m *Manager) Run(ctx context.Context) {
+	logger := logging.WithComponent("links")
+
+	events, err := netmon.Subscribe(ctx, 0)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to subscribe to link changes, links will not be repaired")
+		return
+	}
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event := <-events:
+			if !m.triggers(event) {
+				continue
+			}
+			if !netmon.Settle(ctx, events, settleDelay) {
+				return
+			}
+			if err := m.Apply(); err != nil {
+				logger.WithError(err).Warn("Failed to reconcile links")
+			}
+		}
+	}
+}
+
+// triggers reports whether an event may require reconciling the links. Address changes move
+// tunnels whose local address follows their parent.
+func (m *Manager) triggers(event netmon.Event) bool {
+	switch {
+	case event.Link != nil || event.Resync:
+		return true
+	case event.Addr != nil:
+		return m.followsAddresses()
+	}
+	return false
+}
+
+// followsAddresses reports whether a tunnel takes its local address from its parent.
+func (m *Manager) followsAddresses() bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, config := range m.configs {
+		if config.isTunnel() && config.Parent != "" && config.Local == "" {
+			return true
+		}
+	}
+	return false
+}
+
+// Apply deletes created links that are no longer declared, then creates or repairs the declared
+// links in dependency order. Links whose parent or members are missing are completed later.
+func (m *Manager) Apply() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	declared := make(map[string]bool)
+	for _, config := range m.configs {
+		declared[config.Name] = true
+	}
+
+	var errs []error
+	for _, name := range m.ownedNames() {
+		if declared[name] {
+			continue
+		}
+		if err := m.remove(name); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	for _, config := range m.configs {
+		if err := m.ensure(config); err != nil {
+			errs = append(errs, fmt.Errorf("link %s: %w", config.Name, err))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// ensure creates the link if needed, recreating links it owns whose settings changed, and
+// brings the link and its members up. The caller must hold mu.
+func (m *Manager) ensure(config Config) error {
+	logger := logging.WithComponentAndInterface("links", config.Name).WithField("kind", config.Kind)
+
+	parentIndex := 0
+	var parent netlink.Link
+	if config.Parent != "" {
+		var err error
+		if parent, err = netlink.LinkByName(config.Parent); err != nil {
+			logger.WithField("parent", config.Parent).Debug("Parent link not present yet")
+			return nil
+		}
+		parentIndex = parent.Attrs().Index
+	}
+
+	// Tunnels follow the address of their parent
+	var local net.IP
+	if config.isTunnel() {
+		var ready bool
+		if local, ready = config.localAddress(parent); !ready {
+			logger.WithField("parent", config.Parent).Debug("Parent has no address yet")
+			return nil
+		}
+	}
+
+	link, err := netlink.LinkByName(config.Name)
+	if err == nil && !config.matches(link, parentIndex, local) {
+		if !m.owned[config.Name] {
+			return fmt.Errorf("existing %s link was not created by the daemon and does not match", link.Type())
+		}
+		logger.Info("Link settings changed, recreating it")
+		if err := netlink.LinkDel(link); err != nil {
+			return fmt.Errorf("failed to delete link: %w", err)
+		}
+		link = nil
+	} else if err != nil {
+		link = nil
+	}
+
+	if link == nil {
+		if err := netlink.LinkAdd(config.build(parentIndex, local)); err != nil {
+			return fmt.Errorf("failed to create link: %w", err)
+		}
+		if err := m.own(config.Name); err != nil {
+			logger.WithError(err).Warn("Failed to record created link")
+		}
+		if link, err = netlink.LinkByName(config.Name); err != nil {
+			return fmt.Errorf("failed to get created link: %w", err)
+		}
+		logger.Info("Created link")
+	}
+
+	if config.MTU > 0 && link.Attrs().MTU != config.MTU {
+		if err := netlink.LinkSetMTU(link, config.MTU); err != nil {
+			return fmt.Errorf("failed to set MTU %d: %w", config.MTU, err)
+		}
+	}
+
+	if config.WireGuard != nil {
+		if err := configureWireGuard(config.Name, *config.WireGuard, logger); err != nil {
+			return err
+		}
+	}
+
+	if config.Kind == KindBridge || config.Kind == KindBond || config.Kind == KindVRF {
+		if err := m.ensureMembers(link, config); err != nil {
+			return err
+		}
+	}
+
+	if link.Attrs().RawFlags&unix.IFF_UP == 0 {
+		if err := netlink.LinkSetUp(link); err != nil {
+			return fmt.Errorf("failed to bring link up: %w", err)
+		}
+	}
+	return nil
+}
+
+// ensureMembers enslaves the declared members that are present and releases other ports of
+// bridges and bonds the daemon created. The caller must hold mu.
+func (m *Manager) ensureMembers(master netlink.Link, config Config) error {
+	logger := logging.WithComponentAndInterface("links", config.Name)
+
+	members := make(map[string]bool)
+	for _, name := range config.Members {
+		members[name] = true
+
+		member, err := netlink.LinkByName(name)
+		if err != nil {
+			logger.WithField("member", name).Debug("Member link not present yet")
+			continue
+		}
+		if member.Attrs().MasterIndex == master.Attrs().Index {
+			continue
+		}
+
+		// Bond ports have to be down while they are enslaved
+		if config.Kind == KindBond {
+			if err := netlink.LinkSetDown(member); err != nil {
+				return fmt.Errorf("failed to bring member %s down: %w", name, err)
+			}
+		}
+		if err := netlink.LinkSetMasterByIndex(member, master.Attrs().Index); err != nil {
+			return fmt.Errorf("failed to add member %s: %w", name, err)
+		}
+		if err := netlink.LinkSetUp(member); err != nil {
+			return fmt.Errorf("failed to bring member %s up: %w", name, err)
+		}
+		logger.WithField("member", name).Info("Added member")
+	}
+
+	// Interfaces also join VRFs through their own configuration
+	if !m.owned[config.Name] || config.Kind == KindVRF {
+		return nil
+	}
+	all, err := netlink.LinkList()
+	if err != nil {
+		return fmt.Errorf("failed to list links: %w", err)
+	}
+	for _, link := range all {
+		if link.Attrs().MasterIndex != master.Attrs().Index || members[link.Attrs().Name] {
+			continue
+		}
+		if err := netlink.LinkSetNoMaster(link); err != nil {
+			return fmt.Errorf("failed to release member %s: %w", link.Attrs().Name, err)
+		}
+		logger.WithField("member", link.Attrs().Name).Info("Released member")
+	}
+	return nil
+}
+
+// remove deletes a created link that is no longer declared. The caller must hold mu.
+func (m *Manager) remove(name string) error {
+	logger := logging.WithComponentAndInterface("links", name)
+
+	link, err := netlink.LinkByName(name)
+	if err == nil {
+		if err := netlink.LinkDel(link); err != nil {
+			return fmt.Errorf("failed to delete link %s: %w", name, err)
+		}
+		logger.Info("Deleted link that is no longer configured")
+	}
+	delete(m.owned, name)
+	return m.save()
+}
+
+// own records a link as created by the daemon. The caller must hold mu.
+func (m *Manager) own(name string) error {
+	if m.owned[name] {
+		return nil
+	}
+	m.owned[name] = true
+	return m.save()
+}
+
+// ownedNames returns the created links in name order. The caller must hold mu.
+func (m *Manager) ownedNames() []string {
+	names := make([]string, 0, len(m.owned))
+	for name := range m.owned {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// save writes the created links atomically. The caller must hold mu.
+func (m *Manager) save() error {
+	data, err := json.MarshalIndent(state{Links: m.ownedNames()}, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode link state: %w", err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(m.path), 0755); err != nil {
+		return fmt.Errorf("failed to create state directory: %w", err)
+	}
+	tmp := m.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write link state %s: %w", tmp, err)
+	}
+	if err := os.Rename(tmp, m.path); err != nil {
+		return fmt.Errorf("failed to write link state %s: %w", m.path, err)
+	}
+	return nil
+}
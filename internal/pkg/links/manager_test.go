@@ -0,0 +1,43 @@
+package links
+
+import (
+	"testing"
+
+	"golang-dhcpcd/internal/pkg/netmon"
+
+	"github.com/vishvananda/netlink"
+)
+
+func TestTriggers(t *testing.T) {
+	vlan := Config{Name: "eth0.10", Kind: KindVLAN, Parent: "eth0", ID: 10}
+	following := Config{Name: "gre0", Kind: KindGRE, Parent: "eth0", Remote: "192.0.2.1"}
+	fixed := Config{Name: "gre1", Kind: KindGRE, Parent: "eth0", Local: "192.0.2.2", Remote: "192.0.2.1"}
+
+	link := netmon.Event{Link: &netlink.LinkUpdate{}}
+	addr := netmon.Event{Addr: &netlink.AddrUpdate{}}
+	route := netmon.Event{Route: &netlink.RouteUpdate{}}
+	resync := netmon.Event{Resync: true}
+
+	tests := []struct {
+		name    string
+		configs []Config
+		event   netmon.Event
+		want    bool
+	}{
+		{"link", []Config{vlan}, link, true},
+		{"resync", []Config{vlan}, resync, true},
+		{"route", []Config{following}, route, false},
+		{"address without tunnels", []Config{vlan}, addr, false},
+		{"address with a fixed local address", []Config{fixed}, addr, false},
+		{"address followed by a tunnel", []Config{vlan, following}, addr, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := &Manager{configs: tt.configs}
+			if got := m.triggers(tt.event); got != tt.want {
+				t.Errorf("triggers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
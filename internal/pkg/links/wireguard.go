@@ -0,0 +1,172 @@
+package links
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"golang.zx2c4.com/wireguard/wgctrl"
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// WireGuardConfig holds the keys and peers of a WireGuard link.
+type WireGuardConfig struct {
+	PrivateKeyFile string
+	ListenPort     int // Chosen by the kernel when unset
+	Peers          []WireGuardPeer
+}
+
+// WireGuardPeer is a remote WireGuard endpoint.
+type WireGuardPeer struct {
+	PublicKey           string        `yaml:"public_key"`
+	Endpoint            string        `yaml:"endpoint,omitempty"` // host:port, optional for peers that connect to us
+	AllowedIPs          []string      `yaml:"allowed_ips,omitempty"`
+	PersistentKeepalive time.Duration `yaml:"persistent_keepalive,omitempty"` // Disabled if unset
+}
+
+// Validate checks the peers, the private key is only read when the device is configured.
+func (c WireGuardConfig) Validate() error {
+	if c.PrivateKeyFile == "" {
+		return fmt.Errorf("private key file is required")
+	}
+	if c.ListenPort < 0 || c.ListenPort > 65535 {
+		return fmt.Errorf("invalid listen port %d", c.ListenPort)
+	}
+	for _, peer := range c.Peers {
+		if _, err := wgtypes.ParseKey(peer.PublicKey); err != nil {
+			return fmt.Errorf("invalid peer public key %q: %w", peer.PublicKey, err)
+		}
+		if peer.Endpoint != "" {
+			if _, _, err := net.SplitHostPort(peer.Endpoint); err != nil {
+				return fmt.Errorf("invalid peer endpoint %q: %w", peer.Endpoint, err)
+			}
+		}
+		for _, allowed := range peer.AllowedIPs {
+			if _, _, err := net.ParseCIDR(allowed); err != nil {
+				return fmt.Errorf("invalid allowed IP %q: must be in CIDR notation", allowed)
+			}
+		}
+		if peer.PersistentKeepalive < 0 {
+			return fmt.Errorf("persistent keepalive must not be negative")
+		}
+	}
+	return nil
+}
+
+// deviceConfig reads the private key and resolves the peer endpoints.
+func (c WireGuardConfig) deviceConfig() (wgtypes.Config, error) {
+	data, err := os.ReadFile(c.PrivateKeyFile)
+	if err != nil {
+		return wgtypes.Config{}, fmt.Errorf("failed to read private key: %w", err)
+	}
+	key, err := wgtypes.ParseKey(strings.TrimSpace(string(data)))
+	if err != nil {
+		return wgtypes.Config{}, fmt.Errorf("invalid private key in %s: %w", c.PrivateKeyFile, err)
+	}
+
+	config := wgtypes.Config{PrivateKey: &key, ReplacePeers: true}
+	if c.ListenPort > 0 {
+		config.ListenPort = &c.ListenPort
+	}
+	for _, peer := range c.Peers {
+		publicKey, err := wgtypes.ParseKey(peer.PublicKey)
+		if err != nil {
+			return wgtypes.Config{}, fmt.Errorf("invalid peer public key %q: %w", peer.PublicKey, err)
+		}
+		keepalive := peer.PersistentKeepalive
+		peerConfig := wgtypes.PeerConfig{
+			PublicKey:                   publicKey,
+			PersistentKeepaliveInterval: &keepalive,
+			ReplaceAllowedIPs:           true,
+		}
+		if peer.Endpoint != "" {
+			if peerConfig.Endpoint, err = net.ResolveUDPAddr("udp", peer.Endpoint); err != nil {
+				return wgtypes.Config{}, fmt.Errorf("failed to resolve peer endpoint %s: %w", peer.Endpoint, err)
+			}
+		}
+		for _, allowed := range peer.AllowedIPs {
+			_, prefix, err := net.ParseCIDR(allowed)
+			if err != nil {
+				return wgtypes.Config{}, fmt.Errorf("invalid allowed IP %q: %w", allowed, err)
+			}
+			peerConfig.AllowedIPs = append(peerConfig.AllowedIPs, *prefix)
+		}
+		config.Peers = append(config.Peers, peerConfig)
+	}
+	return config, nil
+}
+
+// configureWireGuard applies the keys and peers to the device unless they are in place already,
+// so that established sessions survive reconciliation.
+func configureWireGuard(name string, c WireGuardConfig, logger *logrus.Entry) error {
+	desired, err := c.deviceConfig()
+	if err != nil {
+		return err
+	}
+
+	client, err := wgctrl.New()
+	if err != nil {
+		return fmt.Errorf("failed to open WireGuard control: %w", err)
+	}
+	defer client.Close()
+
+	device, err := client.Device(name)
+	if err != nil {
+		return fmt.Errorf("failed to get WireGuard device: %w", err)
+	}
+	if deviceMatches(device, desired) {
+		return nil
+	}
+
+	if err := client.ConfigureDevice(name, desired); err != nil {
+		return fmt.Errorf("failed to configure WireGuard device: %w", err)
+	}
+	logger.WithField("peers", len(desired.Peers)).Info("Configured WireGuard device")
+	return nil
+}
+
+// deviceMatches reports whether the device has the desired key, port and peers. Endpoints are
+// only compared for peers with a configured endpoint, as roaming peers update them.
+func deviceMatches(device *wgtypes.Device, desired wgtypes.Config) bool {
+	if device.PrivateKey != *desired.PrivateKey {
+		return false
+	}
+	if desired.ListenPort != nil && device.ListenPort != *desired.ListenPort {
+		return false
+	}
+	if len(device.Peers) != len(desired.Peers) {
+		return false
+	}
+
+	peers := make(map[wgtypes.Key]wgtypes.Peer)
+	for _, peer := range device.Peers {
+		peers[peer.PublicKey] = peer
+	}
+	for _, want := range desired.Peers {
+		have, exists := peers[want.PublicKey]
+		if !exists || have.PersistentKeepaliveInterval != *want.PersistentKeepaliveInterval {
+			return false
+		}
+		if want.Endpoint != nil && (have.Endpoint == nil || have.Endpoint.String() != want.Endpoint.String()) {
+			return false
+		}
+		if prefixList(have.AllowedIPs) != prefixList(want.AllowedIPs) {
+			return false
+		}
+	}
+	return true
+}
+
+// prefixList renders prefixes in a canonical order for comparison.
+func prefixList(prefixes []net.IPNet) string {
+	list := make([]string, 0, len(prefixes))
+	for _, prefix := range prefixes {
+		list = append(list, prefix.String())
+	}
+	sort.Strings(list)
+	return strings.Join(list, ",")
+}
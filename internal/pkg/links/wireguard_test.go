@@ -0,0 +1,130 @@
+package links
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"golang.zx2c4.com/wireguard/wgctrl/wgtypes"
+)
+
+// mustKey generates a private key for the tests.
+func mustKey(t *testing.T) wgtypes.Key {
+	t.Helper()
+
+	key, err := wgtypes.GeneratePrivateKey()
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+	return key
+}
+
+func TestWireGuardConfigValidate(t *testing.T) {
+	peer := mustKey(t).PublicKey().String()
+
+	tests := []struct {
+		name    string
+		config  WireGuardConfig
+		wantErr bool
+	}{
+		{"no peers", WireGuardConfig{PrivateKeyFile: "/etc/wg0.key"}, false},
+		{"peer", WireGuardConfig{PrivateKeyFile: "/etc/wg0.key", ListenPort: 51820, Peers: []WireGuardPeer{
+			{PublicKey: peer, Endpoint: "vpn.example.com:51820", AllowedIPs: []string{"10.8.0.0/24", "fd00::/64"}, PersistentKeepalive: 25 * time.Second},
+		}}, false},
+		{"no private key", WireGuardConfig{}, true},
+		{"invalid listen port", WireGuardConfig{PrivateKeyFile: "/etc/wg0.key", ListenPort: 70000}, true},
+		{"invalid public key", WireGuardConfig{PrivateKeyFile: "/etc/wg0.key", Peers: []WireGuardPeer{{PublicKey: "key"}}}, true},
+		{"endpoint without port", WireGuardConfig{PrivateKeyFile: "/etc/wg0.key", Peers: []WireGuardPeer{{PublicKey: peer, Endpoint: "vpn.example.com"}}}, true},
+		{"allowed ip without prefix", WireGuardConfig{PrivateKeyFile: "/etc/wg0.key", Peers: []WireGuardPeer{{PublicKey: peer, AllowedIPs: []string{"10.8.0.1"}}}}, true},
+		{"negative keepalive", WireGuardConfig{PrivateKeyFile: "/etc/wg0.key", Peers: []WireGuardPeer{{PublicKey: peer, PersistentKeepalive: -time.Second}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDeviceMatches(t *testing.T) {
+	key := mustKey(t)
+	peer := mustKey(t).PublicKey()
+	keyFile := filepath.Join(t.TempDir(), "wg0.key")
+	if err := os.WriteFile(keyFile, []byte(key.String()+"\n"), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+
+	config := WireGuardConfig{PrivateKeyFile: keyFile, ListenPort: 51820, Peers: []WireGuardPeer{
+		{PublicKey: peer.String(), Endpoint: "192.0.2.1:51820", AllowedIPs: []string{"10.8.0.0/24", "10.9.0.0/24"}, PersistentKeepalive: 25 * time.Second},
+	}}
+	desired, err := config.deviceConfig()
+	if err != nil {
+		t.Fatalf("deviceConfig() failed: %v", err)
+	}
+
+	// device returns the device as the kernel reports it after applying the configuration
+	device := func() *wgtypes.Device {
+		_, first, _ := net.ParseCIDR("10.9.0.0/24")
+		_, second, _ := net.ParseCIDR("10.8.0.0/24")
+		return &wgtypes.Device{PrivateKey: key, ListenPort: 51820, Peers: []wgtypes.Peer{{
+			PublicKey:                   peer,
+			Endpoint:                    &net.UDPAddr{IP: net.ParseIP("192.0.2.1"), Port: 51820},
+			PersistentKeepaliveInterval: 25 * time.Second,
+			AllowedIPs:                  []net.IPNet{*first, *second},
+		}}}
+	}
+
+	tests := []struct {
+		name   string
+		change func(device *wgtypes.Device)
+		want   bool
+	}{
+		{"same", func(device *wgtypes.Device) {}, true},
+		{"other private key", func(device *wgtypes.Device) { device.PrivateKey = mustKey(t) }, false},
+		{"other listen port", func(device *wgtypes.Device) { device.ListenPort = 51821 }, false},
+		{"missing peer", func(device *wgtypes.Device) { device.Peers = nil }, false},
+		{"other peer", func(device *wgtypes.Device) { device.Peers[0].PublicKey = mustKey(t).PublicKey() }, false},
+		{"other endpoint", func(device *wgtypes.Device) { device.Peers[0].Endpoint.Port = 51821 }, false},
+		{"other keepalive", func(device *wgtypes.Device) { device.Peers[0].PersistentKeepaliveInterval = 0 }, false},
+		{"other allowed ips", func(device *wgtypes.Device) { device.Peers[0].AllowedIPs = device.Peers[0].AllowedIPs[:1] }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			device := device()
+			tt.change(device)
+			if got := deviceMatches(device, desired); got != tt.want {
+				t.Errorf("deviceMatches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	// Roaming peers without a configured endpoint keep whatever endpoint they last used
+	config.Peers[0].Endpoint = ""
+	if desired, err = config.deviceConfig(); err != nil {
+		t.Fatalf("deviceConfig() failed: %v", err)
+	}
+	roamed := device()
+	roamed.Peers[0].Endpoint.Port = 40000
+	if !deviceMatches(roamed, desired) {
+		t.Error("deviceMatches() = false for a roaming peer")
+	}
+}
+
+func TestDeviceConfigInvalidKey(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "wg0.key")
+	if err := os.WriteFile(keyFile, []byte("not a key"), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	if _, err := (WireGuardConfig{PrivateKeyFile: keyFile}).deviceConfig(); err == nil {
+		t.Error("deviceConfig() accepted an invalid private key")
+	}
+	if _, err := (WireGuardConfig{PrivateKeyFile: filepath.Join(t.TempDir(), "missing")}).deviceConfig(); err == nil {
+		t.Error("deviceConfig() accepted a missing private key file")
+	}
+}
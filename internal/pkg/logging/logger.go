@@ -89,9 +89,14 @@ func (f *CompactFormatter) Format(entry *logrus.Entry) ([]byte, error) {
 
 	b.WriteByte('\n')
 	return b.Bytes(), nil
-} // InitLogger initializes the global logger with the provided configuration
+}
+
+// InitLogger initializes the global logger with the provided configuration. Calling it again
+// reconfigures the logger in place, so that loggers derived from it follow the new settings.
 func InitLogger(config LogConfig) {
-	Logger = logrus.New()
+	if Logger == nil {
+		Logger = logrus.New()
+	}
 
 	// Set log level
 	level, err := logrus.ParseLevel(config.Level)
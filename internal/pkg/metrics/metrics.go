@@ -0,0 +1,204 @@
+package metrics
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"golang-dhcpcd/internal/pkg/logging"
+	"golang-dhcpcd/internal/pkg/version"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// namespace prefixes every metric of the daemon.
+const namespace = "golang_dhcpcd"
+
+// Exchanges with DHCP servers, the exchange label of DHCPExchanges and DHCPExchangeDuration.
+const (
+	ExchangeDiscover   = "discover"
+	ExchangeRequest    = "request"
+	ExchangeInitReboot = "init_reboot"
+	ExchangeRenew      = "renew"
+	ExchangeRebind     = "rebind"
+	ExchangeRelease    = "release"
+)
+
+// Results of exchanges.
+const (
+	ResultSuccess = "success"
+	ResultNak     = "nak"
+	ResultFailure = "failure"
+)
+
+// Reasons of DHCPFailures.
+const (
+	ReasonNoOffer = "no_offer" // No server answered DISCOVER
+	ReasonNoAck   = "no_ack"   // The server did not acknowledge REQUEST
+	ReasonNak     = "nak"      // The server rejected the lease
+	ReasonExpired = "expired"  // The lease expired before it could be extended
+	ReasonApply   = "apply"    // The lease could not be configured on the interface
+)
+
+// NetNSContainers is the netns label of the clients leasing for containers through the IPAM
+// plugin. Their namespaces live as long as a container, they are not told apart.
+const NetNSContainers = "ipam"
+
+// Kinds of StaticRepairs.
+const (
+	RepairLink    = "link"    // Link settings such as the MTU or the administrative state
+	RepairVRF     = "vrf"     // Membership in the VRF
+	RepairAddress = "address" // Missing addresses, the whole configuration is reapplied
+	RepairRoute   = "route"   // Missing default, static or probe routes
+	RepairPolicy  = "policy"  // Missing policy rules
+	RepairDNS     = "dns"     // Resolver configuration rewritten by someone else
+)
+
+var (
+	// DHCPExchanges counts the message exchanges with DHCP servers by their result.
+	DHCPExchanges = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "dhcp",
+		Name:      "exchanges_total",
+		Help:      "DHCP message exchanges by exchange (discover, request, init_reboot, renew, rebind, release) and result.",
+	}, []string{"interface", "netns", "exchange", "result"})
+
+	// DHCPExchangeDuration observes how long exchanges with DHCP servers took.
+	DHCPExchangeDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Subsystem: "dhcp",
+		Name:      "exchange_duration_seconds",
+		Help:      "Duration of DHCP message exchanges, including retransmissions.",
+		Buckets:   []float64{0.005, 0.01, 0.05, 0.1, 0.5, 1, 2, 5, 10, 15},
+	}, []string{"interface", "netns", "exchange"})
+
+	// DHCPFailures counts why leases could not be obtained or kept.
+	DHCPFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "dhcp",
+		Name:      "failures_total",
+		Help:      "DHCP failures by reason (no_offer, no_ack, nak, expired, apply).",
+	}, []string{"interface", "netns", "reason"})
+
+	// StaticRepairs counts the repairs of static configuration changed by someone else.
+	StaticRepairs = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Subsystem: "static",
+		Name:      "repairs_total",
+		Help:      "Repairs of static interface configuration by kind (link, vrf, address, route, policy, dns).",
+	}, []string{"interface", "netns", "kind"})
+)
+
+// Lease describes the lease of a DHCP interface at the time of a scrape.
+type Lease struct {
+	Interface string
+	NetNS     string
+	State     string
+	Expires   time.Time // Zero without a lease
+}
+
+// LeaseFunc returns the leases of the running DHCP interfaces.
+type LeaseFunc func() []Lease
+
+// leaseCollector reports the state and remaining time of the leases on every scrape.
+type leaseCollector struct {
+	leases    LeaseFunc
+	state     *prometheus.Desc
+	remaining *prometheus.Desc
+}
+
+func newLeaseCollector(leases LeaseFunc) *leaseCollector {
+	return &leaseCollector{
+		leases: leases,
+		state: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "dhcp", "lease_state"),
+			"Lease state of a DHCP interface (init, bound, suspect, released), 1 for the current state.",
+			[]string{"interface", "netns", "state"}, nil),
+		remaining: prometheus.NewDesc(
+			prometheus.BuildFQName(namespace, "dhcp", "lease_remaining_seconds"),
+			"Seconds until the lease of a DHCP interface expires.",
+			[]string{"interface", "netns"}, nil),
+	}
+}
+
+func (c *leaseCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.state
+	ch <- c.remaining
+}
+
+func (c *leaseCollector) Collect(ch chan<- prometheus.Metric) {
+	now := time.Now()
+	for _, lease := range c.leases() {
+		ch <- prometheus.MustNewConstMetric(c.state, prometheus.GaugeValue, 1, lease.Interface, lease.NetNS, lease.State)
+		if !lease.Expires.IsZero() {
+			remaining := max(lease.Expires.Sub(now).Seconds(), 0)
+			ch <- prometheus.MustNewConstMetric(c.remaining, prometheus.GaugeValue, remaining, lease.Interface, lease.NetNS)
+		}
+	}
+}
+
+// newRegistry registers the metrics of the daemon, the build information and the process metrics.
+func newRegistry(leases LeaseFunc) *prometheus.Registry {
+	info := version.GetGitInfo()
+	buildInfo := prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "build_info",
+		Help:      "Build information of the daemon, always 1.",
+		ConstLabels: prometheus.Labels{
+			"version": info.Tag,
+			"commit":  info.Commit,
+			"branch":  info.Branch,
+			"dirty":   strconv.FormatBool(info.Dirty),
+		},
+	})
+	buildInfo.Set(1)
+
+	registry := prometheus.NewRegistry()
+	registry.MustRegister(
+		buildInfo,
+		DHCPExchanges,
+		DHCPExchangeDuration,
+		DHCPFailures,
+		StaticRepairs,
+		newLeaseCollector(leases),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return registry
+}
+
+// Serve exposes the metrics on /metrics of the listen address until the context is cancelled.
+func Serve(ctx context.Context, listen string, leases LeaseFunc) error {
+	listener, err := net.Listen("tcp", listen)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", listen, err)
+	}
+	return ServeListener(ctx, listener, leases)
+}
+
+// ServeListener exposes the metrics on /metrics of the listener until the context is cancelled,
+// e.g. on a socket passed by systemd.
+func ServeListener(ctx context.Context, listener net.Listener, leases LeaseFunc) error {
+	logger := logging.WithComponent("metrics").WithField("listen", listener.Addr().String())
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(newRegistry(leases), promhttp.HandlerOpts{}))
+
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		<-ctx.Done()
+		server.Close()
+	}()
+
+	logger.Info("Serving metrics")
+	if err := server.Serve(listener); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("metrics server failed: %w", err)
+	}
+	return nil
+}
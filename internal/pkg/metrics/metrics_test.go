@@ -0,0 +1,113 @@
+package metrics
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestLeaseCollector(t *testing.T) {
+	leases := func() []Lease {
+		return []Lease{
+			{Interface: "eth0", State: "bound", Expires: time.Now().Add(time.Hour)},
+			{Interface: "eth1", NetNS: "blue", State: "init"},
+			{Interface: "eth2", State: "suspect", Expires: time.Now().Add(-time.Minute)},
+		}
+	}
+	collector := newLeaseCollector(leases)
+
+	if got := testutil.CollectAndCount(collector, "golang_dhcpcd_dhcp_lease_state"); got != 3 {
+		t.Errorf("Collected %d lease states, want 3", got)
+	}
+	// Interfaces without a lease have no remaining time
+	if got := testutil.CollectAndCount(collector, "golang_dhcpcd_dhcp_lease_remaining_seconds"); got != 2 {
+		t.Errorf("Collected %d remaining times, want 2", got)
+	}
+
+	expected := `
+# HELP golang_dhcpcd_dhcp_lease_state Lease state of a DHCP interface (init, bound, suspect, released), 1 for the current state.
+# TYPE golang_dhcpcd_dhcp_lease_state gauge
+golang_dhcpcd_dhcp_lease_state{interface="eth0",netns="",state="bound"} 1
+golang_dhcpcd_dhcp_lease_state{interface="eth1",netns="blue",state="init"} 1
+golang_dhcpcd_dhcp_lease_state{interface="eth2",netns="",state="suspect"} 1
+`
+	if err := testutil.CollectAndCompare(collector, strings.NewReader(expected), "golang_dhcpcd_dhcp_lease_state"); err != nil {
+		t.Errorf("Unexpected lease states: %v", err)
+	}
+
+	// Expired leases report zero rather than a negative time
+	expired := newLeaseCollector(func() []Lease {
+		return []Lease{{Interface: "eth2", State: "suspect", Expires: time.Now().Add(-time.Minute)}}
+	})
+	expected = `
+# HELP golang_dhcpcd_dhcp_lease_remaining_seconds Seconds until the lease of a DHCP interface expires.
+# TYPE golang_dhcpcd_dhcp_lease_remaining_seconds gauge
+golang_dhcpcd_dhcp_lease_remaining_seconds{interface="eth2",netns=""} 0
+`
+	if err := testutil.CollectAndCompare(expired, strings.NewReader(expected), "golang_dhcpcd_dhcp_lease_remaining_seconds"); err != nil {
+		t.Errorf("Unexpected remaining time: %v", err)
+	}
+}
+
+func TestRegistry(t *testing.T) {
+	registry := newRegistry(func() []Lease { return nil })
+	DHCPFailures.WithLabelValues("eth0", "", ReasonNoOffer).Inc()
+
+	families, err := registry.Gather()
+	if err != nil {
+		t.Fatalf("Gather() failed: %v", err)
+	}
+	names := make(map[string]bool)
+	for _, family := range families {
+		names[family.GetName()] = true
+	}
+	for _, name := range []string{"golang_dhcpcd_build_info", "golang_dhcpcd_dhcp_failures_total", "go_goroutines"} {
+		if !names[name] {
+			t.Errorf("Registry lacks %s", name)
+		}
+	}
+}
+
+func TestServe(t *testing.T) {
+	// Pick a free port for the server
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	listen := listener.Addr().String()
+	listener.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() { done <- Serve(ctx, listen, func() []Lease { return nil }) }()
+	defer func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("Serve() failed: %v", err)
+		}
+	}()
+
+	var response *http.Response
+	deadline := time.Now().Add(5 * time.Second)
+	for response, err = http.Get("http://" + listen + "/metrics"); err != nil; response, err = http.Get("http://" + listen + "/metrics") {
+		if time.Now().After(deadline) {
+			t.Fatalf("Server did not answer: %v", err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	defer response.Body.Close()
+
+	body, err := io.ReadAll(response.Body)
+	if err != nil {
+		t.Fatalf("Failed to read metrics: %v", err)
+	}
+	if response.StatusCode != http.StatusOK || !strings.Contains(string(body), "golang_dhcpcd_build_info") {
+		t.Errorf("GET /metrics = %d without the build information", response.StatusCode)
+	}
+}
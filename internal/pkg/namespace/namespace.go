@@ -0,0 +1,219 @@
+package namespace
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"golang-dhcpcd/internal/pkg/logging"
+
+	"github.com/vishvananda/netns"
+)
+
+// NamedDir is where `ip netns` mounts named network namespaces.
+const NamedDir = "/run/netns"
+
+// pollInterval is how often a watched namespace is checked for appearing, disappearing or being replaced.
+const pollInterval = 2 * time.Second
+
+// Namespace is a network namespace other than the one of the daemon. A nil *Namespace stands
+// for the namespace of the daemon.
+type Namespace struct {
+	Name   string // Name under /run/netns, empty for namespaces given by path
+	Path   string
+	handle netns.NsHandle
+}
+
+// Path returns the path of a namespace given by name or by absolute path.
+func Path(spec string) string {
+	if strings.Contains(spec, "/") {
+		return spec
+	}
+	return filepath.Join(NamedDir, spec)
+}
+
+// Open opens the namespace given by name or by absolute path.
+func Open(spec string) (*Namespace, error) {
+	ns := &Namespace{Path: Path(spec)}
+	if !strings.Contains(spec, "/") {
+		ns.Name = spec
+	}
+
+	handle, err := netns.GetFromPath(ns.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open network namespace %s: %w", ns.Path, err)
+	}
+	ns.handle = handle
+	return ns, nil
+}
+
+// Handle returns the handle of the namespace, valid until it is closed.
+func (n *Namespace) Handle() netns.NsHandle {
+	return n.handle
+}
+
+// Close releases the namespace.
+func (n *Namespace) Close() error {
+	return n.handle.Close()
+}
+
+// String returns the name of the namespace, or its path if it has no name.
+func (n *Namespace) String() string {
+	if n == nil {
+		return ""
+	}
+	if n.Name != "" {
+		return n.Name
+	}
+	return n.Path
+}
+
+// Qualify prefixes a name used for state files with the namespace, as interface names are only
+// unique within a namespace.
+func (n *Namespace) Qualify(name string) string {
+	if n == nil {
+		return name
+	}
+	key := n.Name
+	if key == "" {
+		key = strings.ReplaceAll(strings.Trim(n.Path, "/"), "/", "_")
+	}
+	return filepath.Join("netns", key, name)
+}
+
+// ResolvConf returns the resolver configuration of the namespace: /etc/resolv.conf for the daemon,
+// /etc/netns/<name>/resolv.conf as used by `ip netns exec` for named namespaces, and nothing for
+// namespaces given by path.
+func (n *Namespace) ResolvConf() string {
+	switch {
+	case n == nil:
+		return "/etc/resolv.conf"
+	case n.Name != "":
+		return filepath.Join("/etc/netns", n.Name, "resolv.conf")
+	}
+	return ""
+}
+
+type contextKey struct{}
+
+// NewContext returns a context for work inside the namespace.
+func NewContext(ctx context.Context, ns *Namespace) context.Context {
+	return context.WithValue(ctx, contextKey{}, ns)
+}
+
+// FromContext returns the namespace of the context, nil for the namespace of the daemon.
+func FromContext(ctx context.Context) *Namespace {
+	ns, _ := ctx.Value(contextKey{}).(*Namespace)
+	return ns
+}
+
+// Do runs fn on the calling goroutine inside the namespace of the context, so that the sockets it
+// opens, including netlink sockets, belong to that namespace. If the namespace of the daemon cannot
+// be restored afterwards, the goroutine stays locked to its thread, which is discarded when the
+// goroutine exits.
+func Do(ctx context.Context, fn func()) error {
+	ns := FromContext(ctx)
+	if ns == nil {
+		fn()
+		return nil
+	}
+
+	runtime.LockOSThread()
+	origin, err := netns.Get()
+	if err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("failed to get current network namespace: %w", err)
+	}
+	defer origin.Close()
+
+	if err := netns.Set(ns.handle); err != nil {
+		runtime.UnlockOSThread()
+		return fmt.Errorf("failed to enter network namespace %s: %w", ns, err)
+	}
+	fn()
+	if err := netns.Set(origin); err != nil {
+		return fmt.Errorf("failed to restore network namespace: %w", err)
+	}
+	runtime.UnlockOSThread()
+	return nil
+}
+
+// Go runs fn in a new goroutine inside the namespace of the context.
+func Go(ctx context.Context, fn func()) {
+	go func() {
+		if err := Do(ctx, fn); err != nil {
+			logging.WithComponent("netns").WithError(err).Error("Failed to run in network namespace")
+		}
+	}()
+}
+
+// Watch runs fn inside the namespace while it exists, until the context is cancelled. fn is
+// cancelled when the namespace disappears and started again when it is created anew.
+func Watch(ctx context.Context, spec string, fn func(ctx context.Context)) {
+	logger := logging.WithComponent("netns").WithField("netns", spec)
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	waiting := false
+	for {
+		ns, err := Open(spec)
+		if err != nil {
+			if !waiting {
+				logger.WithError(err).Info("Network namespace not present, waiting for it to appear")
+				waiting = true
+			}
+		} else {
+			waiting = false
+			logger.Info("Network namespace present, configuring its interfaces")
+			nsCtx, cancel := context.WithCancel(NewContext(ctx, ns))
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				if err := Do(nsCtx, func() { fn(nsCtx) }); err != nil {
+					logger.WithError(err).Error("Failed to run in network namespace")
+				}
+			}()
+
+			gone := ns.wait(ctx, ticker.C, done)
+			cancel()
+			<-done
+			ns.Close()
+			if gone {
+				logger.Info("Network namespace gone, stopped its interfaces")
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// wait returns once the context is cancelled, fn returned or the path no longer refers to the
+// namespace, reporting true in the latter case.
+func (n *Namespace) wait(ctx context.Context, tick <-chan time.Time, done <-chan struct{}) bool {
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-done:
+			return false
+		case <-tick:
+		}
+
+		current, err := netns.GetFromPath(n.Path)
+		if err != nil {
+			return true
+		}
+		replaced := !current.Equal(n.handle)
+		current.Close()
+		if replaced {
+			return true
+		}
+	}
+}
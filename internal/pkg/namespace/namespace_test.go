@@ -0,0 +1,68 @@
+package namespace
+
+import (
+	"context"
+	"testing"
+)
+
+func TestPath(t *testing.T) {
+	tests := []struct {
+		spec string
+		want string
+	}{
+		{"blue", "/run/netns/blue"},
+		{"/proc/1234/ns/net", "/proc/1234/ns/net"},
+		{"/var/run/netns/red", "/var/run/netns/red"},
+	}
+
+	for _, tt := range tests {
+		if got := Path(tt.spec); got != tt.want {
+			t.Errorf("Path(%q) = %s, want %s", tt.spec, got, tt.want)
+		}
+	}
+}
+
+func TestNamespace(t *testing.T) {
+	tests := []struct {
+		name       string
+		ns         *Namespace
+		str        string
+		qualified  string
+		resolvConf string
+	}{
+		{"daemon", nil, "", "eth0", "/etc/resolv.conf"},
+		{"named", &Namespace{Name: "blue", Path: "/run/netns/blue"}, "blue", "netns/blue/eth0", "/etc/netns/blue/resolv.conf"},
+		{"path", &Namespace{Path: "/proc/1234/ns/net"}, "/proc/1234/ns/net", "netns/proc_1234_ns_net/eth0", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.ns.String(); got != tt.str {
+				t.Errorf("String() = %q, want %q", got, tt.str)
+			}
+			if got := tt.ns.Qualify("eth0"); got != tt.qualified {
+				t.Errorf("Qualify() = %q, want %q", got, tt.qualified)
+			}
+			if got := tt.ns.ResolvConf(); got != tt.resolvConf {
+				t.Errorf("ResolvConf() = %q, want %q", got, tt.resolvConf)
+			}
+		})
+	}
+}
+
+func TestContext(t *testing.T) {
+	if ns := FromContext(context.Background()); ns != nil {
+		t.Errorf("FromContext() = %v, want the namespace of the daemon", ns)
+	}
+
+	ns := &Namespace{Name: "blue", Path: "/run/netns/blue"}
+	if got := FromContext(NewContext(context.Background(), ns)); got != ns {
+		t.Errorf("FromContext() = %v, want %v", got, ns)
+	}
+
+	// Work in the namespace of the daemon runs directly
+	ran := false
+	if err := Do(context.Background(), func() { ran = true }); err != nil || !ran {
+		t.Errorf("Do() = %v, ran %v", err, ran)
+	}
+}
@@ -0,0 +1,290 @@
+package netmon
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang-dhcpcd/internal/pkg/logging"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netns"
+	"golang.org/x/sys/unix"
+)
+
+// resubscribeDelay is how long the monitor waits before resubscribing after a netlink error.
+const resubscribeDelay = time.Second
+
+// subscriberBuffer is the number of events queued per subscriber before further events are dropped.
+const subscriberBuffer = 64
+
+// Event is a change of a link, address or route reported by the kernel. Exactly one of Link, Addr
+// and Route is set, unless Resync is set: events may have been lost and the full state must be checked.
+type Event struct {
+	Link   *netlink.LinkUpdate
+	Addr   *netlink.AddrUpdate
+	Route  *netlink.RouteUpdate
+	Resync bool
+}
+
+// Index returns the index of the interface the event relates to, or 0 for resync events.
+func (e Event) Index() int {
+	switch {
+	case e.Link != nil:
+		return int(e.Link.Index)
+	case e.Addr != nil:
+		return e.Addr.LinkIndex
+	case e.Route != nil:
+		return e.Route.LinkIndex
+	}
+	return 0
+}
+
+// Removal reports whether the event removes a link, address or route.
+func (e Event) Removal() bool {
+	switch {
+	case e.Link != nil:
+		return e.Link.Header.Type == unix.RTM_DELLINK
+	case e.Addr != nil:
+		return !e.Addr.NewAddr
+	case e.Route != nil:
+		return e.Route.Type == unix.RTM_DELROUTE
+	}
+	return false
+}
+
+// String describes the event for logging.
+func (e Event) String() string {
+	switch {
+	case e.Link != nil:
+		state := "down"
+		if e.Link.Flags&unix.IFF_UP != 0 {
+			state = "up"
+		}
+		if e.Removal() {
+			return fmt.Sprintf("link %s removed", e.Link.Attrs().Name)
+		}
+		return fmt.Sprintf("link %s changed (%s)", e.Link.Attrs().Name, state)
+	case e.Addr != nil:
+		if e.Removal() {
+			return fmt.Sprintf("address %s removed", e.Addr.LinkAddress.String())
+		}
+		return fmt.Sprintf("address %s added", e.Addr.LinkAddress.String())
+	case e.Route != nil:
+		dst := "default"
+		if e.Route.Dst != nil {
+			dst = e.Route.Dst.String()
+		}
+		if e.Removal() {
+			return fmt.Sprintf("route %s removed", dst)
+		}
+		return fmt.Sprintf("route %s added", dst)
+	}
+	return "resync"
+}
+
+// subscriber receives the events of one interface.
+type subscriber struct {
+	index  int
+	events chan Event
+}
+
+// Monitor subscribes to link, address and route changes once and fans them out to the
+// subscribers of each interface.
+type Monitor struct {
+	mu          sync.Mutex
+	ns          *netns.NsHandle // nil for the namespace of the daemon
+	started     bool
+	closed      bool
+	stop        func()
+	subscribers map[*subscriber]bool
+}
+
+// defaultMonitor watches the namespace of the daemon.
+var defaultMonitor = &Monitor{subscribers: make(map[*subscriber]bool)}
+
+// NewMonitor creates a monitor of another network namespace, which unsubscribes from the kernel
+// once the context is cancelled. The handle must stay open until then.
+func NewMonitor(ctx context.Context, ns netns.NsHandle) *Monitor {
+	m := &Monitor{ns: &ns, subscribers: make(map[*subscriber]bool)}
+	go func() {
+		<-ctx.Done()
+		m.mu.Lock()
+		defer m.mu.Unlock()
+		m.closed = true
+		if m.stop != nil {
+			m.stop()
+		}
+	}()
+	return m
+}
+
+type contextKey struct{}
+
+// NewContext returns a context whose subscriptions go to the monitor.
+func NewContext(ctx context.Context, m *Monitor) context.Context {
+	return context.WithValue(ctx, contextKey{}, m)
+}
+
+// Subscribe delivers the events of the interface with the given index through the monitor of the
+// context, or the default monitor, until the context is cancelled.
+func Subscribe(ctx context.Context, index int) (<-chan Event, error) {
+	if m, ok := ctx.Value(contextKey{}).(*Monitor); ok {
+		return m.Subscribe(ctx, index)
+	}
+	return defaultMonitor.Subscribe(ctx, index)
+}
+
+// Subscribe delivers the events of the interface with the given index until the context is cancelled.
+// An index of 0 receives the events of every interface. Events are dropped while the subscriber falls behind, so every event should be treated as a trigger
+// to check the full state of the interface rather than as a complete change log.
+func (m *Monitor) Subscribe(ctx context.Context, index int) (<-chan Event, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.closed {
+		return nil, fmt.Errorf("monitor is closed")
+	}
+	if !m.started {
+		if err := m.start(); err != nil {
+			return nil, err
+		}
+		m.started = true
+	}
+
+	sub := &subscriber{index: index, events: make(chan Event, subscriberBuffer)}
+	m.subscribers[sub] = true
+
+	go func() {
+		<-ctx.Done()
+		m.mu.Lock()
+		delete(m.subscribers, sub)
+		m.mu.Unlock()
+	}()
+	return sub.events, nil
+}
+
+// start subscribes to the kernel and fans out the updates until a subscription fails,
+// then resubscribes. The caller must hold mu.
+func (m *Monitor) start() error {
+	logger := logging.WithComponent("netmon")
+
+	done := make(chan struct{})
+	stop := sync.OnceFunc(func() { close(done) })
+	links := make(chan netlink.LinkUpdate, subscriberBuffer)
+	addrs := make(chan netlink.AddrUpdate, subscriberBuffer)
+	routeUpdates := make(chan netlink.RouteUpdate, subscriberBuffer)
+	onError := func(err error) {
+		// Receiving fails once the subscription is stopped
+		select {
+		case <-done:
+		default:
+			logger.WithError(err).Warn("Netlink subscription failed")
+		}
+	}
+
+	linkOptions := netlink.LinkSubscribeOptions{Namespace: m.ns, ErrorCallback: onError}
+	if err := netlink.LinkSubscribeWithOptions(links, done, linkOptions); err != nil {
+		stop()
+		return fmt.Errorf("failed to subscribe to link updates: %w", err)
+	}
+	addrOptions := netlink.AddrSubscribeOptions{Namespace: m.ns, ErrorCallback: onError}
+	if err := netlink.AddrSubscribeWithOptions(addrs, done, addrOptions); err != nil {
+		stop()
+		return fmt.Errorf("failed to subscribe to address updates: %w", err)
+	}
+	routeOptions := netlink.RouteSubscribeOptions{Namespace: m.ns, ErrorCallback: onError}
+	if err := netlink.RouteSubscribeWithOptions(routeUpdates, done, routeOptions); err != nil {
+		stop()
+		return fmt.Errorf("failed to subscribe to route updates: %w", err)
+	}
+	m.stop = stop
+	logger.Debug("Subscribed to netlink updates")
+
+	go func() {
+		m.dispatch(links, addrs, routeUpdates)
+		stop()
+
+		// A subscription closed on error, updates may have been lost
+		for {
+			time.Sleep(resubscribeDelay)
+			m.mu.Lock()
+			if m.closed {
+				m.mu.Unlock()
+				return
+			}
+			err := m.start()
+			m.mu.Unlock()
+			if err == nil {
+				m.publish(Event{Resync: true})
+				return
+			}
+			logger.WithError(err).Warn("Failed to resubscribe to netlink updates")
+		}
+	}()
+	return nil
+}
+
+// dispatch forwards updates to the subscribers until one of the channels is closed.
+func (m *Monitor) dispatch(links <-chan netlink.LinkUpdate, addrs <-chan netlink.AddrUpdate, routeUpdates <-chan netlink.RouteUpdate) {
+	for {
+		select {
+		case update, ok := <-links:
+			if !ok {
+				return
+			}
+			m.publish(Event{Link: &update})
+		case update, ok := <-addrs:
+			if !ok {
+				return
+			}
+			m.publish(Event{Addr: &update})
+		case update, ok := <-routeUpdates:
+			if !ok {
+				return
+			}
+			m.publish(Event{Route: &update})
+		}
+	}
+}
+
+// publish hands the event to the subscribers of its interface, or to everyone for resync events.
+func (m *Monitor) publish(event Event) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	index := event.Index()
+	for sub := range m.subscribers {
+		if !event.Resync && sub.index != 0 && sub.index != index {
+			continue
+		}
+		select {
+		case sub.events <- event:
+		default:
+			// The subscriber still has events queued and will check the full state anyway
+		}
+	}
+}
+
+// Settle collects the events arriving within the delay after the last one, but waits at most ten
+// times the delay, so that a burst of changes is handled once. It reports false if the context was cancelled.
+func Settle(ctx context.Context, events <-chan Event, delay time.Duration) bool {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	limit := time.NewTimer(10 * delay)
+	defer limit.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return false
+		case <-limit.C:
+			return true
+		case <-events:
+			timer.Reset(delay)
+		case <-timer.C:
+			return true
+		}
+	}
+}
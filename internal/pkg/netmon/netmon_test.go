@@ -0,0 +1,154 @@
+package netmon
+
+import (
+	"context"
+	"net"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/vishvananda/netlink"
+	"github.com/vishvananda/netlink/nl"
+	"golang.org/x/sys/unix"
+)
+
+func TestSettle(t *testing.T) {
+	const delay = 20 * time.Millisecond
+
+	t.Run("quiet", func(t *testing.T) {
+		start := time.Now()
+		if !Settle(context.Background(), make(chan Event), delay) {
+			t.Fatal("Settle() = false, want true")
+		}
+		if elapsed := time.Since(start); elapsed < delay || elapsed >= 10*delay {
+			t.Errorf("Settled after %v, want about %v", elapsed, delay)
+		}
+	})
+
+	t.Run("burst", func(t *testing.T) {
+		events := make(chan Event)
+		go func() {
+			for range 4 {
+				time.Sleep(delay / 2)
+				events <- Event{Resync: true}
+			}
+		}()
+
+		start := time.Now()
+		if !Settle(context.Background(), events, delay) {
+			t.Fatal("Settle() = false, want true")
+		}
+		if elapsed := time.Since(start); elapsed < 3*delay {
+			t.Errorf("Settled after %v, before the burst ended", elapsed)
+		}
+	})
+
+	t.Run("endless events", func(t *testing.T) {
+		events := make(chan Event)
+		done := make(chan struct{})
+		defer close(done)
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				case events <- Event{Resync: true}:
+					time.Sleep(delay / 4)
+				}
+			}
+		}()
+
+		start := time.Now()
+		if !Settle(context.Background(), events, delay) {
+			t.Fatal("Settle() = false, want true")
+		}
+		if elapsed := time.Since(start); elapsed < 10*delay || elapsed >= 20*delay {
+			t.Errorf("Settled after %v, want about %v", elapsed, 10*delay)
+		}
+	})
+
+	t.Run("cancelled", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		if Settle(ctx, make(chan Event), time.Hour) {
+			t.Error("Settle() = true, want false")
+		}
+	})
+}
+
+// linkEvent builds an event for the link with the given index.
+func linkEvent(index int32, msgType uint16) Event {
+	return Event{Link: &netlink.LinkUpdate{
+		IfInfomsg: nl.IfInfomsg{IfInfomsg: unix.IfInfomsg{Index: index}},
+		Header:    unix.NlMsghdr{Type: msgType},
+		Link:      &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Index: int(index)}},
+	}}
+}
+
+func TestEvent(t *testing.T) {
+	address := net.IPNet{IP: net.IPv4(192, 0, 2, 10).To4(), Mask: net.CIDRMask(24, 32)}
+
+	tests := []struct {
+		name    string
+		event   Event
+		index   int
+		removal bool
+		str     string
+	}{
+		{"new link", linkEvent(2, unix.RTM_NEWLINK), 2, false, "link eth0 changed (down)"},
+		{"deleted link", linkEvent(2, unix.RTM_DELLINK), 2, true, "link eth0 removed"},
+		{"new address", Event{Addr: &netlink.AddrUpdate{LinkAddress: address, LinkIndex: 3, NewAddr: true}}, 3, false, "address 192.0.2.10/24 added"},
+		{"deleted address", Event{Addr: &netlink.AddrUpdate{LinkAddress: address, LinkIndex: 3}}, 3, true, "address 192.0.2.10/24 removed"},
+		{"new route", Event{Route: &netlink.RouteUpdate{Type: unix.RTM_NEWROUTE, Route: netlink.Route{LinkIndex: 4}}}, 4, false, "route default added"},
+		{"deleted route", Event{Route: &netlink.RouteUpdate{Type: unix.RTM_DELROUTE, Route: netlink.Route{LinkIndex: 4}}}, 4, true, "route default removed"},
+		{"resync", Event{Resync: true}, 0, false, "resync"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.event.Index(); got != tt.index {
+				t.Errorf("Index() = %d, want %d", got, tt.index)
+			}
+			if got := tt.event.Removal(); got != tt.removal {
+				t.Errorf("Removal() = %v, want %v", got, tt.removal)
+			}
+			if got := tt.event.String(); got != tt.str {
+				t.Errorf("String() = %q, want %q", got, tt.str)
+			}
+		})
+	}
+}
+
+func TestPublish(t *testing.T) {
+	all := &subscriber{index: 0, events: make(chan Event, subscriberBuffer)}
+	eth0 := &subscriber{index: 2, events: make(chan Event, subscriberBuffer)}
+	eth1 := &subscriber{index: 3, events: make(chan Event, 1)}
+	m := &Monitor{subscribers: map[*subscriber]bool{all: true, eth0: true, eth1: true}}
+
+	m.publish(linkEvent(2, unix.RTM_NEWLINK))
+	m.publish(Event{Resync: true})
+	m.publish(Event{Addr: &netlink.AddrUpdate{LinkIndex: 3, NewAddr: true}})
+
+	tests := []struct {
+		name string
+		sub  *subscriber
+		want []int
+	}{
+		{"every interface", all, []int{2, 0, 3}},
+		{"own interface", eth0, []int{2, 0}},
+		// The queue is full after the resync event, so the address event is dropped
+		{"full queue", eth1, []int{0}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got []int
+			for len(tt.sub.events) > 0 {
+				got = append(got, (<-tt.sub.events).Index())
+			}
+			if !slices.Equal(got, tt.want) {
+				t.Errorf("Received events of interfaces %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,310 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"golang-dhcpcd/internal/pkg/routes"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// DefaultPriority is the priority of the rules installed for an interface unless configured otherwise.
+const DefaultPriority = 1000
+
+// Config represents the policy routing settings of an interface. The subnet and gateway of the
+// interface are installed into a dedicated table, and rules steer matching traffic into it.
+type Config struct {
+	Table    int    `yaml:"table"`
+	Priority int    `yaml:"priority,omitempty"`
+	Rules    []Rule `yaml:"rules,omitempty"`
+}
+
+// Rule selects additional traffic to be routed through the table of the interface.
+// Traffic sourced from the addresses of the interface is always selected.
+type Rule struct {
+	From   string `yaml:"from,omitempty"`   // Source prefix in CIDR notation
+	FWMark string `yaml:"fwmark,omitempty"` // Firewall mark, optionally with a mask: 0x1/0xff
+	IIF    string `yaml:"iif,omitempty"`    // Incoming interface
+}
+
+// Validate checks the policy routing settings.
+func (c Config) Validate() error {
+	if c.Table <= 0 {
+		return fmt.Errorf("policy routing table must be positive")
+	}
+	if c.Table >= unix.RT_TABLE_COMPAT && c.Table <= unix.RT_TABLE_LOCAL {
+		return fmt.Errorf("policy routing table %d is reserved", c.Table)
+	}
+	if c.Priority < 0 {
+		return fmt.Errorf("policy routing priority must not be negative")
+	}
+	// A rule with a source only applies to the family of the source, each rule is checked as both
+	for _, rule := range c.Rules {
+		for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+			if _, err := rule.toNetlink(c, family, 0); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// priority returns the rule priority in use.
+func (c Config) priority() int {
+	if c.Priority == 0 {
+		return DefaultPriority
+	}
+	return c.Priority
+}
+
+// parseMark parses a firewall mark with an optional mask.
+func parseMark(value string) (uint32, *uint32, error) {
+	markValue, maskValue, hasMask := strings.Cut(value, "/")
+	mark, err := strconv.ParseUint(markValue, 0, 32)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid fwmark %q: %w", value, err)
+	}
+	if !hasMask {
+		return uint32(mark), nil, nil
+	}
+	mask, err := strconv.ParseUint(maskValue, 0, 32)
+	if err != nil {
+		return 0, nil, fmt.Errorf("invalid fwmark mask %q: %w", value, err)
+	}
+	m := uint32(mask)
+	return uint32(mark), &m, nil
+}
+
+// toNetlink converts a configured rule into a netlink rule of the given family.
+// It returns nil if the rule does not apply to the family.
+func (r Rule) toNetlink(c Config, family int, protocol netlink.RouteProtocol) (*netlink.Rule, error) {
+	if r.From == "" && r.FWMark == "" && r.IIF == "" {
+		return nil, fmt.Errorf("policy routing rule must set from, fwmark or iif")
+	}
+
+	rule := netlink.NewRule()
+	rule.Family = family
+	rule.Table = c.Table
+	rule.Priority = c.priority()
+	rule.Protocol = uint8(protocol)
+	rule.IifName = r.IIF
+
+	if r.From != "" {
+		_, src, err := net.ParseCIDR(r.From)
+		if err != nil {
+			return nil, fmt.Errorf("invalid policy routing source %q: must be in CIDR notation", r.From)
+		}
+		if (src.IP.To4() != nil) != (family == netlink.FAMILY_V4) {
+			return nil, nil
+		}
+		rule.Src = src
+	}
+
+	if r.FWMark != "" {
+		mark, mask, err := parseMark(r.FWMark)
+		if err != nil {
+			return nil, err
+		}
+		rule.Mark = mark
+		rule.Mask = mask
+	}
+
+	return rule, nil
+}
+
+// familyOf returns the netlink address family of an IP address.
+func familyOf(ip net.IP) int {
+	if ip.To4() != nil {
+		return netlink.FAMILY_V4
+	}
+	return netlink.FAMILY_V6
+}
+
+// TableRoutes returns the routes the interface installs into its table: the connected subnet of every
+// address and a default route through each gateway of a matching address family.
+func (c Config) TableRoutes(link netlink.Link, addrs []*net.IPNet, gateways []net.IP, metric int, protocol netlink.RouteProtocol) []*netlink.Route {
+	var tableRoutes []*netlink.Route
+	families := make(map[int]bool)
+	for _, addr := range addrs {
+		family := familyOf(addr.IP)
+		families[family] = true
+
+		subnet := &net.IPNet{IP: addr.IP.Mask(addr.Mask), Mask: addr.Mask}
+		route := &netlink.Route{
+			LinkIndex: link.Attrs().Index,
+			Dst:       subnet,
+			Src:       addr.IP,
+			Scope:     netlink.SCOPE_LINK,
+			Family:    family,
+			Table:     c.Table,
+			Protocol:  protocol,
+			Type:      unix.RTN_UNICAST,
+		}
+		if family == netlink.FAMILY_V6 {
+			route.Priority = 1024
+		}
+		tableRoutes = append(tableRoutes, route)
+	}
+
+	for _, gateway := range gateways {
+		if !families[familyOf(gateway)] {
+			continue
+		}
+		route := routes.DefaultRoute(link, gateway, metric, protocol)
+		route.Table = c.Table
+		tableRoutes = append(tableRoutes, route)
+	}
+	return tableRoutes
+}
+
+// TableRules returns the rules steering traffic into the table of the interface: one rule per address
+// for traffic sourced from it, plus the configured rules for every address family in use.
+func (c Config) TableRules(addrs []*net.IPNet, protocol netlink.RouteProtocol) ([]*netlink.Rule, error) {
+	var rules []*netlink.Rule
+	families := make(map[int]bool)
+	for _, addr := range addrs {
+		family := familyOf(addr.IP)
+		families[family] = true
+
+		ip, bits := addr.IP.To16(), 128
+		if family == netlink.FAMILY_V4 {
+			ip, bits = addr.IP.To4(), 32
+		}
+		rule := netlink.NewRule()
+		rule.Family = family
+		rule.Table = c.Table
+		rule.Priority = c.priority()
+		rule.Protocol = uint8(protocol)
+		rule.Src = &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)}
+		rules = append(rules, rule)
+	}
+
+	for _, family := range []int{netlink.FAMILY_V4, netlink.FAMILY_V6} {
+		if !families[family] {
+			continue
+		}
+		for _, r := range c.Rules {
+			rule, err := r.toNetlink(c, family, protocol)
+			if err != nil {
+				return nil, err
+			}
+			if rule != nil {
+				rules = append(rules, rule)
+			}
+		}
+	}
+	return rules, nil
+}
+
+// rulesMatch reports whether an existing rule is equivalent to the desired one.
+func rulesMatch(existing netlink.Rule, desired *netlink.Rule) bool {
+	sameSrc := (existing.Src == nil && desired.Src == nil) ||
+		(existing.Src != nil && desired.Src != nil && existing.Src.String() == desired.Src.String())
+	sameMask := (existing.Mask == nil && desired.Mask == nil) ||
+		(existing.Mask != nil && desired.Mask != nil && *existing.Mask == *desired.Mask)
+	return existing.Family == desired.Family &&
+		existing.Table == desired.Table &&
+		existing.Priority == desired.Priority &&
+		existing.Mark == desired.Mark &&
+		existing.IifName == desired.IifName &&
+		sameSrc && sameMask
+}
+
+// ownedRules lists the rules carrying the given protocol that point at the table.
+func ownedRules(table int, protocol netlink.RouteProtocol) ([]netlink.Rule, error) {
+	existing, err := netlink.RuleListFiltered(netlink.FAMILY_ALL, &netlink.Rule{Table: table}, netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list rules: %w", err)
+	}
+
+	var owned []netlink.Rule
+	for _, rule := range existing {
+		if rule.Protocol == uint8(protocol) {
+			owned = append(owned, rule)
+		}
+	}
+	return owned, nil
+}
+
+// Apply installs the rules that are missing and removes rules for the table that the daemon
+// installed earlier but are no longer desired. It returns how many rules were added.
+func Apply(c Config, addrs []*net.IPNet, protocol netlink.RouteProtocol, logger *logrus.Entry) (int, error) {
+	desired, err := c.TableRules(addrs, protocol)
+	if err != nil {
+		return 0, err
+	}
+
+	owned, err := ownedRules(c.Table, protocol)
+	if err != nil {
+		return 0, err
+	}
+
+	// Remove rules installed for addresses the interface no longer has
+	for _, rule := range owned {
+		wanted := false
+		for _, d := range desired {
+			if rulesMatch(rule, d) {
+				wanted = true
+				break
+			}
+		}
+		if wanted {
+			continue
+		}
+		if err := netlink.RuleDel(&rule); err != nil && !errors.Is(err, unix.ENOENT) {
+			logger.WithError(err).WithField("rule", rule.String()).Warn("Failed to remove stale policy rule")
+			continue
+		}
+		logger.WithField("rule", rule.String()).Info("Removed stale policy rule")
+	}
+
+	added := 0
+	for _, d := range desired {
+		exists := false
+		for _, rule := range owned {
+			if rulesMatch(rule, d) {
+				exists = true
+				break
+			}
+		}
+		if exists {
+			continue
+		}
+		if err := netlink.RuleAdd(d); err != nil {
+			if errors.Is(err, unix.EEXIST) {
+				// An equivalent rule of another owner is in place
+				continue
+			}
+			return added, fmt.Errorf("failed to add policy rule %s: %w", d.String(), err)
+		}
+		added++
+		logger.WithFields(logrus.Fields{
+			"rule":  d.String(),
+			"table": c.Table,
+		}).Info("Successfully added policy rule")
+	}
+	return added, nil
+}
+
+// Teardown removes every rule for the table that the daemon installed.
+func Teardown(c Config, protocol netlink.RouteProtocol, logger *logrus.Entry) {
+	owned, err := ownedRules(c.Table, protocol)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to list policy rules")
+		return
+	}
+
+	for _, rule := range owned {
+		if err := netlink.RuleDel(&rule); err != nil && !errors.Is(err, unix.ENOENT) {
+			logger.WithError(err).WithField("rule", rule.String()).Warn("Failed to remove policy rule")
+			continue
+		}
+		logger.WithField("rule", rule.String()).Info("Removed policy rule")
+	}
+}
@@ -0,0 +1,197 @@
+package policy
+
+import (
+	"net"
+	"testing"
+
+	"golang-dhcpcd/internal/pkg/routes"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// mustParse parses an address in CIDR notation, keeping the host bits.
+func mustParse(t *testing.T, cidr string) *net.IPNet {
+	t.Helper()
+
+	ip, ipNet, err := net.ParseCIDR(cidr)
+	if err != nil {
+		t.Fatalf("Invalid address %s: %v", cidr, err)
+	}
+	ipNet.IP = ip
+	return ipNet
+}
+
+func TestParseMark(t *testing.T) {
+	tests := []struct {
+		value   string
+		mark    uint32
+		mask    *uint32
+		wantErr bool
+	}{
+		{"1", 1, nil, false},
+		{"0x10", 16, nil, false},
+		{"0x10/0xff", 16, ptr(uint32(0xff)), false},
+		{"mark", 0, nil, true},
+		{"0x10/mask", 0, nil, true},
+		{"0x100000000", 0, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			mark, mask, err := parseMark(tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseMark() error = %v, want error %v", err, tt.wantErr)
+			}
+			if mark != tt.mark {
+				t.Errorf("Mark = %#x, want %#x", mark, tt.mark)
+			}
+			if (mask == nil) != (tt.mask == nil) || (mask != nil && *mask != *tt.mask) {
+				t.Errorf("Mask = %v, want %v", mask, tt.mask)
+			}
+		})
+	}
+}
+
+// ptr returns a pointer to the value.
+func ptr[T any](value T) *T {
+	return &value
+}
+
+func TestConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		wantErr bool
+	}{
+		{"table only", Config{Table: 100}, false},
+		{"rules", Config{Table: 100, Priority: 500, Rules: []Rule{{From: "10.10.0.0/16"}, {FWMark: "0x1/0xff"}, {IIF: "wg0"}}}, false},
+		{"ipv6 rule", Config{Table: 100, Rules: []Rule{{From: "2001:db8::/32"}}}, false},
+		{"no table", Config{}, true},
+		{"negative table", Config{Table: -1}, true},
+		{"main table", Config{Table: unix.RT_TABLE_MAIN}, true},
+		{"local table", Config{Table: unix.RT_TABLE_LOCAL}, true},
+		{"negative priority", Config{Table: 100, Priority: -1}, true},
+		{"empty rule", Config{Table: 100, Rules: []Rule{{}}}, true},
+		{"invalid source", Config{Table: 100, Rules: []Rule{{From: "10.10.0.0"}}}, true},
+		{"invalid mark", Config{Table: 100, Rules: []Rule{{FWMark: "web"}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.config.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTableRules(t *testing.T) {
+	config := Config{Table: 100, Rules: []Rule{
+		{From: "10.10.0.0/16"},
+		{From: "2001:db8:10::/48"},
+		{FWMark: "0x1/0xff"},
+	}}
+
+	tests := []struct {
+		name  string
+		addrs []string
+		want  []string // Sources of the rules, "" for rules without one
+	}{
+		{"no addresses", nil, nil},
+		{"ipv4", []string{"192.168.1.10/24"}, []string{"192.168.1.10/32", "10.10.0.0/16", ""}},
+		{"ipv6", []string{"2001:db8::10/64"}, []string{"2001:db8::10/128", "2001:db8:10::/48", ""}},
+		{"dual stack", []string{"192.168.1.10/24", "2001:db8::10/64"},
+			[]string{"192.168.1.10/32", "2001:db8::10/128", "10.10.0.0/16", "", "2001:db8:10::/48", ""}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var addrs []*net.IPNet
+			for _, addr := range tt.addrs {
+				addrs = append(addrs, mustParse(t, addr))
+			}
+
+			rules, err := config.TableRules(addrs, routes.ProtocolStatic)
+			if err != nil {
+				t.Fatalf("TableRules() failed: %v", err)
+			}
+			if len(rules) != len(tt.want) {
+				t.Fatalf("TableRules() returned %d rules, want %d", len(rules), len(tt.want))
+			}
+			for i, rule := range rules {
+				src := ""
+				if rule.Src != nil {
+					src = rule.Src.String()
+				}
+				if src != tt.want[i] {
+					t.Errorf("Rule %d has source %q, want %q", i, src, tt.want[i])
+				}
+				if rule.Table != 100 || rule.Priority != DefaultPriority || rule.Protocol != uint8(routes.ProtocolStatic) {
+					t.Errorf("Rule %d = %v, want table 100, priority %d and the static protocol", i, rule, DefaultPriority)
+				}
+			}
+		})
+	}
+}
+
+func TestTableRoutes(t *testing.T) {
+	link := &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Index: 2}}
+	config := Config{Table: 100}
+	addrs := []*net.IPNet{mustParse(t, "192.168.1.10/24")}
+	gateways := []net.IP{net.ParseIP("192.168.1.1"), net.ParseIP("fe80::1")}
+
+	tableRoutes := config.TableRoutes(link, addrs, gateways, 100, routes.ProtocolDHCP)
+	// The IPv6 gateway is skipped as the interface has no IPv6 address
+	if len(tableRoutes) != 2 {
+		t.Fatalf("TableRoutes() returned %d routes, want 2", len(tableRoutes))
+	}
+
+	subnet, gateway := tableRoutes[0], tableRoutes[1]
+	if subnet.Dst.String() != "192.168.1.0/24" || !subnet.Src.Equal(net.ParseIP("192.168.1.10")) || subnet.Scope != netlink.SCOPE_LINK {
+		t.Errorf("Subnet route = %v, want 192.168.1.0/24 from 192.168.1.10 with link scope", subnet)
+	}
+	if !routes.IsDefault(*gateway) || !gateway.Gw.Equal(gateways[0]) || gateway.Priority != 100 {
+		t.Errorf("Default route = %v, want through 192.168.1.1 with metric 100", gateway)
+	}
+	for _, route := range tableRoutes {
+		if route.Table != 100 || route.LinkIndex != 2 || route.Protocol != routes.ProtocolDHCP {
+			t.Errorf("Route %v is not in table 100 on eth0 with the DHCP protocol", route)
+		}
+	}
+}
+
+func TestRulesMatch(t *testing.T) {
+	config := Config{Table: 100, Rules: []Rule{{FWMark: "0x1/0xff"}}}
+	rules, err := config.TableRules([]*net.IPNet{mustParse(t, "192.168.1.10/24")}, routes.ProtocolStatic)
+	if err != nil {
+		t.Fatalf("TableRules() failed: %v", err)
+	}
+	source, mark := rules[0], rules[1]
+
+	tests := []struct {
+		name     string
+		existing netlink.Rule
+		desired  *netlink.Rule
+		want     bool
+	}{
+		{"same source", *source, source, true},
+		{"same mark", *mark, mark, true},
+		{"source and mark", *source, mark, false},
+		{"other table", func() netlink.Rule { r := *source; r.Table = 101; return r }(), source, false},
+		{"other priority", func() netlink.Rule { r := *source; r.Priority = 10; return r }(), source, false},
+		{"other mask", func() netlink.Rule { r := *mark; r.Mask = ptr(uint32(0xf)); return r }(), mark, false},
+		{"no mask", func() netlink.Rule { r := *mark; r.Mask = nil; return r }(), mark, false},
+		{"other source", func() netlink.Rule { r := *source; r.Src = mustParse(t, "192.168.1.11/32"); return r }(), source, false},
+		{"other protocol", func() netlink.Rule { r := *source; r.Protocol = 0; return r }(), source, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := rulesMatch(tt.existing, tt.desired); got != tt.want {
+				t.Errorf("rulesMatch() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
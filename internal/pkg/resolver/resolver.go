@@ -0,0 +1,156 @@
+package resolver
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Config represents the resolver settings contributed by a single interface.
+type Config struct {
+	Nameservers []net.IP
+	Search      []string
+	Domain      string
+}
+
+// IsEmpty reports whether the configuration carries no resolver settings.
+func (c Config) IsEmpty() bool {
+	return len(c.Nameservers) == 0 && len(c.Search) == 0 && c.Domain == ""
+}
+
+// File merges the resolver settings of the interfaces of one network namespace into its resolver
+// configuration file. A nil *File discards the settings, for namespaces without a file of their own.
+type File struct {
+	mu      sync.Mutex
+	path    string
+	configs map[string]Config
+}
+
+var (
+	filesMu sync.Mutex
+	files   = make(map[string]*File)
+)
+
+// For returns the file at the path, shared by every interface writing to it, or nil for an empty path.
+func For(path string) *File {
+	if path == "" {
+		return nil
+	}
+
+	filesMu.Lock()
+	defer filesMu.Unlock()
+
+	f, exists := files[path]
+	if !exists {
+		f = &File{path: path, configs: make(map[string]Config)}
+		files[path] = f
+	}
+	return f
+}
+
+// Path returns the path of the file, empty for a nil file.
+func (f *File) Path() string {
+	if f == nil {
+		return ""
+	}
+	return f.path
+}
+
+// Update records the resolver settings for an interface and rewrites the file
+// if the merged result differs from the file on disk. It reports whether the file was written.
+func (f *File) Update(iface string, config Config) (bool, error) {
+	if f == nil {
+		return false, nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.configs[iface] = config
+	return f.write()
+}
+
+// Remove drops the resolver settings of an interface and rewrites the file
+// without them. It reports whether the file was written.
+func (f *File) Remove(iface string) (bool, error) {
+	if f == nil {
+		return false, nil
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if _, exists := f.configs[iface]; !exists {
+		return false, nil
+	}
+	delete(f.configs, iface)
+	return f.write()
+}
+
+// write renders the merged configuration and writes it if it changed.
+// The caller must hold mu.
+func (f *File) write() (bool, error) {
+	newContent := f.render()
+
+	// Check if the current file already has the same content
+	if currentContent, err := os.ReadFile(f.path); err == nil {
+		if string(currentContent) == newContent {
+			return false, nil
+		}
+	}
+
+	// The directory of a named namespace may not exist yet
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return false, fmt.Errorf("failed to create %s: %w", filepath.Dir(f.path), err)
+	}
+	if err := os.WriteFile(f.path, []byte(newContent), 0644); err != nil {
+		return false, fmt.Errorf("failed to write %s: %w", f.path, err)
+	}
+	return true, nil
+}
+
+// render merges the settings of all interfaces in name order, dropping duplicates.
+func (f *File) render() string {
+	names := make([]string, 0, len(f.configs))
+	for name := range f.configs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var domain string
+	var search, nameservers []string
+	seen := make(map[string]bool)
+	for _, name := range names {
+		config := f.configs[name]
+		if domain == "" {
+			domain = config.Domain
+		}
+		for _, s := range config.Search {
+			if !seen["search "+s] {
+				seen["search "+s] = true
+				search = append(search, s)
+			}
+		}
+		for _, ns := range config.Nameservers {
+			if !seen["nameserver "+ns.String()] {
+				seen["nameserver "+ns.String()] = true
+				nameservers = append(nameservers, ns.String())
+			}
+		}
+	}
+
+	var b strings.Builder
+	b.WriteString("# Generated by golang-dhcpcd\n")
+	if domain != "" {
+		fmt.Fprintf(&b, "domain %s\n", domain)
+	}
+	if len(search) > 0 {
+		fmt.Fprintf(&b, "search %s\n", strings.Join(search, " "))
+	}
+	for _, ns := range nameservers {
+		fmt.Fprintf(&b, "nameserver %s\n", ns)
+	}
+	return b.String()
+}
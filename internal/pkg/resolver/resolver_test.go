@@ -0,0 +1,90 @@
+package resolver
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFor(t *testing.T) {
+	if f := For(""); f != nil {
+		t.Errorf("For(\"\") = %v, want nil", f)
+	}
+
+	path := filepath.Join(t.TempDir(), "resolv.conf")
+	if For(path) != For(path) {
+		t.Error("For() returned two files for the same path")
+	}
+	if got := For(path).Path(); got != path {
+		t.Errorf("Path() = %s, want %s", got, path)
+	}
+}
+
+func TestFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "netns", "blue", "resolv.conf")
+	f := For(path)
+
+	read := func() string {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		return string(data)
+	}
+
+	written, err := f.Update("eth1", Config{
+		Nameservers: []net.IP{net.ParseIP("192.0.2.53"), net.ParseIP("192.0.2.54")},
+		Search:      []string{"lan"},
+	})
+	if err != nil || !written {
+		t.Fatalf("Update() = %v, %v, want the file written", written, err)
+	}
+	written, err = f.Update("eth0", Config{
+		Nameservers: []net.IP{net.ParseIP("192.0.2.54")},
+		Search:      []string{"example.com", "lan"},
+		Domain:      "example.com",
+	})
+	if err != nil || !written {
+		t.Fatalf("Update() = %v, %v, want the file written", written, err)
+	}
+
+	want := "# Generated by golang-dhcpcd\ndomain example.com\nsearch example.com lan\nnameserver 192.0.2.54\nnameserver 192.0.2.53\n"
+	if got := read(); got != want {
+		t.Errorf("File content = %q, want %q", got, want)
+	}
+
+	// Unchanged settings leave the file alone
+	if written, err := f.Update("eth0", Config{
+		Nameservers: []net.IP{net.ParseIP("192.0.2.54")},
+		Search:      []string{"example.com", "lan"},
+		Domain:      "example.com",
+	}); err != nil || written {
+		t.Errorf("Update() = %v, %v, want the file left alone", written, err)
+	}
+
+	if written, err := f.Remove("eth0"); err != nil || !written {
+		t.Fatalf("Remove() = %v, %v, want the file written", written, err)
+	}
+	want = "# Generated by golang-dhcpcd\nsearch lan\nnameserver 192.0.2.53\nnameserver 192.0.2.54\n"
+	if got := read(); got != want {
+		t.Errorf("File content = %q, want %q", got, want)
+	}
+	if written, err := f.Remove("eth0"); err != nil || written {
+		t.Errorf("Remove() of an unknown interface = %v, %v, want nothing written", written, err)
+	}
+}
+
+func TestNilFile(t *testing.T) {
+	var f *File
+	if written, err := f.Update("eth0", Config{Domain: "example.com"}); err != nil || written {
+		t.Errorf("Update() = %v, %v, want nothing written", written, err)
+	}
+	if written, err := f.Remove("eth0"); err != nil || written {
+		t.Errorf("Remove() = %v, %v, want nothing written", written, err)
+	}
+	if got := f.Path(); got != "" {
+		t.Errorf("Path() = %q, want empty", got)
+	}
+}
@@ -0,0 +1,473 @@
+package routes
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"golang-dhcpcd/internal/pkg/inventory"
+
+	"github.com/sirupsen/logrus"
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// Route represents a route to install on an interface.
+type Route struct {
+	Destination string `yaml:"destination"`
+	Gateway     string `yaml:"gateway,omitempty"`
+	Metric      int    `yaml:"metric,omitempty"`
+	Table       int    `yaml:"table,omitempty"`
+	Scope       string `yaml:"scope,omitempty"` // global, site, link, host
+	Type        string `yaml:"type,omitempty"`  // unicast, blackhole, unreachable, prohibit
+	MTU         int    `yaml:"mtu,omitempty"`
+	Source      string `yaml:"source,omitempty"`
+}
+
+// Routing protocols marking the routes installed by the daemon. Cleanup only ever touches routes
+// carrying one of these, unless an interface is managed exclusively.
+const (
+	// ProtocolDHCP marks routes derived from a DHCP lease (RTPROT_DHCP).
+	ProtocolDHCP netlink.RouteProtocol = unix.RTPROT_DHCP
+	// ProtocolStatic marks routes from the static configuration. It has no
+	// kernel name; add "212 golang-dhcpcd" to /etc/iproute2/rt_protos to name it.
+	ProtocolStatic netlink.RouteProtocol = 212
+)
+
+// routeTypes maps route type names to their netlink values.
+var routeTypes = map[string]int{
+	"":            unix.RTN_UNICAST,
+	"unicast":     unix.RTN_UNICAST,
+	"blackhole":   unix.RTN_BLACKHOLE,
+	"unreachable": unix.RTN_UNREACHABLE,
+	"prohibit":    unix.RTN_PROHIBIT,
+}
+
+// routeScopes maps scope names to their netlink values.
+var routeScopes = map[string]netlink.Scope{
+	"global": netlink.SCOPE_UNIVERSE,
+	"site":   netlink.SCOPE_SITE,
+	"link":   netlink.SCOPE_LINK,
+	"host":   netlink.SCOPE_HOST,
+}
+
+// ParseDestination parses a route destination in CIDR notation. "default" is accepted as
+// 0.0.0.0/0, or ::/0 when ipv6 is set.
+func ParseDestination(destination string, ipv6 bool) (*net.IPNet, error) {
+	if destination == "default" {
+		destination = "0.0.0.0/0"
+		if ipv6 {
+			destination = "::/0"
+		}
+	}
+	_, dst, err := net.ParseCIDR(destination)
+	if err != nil {
+		return nil, fmt.Errorf("invalid route destination %q: must be in CIDR notation", destination)
+	}
+	return dst, nil
+}
+
+// family returns the netlink address family of an IP address.
+func family(ip net.IP) int {
+	if ip.To4() != nil {
+		return netlink.FAMILY_V4
+	}
+	return netlink.FAMILY_V6
+}
+
+// ToNetlink converts the route into a netlink route bound to the given link.
+// The address family follows the destination; a "default" destination follows the gateway.
+func (r Route) ToNetlink(link netlink.Link) (*netlink.Route, error) {
+	var gw net.IP
+	if r.Gateway != "" {
+		if gw = net.ParseIP(r.Gateway); gw == nil {
+			return nil, fmt.Errorf("invalid gateway %q for route %s", r.Gateway, r.Destination)
+		}
+	}
+
+	dst, err := ParseDestination(r.Destination, gw != nil && gw.To4() == nil)
+	if err != nil {
+		return nil, err
+	}
+	routeFamily := family(dst.IP)
+
+	routeType, ok := routeTypes[strings.ToLower(r.Type)]
+	if !ok {
+		return nil, fmt.Errorf("invalid type %q for route %s", r.Type, r.Destination)
+	}
+
+	route := &netlink.Route{
+		Dst:      dst,
+		Family:   routeFamily,
+		Priority: r.Metric,
+		Table:    r.Table,
+		Type:     routeType,
+		MTU:      r.MTU,
+	}
+	if route.Table == 0 {
+		route.Table = unix.RT_TABLE_MAIN
+	}
+	if route.Priority == 0 && routeFamily == netlink.FAMILY_V6 {
+		// The kernel assigns IPv6 routes without a metric the default of 1024
+		route.Priority = 1024
+	}
+
+	// Only unicast routes point out of an interface
+	if routeType == unix.RTN_UNICAST {
+		route.LinkIndex = link.Attrs().Index
+
+		if gw != nil {
+			// IPv6 gateways may be link-local, they are resolved on this link
+			if family(gw) != routeFamily {
+				return nil, fmt.Errorf("gateway %s does not match the address family of route %s", r.Gateway, r.Destination)
+			}
+			route.Gw = gw
+		} else {
+			// Directly connected routes default to link scope, like "ip route add ... dev"
+			route.Scope = netlink.SCOPE_LINK
+		}
+	} else if gw != nil {
+		return nil, fmt.Errorf("route %s of type %s cannot have a gateway", r.Destination, r.Type)
+	}
+
+	if r.Scope != "" {
+		scope, ok := routeScopes[strings.ToLower(r.Scope)]
+		if !ok {
+			return nil, fmt.Errorf("invalid scope %q for route %s", r.Scope, r.Destination)
+		}
+		route.Scope = scope
+	}
+
+	if r.Source != "" {
+		src := net.ParseIP(r.Source)
+		if src == nil || family(src) != routeFamily {
+			return nil, fmt.Errorf("invalid source %q for route %s", r.Source, r.Destination)
+		}
+		route.Src = src
+	}
+
+	return route, nil
+}
+
+// DefaultRoute builds a default route through the gateway on the given link with the given metric.
+// The address family of the route follows the gateway.
+func DefaultRoute(link netlink.Link, gateway net.IP, metric int, protocol netlink.RouteProtocol) *netlink.Route {
+	route := &netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Gw:        gateway,
+		Priority:  metric,
+		Table:     unix.RT_TABLE_MAIN,
+		Protocol:  protocol,
+		Type:      unix.RTN_UNICAST,
+	}
+	if gw4 := gateway.To4(); gw4 != nil {
+		route.Family = netlink.FAMILY_V4
+		route.Gw = gw4
+		route.Dst = &net.IPNet{IP: net.IPv4zero.To4(), Mask: net.CIDRMask(0, 32)}
+	} else {
+		route.Family = netlink.FAMILY_V6
+		route.Dst = &net.IPNet{IP: net.IPv6zero, Mask: net.CIDRMask(0, 128)}
+		if route.Priority == 0 {
+			route.Priority = 1024
+		}
+	}
+	return route
+}
+
+// SetTable moves the routes destined for the main table into the given table, e.g. the table of
+// the VRF the interface belongs to. A table of 0 leaves the routes unchanged.
+func SetTable(routes []*netlink.Route, table int) {
+	if table == 0 {
+		return
+	}
+	for _, route := range routes {
+		if route != nil && route.Table == unix.RT_TABLE_MAIN {
+			route.Table = table
+		}
+	}
+}
+
+// Validate checks that the route can be converted into a netlink route.
+func (r Route) Validate() error {
+	if r.Metric < 0 || r.Table < 0 || r.MTU < 0 {
+		return fmt.Errorf("metric, table and mtu of route %s must not be negative", r.Destination)
+	}
+	_, err := r.ToNetlink(&netlink.Dummy{})
+	return err
+}
+
+// Build converts a list of routes into netlink routes bound to the given link and
+// marked with the given protocol.
+func Build(link netlink.Link, routes []Route, protocol netlink.RouteProtocol) ([]*netlink.Route, error) {
+	var built []*netlink.Route
+	for _, r := range routes {
+		route, err := r.ToNetlink(link)
+		if err != nil {
+			return nil, err
+		}
+		route.Protocol = protocol
+		built = append(built, route)
+	}
+	return built, nil
+}
+
+// IsDefault reports whether the route is a default route (0.0.0.0/0 or ::/0).
+func IsDefault(route netlink.Route) bool {
+	if route.Dst == nil {
+		return true
+	}
+	ones, _ := route.Dst.Mask.Size()
+	return ones == 0
+}
+
+// Matches reports whether an existing route is equivalent to the desired one. The kernel reports
+// every IPv6 route with global scope, so the scope is only compared for IPv4. Routes that point out
+// of no interface match whatever link the kernel reports, it attaches IPv6 ones to the loopback.
+func Matches(existing netlink.Route, desired *netlink.Route) bool {
+	return existing.Table == desired.Table &&
+		existing.Type == desired.Type &&
+		existing.Priority == desired.Priority &&
+		(existing.LinkIndex == desired.LinkIndex || !linked(*desired)) &&
+		existing.Gw.Equal(desired.Gw) &&
+		existing.Src.Equal(desired.Src) &&
+		existing.MTU == desired.MTU &&
+		(desired.Family == netlink.FAMILY_V6 || existing.Scope == desired.Scope) &&
+		existing.Dst != nil && desired.Dst != nil &&
+		existing.Dst.String() == desired.Dst.String()
+}
+
+// conflicting returns the route occupying the destination, table and metric of the given one,
+// nil if there is none.
+func conflicting(route *netlink.Route) (*netlink.Route, error) {
+	existing, err := netlink.RouteListFiltered(route.Family, &netlink.Route{
+		Table: route.Table,
+		Dst:   route.Dst,
+	}, netlink.RT_FILTER_TABLE|netlink.RT_FILTER_DST)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list routes: %w", err)
+	}
+	for _, r := range existing {
+		if r.Priority == route.Priority {
+			return &r, nil
+		}
+	}
+	return nil, nil
+}
+
+// Exists reports whether an equivalent route is present in the kernel.
+func Exists(route *netlink.Route) (bool, error) {
+	existing, err := netlink.RouteListFiltered(route.Family, &netlink.Route{
+		Table: route.Table,
+		Dst:   route.Dst,
+	}, netlink.RT_FILTER_TABLE|netlink.RT_FILTER_DST)
+	if err != nil {
+		return false, fmt.Errorf("failed to list routes: %w", err)
+	}
+
+	for _, r := range existing {
+		if Matches(r, route) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// Owned lists the routes carrying the given protocol, in any table, that point out of the link.
+// Blackhole, unreachable and prohibit routes point out of no interface, so those carrying the
+// protocol are listed whichever interface they were installed for.
+func Owned(link netlink.Link, protocol netlink.RouteProtocol) ([]netlink.Route, error) {
+	listed, err := netlink.RouteListFiltered(netlink.FAMILY_ALL, &netlink.Route{
+		Protocol: protocol,
+		Table:    unix.RT_TABLE_UNSPEC,
+	}, netlink.RT_FILTER_PROTOCOL|netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list routes: %w", err)
+	}
+
+	var owned []netlink.Route
+	for _, route := range listed {
+		if route.LinkIndex == link.Attrs().Index || !linked(route) {
+			owned = append(owned, route)
+		}
+	}
+	return owned, nil
+}
+
+// linked reports whether the route points out of an interface, unlike blackhole, unreachable and
+// prohibit routes.
+func linked(route netlink.Route) bool {
+	return route.Type == unix.RTN_UNICAST
+}
+
+// Key identifies a route that points out of no interface, for the inventory of the interface it
+// was installed for.
+func Key(route netlink.Route) string {
+	name := fmt.Sprintf("type %d", route.Type)
+	for typeName, routeType := range routeTypes {
+		if routeType == route.Type && typeName != "" && typeName != "unicast" {
+			name = typeName
+		}
+	}
+	return fmt.Sprintf("%s %s table %d metric %d", name, route.Dst, route.Table, route.Priority)
+}
+
+// Claim records the routes that point out of no interface in the inventory of the interface they
+// are installed for, so that RemoveStale of the interface may remove them.
+func Claim(inv *inventory.Inventory, routes []*netlink.Route) error {
+	for _, route := range routes {
+		if linked(*route) {
+			continue
+		}
+		if err := inv.AddRoute(Key(*route)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Release forgets the routes that point out of no interface, e.g. once they were removed.
+func Release(inv *inventory.Inventory, routes []*netlink.Route) error {
+	for _, route := range routes {
+		if linked(*route) {
+			continue
+		}
+		if err := inv.RemoveRoute(Key(*route)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RemoveStale deletes routes carrying the given protocol that point out of the link and are not
+// part of the desired set, e.g. routes left behind by a previous configuration or daemon run.
+// Routes that point out of no interface are only deleted if the inventory of the link claims them.
+func RemoveStale(link netlink.Link, protocol netlink.RouteProtocol, desired []*netlink.Route, inv *inventory.Inventory, logger *logrus.Entry) error {
+	owned, err := Owned(link, protocol)
+	if err != nil {
+		return err
+	}
+
+	for _, route := range owned {
+		// Installed for another interface
+		if !linked(route) && !inv.OwnsRoute(Key(route)) {
+			continue
+		}
+
+		wanted := false
+		for _, d := range desired {
+			if Matches(route, d) {
+				wanted = true
+				break
+			}
+		}
+		if wanted {
+			continue
+		}
+		if err := netlink.RouteDel(&route); err != nil && !errors.Is(err, unix.ESRCH) {
+			logger.WithError(err).WithField("destination", route.Dst.String()).Warn("Failed to remove stale route")
+			continue
+		}
+		logger.WithField("destination", route.Dst.String()).Info("Removed stale route")
+		if !linked(route) {
+			if err := inv.RemoveRoute(Key(route)); err != nil {
+				logger.WithError(err).Warn("Failed to update route inventory")
+			}
+		}
+	}
+	return nil
+}
+
+// RemoveOtherDefaults deletes the default routes on the link in the table of keep with the same
+// address family that differ from it. Only routes carrying the protocol of keep are removed, unless exclusive is set.
+func RemoveOtherDefaults(link netlink.Link, keep *netlink.Route, exclusive bool, logger *logrus.Entry) error {
+	existing, err := netlink.RouteListFiltered(keep.Family, &netlink.Route{
+		LinkIndex: link.Attrs().Index,
+		Table:     keep.Table,
+	}, netlink.RT_FILTER_OIF|netlink.RT_FILTER_TABLE)
+	if err != nil {
+		return fmt.Errorf("failed to list routes: %w", err)
+	}
+
+	for _, route := range existing {
+		if !IsDefault(route) || Matches(route, keep) {
+			continue
+		}
+		if !exclusive && route.Protocol != keep.Protocol {
+			continue
+		}
+		if err := netlink.RouteDel(&route); err != nil && !errors.Is(err, unix.ESRCH) {
+			logger.WithError(err).WithField("old_gateway", route.Gw).Warn("Failed to remove existing default route")
+			continue
+		}
+		logger.WithField("old_gateway", route.Gw).Debug("Removed existing default route")
+	}
+	return nil
+}
+
+// Ensure installs every route that is missing from the kernel and returns how many were added.
+// A route occupying the same destination and metric is only replaced if it is a previous version
+// of the route, on the same link and with the same protocol. Routes of other links or other
+// owners are left alone and reported.
+func Ensure(routes []*netlink.Route, logger *logrus.Entry) (int, error) {
+	added := 0
+	for _, route := range routes {
+		exists, err := Exists(route)
+		if err != nil {
+			return added, err
+		}
+		if exists {
+			logger.WithField("destination", route.Dst.String()).Debug("Route already configured, skipping")
+			continue
+		}
+
+		if err := add(route); err != nil {
+			return added, err
+		}
+		added++
+		logger.WithFields(logrus.Fields{
+			"destination": route.Dst.String(),
+			"gateway":     route.Gw,
+			"table":       route.Table,
+		}).Info("Successfully added route")
+	}
+	return added, nil
+}
+
+// add installs a route, replacing a previous version of it, e.g. one with another source or MTU.
+func add(route *netlink.Route) error {
+	err := netlink.RouteAdd(route)
+	if err == nil {
+		return nil
+	}
+	if !errors.Is(err, unix.EEXIST) {
+		return fmt.Errorf("failed to add route %s: %w", route.Dst.String(), err)
+	}
+
+	previous, listErr := conflicting(route)
+	if listErr != nil {
+		return listErr
+	}
+	if previous == nil || (linked(*route) && previous.LinkIndex != route.LinkIndex) || previous.Protocol != route.Protocol {
+		return fmt.Errorf("another route to %s with metric %d exists in table %d, configure a distinct metric: %w",
+			route.Dst.String(), route.Priority, route.Table, err)
+	}
+	if err := netlink.RouteReplace(route); err != nil {
+		return fmt.Errorf("failed to replace route %s: %w", route.Dst.String(), err)
+	}
+	return nil
+}
+
+// Remove deletes the given routes from the kernel, ignoring routes that are already gone.
+func Remove(routes []*netlink.Route, logger *logrus.Entry) {
+	for _, route := range routes {
+		if err := netlink.RouteDel(route); err != nil {
+			if errors.Is(err, unix.ESRCH) {
+				continue
+			}
+			logger.WithError(err).WithField("destination", route.Dst.String()).Warn("Failed to remove route")
+			continue
+		}
+		logger.WithField("destination", route.Dst.String()).Info("Removed route")
+	}
+}
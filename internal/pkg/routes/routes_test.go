@@ -0,0 +1,312 @@
+package routes
+
+import (
+	"net"
+	"testing"
+
+	"golang-dhcpcd/internal/pkg/inventory"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// testLink is the link routes are bound to in the tests.
+var testLink = &netlink.Dummy{LinkAttrs: netlink.LinkAttrs{Name: "eth0", Index: 2}}
+
+func TestParseDestination(t *testing.T) {
+	tests := []struct {
+		destination string
+		ipv6        bool
+		want        string
+		wantErr     bool
+	}{
+		{"default", false, "0.0.0.0/0", false},
+		{"default", true, "::/0", false},
+		{"10.1.0.0/16", false, "10.1.0.0/16", false},
+		{"10.1.2.3/16", false, "10.1.0.0/16", false},
+		{"2001:db8:1::/48", true, "2001:db8:1::/48", false},
+		{"10.1.0.0", false, "", true},
+		{"", false, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.destination, func(t *testing.T) {
+			dst, err := ParseDestination(tt.destination, tt.ipv6)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("ParseDestination() = %v, want an error", dst)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseDestination() failed: %v", err)
+			}
+			if dst.String() != tt.want {
+				t.Errorf("ParseDestination() = %s, want %s", dst, tt.want)
+			}
+		})
+	}
+}
+
+func TestToNetlink(t *testing.T) {
+	tests := []struct {
+		name    string
+		route   Route
+		want    netlink.Route
+		wantErr bool
+	}{
+		{"via gateway", Route{Destination: "10.1.0.0/16", Gateway: "192.168.1.254"},
+			netlink.Route{Family: netlink.FAMILY_V4, LinkIndex: 2, Gw: net.IPv4(192, 168, 1, 254), Table: unix.RT_TABLE_MAIN, Type: unix.RTN_UNICAST}, false},
+		{"directly connected", Route{Destination: "10.2.0.0/16"},
+			netlink.Route{Family: netlink.FAMILY_V4, LinkIndex: 2, Scope: netlink.SCOPE_LINK, Table: unix.RT_TABLE_MAIN, Type: unix.RTN_UNICAST}, false},
+		{"metric, table and mtu", Route{Destination: "10.3.0.0/16", Gateway: "192.168.1.254", Metric: 50, Table: 100, MTU: 1400},
+			netlink.Route{Family: netlink.FAMILY_V4, LinkIndex: 2, Gw: net.IPv4(192, 168, 1, 254), Priority: 50, Table: 100, MTU: 1400, Type: unix.RTN_UNICAST}, false},
+		{"explicit scope", Route{Destination: "10.4.0.0/16", Scope: "host"},
+			netlink.Route{Family: netlink.FAMILY_V4, LinkIndex: 2, Scope: netlink.SCOPE_HOST, Table: unix.RT_TABLE_MAIN, Type: unix.RTN_UNICAST}, false},
+		{"source", Route{Destination: "10.5.0.0/16", Gateway: "192.168.1.254", Source: "192.168.1.10"},
+			netlink.Route{Family: netlink.FAMILY_V4, LinkIndex: 2, Gw: net.IPv4(192, 168, 1, 254), Src: net.IPv4(192, 168, 1, 10), Table: unix.RT_TABLE_MAIN, Type: unix.RTN_UNICAST}, false},
+		{"blackhole", Route{Destination: "10.6.0.0/16", Type: "blackhole"},
+			netlink.Route{Family: netlink.FAMILY_V4, Table: unix.RT_TABLE_MAIN, Type: unix.RTN_BLACKHOLE}, false},
+		{"unreachable", Route{Destination: "10.7.0.0/16", Type: "Unreachable"},
+			netlink.Route{Family: netlink.FAMILY_V4, Table: unix.RT_TABLE_MAIN, Type: unix.RTN_UNREACHABLE}, false},
+		{"ipv6 via gateway", Route{Destination: "2001:db8:1::/48", Gateway: "2001:db8::1"},
+			netlink.Route{Family: netlink.FAMILY_V6, LinkIndex: 2, Gw: net.ParseIP("2001:db8::1"), Priority: 1024, Table: unix.RT_TABLE_MAIN, Type: unix.RTN_UNICAST}, false},
+		{"ipv6 default via link-local gateway", Route{Destination: "default", Gateway: "fe80::1", Metric: 100},
+			netlink.Route{Family: netlink.FAMILY_V6, LinkIndex: 2, Gw: net.ParseIP("fe80::1"), Priority: 100, Table: unix.RT_TABLE_MAIN, Type: unix.RTN_UNICAST}, false},
+		{"ipv6 source", Route{Destination: "2001:db8:2::/48", Source: "2001:db8::10"},
+			netlink.Route{Family: netlink.FAMILY_V6, LinkIndex: 2, Src: net.ParseIP("2001:db8::10"), Priority: 1024, Scope: netlink.SCOPE_LINK, Table: unix.RT_TABLE_MAIN, Type: unix.RTN_UNICAST}, false},
+		{"invalid destination", Route{Destination: "10.1.0.0"}, netlink.Route{}, true},
+		{"invalid gateway", Route{Destination: "10.1.0.0/16", Gateway: "router"}, netlink.Route{}, true},
+		{"invalid type", Route{Destination: "10.1.0.0/16", Type: "local"}, netlink.Route{}, true},
+		{"blackhole with gateway", Route{Destination: "10.1.0.0/16", Type: "blackhole", Gateway: "192.168.1.254"}, netlink.Route{}, true},
+		{"invalid scope", Route{Destination: "10.1.0.0/16", Scope: "universe"}, netlink.Route{}, true},
+		{"invalid source", Route{Destination: "10.1.0.0/16", Source: "eth0"}, netlink.Route{}, true},
+		{"gateway of another family", Route{Destination: "10.1.0.0/16", Gateway: "2001:db8::1"}, netlink.Route{}, true},
+		{"source of another family", Route{Destination: "2001:db8:1::/48", Source: "192.168.1.10"}, netlink.Route{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route, err := tt.route.ToNetlink(testLink)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("ToNetlink() = %v, want an error", route)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ToNetlink() failed: %v", err)
+			}
+			if route.Family != tt.want.Family || route.LinkIndex != tt.want.LinkIndex || route.Table != tt.want.Table || route.Type != tt.want.Type ||
+				route.Priority != tt.want.Priority || route.MTU != tt.want.MTU || route.Scope != tt.want.Scope {
+				t.Errorf("ToNetlink() = %+v, want %+v", route, tt.want)
+			}
+			if !route.Gw.Equal(tt.want.Gw) || !route.Src.Equal(tt.want.Src) {
+				t.Errorf("Gateway and source = %v and %v, want %v and %v", route.Gw, route.Src, tt.want.Gw, tt.want.Src)
+			}
+		})
+	}
+}
+
+func TestBuild(t *testing.T) {
+	built, err := Build(testLink, []Route{
+		{Destination: "10.1.0.0/16", Gateway: "192.168.1.254"},
+		{Destination: "default", Gateway: "192.168.1.1", Metric: 10},
+	}, ProtocolStatic)
+	if err != nil {
+		t.Fatalf("Build() failed: %v", err)
+	}
+	if len(built) != 2 || built[0].Dst.String() != "10.1.0.0/16" || built[1].Dst.String() != "0.0.0.0/0" {
+		t.Errorf("Build() = %v", built)
+	}
+	for _, route := range built {
+		if route.Protocol != ProtocolStatic {
+			t.Errorf("Route to %s has protocol %d, want %d", route.Dst, route.Protocol, ProtocolStatic)
+		}
+	}
+
+	if _, err := Build(testLink, []Route{{Destination: "10.1.0.0/16"}, {Destination: "invalid"}}, ProtocolStatic); err == nil {
+		t.Error("Build() accepted an invalid route")
+	}
+}
+
+func TestDefaultRoute(t *testing.T) {
+	tests := []struct {
+		name     string
+		gateway  net.IP
+		metric   int
+		family   int
+		dst      string
+		priority int
+	}{
+		{"ipv4", net.ParseIP("192.168.1.1"), 100, netlink.FAMILY_V4, "0.0.0.0/0", 100},
+		{"ipv6", net.ParseIP("fe80::1"), 100, netlink.FAMILY_V6, "::/0", 100},
+		{"ipv6 without metric", net.ParseIP("2001:db8::1"), 0, netlink.FAMILY_V6, "::/0", 1024},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route := DefaultRoute(testLink, tt.gateway, tt.metric, ProtocolDHCP)
+			if route.Protocol != ProtocolDHCP {
+				t.Errorf("Protocol = %d, want %d", route.Protocol, ProtocolDHCP)
+			}
+			if route.Family != tt.family || route.Dst.String() != tt.dst || route.Priority != tt.priority {
+				t.Errorf("DefaultRoute() = %+v, want family %d, destination %s and metric %d", route, tt.family, tt.dst, tt.priority)
+			}
+			if route.LinkIndex != testLink.Index || route.Table != unix.RT_TABLE_MAIN || !route.Gw.Equal(tt.gateway) {
+				t.Errorf("DefaultRoute() = %+v, want the main table through %s", route, tt.gateway)
+			}
+		})
+	}
+
+	// IPv4 gateways are stored in their 4 byte form, as the kernel reports them
+	if route := DefaultRoute(testLink, net.ParseIP("192.168.1.1"), 0, ProtocolStatic); len(route.Gw) != net.IPv4len {
+		t.Errorf("Gateway has %d bytes, want %d", len(route.Gw), net.IPv4len)
+	}
+}
+
+func TestIsDefault(t *testing.T) {
+	tests := []struct {
+		dst  string
+		want bool
+	}{
+		{"", true},
+		{"0.0.0.0/0", true},
+		{"::/0", true},
+		{"10.0.0.0/8", false},
+		{"2001:db8::/32", false},
+	}
+
+	for _, tt := range tests {
+		route := netlink.Route{}
+		if tt.dst != "" {
+			_, route.Dst, _ = net.ParseCIDR(tt.dst)
+		}
+		if got := IsDefault(route); got != tt.want {
+			t.Errorf("IsDefault(%q) = %v, want %v", tt.dst, got, tt.want)
+		}
+	}
+}
+
+func TestMatches(t *testing.T) {
+	desired, err := Route{Destination: "10.1.0.0/16", Gateway: "192.168.1.254", Metric: 10}.ToNetlink(testLink)
+	if err != nil {
+		t.Fatalf("ToNetlink() failed: %v", err)
+	}
+
+	tests := []struct {
+		name   string
+		change func(route *netlink.Route)
+		want   bool
+	}{
+		{"same", func(route *netlink.Route) {}, true},
+		{"other protocol", func(route *netlink.Route) { route.Protocol = unix.RTPROT_BOOT }, true},
+		{"other table", func(route *netlink.Route) { route.Table = 100 }, false},
+		{"other type", func(route *netlink.Route) { route.Type = unix.RTN_BLACKHOLE }, false},
+		{"other metric", func(route *netlink.Route) { route.Priority = 20 }, false},
+		{"other link", func(route *netlink.Route) { route.LinkIndex = 3 }, false},
+		{"other gateway", func(route *netlink.Route) { route.Gw = net.IPv4(192, 168, 1, 1) }, false},
+		{"other destination", func(route *netlink.Route) { _, route.Dst, _ = net.ParseCIDR("10.2.0.0/16") }, false},
+		{"no destination", func(route *netlink.Route) { route.Dst = nil }, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			existing := *desired
+			tt.change(&existing)
+			if got := Matches(existing, desired); got != tt.want {
+				t.Errorf("Matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetTable(t *testing.T) {
+	main := &netlink.Route{Table: unix.RT_TABLE_MAIN}
+	custom := &netlink.Route{Table: 100}
+	routes := []*netlink.Route{main, custom, nil}
+
+	SetTable(routes, 0)
+	if main.Table != unix.RT_TABLE_MAIN {
+		t.Errorf("SetTable(0) moved the route to table %d", main.Table)
+	}
+
+	SetTable(routes, 10)
+	if main.Table != 10 {
+		t.Errorf("Main table route is in table %d, want 10", main.Table)
+	}
+	if custom.Table != 100 {
+		t.Errorf("Route of table 100 moved to table %d", custom.Table)
+	}
+}
+
+func TestMatchesUnlinked(t *testing.T) {
+	desired, err := Route{Destination: "2001:db8:100::/48", Type: "blackhole"}.ToNetlink(testLink)
+	if err != nil {
+		t.Fatalf("ToNetlink() failed: %v", err)
+	}
+
+	// The kernel reports IPv6 blackhole routes on the loopback interface
+	existing := *desired
+	existing.LinkIndex = 1
+	if !Matches(existing, desired) {
+		t.Error("Matches() = false for a blackhole route reported on the loopback interface")
+	}
+}
+
+func TestKey(t *testing.T) {
+	tests := []struct {
+		name  string
+		route Route
+		want  string
+	}{
+		{"blackhole", Route{Destination: "10.2.0.0/16", Type: "blackhole"}, "blackhole 10.2.0.0/16 table 254 metric 0"},
+		{"unreachable", Route{Destination: "2001:db8::/32", Type: "unreachable", Table: 100}, "unreachable 2001:db8::/32 table 100 metric 1024"},
+		{"prohibit", Route{Destination: "10.3.0.0/16", Type: "prohibit", Metric: 5}, "prohibit 10.3.0.0/16 table 254 metric 5"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			route, err := tt.route.ToNetlink(testLink)
+			if err != nil {
+				t.Fatalf("ToNetlink() failed: %v", err)
+			}
+			if got := Key(*route); got != tt.want {
+				t.Errorf("Key() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClaimRelease(t *testing.T) {
+	inventory.SetStateDir(t.TempDir())
+	t.Cleanup(func() { inventory.SetStateDir("") })
+
+	inv, err := inventory.Open("eth0")
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	built, err := Build(testLink, []Route{
+		{Destination: "10.1.0.0/16", Gateway: "192.168.1.254"},
+		{Destination: "10.2.0.0/16", Type: "blackhole"},
+	}, ProtocolStatic)
+	if err != nil {
+		t.Fatalf("Build() failed: %v", err)
+	}
+	unicast, blackhole := Key(*built[0]), Key(*built[1])
+
+	if err := Claim(inv, built); err != nil {
+		t.Fatalf("Claim() failed: %v", err)
+	}
+	// Routes pointing out of the link are owned through their link, not the inventory
+	if inv.OwnsRoute(unicast) || !inv.OwnsRoute(blackhole) {
+		t.Errorf("OwnsRoute() = %v and %v after Claim(), want false and true", inv.OwnsRoute(unicast), inv.OwnsRoute(blackhole))
+	}
+
+	if err := Release(inv, built); err != nil {
+		t.Fatalf("Release() failed: %v", err)
+	}
+	if inv.OwnsRoute(blackhole) {
+		t.Error("OwnsRoute() = true after Release()")
+	}
+}
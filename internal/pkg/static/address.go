@@ -0,0 +1,318 @@
+package static
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang-dhcpcd/internal/pkg/logging"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+	"gopkg.in/yaml.v3"
+)
+
+// Address represents a single address in CIDR notation with optional attributes.
+// It can be written either as a plain string ("10.0.0.5/24") or as a mapping with the attributes.
+type Address struct {
+	Address           string `yaml:"address"`
+	Label             string `yaml:"label,omitempty"`
+	Scope             string `yaml:"scope,omitempty"`              // global, site, link or host
+	PreferredLifetime string `yaml:"preferred_lifetime,omitempty"` // duration or "forever"
+	ValidLifetime     string `yaml:"valid_lifetime,omitempty"`     // duration or "forever"
+}
+
+// UnmarshalYAML accepts both the plain string and the mapping form of an address.
+func (a *Address) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		a.Address = value.Value
+		return nil
+	}
+
+	type plain Address
+	return value.Decode((*plain)(a))
+}
+
+// Validate checks the address, its scope and lifetimes. The label is only checked against the
+// name of the interface once the address is applied.
+func (a Address) Validate() error {
+	_, err := a.toNetlink("")
+	return err
+}
+
+// addressScopes maps scope names to their netlink values.
+var addressScopes = map[string]netlink.Scope{
+	"global": netlink.SCOPE_UNIVERSE,
+	"site":   netlink.SCOPE_SITE,
+	"link":   netlink.SCOPE_LINK,
+	"host":   netlink.SCOPE_HOST,
+}
+
+// defaultScope returns the scope the kernel gives an address without a configured one: link for
+// IPv6 link-local, host for loopback and global otherwise.
+func defaultScope(ip net.IP) netlink.Scope {
+	switch {
+	case ip.IsLoopback():
+		return netlink.SCOPE_HOST
+	case ip.To4() == nil && ip.IsLinkLocalUnicast():
+		return netlink.SCOPE_LINK
+	default:
+		return netlink.SCOPE_UNIVERSE
+	}
+}
+
+// parseLifetime parses an address lifetime. An empty value or "forever" means infinite and returns 0.
+func parseLifetime(value string) (int, error) {
+	if value == "" || value == "forever" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid lifetime %q: %w", value, err)
+	}
+	if d < time.Second {
+		return 0, fmt.Errorf("invalid lifetime %q: must be at least 1s", value)
+	}
+	return int(d.Seconds()), nil
+}
+
+// toNetlink converts the address into a netlink address for the given interface.
+func (a Address) toNetlink(ifaceName string) (*netlink.Addr, error) {
+	ip, ipNet, err := net.ParseCIDR(a.Address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid address %q: must be in CIDR notation", a.Address)
+	}
+	if ip4 := ip.To4(); ip4 != nil {
+		ipNet.IP = ip4
+	} else {
+		ipNet.IP = ip
+	}
+
+	scope := defaultScope(ip)
+	if a.Scope != "" {
+		var ok bool
+		if scope, ok = addressScopes[strings.ToLower(a.Scope)]; !ok {
+			return nil, fmt.Errorf("invalid scope %q for address %s", a.Scope, a.Address)
+		}
+	}
+
+	if a.Label != "" && !strings.HasPrefix(a.Label, ifaceName) {
+		return nil, fmt.Errorf("label %q for address %s must begin with interface name %s", a.Label, a.Address, ifaceName)
+	}
+
+	preferred, err := parseLifetime(a.PreferredLifetime)
+	if err != nil {
+		return nil, err
+	}
+	valid, err := parseLifetime(a.ValidLifetime)
+	if err != nil {
+		return nil, err
+	}
+
+	// The kernel needs both lifetimes once one of them is finite
+	const forever = 0xFFFFFFFF
+	switch {
+	case valid == 0 && preferred > 0:
+		valid = forever
+	case preferred == 0 && valid > 0:
+		preferred = valid
+	case preferred > valid:
+		return nil, fmt.Errorf("preferred lifetime of address %s exceeds its valid lifetime", a.Address)
+	}
+
+	return &netlink.Addr{
+		IPNet:       ipNet,
+		Label:       a.Label,
+		Scope:       int(scope),
+		PreferedLft: preferred,
+		ValidLft:    valid,
+	}, nil
+}
+
+// desiredAddresses returns the full set of addresses the configuration asks for,
+// starting with the legacy ip/netmask pair if present.
+func (c *Client) desiredAddresses(config Config) ([]*netlink.Addr, error) {
+	var addresses []Address
+	if config.IPAddress != "" {
+		mask := net.ParseIP(config.Netmask)
+		if mask == nil || mask.To4() == nil {
+			return nil, fmt.Errorf("invalid netmask: %s", config.Netmask)
+		}
+		ones, _ := net.IPMask(mask.To4()).Size()
+		addresses = append(addresses, Address{Address: fmt.Sprintf("%s/%d", config.IPAddress, ones)})
+	}
+	addresses = append(addresses, config.Addresses...)
+
+	var desired []*netlink.Addr
+	seen := make(map[string]bool)
+	for _, a := range addresses {
+		addr, err := a.toNetlink(c.Iface.Name)
+		if err != nil {
+			return nil, err
+		}
+		if seen[addr.IP.String()] {
+			return nil, fmt.Errorf("duplicate address %s", addr.IP.String())
+		}
+		seen[addr.IP.String()] = true
+		desired = append(desired, addr)
+	}
+	return desired, nil
+}
+
+// matchesAddress reports whether an existing address satisfies the desired one.
+func matchesAddress(existing netlink.Addr, desired *netlink.Addr) bool {
+	return existing.IPNet.IP.Equal(desired.IP) &&
+		existing.IPNet.Mask.String() == desired.Mask.String() &&
+		(desired.Label == "" || existing.Label == desired.Label) &&
+		existing.Scope == desired.Scope
+}
+
+// addressFamily returns the netlink address family of an IP address.
+func addressFamily(ip net.IP) int {
+	if ip.To4() != nil {
+		return netlink.FAMILY_V4
+	}
+	return netlink.FAMILY_V6
+}
+
+// isManagedAddress reports whether an existing address may be removed when it is not desired.
+// IPv6 link-local addresses and addresses created by the kernel (SLAAC, privacy extensions) are
+// left alone; only permanent global IPv6 addresses are managed.
+func isManagedAddress(addr netlink.Addr) bool {
+	if addressFamily(addr.IP) == netlink.FAMILY_V4 {
+		return true
+	}
+	return addr.Scope == int(netlink.SCOPE_UNIVERSE) && addr.Flags&unix.IFA_F_PERMANENT != 0
+}
+
+// configureAddresses makes the addresses on the link match the desired set. Missing addresses are
+// added and recorded in the inventory; addresses that are no longer desired are removed if the
+// daemon installed them. In exclusive mode every other address of the families in use is removed.
+func (c *Client) configureAddresses(link netlink.Link, desired []*netlink.Addr, exclusive bool) error {
+	logger := logging.WithComponentAndInterface("static", c.Iface.Name)
+
+	// Only address families present in the desired set are managed
+	families := make(map[int]bool)
+	for _, d := range desired {
+		families[addressFamily(d.IP)] = true
+	}
+
+	// Get existing addresses to check for duplicates
+	existingAddrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
+	if err != nil {
+		return fmt.Errorf("failed to list existing addresses: %w", err)
+	}
+
+	// Remove existing addresses that are not part of the desired set
+	for _, addr := range existingAddrs {
+		if exclusive {
+			if !families[addressFamily(addr.IP)] || !isManagedAddress(addr) {
+				continue
+			}
+		} else if !c.inventory.Owns(addr.IPNet) {
+			continue
+		}
+		wanted := false
+		for _, d := range desired {
+			if matchesAddress(addr, d) {
+				wanted = true
+				break
+			}
+		}
+		if wanted {
+			continue
+		}
+		if err := netlink.AddrDel(link, &addr); err != nil {
+			logger.WithError(err).WithField("address", addr.IPNet.String()).Warn("Failed to remove existing address")
+			continue
+		}
+		logger.WithField("address", addr.IPNet.String()).Debug("Removed existing address")
+		if err := c.inventory.Remove(addr.IPNet); err != nil {
+			logger.WithError(err).Warn("Failed to update address inventory")
+		}
+	}
+
+	// Add missing addresses, refreshing those with finite lifetimes
+	for _, d := range desired {
+		configured := false
+		for _, addr := range existingAddrs {
+			if matchesAddress(addr, d) {
+				configured = true
+				break
+			}
+		}
+
+		switch {
+		case !configured:
+			if err := netlink.AddrAdd(link, d); err != nil {
+				return fmt.Errorf("failed to add IP address %s: %w", d.IPNet.String(), err)
+			}
+			logger.WithField("ip", d.IPNet.String()).Info("Successfully added IP address")
+			if err := c.inventory.Add(d.IPNet); err != nil {
+				logger.WithError(err).Warn("Failed to update address inventory")
+			}
+		case d.ValidLft > 0:
+			if err := netlink.AddrReplace(link, d); err != nil {
+				return fmt.Errorf("failed to refresh IP address %s: %w", d.IPNet.String(), err)
+			}
+			logger.WithField("ip", d.IPNet.String()).Debug("Refreshed IP address lifetimes")
+		default:
+			logger.WithField("ip", d.IPNet.String()).Debug("IP address already configured, skipping")
+		}
+	}
+
+	return nil
+}
+
+// RemoveAddresses removes the addresses the daemon installed on the interface, e.g. once its
+// configuration entry was removed. Addresses of a link that is gone are only forgotten.
+func (c *Client) RemoveAddresses() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	logger := logging.WithComponentAndInterface("static", c.Iface.Name)
+
+	// The link may be gone already
+	link, err := netlink.LinkByName(c.Iface.Name)
+	if err != nil {
+		link = nil
+	}
+
+	for _, address := range c.inventory.Addresses() {
+		ipNet, err := netlink.ParseIPNet(address)
+		if err != nil {
+			continue
+		}
+		if link != nil {
+			if err := netlink.AddrDel(link, &netlink.Addr{IPNet: ipNet}); err != nil && !errors.Is(err, unix.EADDRNOTAVAIL) {
+				logger.WithError(err).WithField("address", address).Warn("Failed to remove address")
+				continue
+			}
+			logger.WithField("address", address).Info("Removed address")
+		}
+		if err := c.inventory.Remove(ipNet); err != nil {
+			logger.WithError(err).Warn("Failed to update address inventory")
+		}
+	}
+	c.applied = false
+}
+
+// missingAddresses returns the desired addresses that are not present in the given list.
+func missingAddresses(existing []netlink.Addr, desired []*netlink.Addr) []*netlink.Addr {
+	var missing []*netlink.Addr
+	for _, d := range desired {
+		found := false
+		for _, addr := range existing {
+			if matchesAddress(addr, d) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			missing = append(missing, d)
+		}
+	}
+	return missing
+}
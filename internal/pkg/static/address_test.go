@@ -0,0 +1,211 @@
+package static
+
+import (
+	"net"
+	"testing"
+
+	"golang-dhcpcd/internal/pkg/inventory"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+func TestAddressToNetlink(t *testing.T) {
+	tests := []struct {
+		name      string
+		address   Address
+		want      string
+		scope     netlink.Scope
+		preferred int
+		valid     int
+		wantErr   bool
+	}{
+		{"plain", Address{Address: "10.0.0.5/24"}, "10.0.0.5/24", netlink.SCOPE_UNIVERSE, 0, 0, false},
+		{"host bits kept", Address{Address: "10.0.0.5/8"}, "10.0.0.5/8", netlink.SCOPE_UNIVERSE, 0, 0, false},
+		{"scope", Address{Address: "10.0.0.5/24", Scope: "Link"}, "10.0.0.5/24", netlink.SCOPE_LINK, 0, 0, false},
+		{"label", Address{Address: "10.0.0.5/24", Label: "eth0:web"}, "10.0.0.5/24", netlink.SCOPE_UNIVERSE, 0, 0, false},
+		{"finite lifetimes", Address{Address: "10.0.0.5/24", PreferredLifetime: "30m", ValidLifetime: "1h"}, "10.0.0.5/24", netlink.SCOPE_UNIVERSE, 1800, 3600, false},
+		{"valid lifetime only", Address{Address: "10.0.0.5/24", ValidLifetime: "1h"}, "10.0.0.5/24", netlink.SCOPE_UNIVERSE, 3600, 3600, false},
+		{"preferred lifetime only", Address{Address: "10.0.0.5/24", PreferredLifetime: "1h", ValidLifetime: "forever"}, "10.0.0.5/24", netlink.SCOPE_UNIVERSE, 3600, 0xFFFFFFFF, false},
+		{"ipv6", Address{Address: "2001:db8::5/64"}, "2001:db8::5/64", netlink.SCOPE_UNIVERSE, 0, 0, false},
+		{"ipv6 link-local", Address{Address: "fe80::5/64", Scope: "link"}, "fe80::5/64", netlink.SCOPE_LINK, 0, 0, false},
+		{"ipv6 link-local default scope", Address{Address: "fe80::5/64"}, "fe80::5/64", netlink.SCOPE_LINK, 0, 0, false},
+		{"loopback default scope", Address{Address: "127.0.0.5/8"}, "127.0.0.5/8", netlink.SCOPE_HOST, 0, 0, false},
+		{"not cidr", Address{Address: "10.0.0.5"}, "", 0, 0, 0, true},
+		{"invalid scope", Address{Address: "10.0.0.5/24", Scope: "universe"}, "", 0, 0, 0, true},
+		{"label of another interface", Address{Address: "10.0.0.5/24", Label: "eth1:web"}, "", 0, 0, 0, true},
+		{"invalid lifetime", Address{Address: "10.0.0.5/24", ValidLifetime: "soon"}, "", 0, 0, 0, true},
+		{"lifetime below a second", Address{Address: "10.0.0.5/24", ValidLifetime: "10ms"}, "", 0, 0, 0, true},
+		{"preferred exceeds valid", Address{Address: "10.0.0.5/24", PreferredLifetime: "2h", ValidLifetime: "1h"}, "", 0, 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, err := tt.address.toNetlink("eth0")
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("toNetlink() = %v, want an error", addr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("toNetlink() failed: %v", err)
+			}
+			if got := addr.IPNet.String(); got != tt.want {
+				t.Errorf("Address = %s, want %s", got, tt.want)
+			}
+			if addr.Scope != int(tt.scope) {
+				t.Errorf("Scope = %d, want %d", addr.Scope, tt.scope)
+			}
+			if addr.Label != tt.address.Label {
+				t.Errorf("Label = %q, want %q", addr.Label, tt.address.Label)
+			}
+			if addr.PreferedLft != tt.preferred || addr.ValidLft != tt.valid {
+				t.Errorf("Lifetimes = %d/%d, want %d/%d", addr.PreferedLft, addr.ValidLft, tt.preferred, tt.valid)
+			}
+		})
+	}
+}
+
+func TestDesiredAddresses(t *testing.T) {
+	tests := []struct {
+		name    string
+		config  Config
+		want    []string
+		wantErr bool
+	}{
+		{"legacy", Config{IPAddress: "10.0.0.5", Netmask: "255.255.255.0"}, []string{"10.0.0.5/24"}, false},
+		{"list", Config{Addresses: []Address{{Address: "10.0.0.5/24"}, {Address: "10.0.1.5/25"}}}, []string{"10.0.0.5/24", "10.0.1.5/25"}, false},
+		{"dual stack", Config{Addresses: []Address{{Address: "10.0.0.5/24"}, {Address: "2001:db8::5/64"}}}, []string{"10.0.0.5/24", "2001:db8::5/64"}, false},
+		{"legacy first", Config{IPAddress: "10.0.0.5", Netmask: "255.255.0.0", Addresses: []Address{{Address: "10.1.0.5/24"}}}, []string{"10.0.0.5/16", "10.1.0.5/24"}, false},
+		{"invalid netmask", Config{IPAddress: "10.0.0.5", Netmask: "24"}, nil, true},
+		{"duplicate", Config{IPAddress: "10.0.0.5", Netmask: "255.255.255.0", Addresses: []Address{{Address: "10.0.0.5/16"}}}, nil, true},
+		{"invalid address", Config{Addresses: []Address{{Address: "10.0.0.500/24"}}}, nil, true},
+	}
+
+	client := &Client{Iface: &net.Interface{Name: "eth0"}}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			desired, err := client.desiredAddresses(tt.config)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("desiredAddresses() error = %v, want error %v", err, tt.wantErr)
+			}
+			if len(desired) != len(tt.want) {
+				t.Fatalf("desiredAddresses() returned %d addresses, want %d", len(desired), len(tt.want))
+			}
+			for i, addr := range desired {
+				if got := addr.IPNet.String(); got != tt.want[i] {
+					t.Errorf("Address %d = %s, want %s", i, got, tt.want[i])
+				}
+			}
+		})
+	}
+}
+
+// mustAddr converts an address for the tests.
+func mustAddr(t *testing.T, address Address) *netlink.Addr {
+	t.Helper()
+
+	addr, err := address.toNetlink("eth0")
+	if err != nil {
+		t.Fatalf("Failed to convert %s: %v", address.Address, err)
+	}
+	return addr
+}
+
+func TestMatchesAddress(t *testing.T) {
+	tests := []struct {
+		name     string
+		existing Address
+		desired  Address
+		want     bool
+	}{
+		{"same", Address{Address: "10.0.0.5/24"}, Address{Address: "10.0.0.5/24"}, true},
+		{"other prefix length", Address{Address: "10.0.0.5/16"}, Address{Address: "10.0.0.5/24"}, false},
+		{"other address", Address{Address: "10.0.0.6/24"}, Address{Address: "10.0.0.5/24"}, false},
+		{"any label", Address{Address: "10.0.0.5/24", Label: "eth0:a"}, Address{Address: "10.0.0.5/24"}, true},
+		{"other label", Address{Address: "10.0.0.5/24", Label: "eth0:a"}, Address{Address: "10.0.0.5/24", Label: "eth0:b"}, false},
+		{"other scope", Address{Address: "10.0.0.5/24", Scope: "host"}, Address{Address: "10.0.0.5/24"}, false},
+		{"ipv6", Address{Address: "2001:db8::5/64"}, Address{Address: "2001:db8::5/64"}, true},
+		{"ipv6 link-local", Address{Address: "fe80::5/64", Scope: "link"}, Address{Address: "fe80::5/64"}, true},
+		{"ipv6 other prefix length", Address{Address: "2001:db8::5/48"}, Address{Address: "2001:db8::5/64"}, false},
+		{"lifetimes ignored", Address{Address: "10.0.0.5/24", ValidLifetime: "1h"}, Address{Address: "10.0.0.5/24"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			existing := mustAddr(t, tt.existing)
+			if got := matchesAddress(*existing, mustAddr(t, tt.desired)); got != tt.want {
+				t.Errorf("matchesAddress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMissingAddresses(t *testing.T) {
+	existing := []netlink.Addr{*mustAddr(t, Address{Address: "10.0.0.5/24"})}
+	desired := []*netlink.Addr{
+		mustAddr(t, Address{Address: "10.0.0.5/24"}),
+		mustAddr(t, Address{Address: "10.0.1.5/24"}),
+	}
+
+	missing := missingAddresses(existing, desired)
+	if len(missing) != 1 || missing[0] != desired[1] {
+		t.Errorf("missingAddresses() = %v, want only 10.0.1.5/24", missing)
+	}
+}
+
+func TestIsManagedAddress(t *testing.T) {
+	tests := []struct {
+		name  string
+		addr  string
+		scope netlink.Scope
+		flags int
+		want  bool
+	}{
+		{"ipv4", "10.0.0.5/24", netlink.SCOPE_UNIVERSE, 0, true},
+		{"ipv4 host scope", "127.0.0.2/8", netlink.SCOPE_HOST, 0, true},
+		{"permanent ipv6", "2001:db8::5/64", netlink.SCOPE_UNIVERSE, unix.IFA_F_PERMANENT, true},
+		{"slaac ipv6", "2001:db8::5054:ff:fe12:3456/64", netlink.SCOPE_UNIVERSE, unix.IFA_F_MANAGETEMPADDR, false},
+		{"temporary ipv6", "2001:db8::1234/64", netlink.SCOPE_UNIVERSE, unix.IFA_F_TEMPORARY, false},
+		{"ipv6 link-local", "fe80::5/64", netlink.SCOPE_LINK, unix.IFA_F_PERMANENT, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ip, ipNet, _ := net.ParseCIDR(tt.addr)
+			ipNet.IP = ip
+			addr := netlink.Addr{IPNet: ipNet, Scope: int(tt.scope), Flags: tt.flags}
+			if got := isManagedAddress(addr); got != tt.want {
+				t.Errorf("isManagedAddress() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRemoveAddressesOfMissingLink(t *testing.T) {
+	inventory.SetStateDir(t.TempDir())
+	t.Cleanup(func() { inventory.SetStateDir("") })
+
+	inv, err := inventory.Open("nonexistent0")
+	if err != nil {
+		t.Fatalf("Open() failed: %v", err)
+	}
+	for _, address := range []string{"10.0.0.5/24", "2001:db8::5/64"} {
+		ip, ipNet, _ := net.ParseCIDR(address)
+		ipNet.IP = ip
+		if err := inv.Add(ipNet); err != nil {
+			t.Fatalf("Add() failed: %v", err)
+		}
+	}
+
+	client := &Client{Iface: &net.Interface{Name: "nonexistent0"}, inventory: inv, applied: true}
+	client.RemoveAddresses()
+
+	if addresses := inv.Addresses(); len(addresses) != 0 {
+		t.Errorf("Inventory still owns %v", addresses)
+	}
+	if client.applied {
+		t.Error("Client still reports the configuration as applied")
+	}
+}
@@ -1,39 +1,106 @@
 package static
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
+	"slices"
 	"strings"
+	"sync"
 	"time"
 
+	"golang-dhcpcd/internal/pkg/health"
+	"golang-dhcpcd/internal/pkg/inventory"
+	"golang-dhcpcd/internal/pkg/links"
 	"golang-dhcpcd/internal/pkg/logging"
+	"golang-dhcpcd/internal/pkg/metrics"
+	"golang-dhcpcd/internal/pkg/namespace"
+	"golang-dhcpcd/internal/pkg/netmon"
+	"golang-dhcpcd/internal/pkg/policy"
+	"golang-dhcpcd/internal/pkg/resolver"
+	"golang-dhcpcd/internal/pkg/routes"
 
 	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
 )
 
 // Client handles static IP configuration for a network interface.
 type Client struct {
 	Iface *net.Interface
+
+	// mu serializes reconfiguration between the monitor and the health check
+	mu            sync.Mutex
+	config        Config
+	applied       bool
+	health        *health.Checker
+	gatewayDown   bool
+	probeRoute    *netlink.Route
+	ns            *namespace.Namespace
+	resolver      *resolver.File
+	inventory     *inventory.Inventory
+	defaultRoutes map[int]*netlink.Route
+	routes        []*netlink.Route
+	policyRoutes  []*netlink.Route
+	policyRouting *policy.Config
+	policyAddrs   []*net.IPNet
+	vrfTable      int
 }
 
 // Config represents static IP configuration parameters.
 type Config struct {
-	IPAddress string `yaml:"ip"`
-	Netmask   string `yaml:"netmask"`
-	Gateway   string `yaml:"gateway"`
+	IPAddress string         `yaml:"ip"`
+	Netmask   string         `yaml:"netmask"`
+	Gateway   string         `yaml:"gateway"`
+	Gateway6  string         `yaml:"gateway6,omitempty"`
+	Metric    int            `yaml:"metric"`
+	Exclusive bool           `yaml:"exclusive,omitempty"`
+	Addresses []Address      `yaml:"addresses,omitempty"`
+	DNS       []string       `yaml:"dns,omitempty"`
+	Search    []string       `yaml:"search,omitempty"`
+	Domain    string         `yaml:"domain,omitempty"`
+	Routes    []routes.Route `yaml:"routes,omitempty"`
+
+	// PolicyRouting installs the configuration into a dedicated routing table when set
+	PolicyRouting *policy.Config `yaml:"policy_routing,omitempty"`
+
+	// HealthCheck probes the gateway and fails over the default routes when set
+	HealthCheck *health.Config `yaml:"health_check,omitempty"`
+
+	// VRF enslaves the interface to the named VRF and installs the routes into its table when set
+	VRF string `yaml:"vrf,omitempty"`
+
+	// DeclaredVRFs lists the VRFs declared in the configuration. Without VRF the interface only
+	// leaves those, or the VRF it joined itself, other VRFs are managed elsewhere.
+	DeclaredVRFs []string `yaml:"-"`
+
+	// Link holds the link attributes enforced on the interface
+	Link LinkSettings `yaml:"link,omitempty"`
+
+	// OneShot returns from Run once the configuration is applied, leaving it in place without
+	// maintaining it
+	OneShot bool `yaml:"oneshot,omitempty"`
 }
 
-// NewClient creates a new static IP client for the given interface name.
-func NewClient(ifaceName string) (*Client, error) {
+// NewClient creates a new static IP client for the given interface name in the network namespace
+// of the context.
+func NewClient(ctx context.Context, ifaceName string) (*Client, error) {
 	iface, err := net.InterfaceByName(ifaceName)
 	if err != nil {
 		return nil, fmt.Errorf("interface not found: %w", err)
 	}
-	return &Client{Iface: iface}, nil
+	ns := namespace.FromContext(ctx)
+	inv, err := inventory.Open(ns.Qualify(ifaceName))
+	if err != nil {
+		return nil, err
+	}
+	return &Client{Iface: iface, ns: ns, resolver: resolver.For(ns.ResolvConf()), inventory: inv, defaultRoutes: make(map[int]*netlink.Route)}, nil
 }
 
 // Run configures the interface with static IP settings and maintains the configuration.
-func (c *Client) Run(config Config) error {
+// It returns when the context is cancelled, removing the routes it installed. One-shot clients
+// return as soon as the configuration is applied.
+func (c *Client) Run(ctx context.Context, config Config) error {
 	logger := logging.WithComponentAndInterface("static", c.Iface.Name).WithField("mac", c.Iface.HardwareAddr.String())
 	logger.Info("Starting static IP configuration")
 
@@ -43,38 +110,67 @@ func (c *Client) Run(config Config) error {
 	}
 
 	// Apply static IP configuration
-	if err := c.applyStaticConfig(config); err != nil {
+	c.mu.Lock()
+	c.config = config
+	err := c.applyStaticConfig(config)
+	c.applied = err == nil
+	c.mu.Unlock()
+	if err != nil {
 		return fmt.Errorf("failed to apply static configuration: %w", err)
 	}
 
 	logger.WithFields(map[string]interface{}{
-		"ip":      config.IPAddress,
-		"netmask": config.Netmask,
-		"gateway": config.Gateway,
+		"ip":        config.IPAddress,
+		"netmask":   config.Netmask,
+		"addresses": len(config.Addresses),
+		"gateway":   config.Gateway,
+		"gateway6":  config.Gateway6,
 	}).Info("Static IP configuration applied successfully")
+	if config.OneShot {
+		return nil
+	}
+
+	// Probe the gateway and fail over while it is unreachable
+	if config.HealthCheck != nil && !config.Link.Down {
+		c.mu.Lock()
+		c.health = health.NewChecker(*config.HealthCheck, c.Iface, healthGateway(config), c.onHealthChange)
+		checker := c.health
+		namespace.Go(ctx, func() { checker.Run(ctx) })
+		c.mu.Unlock()
+	}
 
 	// Monitor interface status and reapply configuration if needed
-	return c.monitorInterface(config)
+	return c.monitorInterface(ctx, config)
 }
 
 // validateConfig validates the static IP configuration parameters.
 func (c *Client) validateConfig(config Config) error {
-	// Validate IP address
-	ip := net.ParseIP(config.IPAddress)
-	if ip == nil {
-		return fmt.Errorf("invalid IP address: %s", config.IPAddress)
-	}
-	if ip.To4() == nil {
-		return fmt.Errorf("only IPv4 addresses are supported: %s", config.IPAddress)
+	// Validate legacy IP address and netmask
+	if config.IPAddress != "" {
+		ip := net.ParseIP(config.IPAddress)
+		if ip == nil {
+			return fmt.Errorf("invalid IP address: %s", config.IPAddress)
+		}
+		if ip.To4() == nil {
+			return fmt.Errorf("ip/netmask only supports IPv4, use addresses for IPv6: %s", config.IPAddress)
+		}
+
+		mask := net.ParseIP(config.Netmask)
+		if mask == nil {
+			return fmt.Errorf("invalid netmask: %s", config.Netmask)
+		}
+		if mask.To4() == nil {
+			return fmt.Errorf("only IPv4 netmasks are supported: %s", config.Netmask)
+		}
 	}
 
-	// Validate netmask
-	mask := net.ParseIP(config.Netmask)
-	if mask == nil {
-		return fmt.Errorf("invalid netmask: %s", config.Netmask)
+	// Validate the full address set
+	desired, err := c.desiredAddresses(config)
+	if err != nil {
+		return err
 	}
-	if mask.To4() == nil {
-		return fmt.Errorf("only IPv4 netmasks are supported: %s", config.Netmask)
+	if len(desired) == 0 {
+		return fmt.Errorf("no addresses configured")
 	}
 
 	// Validate gateway
@@ -88,6 +184,57 @@ func (c *Client) validateConfig(config Config) error {
 		}
 	}
 
+	// Validate IPv6 gateway, link-local gateways are reached through this interface
+	if config.Gateway6 != "" {
+		gw := net.ParseIP(config.Gateway6)
+		if gw == nil {
+			return fmt.Errorf("invalid IPv6 gateway address: %s", config.Gateway6)
+		}
+		if gw.To4() != nil {
+			return fmt.Errorf("gateway6 must be an IPv6 address: %s", config.Gateway6)
+		}
+	}
+
+	// Validate DNS servers
+	for _, dns := range config.DNS {
+		if net.ParseIP(dns) == nil {
+			return fmt.Errorf("invalid DNS server address: %s", dns)
+		}
+	}
+
+	// Validate link settings
+	if err := config.Link.Validate(); err != nil {
+		return err
+	}
+
+	// Validate routes
+	for _, route := range config.Routes {
+		if err := route.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// Validate policy routing
+	if config.PolicyRouting != nil {
+		if err := config.PolicyRouting.Validate(); err != nil {
+			return err
+		}
+	}
+
+	// Validate health check, ARP needs an IPv4 gateway
+	if config.HealthCheck != nil {
+		if err := config.HealthCheck.Validate(); err != nil {
+			return err
+		}
+		gateway := healthGateway(config)
+		if gateway == nil {
+			return fmt.Errorf("health check requires a gateway")
+		}
+		if config.HealthCheck.Type == health.ProbeARP && gateway.To4() == nil {
+			return fmt.Errorf("arp health check requires an IPv4 gateway")
+		}
+	}
+
 	return nil
 }
 
@@ -99,66 +246,109 @@ func (c *Client) applyStaticConfig(config Config) error {
 	link, err := netlink.LinkByName(c.Iface.Name)
 	if err != nil {
 		return fmt.Errorf("failed to get netlink interface: %w", err)
-	} // Parse IP address and netmask
-	ip := net.ParseIP(config.IPAddress)
-	if ip == nil {
-		return fmt.Errorf("invalid IP address: %s", config.IPAddress)
 	}
 
-	mask := net.ParseIP(config.Netmask)
-	if mask == nil {
-		return fmt.Errorf("invalid netmask: %s", config.Netmask)
+	// Apply link settings before addresses, a MAC address change takes the link down
+	if _, err := c.applyLinkSettings(link, config.Link); err != nil {
+		return err
 	}
 
-	// Create IP network
-	ipNet := &net.IPNet{
-		IP:   ip,
-		Mask: net.IPMask(mask.To4()),
+	// Join the VRF first, so that the routes of the addresses land in its table. Without a VRF
+	// the interface leaves the one it was put into by an earlier configuration.
+	if config.VRF != "" {
+		if c.vrfTable, err = links.JoinVRF(link, config.VRF, logger); err != nil {
+			return err
+		}
+	} else {
+		c.vrfTable = 0
+		vrfs := config.DeclaredVRFs
+		if joined := c.inventory.VRF(); joined != "" {
+			vrfs = append(slices.Clone(vrfs), joined)
+		}
+		if err := links.LeaveVRF(link, vrfs, logger); err != nil {
+			return err
+		}
+	}
+	if err := c.inventory.SetVRF(config.VRF); err != nil {
+		logger.WithError(err).Warn("Failed to record VRF")
 	}
 
-	logger.WithField("ip", ipNet.String()).Info("Configuring interface with IP")
-
-	// Get existing addresses to check for duplicates
-	existingAddrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+	// Build the desired address set
+	desired, err := c.desiredAddresses(config)
 	if err != nil {
-		return fmt.Errorf("failed to list existing addresses: %w", err)
+		return err
 	}
 
-	// Check if the target IP is already configured
-	targetConfigured := false
-	for _, addr := range existingAddrs {
-		if addr.IPNet.IP.Equal(ipNet.IP) && addr.IPNet.Mask.String() == ipNet.Mask.String() {
-			logger.WithField("ip", ipNet.String()).Info("IP address already configured, skipping")
-			targetConfigured = true
-			break
+	for _, addr := range desired {
+		logger.WithField("ip", addr.IPNet.String()).Info("Configuring interface with IP")
+	}
+
+	if err := c.configureAddresses(link, desired, config.Exclusive); err != nil {
+		return err
+	}
+
+	// Routes cannot be installed on a link that is kept down
+	if config.Link.Down {
+		if err := c.configureDNS(config); err != nil {
+			logger.WithError(err).Warn("Failed to configure DNS")
 		}
+		return nil
 	}
 
-	// Only remove existing addresses if target IP is not already configured
-	if !targetConfigured {
-		// Remove existing IPv4 addresses that don't match our target
-		for _, addr := range existingAddrs {
-			if !addr.IPNet.IP.Equal(ipNet.IP) {
-				if err := netlink.AddrDel(link, &addr); err != nil {
-					logger.WithError(err).WithField("address", addr.IPNet.String()).Warn("Failed to remove existing address")
-				} else {
-					logger.WithField("address", addr.IPNet.String()).Debug("Removed existing address")
-				}
+	// Configure default gateways, unless they are withdrawn while the gateway is down
+	c.defaultRoutes = make(map[int]*netlink.Route)
+	c.probeRoute = nil
+	if c.defaultRoutesWithdrawn(config) {
+		logger.Warn("Gateway is down, default routes withdrawn")
+
+		// Keep the probe target reachable through the gateway
+		c.probeRoute = config.HealthCheck.ProbeRoute(link, healthGateway(config), routes.ProtocolStatic)
+		routes.SetTable([]*netlink.Route{c.probeRoute}, c.vrfTable)
+		if c.probeRoute != nil {
+			if _, err := routes.Ensure([]*netlink.Route{c.probeRoute}, logger); err != nil {
+				return fmt.Errorf("failed to add health check route: %w", err)
 			}
 		}
+	} else if err := c.configureDefaultRoutes(link, config); err != nil {
+		return err
 	}
 
-	// Add new IP address only if not already configured
-	if !targetConfigured {
-		addr := &netlink.Addr{
-			IPNet: ipNet,
-		}
-		if err := netlink.AddrAdd(link, addr); err != nil {
-			return fmt.Errorf("failed to add IP address %s: %w", ipNet.String(), err)
-		}
-		logger.WithField("ip", ipNet.String()).Info("Successfully added IP address")
+	// Install configured static routes
+	if err := c.configureRoutes(link, config); err != nil {
+		return err
 	}
 
+	// Install the configuration into the dedicated table of the interface
+	if err := c.configurePolicyRouting(link, config, desired); err != nil {
+		return fmt.Errorf("failed to configure policy routing: %w", err)
+	}
+
+	// Remove routes installed by a previous configuration
+	owned := append([]*netlink.Route{}, c.routes...)
+	owned = append(owned, c.policyRoutes...)
+	for _, route := range c.defaultRoutes {
+		owned = append(owned, route)
+	}
+	if c.probeRoute != nil {
+		owned = append(owned, c.probeRoute)
+	}
+	if err := routes.RemoveStale(link, routes.ProtocolStatic, owned, c.inventory, logger); err != nil {
+		logger.WithError(err).Warn("Failed to remove stale routes")
+	}
+
+	// Configure DNS if specified
+	if err := c.configureDNS(config); err != nil {
+		logger.WithError(err).Warn("Failed to configure DNS")
+	}
+
+	return nil
+}
+
+// configureDefaultRoutes installs the IPv4 and IPv6 default routes through the configured gateways.
+func (c *Client) configureDefaultRoutes(link netlink.Link, config Config) error {
+	logger := logging.WithComponentAndInterface("static", c.Iface.Name)
+	metric := c.defaultRouteMetric(config)
+
 	// Configure default gateway if specified
 	if config.Gateway != "" {
 		gateway := net.ParseIP(config.Gateway)
@@ -168,85 +358,336 @@ func (c *Client) applyStaticConfig(config Config) error {
 
 		logger.WithField("gateway", gateway.String()).Info("Setting default gateway")
 
-		if err := c.configureDefaultRoute(link, gateway); err != nil {
+		if err := c.configureDefaultRoute(link, gateway, metric, config.Exclusive); err != nil {
 			return fmt.Errorf("failed to set default gateway: %w", err)
 		}
 	}
 
+	// Configure IPv6 default gateway if specified
+	if config.Gateway6 != "" {
+		gateway := net.ParseIP(config.Gateway6)
+		if gateway == nil {
+			return fmt.Errorf("invalid IPv6 gateway address: %s", config.Gateway6)
+		}
+
+		logger.WithField("gateway", gateway.String()).Info("Setting IPv6 default gateway")
+
+		if err := c.configureDefaultRoute(link, gateway, metric, config.Exclusive); err != nil {
+			return fmt.Errorf("failed to set IPv6 default gateway: %w", err)
+		}
+	}
+
 	return nil
 }
 
-// configureDefaultRoute configures the default gateway for the interface.
-func (c *Client) configureDefaultRoute(link netlink.Link, gateway net.IP) error {
-	logger := logging.WithComponentAndInterface("static", c.Iface.Name).WithField("gateway", gateway.String())
+// healthGateway returns the gateway probed by the health check, preferring the IPv4 gateway.
+func healthGateway(config Config) net.IP {
+	if gateway := net.ParseIP(config.Gateway); gateway != nil {
+		return gateway
+	}
+	return net.ParseIP(config.Gateway6)
+}
+
+// defaultRouteMetric returns the metric of the default routes, raised while the gateway is down.
+func (c *Client) defaultRouteMetric(config Config) int {
+	if c.gatewayDown && config.HealthCheck != nil {
+		return config.HealthCheck.Metric(config.Metric)
+	}
+	return config.Metric
+}
+
+// defaultRoutesWithdrawn reports whether the default routes are removed because the gateway is down.
+func (c *Client) defaultRoutesWithdrawn(config Config) bool {
+	return c.gatewayDown && config.HealthCheck != nil && config.HealthCheck.Withdraws()
+}
+
+// onHealthChange fails the default routes over when the gateway goes down and restores them when it recovers.
+func (c *Client) onHealthChange(state health.State) {
+	logger := logging.WithComponentAndInterface("static", c.Iface.Name).WithField("state", state)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.gatewayDown = state == health.StateDown
+	if err := c.applyStaticConfig(c.config); err != nil {
+		logger.WithError(err).Error("Failed to update default routes after gateway health change")
+		return
+	}
+	logger.Info("Updated default routes after gateway health change")
+}
+
+// HealthStatus returns the state of the gateway health check, or nil if none is configured.
+func (c *Client) HealthStatus() *health.Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.health == nil {
+		return nil
+	}
+	status := c.health.Status()
+	return &status
+}
+
+// Status describes the configuration a static client maintains.
+type Status struct {
+	Configured bool           `json:"configured"`
+	Addresses  []string       `json:"addresses,omitempty"`
+	Gateway    string         `json:"gateway,omitempty"`
+	Gateway6   string         `json:"gateway6,omitempty"`
+	DNS        []string       `json:"dns,omitempty"`
+	Health     *health.Status `json:"health,omitempty"`
+}
+
+// Status returns the configuration the client maintains.
+func (c *Client) Status() Status {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	status := Status{Configured: c.applied, Gateway: c.config.Gateway, Gateway6: c.config.Gateway6, DNS: c.config.DNS}
+	if desired, err := c.desiredAddresses(c.config); err == nil {
+		for _, addr := range desired {
+			status.Addresses = append(status.Addresses, addr.IPNet.String())
+		}
+	}
+	if c.health != nil {
+		checkStatus := c.health.Status()
+		status.Health = &checkStatus
+	}
+	return status
+}
+
+// Reapply configures the interface again, e.g. after it was changed behind the back of the client.
+// It enters the namespace of the interface, callers such as the control socket run in the namespace
+// of the daemon.
+func (c *Client) Reapply() error {
+	var err error
+	if nsErr := namespace.Do(namespace.NewContext(context.Background(), c.ns), func() { err = c.reapply() }); nsErr != nil {
+		return nsErr
+	}
+	return err
+}
+
+// reapply configures the interface again inside its namespace.
+func (c *Client) reapply() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.applied {
+		return errors.New("configuration was not applied yet")
+	}
+	return c.applyStaticConfig(c.config)
+}
+
+// configureRoutes installs the configured static routes that are missing.
+func (c *Client) configureRoutes(link netlink.Link, config Config) error {
+	c.routes = nil
+	if len(config.Routes) == 0 {
+		return nil
+	}
 
-	// Check if default route already exists with this gateway
-	routes, err := netlink.RouteList(nil, netlink.FAMILY_V4)
+	desired, err := routes.Build(link, config.Routes, routes.ProtocolStatic)
 	if err != nil {
-		return fmt.Errorf("failed to list routes: %w", err)
-	}
-
-	// Check for existing default route
-	hasDefaultRoute := false
-	for _, route := range routes {
-		// Check if this is a default route (0.0.0.0/0)
-		if route.Dst == nil && route.Gw != nil {
-			if route.Gw.Equal(gateway) && route.LinkIndex == link.Attrs().Index {
-				logger.Debug("Default route already configured, skipping")
-				hasDefaultRoute = true
-				break
-			} else {
-				// Remove conflicting default route
-				if err := netlink.RouteDel(&route); err != nil {
-					logger.WithError(err).WithField("existing_gateway", route.Gw.String()).
-						Warn("Failed to remove existing default route")
-				} else {
-					logger.WithField("existing_gateway", route.Gw.String()).
-						Debug("Removed conflicting default route")
-				}
-			}
+		return fmt.Errorf("invalid route configuration: %w", err)
+	}
+	routes.SetTable(desired, c.vrfTable)
+	c.routes = desired
+
+	logger := logging.WithComponentAndInterface("static", c.Iface.Name)
+	if err := routes.Claim(c.inventory, desired); err != nil {
+		logger.WithError(err).Warn("Failed to update route inventory")
+	}
+	if _, err := routes.Ensure(desired, logger); err != nil {
+		return fmt.Errorf("failed to configure routes: %w", err)
+	}
+	return nil
+}
+
+// configurePolicyRouting installs the subnets and gateways of the interface into its table
+// and adds the rules steering traffic into it.
+func (c *Client) configurePolicyRouting(link netlink.Link, config Config, desired []*netlink.Addr) error {
+	c.policyRoutes = nil
+	c.policyRouting = config.PolicyRouting
+	c.policyAddrs = nil
+	if config.PolicyRouting == nil {
+		return nil
+	}
+
+	logger := logging.WithComponentAndInterface("static", c.Iface.Name).WithField("table", config.PolicyRouting.Table)
+
+	for _, addr := range desired {
+		c.policyAddrs = append(c.policyAddrs, addr.IPNet)
+	}
+	var gateways []net.IP
+	for _, gw := range []string{config.Gateway, config.Gateway6} {
+		if ip := net.ParseIP(gw); ip != nil {
+			gateways = append(gateways, ip)
 		}
 	}
 
-	// Add new default route if not already present
-	if !hasDefaultRoute {
-		route := &netlink.Route{
-			LinkIndex: link.Attrs().Index,
-			Gw:        gateway,
+	c.policyRoutes = config.PolicyRouting.TableRoutes(link, c.policyAddrs, gateways, config.Metric, routes.ProtocolStatic)
+	if _, err := routes.Ensure(c.policyRoutes, logger); err != nil {
+		return err
+	}
+	if _, err := policy.Apply(*config.PolicyRouting, c.policyAddrs, routes.ProtocolStatic, logger); err != nil {
+		return err
+	}
+	return nil
+}
+
+// removeRoutes removes the default and configured static routes and the policy routing state
+// installed by the client.
+func (c *Client) removeRoutes() {
+	logger := logging.WithComponentAndInterface("static", c.Iface.Name)
+
+	if len(c.defaultRoutes) > 0 {
+		defaults := make([]*netlink.Route, 0, len(c.defaultRoutes))
+		for _, route := range c.defaultRoutes {
+			defaults = append(defaults, route)
+		}
+		routes.Remove(defaults, logger)
+		c.defaultRoutes = make(map[int]*netlink.Route)
+	}
+	if c.policyRouting != nil {
+		policy.Teardown(*c.policyRouting, routes.ProtocolStatic, logger)
+		routes.Remove(c.policyRoutes, logger)
+		c.policyRoutes = nil
+	}
+	if len(c.routes) > 0 {
+		routes.Remove(c.routes, logger)
+		if err := routes.Release(c.inventory, c.routes); err != nil {
+			logger.WithError(err).Warn("Failed to update route inventory")
 		}
+		c.routes = nil
+	}
+	if c.probeRoute != nil {
+		routes.Remove([]*netlink.Route{c.probeRoute}, logger)
+		c.probeRoute = nil
+	}
+}
 
-		if err := netlink.RouteAdd(route); err != nil {
-			// Check if the error is because the route already exists
-			if strings.Contains(err.Error(), "file exists") {
-				logger.WithField("gateway", gateway.String()).
-					Debug("Default route already exists, ignoring error")
-			} else {
-				return fmt.Errorf("failed to add default route: %w", err)
-			}
-		} else {
-			logger.Info("Successfully configured default route")
+// resolverConfig converts the DNS settings of the static configuration for the resolver.
+func resolverConfig(config Config) resolver.Config {
+	var nameservers []net.IP
+	for _, dns := range config.DNS {
+		if ip := net.ParseIP(dns); ip != nil {
+			nameservers = append(nameservers, ip)
+		}
+	}
+	return resolver.Config{
+		Nameservers: nameservers,
+		Search:      config.Search,
+		Domain:      config.Domain,
+	}
+}
+
+// configureDNS hands the static DNS servers, search list and domain to the resolver.
+func (c *Client) configureDNS(config Config) error {
+	logger := logging.WithComponentAndInterface("static", c.Iface.Name)
+
+	resolverConfig := resolverConfig(config)
+	if resolverConfig.IsEmpty() {
+		return nil
+	}
+
+	updated, err := c.resolver.Update(c.Iface.Name, resolverConfig)
+	if err != nil {
+		return err
+	}
+	if !updated {
+		logger.Debug("DNS configuration already up to date, skipping")
+		return nil
+	}
+
+	logger.WithField("dns_servers", strings.Join(config.DNS, ", ")).WithField("file", c.resolver.Path()).Info("Updated resolver configuration with DNS servers")
+	return nil
+}
+
+// configureDefaultRoute installs the default route through the gateway with the interface metric.
+// Only default routes on this link that the daemon installed are replaced, unless exclusive is set,
+// so that several interfaces and foreign routes can coexist.
+func (c *Client) configureDefaultRoute(link netlink.Link, gateway net.IP, metric int, exclusive bool) error {
+	logger := logging.WithComponentAndInterface("static", c.Iface.Name).WithField("gateway", gateway.String())
+
+	route := routes.DefaultRoute(link, gateway, metric, routes.ProtocolStatic)
+	routes.SetTable([]*netlink.Route{route}, c.vrfTable)
+	logger = logger.WithField("metric", route.Priority)
+
+	// Check if the desired default route already exists
+	exists, err := routes.Exists(route)
+	if err != nil {
+		return err
+	}
+	if exists {
+		logger.Debug("Default route already configured, skipping")
+		c.defaultRoutes[route.Family] = route
+		return nil
+	}
+
+	// Remove the default routes installed before, e.g. through a previous gateway
+	if err := routes.RemoveOtherDefaults(link, route, exclusive, logger); err != nil {
+		return err
+	}
+
+	if err := netlink.RouteAdd(route); err != nil {
+		if errors.Is(err, unix.EEXIST) {
+			return fmt.Errorf("another default route with metric %d exists, configure a distinct metric: %w", route.Priority, err)
 		}
+		return fmt.Errorf("failed to add default route: %w", err)
 	}
+	c.defaultRoutes[route.Family] = route
 
+	logger.Info("Successfully added default route")
 	return nil
 }
 
+// Monitoring intervals: changes reported by netlink are repaired once they settle, and the full
+// configuration, including /etc/resolv.conf which netlink does not report on, is checked periodically.
+const (
+	settleDelay   = 50 * time.Millisecond
+	checkInterval = 30 * time.Second
+)
+
 // monitorInterface monitors the interface and reapplies configuration if needed.
-func (c *Client) monitorInterface(config Config) error {
+func (c *Client) monitorInterface(ctx context.Context, config Config) error {
 	logger := logging.WithComponentAndInterface("static", c.Iface.Name)
 	logger.Info("Starting interface monitoring")
 
-	ticker := time.NewTicker(30 * time.Second)
+	events, err := netmon.Subscribe(ctx, c.Iface.Index)
+	if err != nil {
+		logger.WithError(err).Warn("Failed to subscribe to netlink events, falling back to periodic checks")
+	}
+
+	ticker := time.NewTicker(checkInterval)
 	defer ticker.Stop()
 
-	for range ticker.C {
-		if err := c.checkAndRepairConfiguration(config); err != nil {
-			logger.WithError(err).Error("Configuration check failed")
+	for {
+		select {
+		case <-ctx.Done():
+			logger.Info("Stopping interface monitoring")
+			c.mu.Lock()
+			c.removeRoutes()
+			c.mu.Unlock()
+			return nil
+		case event := <-events:
+			logger.WithField("event", event.String()).Debug("Interface changed")
+			if !netmon.Settle(ctx, events, settleDelay) {
+				continue
+			}
+			c.mu.Lock()
+			err := c.checkAndRepairConfiguration(config)
+			c.mu.Unlock()
+			if err != nil {
+				logger.WithError(err).Error("Configuration check failed")
+			}
+		case <-ticker.C:
+			c.mu.Lock()
+			err := c.checkAndRepairConfiguration(config)
+			c.mu.Unlock()
+			if err != nil {
+				logger.WithError(err).Error("Configuration check failed")
+			}
 		}
 	}
-
-	// This line is unreachable, but required for compilation
-	return nil
 }
 
 // checkAndRepairConfiguration checks if the static configuration is still applied and repairs if needed.
@@ -266,40 +707,96 @@ func (c *Client) checkAndRepairConfiguration(config Config) error {
 		return fmt.Errorf("failed to get netlink interface: %w", err)
 	}
 
-	// Check if interface is up
-	if c.Iface.Flags&net.FlagUp == 0 {
-		logger.Warn("Interface is down, bringing it up")
-		if err := netlink.LinkSetUp(link); err != nil {
-			return fmt.Errorf("failed to bring interface up: %w", err)
-		}
+	// Enforce link settings, including the administrative state
+	changed, err := c.applyLinkSettings(link, config.Link)
+	if err != nil {
+		return err
+	}
+	if changed {
+		logger.Warn("Link settings were changed, restored them")
+		c.repaired(metrics.RepairLink)
+	}
+
+	// Rejoin the VRF if the interface was released from it
+	if config.VRF != "" && !links.InVRF(link, config.VRF) {
+		logger.WithField("vrf", config.VRF).Warn("Interface left its VRF, reapplying configuration")
+		c.repaired(metrics.RepairVRF)
+		return c.applyStaticConfig(config)
 	}
 
 	// Get current IP addresses using netlink
-	addrs, err := netlink.AddrList(link, netlink.FAMILY_V4)
+	addrs, err := netlink.AddrList(link, netlink.FAMILY_ALL)
 	if err != nil {
 		return fmt.Errorf("failed to get interface addresses: %w", err)
 	}
 
-	// Check if our static IP is configured
-	expectedIP := net.ParseIP(config.IPAddress)
-	hasStaticIP := false
-
-	for _, addr := range addrs {
-		if addr.IPNet.IP.Equal(expectedIP) {
-			hasStaticIP = true
-			break
-		}
+	// Check if all of our static addresses are configured
+	desired, err := c.desiredAddresses(config)
+	if err != nil {
+		return err
 	}
 
-	// Reapply configuration if static IP is missing
-	if !hasStaticIP {
-		logger.WithField("ip", config.IPAddress).
-			Warn("Static IP not found on interface, reapplying configuration")
+	// Reapply configuration if any static address is missing
+	if missing := missingAddresses(addrs, desired); len(missing) > 0 {
+		for _, addr := range missing {
+			logger.WithField("ip", addr.IPNet.String()).
+				Warn("Static IP not found on interface, reapplying configuration")
+		}
+		c.repaired(metrics.RepairAddress)
 		if err := c.applyStaticConfig(config); err != nil {
 			return fmt.Errorf("failed to reapply static configuration: %w", err)
 		}
 		logger.Info("Static configuration reapplied successfully")
+		return nil
+	}
+
+	// Re-assert default and static routes in case they were deleted
+	owned := append(append([]*netlink.Route{}, c.routes...), c.policyRoutes...)
+	for _, route := range c.defaultRoutes {
+		owned = append(owned, route)
+	}
+	if c.probeRoute != nil {
+		owned = append(owned, c.probeRoute)
+	}
+	if len(owned) > 0 {
+		added, err := routes.Ensure(owned, logger)
+		if err != nil {
+			return fmt.Errorf("failed to reapply routes: %w", err)
+		}
+		if added > 0 {
+			logger.WithField("routes", added).Warn("Routes were missing, reinstalled them")
+			c.repaired(metrics.RepairRoute)
+		}
+	}
+
+	// Re-assert policy rules in case they were deleted
+	if c.policyRouting != nil {
+		added, err := policy.Apply(*c.policyRouting, c.policyAddrs, routes.ProtocolStatic, logger)
+		if err != nil {
+			return fmt.Errorf("failed to reapply policy rules: %w", err)
+		}
+		if added > 0 {
+			logger.WithField("rules", added).Warn("Policy rules were missing, reinstalled them")
+			c.repaired(metrics.RepairPolicy)
+		}
+	}
+
+	// Re-assert resolver settings in case /etc/resolv.conf was rewritten by someone else
+	if resolverConfig := resolverConfig(config); !resolverConfig.IsEmpty() {
+		updated, err := c.resolver.Update(c.Iface.Name, resolverConfig)
+		if err != nil {
+			return fmt.Errorf("failed to reapply DNS configuration: %w", err)
+		}
+		if updated {
+			logger.WithField("file", c.resolver.Path()).Warn("DNS configuration drifted, rewrote resolver configuration")
+			c.repaired(metrics.RepairDNS)
+		}
 	}
 
 	return nil
 }
+
+// repaired records the repair of configuration someone else changed.
+func (c *Client) repaired(kind string) {
+	metrics.StaticRepairs.WithLabelValues(c.Iface.Name, c.ns.String(), kind).Inc()
+}
@@ -0,0 +1,119 @@
+package static
+
+import (
+	"bytes"
+	"fmt"
+	"net"
+
+	"golang-dhcpcd/internal/pkg/logging"
+
+	"github.com/vishvananda/netlink"
+	"golang.org/x/sys/unix"
+)
+
+// LinkSettings are link attributes enforced on the interface. Zero values leave the current
+// setting of the link alone.
+type LinkSettings struct {
+	MTU        int    `yaml:"mtu,omitempty"`
+	MAC        string `yaml:"mac,omitempty"`
+	TxQueueLen int    `yaml:"txqueuelen,omitempty"`
+	Down       bool   `yaml:"down,omitempty"` // Keep the link administratively down
+	Promisc    *bool  `yaml:"promiscuous,omitempty"`
+	Alias      string `yaml:"alias,omitempty"`
+}
+
+// Validate checks the link settings.
+func (s LinkSettings) Validate() error {
+	if s.MAC != "" {
+		if _, err := net.ParseMAC(s.MAC); err != nil {
+			return fmt.Errorf("invalid MAC address %q: %w", s.MAC, err)
+		}
+	}
+	if s.MTU < 0 || s.TxQueueLen < 0 {
+		return fmt.Errorf("mtu and txqueuelen must not be negative")
+	}
+	return nil
+}
+
+// applyLinkSettings brings the link attributes in line with the settings and the link up, unless
+// it is to be kept down. It reports whether anything had to be changed.
+func (c *Client) applyLinkSettings(link netlink.Link, settings LinkSettings) (bool, error) {
+	logger := logging.WithComponentAndInterface("static", c.Iface.Name)
+	attrs := link.Attrs()
+	changed := false
+
+	// Most drivers only accept a new MAC address while the link is down
+	if settings.MAC != "" {
+		mac, _ := net.ParseMAC(settings.MAC)
+		if !bytes.Equal(attrs.HardwareAddr, mac) {
+			if err := netlink.LinkSetDown(link); err != nil {
+				return changed, fmt.Errorf("failed to bring interface down: %w", err)
+			}
+			attrs.RawFlags &^= unix.IFF_UP
+			if err := netlink.LinkSetHardwareAddr(link, mac); err != nil {
+				return changed, fmt.Errorf("failed to set MAC address %s: %w", mac, err)
+			}
+			logger.WithField("mac", mac.String()).WithField("previous", attrs.HardwareAddr.String()).Info("Set MAC address")
+			changed = true
+		}
+	}
+
+	if settings.MTU > 0 && attrs.MTU != settings.MTU {
+		if err := netlink.LinkSetMTU(link, settings.MTU); err != nil {
+			return changed, fmt.Errorf("failed to set MTU %d: %w", settings.MTU, err)
+		}
+		logger.WithField("mtu", settings.MTU).WithField("previous", attrs.MTU).Info("Set MTU")
+		changed = true
+	}
+
+	if settings.TxQueueLen > 0 && attrs.TxQLen != settings.TxQueueLen {
+		if err := netlink.LinkSetTxQLen(link, settings.TxQueueLen); err != nil {
+			return changed, fmt.Errorf("failed to set txqueuelen %d: %w", settings.TxQueueLen, err)
+		}
+		logger.WithField("txqueuelen", settings.TxQueueLen).WithField("previous", attrs.TxQLen).Info("Set transmit queue length")
+		changed = true
+	}
+
+	if settings.Promisc != nil && (attrs.Promisc != 0) != *settings.Promisc {
+		set := netlink.SetPromiscOff
+		if *settings.Promisc {
+			set = netlink.SetPromiscOn
+		}
+		if err := set(link); err != nil {
+			return changed, fmt.Errorf("failed to set promiscuous mode: %w", err)
+		}
+		logger.WithField("promiscuous", *settings.Promisc).Info("Set promiscuous mode")
+		changed = true
+	}
+
+	if settings.Alias != "" && attrs.Alias != settings.Alias {
+		if err := netlink.LinkSetAlias(link, settings.Alias); err != nil {
+			return changed, fmt.Errorf("failed to set alias: %w", err)
+		}
+		logger.WithField("alias", settings.Alias).Info("Set alias")
+		changed = true
+	}
+
+	up := attrs.RawFlags&unix.IFF_UP != 0
+	if settings.Down && up {
+		if err := netlink.LinkSetDown(link); err != nil {
+			return changed, fmt.Errorf("failed to bring interface down: %w", err)
+		}
+		logger.Info("Interface is configured down, brought it down")
+		changed = true
+	} else if !settings.Down && !up {
+		if err := netlink.LinkSetUp(link); err != nil {
+			return changed, fmt.Errorf("failed to bring interface up: %w", err)
+		}
+		logger.Info("Interface is down, brought it up")
+		changed = true
+	}
+
+	// Refresh the interface information, e.g. after the MAC address changed
+	if changed {
+		if iface, err := net.InterfaceByName(c.Iface.Name); err == nil {
+			c.Iface = iface
+		}
+	}
+	return changed, nil
+}
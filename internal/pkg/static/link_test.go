@@ -0,0 +1,26 @@
+package static
+
+import "testing"
+
+func TestLinkSettingsValidate(t *testing.T) {
+	tests := []struct {
+		name     string
+		settings LinkSettings
+		wantErr  bool
+	}{
+		{"empty", LinkSettings{}, false},
+		{"all settings", LinkSettings{MTU: 9000, MAC: "52:54:00:12:34:56", TxQueueLen: 1000, Down: true, Alias: "uplink"}, false},
+		{"invalid mac", LinkSettings{MAC: "52:54:00"}, true},
+		{"negative mtu", LinkSettings{MTU: -1}, true},
+		{"negative txqueuelen", LinkSettings{TxQueueLen: -1}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.settings.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Validate() = %v, want error %v", err, tt.wantErr)
+			}
+		})
+	}
+}
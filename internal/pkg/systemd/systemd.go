@@ -0,0 +1,131 @@
+package systemd
+
+import (
+	"net"
+	"time"
+
+	"golang-dhcpcd/internal/pkg/logging"
+
+	"github.com/coreos/go-systemd/v22/activation"
+	"github.com/coreos/go-systemd/v22/daemon"
+)
+
+// notify sends a state change to the service manager. It does nothing unless the daemon was
+// started by systemd with NotifyAccess.
+func notify(state string) {
+	if _, err := daemon.SdNotify(false, state); err != nil {
+		logging.WithComponent("systemd").WithError(err).WithField("state", state).Debug("Failed to notify service manager")
+	}
+}
+
+// Ready reports that startup finished.
+func Ready() {
+	notify(daemon.SdNotifyReady)
+}
+
+// Stopping reports that the daemon shuts down.
+func Stopping() {
+	notify(daemon.SdNotifyStopping)
+}
+
+// Watchdog tells the service manager that the daemon is alive.
+func Watchdog() {
+	notify(daemon.SdNotifyWatchdog)
+}
+
+// Status sets the status line shown by systemctl status.
+func Status(status string) {
+	notify("STATUS=" + status)
+}
+
+// WatchdogInterval returns how often Watchdog has to be called, half the configured WatchdogSec,
+// or zero if the watchdog is disabled.
+func WatchdogInterval() time.Duration {
+	interval, err := daemon.SdWatchdogEnabled(false)
+	if err != nil {
+		logging.WithComponent("systemd").WithError(err).Warn("Invalid watchdog settings")
+		return 0
+	}
+	return interval / 2
+}
+
+// namedListener is a socket passed by systemd with its FileDescriptorName.
+type namedListener struct {
+	name     string
+	listener net.Listener
+}
+
+// Sockets are the listening sockets passed by socket activation.
+type Sockets struct {
+	listeners []namedListener
+}
+
+// Activated takes over the sockets passed by systemd, none if the daemon was not socket activated.
+func Activated() *Sockets {
+	named, err := activation.ListenersWithNames()
+	if err != nil {
+		logging.WithComponent("systemd").WithError(err).Warn("Failed to take over activated sockets")
+	}
+
+	sockets := &Sockets{}
+	for name, listeners := range named {
+		for _, listener := range listeners {
+			// Datagram sockets are not listeners
+			if listener != nil {
+				sockets.listeners = append(sockets.listeners, namedListener{name: name, listener: listener})
+			}
+		}
+	}
+	return sockets
+}
+
+// Take returns the socket with the FileDescriptorName, or else the one bound to the address, and
+// nil if there is none. A unix socket address is its path, a TCP address host:port.
+func (s *Sockets) Take(name, address string) net.Listener {
+	index := -1
+	for i, named := range s.listeners {
+		if named.name == name {
+			index = i
+			break
+		}
+		if index < 0 && address != "" && boundTo(named.listener.Addr(), address) {
+			index = i
+		}
+	}
+	if index < 0 {
+		return nil
+	}
+
+	listener := s.listeners[index].listener
+	s.listeners = append(s.listeners[:index], s.listeners[index+1:]...)
+	logging.WithComponent("systemd").WithField("name", name).WithField("address", listener.Addr().String()).Info("Using activated socket")
+	return listener
+}
+
+// Close closes the sockets nobody took.
+func (s *Sockets) Close() {
+	for _, named := range s.listeners {
+		logging.WithComponent("systemd").WithField("name", named.name).WithField("address", named.listener.Addr().String()).
+			Warn("Activated socket is not used, closing it")
+		named.listener.Close()
+	}
+	s.listeners = nil
+}
+
+// boundTo reports whether the socket address is the given unix path or TCP host:port.
+func boundTo(addr net.Addr, address string) bool {
+	switch addr := addr.(type) {
+	case *net.UnixAddr:
+		return addr.Name == address
+	case *net.TCPAddr:
+		want, err := net.ResolveTCPAddr("tcp", address)
+		if err != nil || want.Port != addr.Port {
+			return false
+		}
+		if want.IP == nil || want.IP.IsUnspecified() {
+			return addr.IP == nil || addr.IP.IsUnspecified()
+		}
+		return want.IP.Equal(addr.IP)
+	}
+	return false
+}
@@ -0,0 +1,170 @@
+package systemd
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// listenNotify binds a notification socket and points NOTIFY_SOCKET at it.
+func listenNotify(t *testing.T) *net.UnixConn {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "notify.sock")
+	conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+	if err != nil {
+		t.Fatalf("Failed to bind notification socket: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	t.Setenv("NOTIFY_SOCKET", path)
+	return conn
+}
+
+// receive reads the next notification.
+func receive(t *testing.T, conn *net.UnixConn) string {
+	t.Helper()
+
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		t.Fatalf("No notification received: %v", err)
+	}
+	return string(buf[:n])
+}
+
+func TestNotify(t *testing.T) {
+	tests := []struct {
+		name   string
+		notify func()
+		want   string
+	}{
+		{"ready", Ready, "READY=1"},
+		{"stopping", Stopping, "STOPPING=1"},
+		{"watchdog", Watchdog, "WATCHDOG=1"},
+		{"status", func() { Status("2/3 interfaces configured") }, "STATUS=2/3 interfaces configured"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn := listenNotify(t)
+			tt.notify()
+			if got := receive(t, conn); got != tt.want {
+				t.Errorf("Notification = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNotifyWithoutServiceManager(t *testing.T) {
+	t.Setenv("NOTIFY_SOCKET", "")
+	os.Unsetenv("NOTIFY_SOCKET")
+
+	// Nothing to notify, which must not fail
+	Ready()
+	Status("ready")
+}
+
+func TestWatchdogInterval(t *testing.T) {
+	tests := []struct {
+		name string
+		usec string
+		pid  string
+		want time.Duration
+	}{
+		{"disabled", "", "", 0},
+		{"half of the interval", "10000000", "", 5 * time.Second},
+		{"own pid", "2000000", strconv.Itoa(os.Getpid()), time.Second},
+		{"other pid", "2000000", "1", 0},
+		{"invalid", "soon", "", 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv("WATCHDOG_USEC", tt.usec)
+			t.Setenv("WATCHDOG_PID", tt.pid)
+			if got := WatchdogInterval(); got != tt.want {
+				t.Errorf("WatchdogInterval() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSocketsTake(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control.sock")
+	control, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatalf("Failed to listen on %s: %v", path, err)
+	}
+	metrics, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen on TCP: %v", err)
+	}
+	unused, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen on TCP: %v", err)
+	}
+
+	sockets := &Sockets{listeners: []namedListener{
+		{name: "unknown", listener: metrics},
+		{name: "control", listener: control},
+		{name: "unknown", listener: unused},
+	}}
+
+	// A matching name wins over an earlier socket bound to the address
+	if got := sockets.Take("control", metrics.Addr().String()); got != control {
+		t.Errorf("Take(control) = %v, want the socket named control", got)
+	}
+	if got := sockets.Take("control", ""); got != nil {
+		t.Errorf("Take(control) again = %v, want nil", got.Addr())
+	}
+	if got := sockets.Take("metrics", metrics.Addr().String()); got != metrics {
+		t.Errorf("Take(metrics) = %v, want the socket bound to %s", got, metrics.Addr())
+	}
+	if got := sockets.Take("metrics", "127.0.0.1:1"); got != nil {
+		t.Errorf("Take with another address = %v, want nil", got.Addr())
+	}
+
+	// The socket nobody took is closed
+	sockets.Close()
+	if _, err := unused.Accept(); err == nil {
+		t.Error("Unused socket was not closed")
+	}
+	if len(sockets.listeners) != 0 {
+		t.Errorf("Close left %d sockets", len(sockets.listeners))
+	}
+	control.Close()
+	metrics.Close()
+}
+
+func TestBoundTo(t *testing.T) {
+	tests := []struct {
+		name    string
+		addr    net.Addr
+		address string
+		want    bool
+	}{
+		{"unix path", &net.UnixAddr{Name: "/run/control.sock", Net: "unix"}, "/run/control.sock", true},
+		{"other unix path", &net.UnixAddr{Name: "/run/control.sock", Net: "unix"}, "/run/other.sock", false},
+		{"tcp address", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9420}, "127.0.0.1:9420", true},
+		{"other tcp port", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9420}, "127.0.0.1:9421", false},
+		{"other tcp host", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9420}, "10.0.0.1:9420", false},
+		{"any address", &net.TCPAddr{IP: net.IPv6unspecified, Port: 9420}, ":9420", true},
+		{"any address for a host", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9420}, ":9420", false},
+		{"host for any address", &net.TCPAddr{IP: net.IPv6unspecified, Port: 9420}, "127.0.0.1:9420", false},
+		{"invalid address", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9420}, "localhost", false},
+		{"unix path for tcp", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9420}, "/run/control.sock", false},
+		{"udp socket", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 9420}, "127.0.0.1:9420", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := boundTo(tt.addr, tt.address); got != tt.want {
+				t.Errorf("boundTo(%v, %q) = %v, want %v", tt.addr, tt.address, got, tt.want)
+			}
+		})
+	}
+}